@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+	"github.com/dustin/go-humanize"
+	"github.com/fatih/color"
+)
+
+// accessOutput is where the access log goes. With a CLF access log and
+// --log-file it's the file alone, since tools parsing CLF can't skip the
+// server's own lines.
+var accessOutput io.Writer = logging.Output
+
+// configureLogging applies --log-format, --log-level, and --log-file. With
+// an access log format the server's own lines are slog's text, or JSON
+// along with --log-format json, and the colored request lines drop to debug
+// level.
+func configureLogging() error {
+	if len(args.LogFile) > 0 {
+		if isWorker() {
+			// the supervisor sends stderr to the file, or with a CLF access
+			// log, stdout
+			color.Output = color.Error
+			color.NoColor = !isCLF()
+
+			if isCLF() {
+				accessOutput = os.Stdout
+			}
+		} else {
+			maxSize, err := humanize.ParseBytes(args.LogMaxSize)
+			if err != nil {
+				return fmt.Errorf("invalid --log-max-size: %w", err)
+			}
+
+			file, err := logging.OpenRotating(args.LogFile, int64(maxSize), args.LogKeep)
+			if err != nil {
+				return err
+			}
+
+			if isCLF() {
+				// the server's own lines go to stderr instead
+				color.Output = color.Error
+				accessOutput = file
+			} else {
+				color.Output = file
+				color.NoColor = true
+			}
+		}
+	}
+
+	format := args.LogFormat
+	if isCLF() {
+		format = "text"
+	}
+
+	logging.SetAccessLogged(args.LogFormat != "pretty")
+
+	return logging.Configure(format, args.LogLevel)
+}
+
+// isCLF reports whether the access log is in Common or Combined Log Format.
+func isCLF() bool {
+	return args.LogFormat == "common" || args.LogFormat == "combined"
+}
+
+// AccessLog writes a line for every request once it's been answered, with
+// its status, size, and latency, in Common or Combined Log Format or as
+// JSON. The lines aren't subject to --log-level.
+type AccessLog struct {
+	format string
+	w      io.Writer
+	json   *slog.Logger
+}
+
+// NewAccessLog creates an access log writing format to w.
+func NewAccessLog(format string, w io.Writer) *AccessLog {
+	return &AccessLog{
+		format: format,
+		w:      w,
+		json:   slog.New(slog.NewJSONHandler(w, nil)),
+	}
+}
+
+// Wrap logs the requests next answers.
+func (al *AccessLog) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := trackResponse(w)
+
+		next.ServeHTTP(sw, r)
+
+		status := sw.Status()
+		if status == 0 {
+			// nothing was written, net/http sends an empty 200
+			status = http.StatusOK
+		}
+
+		al.log(r, status, sw.Written(), start, time.Since(start))
+	})
+}
+
+func (al *AccessLog) log(r *http.Request, status int, written int64, start time.Time, latency time.Duration) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if al.format == "json" {
+		level := slog.LevelInfo
+
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+
+		al.json.LogAttrs(context.Background(), level, "request",
+			slog.String("method", r.Method),
+			slog.String("path", logging.RedactURL(r.URL)),
+			slog.String("proto", r.Proto),
+			slog.Int("status", status),
+			slog.Int64("bytes", written),
+			slog.Float64("latency_ms", float64(latency.Microseconds())/1000),
+			slog.String("remote_ip", host),
+			slog.String("user_agent", r.UserAgent()),
+			slog.String("referer", r.Referer()),
+		)
+
+		return
+	}
+
+	size := "-"
+	if written > 0 {
+		size = strconv.FormatInt(written, 10)
+	}
+
+	line := fmt.Sprintf("%s - - [%s] %q %d %s", host, start.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method+" "+logging.RedactURL(r.URL)+" "+r.Proto, status, size)
+
+	if al.format == "combined" {
+		line += fmt.Sprintf(" %q %q", orDash(r.Referer()), orDash(r.UserAgent()))
+	}
+
+	_, _ = io.WriteString(al.w, line+"\n")
+}
+
+// orDash returns s, or "-" for a field that's missing.
+func orDash(s string) string {
+	if len(s) == 0 {
+		return "-"
+	}
+
+	return s
+}
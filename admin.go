@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/cache"
+	"github.com/coreyog/spa-server/internal/logging"
+	"github.com/coreyog/spa-server/internal/responder"
+)
+
+// adminScopes are the permissions an admin token can be given.
+var adminScopes = map[string]bool{
+	"stats":     true, // read /_admin/stats
+	"purge":     true, // drop cached files with /_admin/purge
+	"deploy":    true, // call /_hooks/deploy and /_admin/rollback
+	"previous":  true, // browse the previous release under /_previous
+	"analytics": true, // read the page views on /_analytics
+}
+
+// adminToken is a bearer token allowed to use some of the admin endpoints.
+type adminToken struct {
+	name   string
+	scopes map[string]bool
+	secret []byte
+}
+
+var adminTokens []adminToken
+
+// started is when the server started, for the uptime in stats.
+var started = time.Now()
+
+// parseAdminTokens parses NAME:SCOPE[,SCOPE...]=SECRET tokens, whose
+// SECRET references resolveSecrets has already replaced.
+func parseAdminTokens(raw []string) ([]adminToken, error) {
+	tokens := make([]adminToken, 0, len(raw))
+
+	for _, r := range raw {
+		nameScopes, ref, ok := strings.Cut(r, "=")
+		name, scopes, _ := strings.Cut(nameScopes, ":")
+
+		if !ok || len(name) == 0 || len(scopes) == 0 || len(ref) == 0 {
+			return nil, fmt.Errorf("invalid admin token %q, expected NAME:SCOPE[,SCOPE...]=SECRET", nameScopes)
+		}
+
+		token := adminToken{name: name, scopes: map[string]bool{}}
+
+		for _, scope := range strings.Split(scopes, ",") {
+			if !adminScopes[scope] {
+				return nil, fmt.Errorf("admin token %s has unknown scope %q, expected stats, purge, deploy, previous, or analytics", name, scope)
+			}
+
+			token.scopes[scope] = true
+		}
+
+		token.secret = []byte(ref)
+		tokens = append(tokens, token)
+	}
+
+	return tokens, nil
+}
+
+// hasAdminScope reports whether any admin token has scope.
+func hasAdminScope(scope string) bool {
+	for _, token := range adminTokens {
+		if token.scopes[scope] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// adminTokenFor returns the admin token r's bearer token matches.
+func adminTokenFor(r *http.Request) (adminToken, bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return adminToken{}, false
+	}
+
+	presented := []byte(strings.TrimPrefix(auth, "Bearer "))
+
+	for _, token := range adminTokens {
+		if subtle.ConstantTimeCompare(presented, token.secret) == 1 {
+			return token, true
+		}
+	}
+
+	return adminToken{}, false
+}
+
+// requireScope only lets requests with an admin token having scope through
+// to next, answering 401 without a valid token and 403 without the scope.
+func requireScope(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := adminTokenFor(r)
+
+		switch {
+		case !ok:
+			auditLog.Record(AuditEvent{Action: scope, Actor: r.RemoteAddr, Result: "denied"})
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		case !token.scopes[scope]:
+			auditLog.Record(AuditEvent{Action: scope, Actor: "token:" + token.name, Result: "denied"})
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// browserAuthorized lets through requests for pages browsed with an admin
+// token having scope: as a bearer token, or in cookie, which is set from a
+// token= query parameter and scoped to path. Trading the parameter for the
+// cookie redirects to the same URL without it, keeping the token out of the
+// address bar and history. It answers the request itself and returns false
+// otherwise.
+func browserAuthorized(w http.ResponseWriter, r *http.Request, scope string, cookie string, path string) bool {
+	if secret := r.URL.Query().Get("token"); len(secret) > 0 {
+		if !hasScopedToken(secret, scope) {
+			auditLog.Record(AuditEvent{Action: scope, Actor: r.RemoteAddr, Result: "denied"})
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+
+			return false
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     cookie,
+			Value:    secret,
+			Path:     path,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		query := r.URL.Query()
+		query.Del("token")
+
+		target := &url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		http.Redirect(w, r, target.RequestURI(), http.StatusSeeOther)
+
+		return false
+	}
+
+	if token, ok := adminTokenFor(r); ok && token.scopes[scope] {
+		return true
+	}
+
+	if c, err := r.Cookie(cookie); err == nil && hasScopedToken(c.Value, scope) {
+		return true
+	}
+
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+
+	return false
+}
+
+// hasScopedToken reports whether secret is an admin token with scope.
+func hasScopedToken(secret string, scope string) bool {
+	for _, token := range adminTokens {
+		if subtle.ConstantTimeCompare([]byte(secret), token.secret) == 1 {
+			return token.scopes[scope]
+		}
+	}
+
+	return false
+}
+
+// adminStats is the body of /_admin/stats.
+type adminStats struct {
+	Version   string           `json:"version"`
+	Uptime    string           `json:"uptime"`
+	InFlight  int64            `json:"in_flight"`
+	Aborted   int64            `json:"client_aborted"`
+	Cache     cache.Stats      `json:"cache"`
+	Indexed   int              `json:"indexed_files,omitempty"`
+	Fallbacks *fallbackSummary `json:"fallbacks,omitempty"`
+	Release   string           `json:"release,omitempty"`
+}
+
+// serveStats reports the server's state without changing anything.
+func (h *spaHandler) serveStats(drainer *Drainer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats := adminStats{
+			Version:  serverVersion(),
+			Uptime:   time.Since(started).Round(time.Second).String(),
+			InFlight: atomic.LoadInt64(&drainer.inFlight),
+			Aborted:  responder.Aborted(),
+			Cache:    h.store.Stats(),
+		}
+
+		if h.index != nil {
+			stats.Indexed = h.index.Len()
+		}
+
+		if h.fallbacks != nil {
+			stats.Fallbacks = h.fallbacks.Summary()
+		}
+
+		if target, err := os.Readlink(args.Positional.Directory); err == nil {
+			stats.Release = target
+		}
+
+		body, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = w.Write(append(body, '\n'))
+	})
+}
+
+// servePurge drops the cached copies of the files given as path query
+// parameters, or of every file if there are none.
+func (h *spaHandler) servePurge(w http.ResponseWriter, r *http.Request) {
+	var changed []string
+
+	for _, p := range r.URL.Query()["path"] {
+		changed = append(changed, strings.TrimPrefix(path.Clean("/"+p), "/"))
+	}
+
+	h.invalidate(changed)
+
+	target := "all"
+	if changed != nil {
+		target = strings.Join(changed, ",")
+	}
+
+	token, _ := adminTokenFor(r)
+
+	auditLog.Record(AuditEvent{
+		Action:  "purge",
+		Actor:   "token:" + token.name,
+		Result:  "ok",
+		Details: map[string]string{"paths": target},
+	})
+	logging.Warn("purged %s", target)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// Affinity pins clients to this instance with a cookie that load balancers
+// can use for sticky sessions, and tags log lines with the instance ID.
+type Affinity struct {
+	cookie   string
+	instance string
+}
+
+// NewAffinity creates an Affinity setting cookie to instance, defaulting the
+// instance ID to the hostname.
+func NewAffinity(cookie string, instance string) *Affinity {
+	if len(instance) == 0 {
+		instance, _ = os.Hostname()
+	}
+
+	return &Affinity{
+		cookie:   cookie,
+		instance: instance,
+	}
+}
+
+// Wrap sets the affinity cookie when the client doesn't already carry this
+// instance's ID before handing off to next.
+func (a *Affinity) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tag := a.instance
+
+		c, err := r.Cookie(a.cookie)
+		if err != nil || c.Value != a.instance {
+			if err == nil && len(c.Value) > 0 {
+				// the balancer moved this client, log where it came from
+				tag = c.Value + "->" + a.instance
+			}
+
+			http.SetCookie(w, &http.Cookie{
+				Name:     a.cookie,
+				Value:    a.instance,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		next.ServeHTTP(w, logging.WithTag(r, "{"+tag+"}"))
+	})
+}
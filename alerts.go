@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+const (
+	// alertResolution is the width of each bucket of response counts.
+	alertResolution = 10 * time.Second
+
+	// alertHistory is how far back counts are kept: the longest window
+	// plus the hour a spike is compared against.
+	alertHistory = 2 * time.Hour
+
+	// maxAlertWindow leaves room in the history for a spike's baseline.
+	maxAlertWindow = time.Hour
+
+	// minBaselineShare keeps a spike rule from firing on a single response
+	// when there were none in the baseline.
+	minBaselineShare = 0.01
+)
+
+var alertRulePattern = regexp.MustCompile(`^(5xx|4xx|404)>([0-9.]+)(%|x)/([0-9a-z]+)$`)
+
+// alertRule fires when the share of responses of a kind over a window
+// exceeds a percentage, or a multiple of its share over the hour before.
+type alertRule struct {
+	raw    string
+	kind   string
+	limit  float64
+	spike  bool
+	window time.Duration
+	firing bool
+}
+
+// parseAlertRules parses KIND>N%/WINDOW and KIND>Nx/WINDOW rules, where KIND
+// is 5xx, 4xx, or 404, e.g. 5xx>5%/5m or 404>3x/10m.
+func parseAlertRules(raw []string) ([]*alertRule, error) {
+	rules := make([]*alertRule, 0, len(raw))
+
+	for _, r := range raw {
+		m := alertRulePattern.FindStringSubmatch(r)
+		if m == nil {
+			return nil, fmt.Errorf("invalid alert %q, expected KIND>N%%/WINDOW or KIND>Nx/WINDOW with KIND 5xx, 4xx, or 404", r)
+		}
+
+		limit, err := strconv.ParseFloat(m[2], 64)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid alert %q, the threshold must be positive", r)
+		}
+
+		window, err := time.ParseDuration(m[4])
+		if err != nil || window < alertResolution || window > maxAlertWindow {
+			return nil, fmt.Errorf("invalid alert %q, the window must be between %s and %s", r, alertResolution, maxAlertWindow)
+		}
+
+		rule := &alertRule{raw: r, kind: m[1], limit: limit, spike: m[3] == "x", window: window}
+		if !rule.spike {
+			rule.limit /= 100
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// alertCounts are the responses counted in one bucket.
+type alertCounts struct {
+	slot  int64
+	total int
+	kinds map[string]int
+}
+
+// Alerter counts responses by status and fires a webhook and/or command
+// when an alert rule starts or stops matching, for installs without
+// external monitoring.
+type Alerter struct {
+	rules       []*alertRule
+	minRequests int
+	webhook     string
+	command     []string
+	client      *http.Client
+
+	mu      sync.Mutex
+	buckets []alertCounts
+}
+
+// NewAlerter creates an alerter checking rules once a window has at least
+// minRequests responses. webhook and command may be empty.
+func NewAlerter(rules []*alertRule, minRequests int, webhook string, command string) *Alerter {
+	return &Alerter{
+		rules:       rules,
+		minRequests: minRequests,
+		webhook:     webhook,
+		command:     strings.Fields(command),
+		client:      &http.Client{Timeout: 10 * time.Second},
+		buckets:     make([]alertCounts, alertHistory/alertResolution),
+	}
+}
+
+// Wrap counts the status of every response to next, leaving out health
+// checks and the server's own endpoints.
+func (a *Alerter) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/readyz" || strings.HasPrefix(r.URL.Path, "/_") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sw := trackResponse(w)
+		next.ServeHTTP(sw, r)
+
+		a.record(time.Now(), sw.Status())
+	})
+}
+
+func (a *Alerter) record(now time.Time, status int) {
+	slot := now.UnixNano() / int64(alertResolution)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	b := &a.buckets[slot%int64(len(a.buckets))]
+	if b.slot != slot {
+		*b = alertCounts{slot: slot, kinds: map[string]int{}}
+	}
+
+	b.total++
+
+	switch {
+	case status >= 500:
+		b.kinds["5xx"]++
+	case status >= 400:
+		b.kinds["4xx"]++
+
+		if status == http.StatusNotFound {
+			b.kinds["404"]++
+		}
+	}
+}
+
+// share returns how many responses there were between from and to, and
+// what share of them were of kind.
+func (a *Alerter) share(kind string, from time.Time, to time.Time) (int, float64) {
+	first := from.UnixNano() / int64(alertResolution)
+	last := to.UnixNano() / int64(alertResolution)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	total, matched := 0, 0
+
+	for _, b := range a.buckets {
+		if b.slot > first && b.slot <= last {
+			total += b.total
+			matched += b.kinds[kind]
+		}
+	}
+
+	if total == 0 {
+		return 0, 0
+	}
+
+	return total, float64(matched) / float64(total)
+}
+
+// Run checks the rules every alertResolution for as long as the server
+// runs.
+func (a *Alerter) Run() {
+	for now := range time.Tick(alertResolution) {
+		a.check(now)
+	}
+}
+
+func (a *Alerter) check(now time.Time) {
+	for _, rule := range a.rules {
+		total, share := a.share(rule.kind, now.Add(-rule.window), now)
+		if total < a.minRequests {
+			continue // too quiet to tell
+		}
+
+		threshold := rule.limit
+
+		if rule.spike {
+			baselineTotal, baseline := a.share(rule.kind, now.Add(-rule.window-time.Hour), now.Add(-rule.window))
+			if baselineTotal < a.minRequests {
+				continue
+			}
+
+			if baseline < minBaselineShare {
+				baseline = minBaselineShare
+			}
+
+			threshold = baseline * rule.limit
+		}
+
+		if matching := share > threshold; matching != rule.firing {
+			rule.firing = matching
+			a.notify(rule, share, total)
+		}
+	}
+}
+
+// alertNotice is the webhook's JSON body. Text lets it go straight to a
+// Slack or Mattermost incoming webhook.
+type alertNotice struct {
+	Rule     string  `json:"rule"`
+	State    string  `json:"state"`
+	Share    float64 `json:"share"`
+	Requests int     `json:"requests"`
+	Instance string  `json:"instance,omitempty"`
+	Text     string  `json:"text"`
+}
+
+func (a *Alerter) notify(rule *alertRule, share float64, total int) {
+	notice := alertNotice{
+		Rule:     rule.raw,
+		State:    "resolved",
+		Share:    share,
+		Requests: total,
+		Instance: args.InstanceID,
+	}
+
+	if rule.firing {
+		notice.State = "firing"
+	}
+
+	notice.Text = fmt.Sprintf("spa-server alert %s: %s (%.1f%% of %d requests over %s)", notice.State, rule.raw, share*100, total, rule.window)
+
+	if rule.firing {
+		logging.Error("%s", notice.Text)
+	} else {
+		logging.Success("%s", notice.Text)
+	}
+
+	if len(a.webhook) > 0 {
+		go a.post(notice)
+	}
+
+	if len(a.command) > 0 {
+		go a.run(notice)
+	}
+}
+
+func (a *Alerter) post(notice alertNotice) {
+	body, err := json.Marshal(notice)
+	if err != nil {
+		logging.Error("unable to send alert: %s", err)
+		return
+	}
+
+	resp, err := a.client.Post(a.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logging.Error("unable to send alert: %s", err)
+		return
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		logging.Error("unable to send alert: webhook returned %s", resp.Status)
+	}
+}
+
+func (a *Alerter) run(notice alertNotice) {
+	cmd := exec.Command(a.command[0], a.command[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"ALERT_RULE="+notice.Rule,
+		"ALERT_STATE="+notice.State,
+		"ALERT_SHARE="+strconv.FormatFloat(notice.Share, 'f', 4, 64),
+		"ALERT_REQUESTS="+strconv.Itoa(notice.Requests),
+		"ALERT_TEXT="+notice.Text,
+	)
+
+	err := cmd.Run()
+	if err != nil {
+		logging.Error("alert command failed: %s", err)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseAlertRules(t *testing.T) {
+	tests := []struct {
+		raw     string
+		kind    string
+		limit   float64
+		spike   bool
+		window  time.Duration
+		wantErr bool
+	}{
+		{raw: "5xx>5%/5m", kind: "5xx", limit: 0.05, window: 5 * time.Minute},
+		{raw: "404>3x/10m", kind: "404", limit: 3, spike: true, window: 10 * time.Minute},
+		{raw: "4xx>0.5%/30s", kind: "4xx", limit: 0.005, window: 30 * time.Second},
+		{raw: "3xx>5%/5m", wantErr: true},
+		{raw: "5xx>0%/5m", wantErr: true},
+		{raw: "5xx>5%/1s", wantErr: true},
+		{raw: "5xx>5%/2h", wantErr: true},
+		{raw: "5xx>5/5m", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		rules, err := parseAlertRules([]string{tt.raw})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseAlertRules(%q) error = %v, want error %v", tt.raw, err, tt.wantErr)
+			continue
+		}
+
+		if err != nil {
+			continue
+		}
+
+		r := rules[0]
+		if r.kind != tt.kind || r.limit != tt.limit || r.spike != tt.spike || r.window != tt.window {
+			t.Errorf("parseAlertRules(%q) = %s %v %v %s, want %s %v %v %s", tt.raw, r.kind, r.limit, r.spike, r.window, tt.kind, tt.limit, tt.spike, tt.window)
+		}
+	}
+}
+
+// alertsAt records count responses with status at when.
+func alertsAt(a *Alerter, when time.Time, status int, count int) {
+	for i := 0; i < count; i++ {
+		a.record(when, status)
+	}
+}
+
+func TestAlerterThreshold(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	rules, err := parseAlertRules([]string{"5xx>5%/1m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewAlerter(rules, 20, "", "")
+	rule := rules[0]
+
+	// too few requests to judge, even though they all failed
+	alertsAt(a, now.Add(-30*time.Second), http.StatusInternalServerError, 5)
+	a.check(now)
+
+	if rule.firing {
+		t.Fatal("fired on fewer than --alert-min-requests responses")
+	}
+
+	// 5 of 105 is under 5%
+	alertsAt(a, now.Add(-30*time.Second), http.StatusOK, 100)
+	a.check(now)
+
+	if rule.firing {
+		t.Fatal("fired at 4.8% with a 5% threshold")
+	}
+
+	// 10 of 110 is over
+	alertsAt(a, now.Add(-20*time.Second), http.StatusBadGateway, 5)
+	a.check(now)
+
+	if !rule.firing {
+		t.Fatal("didn't fire at 9.1% with a 5% threshold")
+	}
+
+	// the failures age out of the window
+	later := now.Add(2 * time.Minute)
+	alertsAt(a, later.Add(-10*time.Second), http.StatusOK, 50)
+	a.check(later)
+
+	if rule.firing {
+		t.Error("still firing once the errors left the window")
+	}
+}
+
+func TestAlerterSpike(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name     string
+		baseline int // 404s in 1000 responses over the hour before
+		recent   int // 404s in 100 responses over the window
+		want     bool
+	}{
+		{"tripled", 10, 5, true},
+		{"steady", 10, 1, false},
+		{"under three times", 20, 5, false},
+		{"no baseline 404s", 0, 2, false}, // the floor keeps 2% from being infinitely more
+		{"no baseline 404s, real spike", 0, 5, true},
+	}
+
+	for _, tt := range tests {
+		rules, err := parseAlertRules([]string{"404>3x/10m"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a := NewAlerter(rules, 20, "", "")
+
+		base := now.Add(-40 * time.Minute)
+		alertsAt(a, base, http.StatusNotFound, tt.baseline)
+		alertsAt(a, base, http.StatusOK, 1000-tt.baseline)
+
+		recent := now.Add(-time.Minute)
+		alertsAt(a, recent, http.StatusNotFound, tt.recent)
+		alertsAt(a, recent, http.StatusOK, 100-tt.recent)
+
+		a.check(now)
+
+		if rules[0].firing != tt.want {
+			t.Errorf("%s: firing = %v, want %v", tt.name, rules[0].firing, tt.want)
+		}
+	}
+}
+
+func TestAlerterWebhook(t *testing.T) {
+	notices := make(chan alertNotice, 4)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var notice alertNotice
+
+		err := json.NewDecoder(r.Body).Decode(&notice)
+		if err != nil {
+			t.Errorf("bad webhook body: %s", err)
+		}
+
+		notices <- notice
+	}))
+	defer srv.Close()
+
+	rules, err := parseAlertRules([]string{"5xx>5%/1m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewAlerter(rules, 1, srv.URL, "")
+	now := time.Unix(1700000000, 0)
+
+	alertsAt(a, now.Add(-30*time.Second), http.StatusInternalServerError, 10)
+	a.check(now)
+	a.check(now.Add(alertResolution)) // still firing, no second notice
+
+	alertsAt(a, now.Add(2*time.Minute), http.StatusOK, 10)
+	a.check(now.Add(2*time.Minute + time.Second))
+
+	// each notice is posted on its own, so they may arrive in any order
+	states := map[string]int{}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case notice := <-notices:
+			if notice.Rule != "5xx>5%/1m" {
+				t.Errorf("webhook got rule %s, want 5xx>5%%/1m", notice.Rule)
+			}
+
+			states[notice.State]++
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d notices, want 2", i)
+		}
+	}
+
+	if states["firing"] != 1 || states["resolved"] != 1 {
+		t.Errorf("webhook got %v, want one firing and one resolved notice", states)
+	}
+
+	select {
+	case notice := <-notices:
+		t.Errorf("unexpected %s notice", notice.State)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// analyticsPrefix is where the analytics dashboard and beacon are served.
+const analyticsPrefix = "/_analytics"
+
+// analyticsCookie carries the token for the dashboard, see
+// browserAuthorized.
+const analyticsCookie = "spa_analytics"
+
+// maxAnalyticsRoutes bounds the routes counted each day. Views of routes
+// past it are counted under otherRoutes.
+const maxAnalyticsRoutes = 1000
+
+const otherRoutes = "(other)"
+
+// maxBeaconPath bounds the paths the beacon accepts.
+const maxBeaconPath = 512
+
+// botAgents matches the user agents of crawlers, which aren't counted.
+var botAgents = regexp.MustCompile(`(?i)bot|crawl|spider|slurp|headless`)
+
+// Analytics counts page views and unique visitors per route without
+// storing anything that identifies a visitor. Visitors are told apart by a
+// hash of their address and user agent with a salt that's replaced, and
+// forgotten, every day, so they can't be followed from one day to the next
+// and the hashes can't be reversed. Only the counts are kept for past days.
+type Analytics struct {
+	days int
+
+	mu      sync.Mutex
+	day     string
+	salt    []byte
+	today   map[string]*routeDay
+	history []analyticsDay // oldest first
+}
+
+// routeDay is today's views of a route and the visitors seen.
+type routeDay struct {
+	views    int
+	visitors map[uint64]struct{}
+}
+
+// analyticsDay is a past day's counts by route.
+type analyticsDay struct {
+	date   string
+	routes map[string]routeTotal
+}
+
+// routeTotal is a route's views and unique visitors over some days.
+type routeTotal struct {
+	Route   string `json:"route"`
+	Views   int    `json:"views"`
+	Uniques int    `json:"uniques"`
+}
+
+// NewAnalytics creates analytics keeping days days of counts.
+func NewAnalytics(days int) *Analytics {
+	return &Analytics{days: days}
+}
+
+// View counts a view of route by the visitor behind r.
+func (a *Analytics) View(r *http.Request, route string) {
+	if botAgents.MatchString(r.UserAgent()) {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.rotate(time.Now())
+
+	visitor := sha256.Sum256(append(append([]byte{}, a.salt...), clientKey(r)...))
+
+	rd, ok := a.today[route]
+	if !ok {
+		if len(a.today) >= maxAnalyticsRoutes {
+			route = otherRoutes
+			rd, ok = a.today[route]
+		}
+
+		if !ok {
+			rd = &routeDay{visitors: map[uint64]struct{}{}}
+			a.today[route] = rd
+		}
+	}
+
+	rd.views++
+	rd.visitors[binary.BigEndian.Uint64(visitor[:8])] = struct{}{}
+}
+
+// rotate starts a new day with a new salt once the date changes, keeping
+// only the counts of the day that ended. a.mu must be held.
+func (a *Analytics) rotate(now time.Time) {
+	day := now.UTC().Format("2006-01-02")
+	if day == a.day {
+		return
+	}
+
+	if len(a.day) > 0 {
+		a.history = append(a.history, analyticsDay{date: a.day, routes: a.totals()})
+
+		if len(a.history) > a.days-1 {
+			a.history = a.history[len(a.history)-(a.days-1):]
+		}
+	}
+
+	a.salt = make([]byte, 32)
+	_, _ = rand.Read(a.salt)
+
+	a.day = day
+	a.today = map[string]*routeDay{}
+}
+
+// totals returns today's counts by route. a.mu must be held.
+func (a *Analytics) totals() map[string]routeTotal {
+	totals := make(map[string]routeTotal, len(a.today))
+	for route, rd := range a.today {
+		totals[route] = routeTotal{Route: route, Views: rd.views, Uniques: len(rd.visitors)}
+	}
+
+	return totals
+}
+
+// Report returns the views and uniques by route over the last days days,
+// including today, most viewed first. Uniques are summed over the days,
+// since the daily salt keeps a visitor from being recognized the next day.
+func (a *Analytics) Report(days int) []routeTotal {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.rotate(time.Now())
+
+	sums := a.totals()
+
+	for i := len(a.history) - 1; i >= 0 && i >= len(a.history)-(days-1); i-- {
+		for route, t := range a.history[i].routes {
+			sum := sums[route]
+			sum.Route = route
+			sum.Views += t.Views
+			sum.Uniques += t.Uniques
+			sums[route] = sum
+		}
+	}
+
+	report := make([]routeTotal, 0, len(sums))
+	for _, t := range sums {
+		report = append(report, t)
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Views != report[j].Views {
+			return report[i].Views > report[j].Views
+		}
+
+		return report[i].Route < report[j].Route
+	})
+
+	return report
+}
+
+// ServeBeacon counts a client-side navigation the app reports with
+// navigator.sendBeacon("/_analytics/view?path=" + location.pathname), since
+// those never reach the server otherwise.
+func (a *Analytics) ServeBeacon(w http.ResponseWriter, r *http.Request) {
+	route := r.URL.Query().Get("path")
+	if !strings.HasPrefix(route, "/") || len(route) > maxBeaconPath {
+		http.Error(w, "missing or invalid path", http.StatusBadRequest)
+		return
+	}
+
+	a.View(r, route)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+var analyticsPage = template.Must(template.New("analytics").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Analytics</title>
+<style>
+body { font: 14px sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.3em 1em; text-align: right; border-bottom: 1px solid #ddd; }
+th:first-child, td:first-child { text-align: left; }
+</style>
+</head>
+<body>
+<h1>Page views, last {{.Days}} day{{if ne .Days 1}}s{{end}}</h1>
+<p>{{range .Choices}}<a href="?days={{.}}">{{.}}d</a> {{end}}</p>
+<table>
+<tr><th>Route</th><th>Views</th><th>Uniques</th></tr>
+{{range .Routes}}<tr><td>{{.Route}}</td><td>{{.Views}}</td><td>{{.Uniques}}</td></tr>
+{{else}}<tr><td colspan="3">No views yet</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// ServeHTTP shows the dashboard, or the report as JSON with ?format=json,
+// for ?days=N days, 7 by default.
+func (a *Analytics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !browserAuthorized(w, r, "analytics", analyticsCookie, analyticsPrefix) {
+		return
+	}
+
+	days, err := strconv.Atoi(r.URL.Query().Get("days"))
+	if err != nil || days < 1 {
+		days = 7
+	}
+
+	if days > a.days {
+		days = a.days
+	}
+
+	report := a.Report(days)
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.URL.Query().Get("format") == "json" {
+		body, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(append(body, '\n'))
+
+		return
+	}
+
+	var choices []int
+	for _, choice := range []int{1, 7, 30, 90} {
+		if choice <= a.days {
+			choices = append(choices, choice)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = analyticsPage.Execute(w, struct {
+		Days    int
+		Choices []int
+		Routes  []routeTotal
+	}{days, choices, report})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// AuditEvent is one line of the audit log.
+type AuditEvent struct {
+	Time    time.Time         `json:"time"`
+	Action  string            `json:"action"`
+	Actor   string            `json:"actor"`
+	Result  string            `json:"result"` // "ok", "denied", or "failed"
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// AuditLog appends a JSON line for each administrative action, kept apart
+// from the request log so it can be retained and protected on its own.
+type AuditLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+var auditLog *AuditLog
+
+// OpenAuditLog opens path for appending, creating it readable only by the
+// server's user if needed.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuditLog{file: file}, nil
+}
+
+// Record writes ev, stamping it with the current time. It does nothing if
+// there's no audit log.
+func (al *AuditLog) Record(ev AuditEvent) {
+	if al == nil {
+		return
+	}
+
+	ev.Time = time.Now().UTC()
+
+	line, err := json.Marshal(ev)
+	if err != nil {
+		logging.Error("unable to audit %s: %s", ev.Action, err)
+		return
+	}
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	// one write per line keeps lines whole with O_APPEND
+	_, err = al.file.Write(append(line, '\n'))
+	if err == nil {
+		err = al.file.Sync()
+	}
+
+	if err != nil {
+		logging.Error("unable to audit %s: %s", ev.Action, err)
+	}
+}
+
+// Close closes the log.
+func (al *AuditLog) Close() error {
+	if al == nil {
+		return nil
+	}
+
+	return al.file.Close()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	al, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	al.Record(AuditEvent{Action: "deploy", Actor: "10.0.0.1:1234", Result: "denied"})
+	al.Record(AuditEvent{Action: "rollback", Actor: "token:ops", Result: "ok", Details: map[string]string{"release": "001"}})
+
+	if err := al.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopening appends rather than truncating
+	al, err = OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	al.Record(AuditEvent{Action: "purge", Actor: "token:ops", Result: "failed"})
+
+	if err := al.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("audit log mode %o, want 600", perm)
+	}
+
+	events := readAuditLog(t, path)
+
+	want := []AuditEvent{
+		{Action: "deploy", Actor: "10.0.0.1:1234", Result: "denied"},
+		{Action: "rollback", Actor: "token:ops", Result: "ok", Details: map[string]string{"release": "001"}},
+		{Action: "purge", Actor: "token:ops", Result: "failed"},
+	}
+
+	if len(events) != len(want) {
+		t.Fatalf("audit log has %d events, want %d", len(events), len(want))
+	}
+
+	for i, ev := range events {
+		w := want[i]
+		if ev.Action != w.Action || ev.Actor != w.Actor || ev.Result != w.Result || ev.Details["release"] != w.Details["release"] {
+			t.Errorf("event %d = %+v, want %+v", i, ev, w)
+		}
+
+		if ev.Time.IsZero() || time.Since(ev.Time) > time.Minute || ev.Time.Location() != time.UTC {
+			t.Errorf("event %d is stamped %s, want the current UTC time", i, ev.Time)
+		}
+	}
+}
+
+func TestAuditLogConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	al, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer al.Close()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			al.Record(AuditEvent{Action: "deploy", Actor: "sync", Result: "ok"})
+		}()
+	}
+
+	wg.Wait()
+
+	// every line must still parse
+	if events := readAuditLog(t, path); len(events) != 20 {
+		t.Errorf("audit log has %d events, want 20", len(events))
+	}
+}
+
+func TestAuditLogDisabled(t *testing.T) {
+	var al *AuditLog
+
+	// without --audit-log there's no log, and recording does nothing
+	al.Record(AuditEvent{Action: "deploy", Actor: "sync", Result: "ok"})
+
+	if err := al.Close(); err != nil {
+		t.Errorf("Close() = %v on a nil log", err)
+	}
+}
+
+// readAuditLog parses each line of the audit log at path.
+func readAuditLog(t *testing.T, path string) []AuditEvent {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var events []AuditEvent
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var ev AuditEvent
+
+		err := json.Unmarshal(scanner.Bytes(), &ev)
+		if err != nil {
+			t.Fatalf("bad audit line %q: %s", scanner.Text(), err)
+		}
+
+		events = append(events, ev)
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return events
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// autoTLSConfig builds a TLS config that gets certificates for the
+// --auto-tls domains from Let's Encrypt on first use, keeps them in the
+// --auto-tls-cache, and renews them before they expire. HTTP-01 challenges
+// are answered on --auto-tls-http-port, which redirects everything else to
+// HTTPS, and TLS-ALPN-01 challenges on the HTTPS port itself.
+func autoTLSConfig() (*tls.Config, error) {
+	if len(args.TLSCert) > 0 || len(args.TLSKey) > 0 || args.OCSPStaple {
+		return nil, errors.New("--auto-tls can't be combined with --tls-cert, --tls-key, or --ocsp-staple")
+	}
+
+	if args.Port == args.AutoTLSHTTPPort {
+		return nil, errors.New("--auto-tls serves HTTPS on --port, which can't also be the --auto-tls-http-port, e.g. use --port 443")
+	}
+
+	cache := args.AutoTLSCache
+	if len(cache) == 0 {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+
+		cache = filepath.Join(dir, "spa-server", "autocert")
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cache),
+		HostPolicy: autocert.HostWhitelist(args.AutoTLS...),
+		Email:      args.AutoTLSEmail,
+	}
+
+	if args.AutoTLSHTTPPort > 0 {
+		go serveHTTPChallenges(m)
+	}
+
+	cfg := m.TLSConfig()
+	cfg.MinVersion = tls.VersionTLS12
+
+	logging.Info("certificates for %v are kept in %s", args.AutoTLS, cache)
+
+	return cfg, nil
+}
+
+// serveHTTPChallenges answers HTTP-01 challenges for m and redirects every
+// other plain HTTP request to HTTPS.
+func serveHTTPChallenges(m *autocert.Manager) {
+	ln, err := listen(ipNetworks[args.IPFamily], []int{args.AutoTLSHTTPPort}, isWorker())
+	if err != nil {
+		logging.Error("unable to listen for HTTP challenges, only TLS-ALPN challenges will work: %s", err)
+		return
+	}
+
+	srv := &http.Server{
+		Handler:           m.HTTPHandler(nil),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	err = srv.Serve(ln)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logging.Error("unable to serve HTTP challenges: %s", err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// logStartup logs the event tooling can check the server started as
+// intended with: where it listens, what it serves, and how. Its fields are
+// resolved from the flags after the handler was built, so e.g. --load shows
+// as the cache mode, and they're the same, in the same order, for the same
+// flags. With --log-format json it's one line of JSON.
+func logStartup(scheme string, addr net.Addr, families string) {
+	logging.Logger().LogAttrs(context.Background(), slog.LevelInfo, "listening", startupAttrs(scheme, addr, families)...)
+}
+
+// startupAttrs are the fields of the startup event.
+func startupAttrs(scheme string, addr net.Addr, families string) []slog.Attr {
+	listen := []string{scheme + "://" + addr.String()}
+
+	if len(args.AutoTLS) > 0 && args.AutoTLSHTTPPort > 0 {
+		host, _, _ := net.SplitHostPort(addr.String())
+		listen = append(listen, "http://"+net.JoinHostPort(host, strconv.Itoa(args.AutoTLSHTTPPort)))
+	}
+
+	tlsMode := "off"
+
+	switch {
+	case len(args.AutoTLS) > 0:
+		tlsMode = "auto"
+	case len(args.TLSCert) > 0:
+		tlsMode = "certs"
+	}
+
+	cacheMode := "off"
+
+	switch {
+	case args.LoadCache:
+		cacheMode = "load"
+	case args.Index:
+		cacheMode = "index"
+	case args.MemCache:
+		cacheMode = "memory"
+	}
+
+	return []slog.Attr{
+		slog.String("version", serverVersion()),
+		slog.Any("listen", listen),
+		slog.String("families", families),
+		slog.String("dir", args.Positional.Directory),
+		slog.String("tls", tlsMode),
+		slog.Group("cache",
+			slog.String("mode", cacheMode),
+			slog.String("ttl", args.CacheTTL.String()),
+			slog.String("limit", args.CacheLimit),
+		),
+		slog.Any("features", enabledFeatures()),
+	}
+}
+
+// enabledFeatures returns the long names of the switches that are on,
+// sorted.
+func enabledFeatures() []string {
+	features := []string{}
+
+	v := reflect.ValueOf(args)
+
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Tag.Get("long")
+
+		if v.Field(i).Kind() == reflect.Bool && v.Field(i).Bool() && len(name) > 0 {
+			features = append(features, name)
+		}
+	}
+
+	sort.Strings(features)
+
+	return features
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"html"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+var (
+	baseTag  = regexp.MustCompile(`(?is)<base\b[^>]*>`)
+	hrefAttr = regexp.MustCompile(`(?is)\shref\s*=\s*("[^"]*"|'[^']*'|[^\s>]+)`)
+	headTag  = regexp.MustCompile(`(?is)<head\b[^>]*>`)
+	htmlTag  = regexp.MustCompile(`(?is)<html\b[^>]*>`)
+	urlAttr  = regexp.MustCompile(`(?is)(\s(?:src|href)\s*=\s*)("[^"]*"|'[^']*'|[^\s>"']+)`)
+)
+
+// baseHref returns the <base href> for r per --base-href and the mount of
+// the release serving it, or an empty string if HTML shouldn't be touched.
+// "forwarded" uses the prefix sent by a trusted proxy, falling back to the
+// site root.
+func baseHref(w http.ResponseWriter, r *http.Request) string {
+	mount := mountPrefix(r)
+
+	if len(args.BaseHref) == 0 && len(mount) == 0 {
+		return ""
+	}
+
+	base := args.BaseHref
+
+	if base == "forwarded" {
+		w.Header().Add("Vary", "X-Forwarded-Prefix")
+		base = forwardedPrefix(r)
+	}
+
+	if len(mount) > 0 {
+		// a mounted release resolves relative URLs under its mount
+		base = strings.TrimSuffix(base, "/") + mount
+	}
+
+	if !strings.HasSuffix(base, "/") {
+		// without the slash the last segment would be dropped when
+		// resolving relative URLs
+		base += "/"
+	}
+
+	return base
+}
+
+// setBaseHref points the document's <base> at href, rewriting an existing
+// tag or adding one at the start of <head> (or <html> if there's no head).
+func setBaseHref(content []byte, href string) []byte {
+	attr := ` href="` + html.EscapeString(href) + `"`
+
+	if loc := baseTag.FindIndex(content); loc != nil {
+		tag := content[loc[0]:loc[1]]
+
+		var rewritten []byte
+		if hrefAttr.Match(tag) {
+			rewritten = hrefAttr.ReplaceAllLiteral(tag, []byte(attr))
+		} else {
+			rewritten = append([]byte("<base"+attr), tag[len("<base"):]...)
+		}
+
+		return splice(content, loc[0], loc[1], rewritten)
+	}
+
+	loc := headTag.FindIndex(content)
+	if loc == nil {
+		loc = htmlTag.FindIndex(content)
+	}
+
+	if loc == nil {
+		return content
+	}
+
+	return splice(content, loc[1], loc[1], []byte("<base"+attr+">"))
+}
+
+// mountURLs rewrites the root-absolute src and href attributes in content,
+// those under root like /assets/app.js, to point under mount instead, so a
+// mounted release loads its own assets rather than the current release's.
+// Protocol-relative URLs and URLs already under the mount are left alone.
+func mountURLs(content []byte, root string, mount string) []byte {
+	mounted := root + strings.TrimPrefix(mount, "/") + "/"
+
+	return urlAttr.ReplaceAllFunc(content, func(attr []byte) []byte {
+		m := urlAttr.FindSubmatch(attr)
+		value, quote := string(m[2]), ""
+
+		if len(value) > 0 && (value[0] == '"' || value[0] == '\'') {
+			value, quote = value[1:len(value)-1], value[:1]
+		}
+
+		if !strings.HasPrefix(value, root) || strings.HasPrefix(value, "//") || strings.HasPrefix(value, mounted) {
+			return attr
+		}
+
+		return []byte(string(m[1]) + quote + mounted + strings.TrimPrefix(value, root) + quote)
+	})
+}
+
+// splice returns a copy of content with content[start:end] replaced by
+// insert.
+func splice(content []byte, start int, end int, insert []byte) []byte {
+	out := make([]byte, 0, len(content)-(end-start)+len(insert))
+	out = append(out, content[:start]...)
+	out = append(out, insert...)
+
+	return append(out, content[end:]...)
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestMountURLs(t *testing.T) {
+	tests := []struct {
+		root, in, want string
+	}{
+		{"/", `<script src="/assets/index-abc.js"></script>`, `<script src="/_previous/assets/index-abc.js"></script>`},
+		{"/", `<link rel="stylesheet" href='/styles/app.css'>`, `<link rel="stylesheet" href='/_previous/styles/app.css'>`},
+		{"/", `<a href=/settings>`, `<a href=/_previous/settings>`},
+		{"/", `<img src="logo.svg">`, `<img src="logo.svg">`},
+		{"/", `<script src="//cdn.example.com/lib.js"></script>`, `<script src="//cdn.example.com/lib.js"></script>`},
+		{"/", `<script src="https://cdn.example.com/lib.js"></script>`, `<script src="https://cdn.example.com/lib.js"></script>`},
+		{"/", `<script src="/_previous/assets/index-abc.js"></script>`, `<script src="/_previous/assets/index-abc.js"></script>`},
+		{"/app/", `<script src="/app/assets/index-abc.js"></script>`, `<script src="/app/_previous/assets/index-abc.js"></script>`},
+		{"/app/", `<a href="/elsewhere">`, `<a href="/elsewhere">`},
+	}
+
+	for _, tt := range tests {
+		got := string(mountURLs([]byte(tt.in), tt.root, previousPrefix))
+		if got != tt.want {
+			t.Errorf("mountURLs(%q, %q) = %q, want %q", tt.in, tt.root, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/cache"
+	"github.com/coreyog/spa-server/internal/logging"
+	"github.com/coreyog/spa-server/internal/resolver"
+	"github.com/coreyog/spa-server/internal/responder"
+	"golang.org/x/sync/singleflight"
+)
+
+// buildVersionPattern is what a build version may look like, which also
+// keeps it from naming anything outside the builds directory.
+var buildVersionPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// Builds serves retained builds, one subdirectory of dir per version, to
+// requests that pin a version with a query parameter or header, e.g. an
+// embedded widget loading /widget.js?v=2.3.1. Requests without a version
+// are served from DIR as usual.
+type Builds struct {
+	dir    string
+	param  string
+	header string
+	keep   int
+	serve  func(dir string) (http.Handler, error)
+
+	mu       sync.Mutex
+	handlers map[string]http.Handler
+}
+
+// NewBuilds creates a selector for the builds in dir. serve creates the
+// handler for one build's directory, and keep, if positive, is how many of
+// the newest builds are retained.
+func NewBuilds(dir string, param string, header string, keep int, serve func(dir string) (http.Handler, error)) (*Builds, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	return &Builds{
+		dir:      dir,
+		param:    param,
+		header:   header,
+		keep:     keep,
+		serve:    serve,
+		handlers: map[string]http.Handler{},
+	}, nil
+}
+
+// Wrap serves requests pinning a version from that build, answering 404 for
+// versions that aren't retained, and hands the rest to next.
+func (b *Builds) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(b.header) > 0 {
+			w.Header().Add("Vary", b.header)
+		}
+
+		version := b.version(r)
+		if len(version) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		explainf(r, "pinned to build %s", version)
+
+		handler, err := b.handler(version)
+		if err != nil {
+			logging.RequestError(r, "%s => ??? (build %s: %s)", r.URL.Path, version, err)
+			http.Error(w, "unknown build", http.StatusNotFound)
+
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// version returns the build r asks for, if any.
+func (b *Builds) version(r *http.Request) string {
+	if len(b.param) > 0 {
+		if v := r.URL.Query().Get(b.param); len(v) > 0 {
+			return v
+		}
+	}
+
+	if len(b.header) > 0 {
+		return r.Header.Get(b.header)
+	}
+
+	return ""
+}
+
+// handler returns the handler for version's build, creating it the first
+// time it's asked for.
+func (b *Builds) handler(version string) (http.Handler, error) {
+	if !buildVersionPattern.MatchString(version) {
+		return nil, fmt.Errorf("invalid version")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if handler, ok := b.handlers[version]; ok {
+		return handler, nil
+	}
+
+	dir := filepath.Join(b.dir, version)
+
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("not retained")
+	}
+
+	handler, err := b.serve(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	b.handlers[version] = handler
+
+	return handler, nil
+}
+
+// Prune removes all but the newest keep builds, by modification time.
+func (b *Builds) Prune() {
+	if b.keep <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		logging.Error("unable to prune builds: %s", err)
+		return
+	}
+
+	type build struct {
+		version  string
+		modified time.Time
+	}
+
+	var builds []build
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.IsDir() || !buildVersionPattern.MatchString(entry.Name()) {
+			continue
+		}
+
+		builds = append(builds, build{version: entry.Name(), modified: info.ModTime()})
+	}
+
+	sort.Slice(builds, func(i, j int) bool { return builds[i].modified.After(builds[j].modified) })
+
+	for len(builds) > b.keep {
+		old := builds[len(builds)-1]
+		builds = builds[:len(builds)-1]
+
+		b.mu.Lock()
+		delete(b.handlers, old.version)
+		b.mu.Unlock()
+
+		err := os.RemoveAll(filepath.Join(b.dir, old.version))
+		if err != nil {
+			logging.Error("unable to prune build %s: %s", old.version, err)
+			continue
+		}
+
+		logging.Warn("pruned build %s", old.version)
+	}
+}
+
+// Run prunes the builds every interval for as long as the server runs.
+func (b *Builds) Run(interval time.Duration) {
+	b.Prune()
+
+	for range time.Tick(interval) {
+		b.Prune()
+	}
+}
+
+// newBuildHandler serves a build's or old release's directory the way DIR is
+// served, with a cache of its own.
+func newBuildHandler(dir string, variantSize uint64, types *responder.Types) (http.Handler, error) {
+	res, err := resolver.New(dir, args.DefaultDoc...)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &spaHandler{
+		res:   res,
+		store: cache.New(variantSize, nil),
+		types: types,
+		loads: &singleflight.Group{},
+	}
+
+	res.Exists = func(fullpath string) bool {
+		return fileExists(fullpath, h.store)
+	}
+
+	return h, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+)
+
+// cdnCacheControl is sent with assets in --cdn-mode. Assets on a CDN origin
+// are expected to have content-hashed or versioned names.
+const cdnCacheControl = "public, max-age=31536000, immutable"
+
+// fontTypes aren't in Go's built-in MIME table, and browsers are strict
+// about cross-origin fonts.
+var fontTypes = map[string]string{
+	".woff2": "font/woff2",
+	".woff":  "font/woff",
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+	".eot":   "application/vnd.ms-fontobject",
+}
+
+// setupCDNMode applies the --cdn-mode preset: any origin may load and time
+// the assets, fonts get their proper types, and content is served byte for
+// byte so Subresource Integrity hashes match.
+func setupCDNMode() error {
+	switch {
+	case args.Minify:
+		return errors.New("--cdn-mode can't be combined with --minify, which breaks SRI hashes")
+	case args.SSI:
+		return errors.New("--cdn-mode can't be combined with --ssi, which breaks SRI hashes")
+	case len(args.Replace) > 0:
+		return errors.New("--cdn-mode can't be combined with --replace, which breaks SRI hashes")
+	case len(args.BaseHref) > 0:
+		return errors.New("--cdn-mode can't be combined with --base-href, which breaks SRI hashes")
+	case len(args.Slots) > 0:
+		return errors.New("--cdn-mode can't be combined with --slot, which breaks SRI hashes")
+	}
+
+	if len(args.CORSOrigins) == 0 {
+		args.CORSOrigins = []string{"*"}
+	}
+
+	for ext, contentType := range fontTypes {
+		err := mime.AddExtensionType(ext, contentType)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cdnHeaders adds the --cdn-mode headers: Timing-Allow-Origin everywhere,
+// and long-lived caching on successful responses only, so a 404 during a
+// deploy isn't cached for a year.
+func cdnHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Timing-Allow-Origin", "*")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+
+		next.ServeHTTP(&cdnWriter{ResponseWriter: w}, r)
+	})
+}
+
+// cdnWriter sets Cache-Control once the status is known.
+type cdnWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (cw *cdnWriter) WriteHeader(status int) {
+	if !cw.wrote {
+		cw.wrote = true
+
+		if status == http.StatusOK || status == http.StatusPartialContent || status == http.StatusNotModified {
+			cw.Header().Set("Cache-Control", cdnCacheControl)
+		} else {
+			cw.Header().Set("Cache-Control", "no-store")
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *cdnWriter) Write(b []byte) (int, error) {
+	if !cw.wrote {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	return cw.ResponseWriter.Write(b)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (cw *cdnWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
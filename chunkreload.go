@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// defaultChunkReload reloads the page, at most once every 10 seconds per
+// tab so a document referring to a chunk that's really gone can't loop.
+const defaultChunkReload = `(function () {
+  try {
+    var last = Number(sessionStorage.getItem("spa-chunk-reload")) || 0;
+    if (Date.now() - last < 10000) return;
+    sessionStorage.setItem("spa-chunk-reload", String(Date.now()));
+  } catch (e) {}
+  location.reload();
+})();
+`
+
+// ChunkReload answers requests for scripts that don't exist, typically the
+// lazily loaded chunks of a release that's been replaced, with a script
+// that reloads the page instead of the fallback document, which the
+// browser would refuse to run and leave the app on a white screen.
+type ChunkReload struct {
+	script []byte
+}
+
+// NewChunkReload creates the handler sending the script in file, or the
+// default one if file is empty.
+func NewChunkReload(file string) (*ChunkReload, error) {
+	if len(file) == 0 {
+		return &ChunkReload{script: []byte(defaultChunkReload)}, nil
+	}
+
+	script, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ChunkReload{script: script}, nil
+}
+
+// Serve answers a request for relPath, which doesn't exist, if it's for a
+// script, and reports whether it did.
+func (cr *ChunkReload) Serve(w http.ResponseWriter, r *http.Request, relPath string) bool {
+	ext := strings.ToLower(filepath.Ext(relPath))
+	if ext != ".js" && ext != ".mjs" {
+		return false
+	}
+
+	explainf(r, "missing script: sending the reload script")
+	logging.RequestWarn(r, "%s => %s", r.URL.Path, logging.Highlight("reload script"))
+
+	// browsers only run scripts that were fetched successfully
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(cr.script)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Spa-Reload", "true")
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(cr.script)
+	}
+
+	return true
+}
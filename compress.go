@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/coreyog/spa-server/internal/cache"
+	"github.com/coreyog/spa-server/internal/responder"
+)
+
+// contentEncodings are the encodings --compress and --precompressed offer,
+// in order of preference, with the suffix of a precompressed sibling.
+var contentEncodings = []struct {
+	name   string
+	suffix string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// compressible reports whether contentType is worth compressing: text, and
+// the structured and script formats that aren't already compressed.
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(strings.ToLower(mediaType))
+
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+
+	switch mediaType {
+	case "application/javascript", "application/json", "application/manifest+json",
+		"application/xml", "application/wasm", "image/svg+xml", "image/x-icon":
+		return true
+	}
+
+	return strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// encode returns entry encoded for the client, if it accepts an encoding
+// and there's something to gain, setting Content-Encoding and Vary. With
+// --precompressed, a FILE.br or FILE.gz next to an untransformed file is
+// sent as it is; with --compress, the content is compressed. Either way,
+// with memcache the encoded copy is cached beside entry so it's only
+// compressed once.
+func encode(w http.ResponseWriter, r *http.Request, store *cache.Cache, fullpath string, entry *cache.Entry) *cache.Entry {
+	// transformed entries have weak ETags, and a precompressed sibling was
+	// built from the file itself
+	precompressed := args.Precompressed && !strings.HasPrefix(entry.ETag, "W/")
+	compress := args.Compress && compressible(entry.ContentType) && len(entry.Content) >= args.CompressMinSize
+
+	if !precompressed && !compress {
+		return entry
+	}
+
+	addVary(w, "Accept-Encoding")
+
+	accept := r.Header.Get("Accept-Encoding")
+
+	// encoded copies are tied to the content they were made from, which an
+	// ETag identifies and otherwise the time it was loaded
+	variant := entry.ETag
+	if len(variant) == 0 {
+		variant = strconv.FormatInt(entry.Loaded.UnixNano(), 36)
+	}
+
+	for _, ce := range contentEncodings {
+		if !accepts(accept, ce.name) {
+			continue
+		}
+
+		key := cache.Key{Path: fullpath, Encoding: ce.name, Variant: variant}
+
+		var encoded *cache.Entry
+		ok := false
+
+		if args.MemCache {
+			encoded, ok = store.Load(key)
+		}
+
+		if !ok {
+			if precompressed {
+				encoded = loadPrecompressed(fullpath+ce.suffix, entry)
+			}
+
+			if encoded == nil && compress {
+				encoded = compressEntry(ce.name, entry)
+			}
+
+			if encoded == nil {
+				continue
+			}
+
+			if args.MemCache {
+				store.Store(key, encoded)
+			}
+		}
+
+		explainf(r, "sending it %s encoded (%d of %d bytes)", ce.name, len(encoded.Content), len(entry.Content))
+
+		w.Header().Set("Content-Encoding", ce.name)
+
+		return encoded
+	}
+
+	return entry
+}
+
+// loadPrecompressed reads the precompressed sibling of entry at path,
+// returning nil if there isn't one.
+func loadPrecompressed(path string, entry *cache.Entry) *cache.Entry {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	return &cache.Entry{
+		Content:     content,
+		ContentType: entry.ContentType,
+		ETag:        responder.WeakETag(content),
+		Loaded:      entry.Loaded,
+		ModTime:     entry.ModTime,
+	}
+}
+
+// compressEntry compresses entry's content with encoding, returning nil if
+// that doesn't make it any smaller.
+func compressEntry(encoding string, entry *cache.Entry) *cache.Entry {
+	buf := &bytes.Buffer{}
+
+	var err error
+
+	switch encoding {
+	case "br":
+		bw := brotli.NewWriterLevel(buf, brotli.DefaultCompression)
+		_, err = bw.Write(entry.Content)
+		if err == nil {
+			err = bw.Close()
+		}
+	case "gzip":
+		gw, _ := gzip.NewWriterLevel(buf, gzip.BestCompression)
+		_, err = gw.Write(entry.Content)
+		if err == nil {
+			err = gw.Close()
+		}
+	}
+
+	if err != nil || buf.Len() >= len(entry.Content) {
+		return nil
+	}
+
+	return &cache.Entry{
+		Content:     buf.Bytes(),
+		ContentType: entry.ContentType,
+		ETag:        encodedETag(entry, encoding),
+		Loaded:      entry.Loaded,
+		ModTime:     entry.ModTime,
+	}
+}
+
+// encodedETag derives the ETag of entry encoded with encoding from its own,
+// so the encoded and identity responses can't be mistaken for each other.
+func encodedETag(entry *cache.Entry, encoding string) string {
+	etag := entry.ETag
+	if len(etag) == 0 {
+		etag = responder.WeakETag(entry.Content)
+	}
+
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// addVary adds field to w's Vary header unless it's already there.
+func addVary(w http.ResponseWriter, field string) {
+	for _, v := range w.Header().Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(f), field) {
+				return
+			}
+		}
+	}
+
+	w.Header().Add("Vary", field)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/coreyog/spa-server/internal/logging"
+	"github.com/fatih/color"
+	"github.com/jessevdk/go-flags"
+)
+
+// ConfigArguments are the options for the config subcommand.
+type ConfigArguments struct {
+	Positional struct {
+		Command string `positional-arg-name:"COMMAND" description:"What to do: schema, or import" choice:"schema" choice:"import" required:"true"`
+		Dir     string `positional-arg-name:"DIR" description:"For import, the directory with _redirects, _headers, vercel.json, or nginx.conf, or an nginx configuration file"`
+	} `positional-args:"yes"`
+}
+
+// config works with the server's configuration files. schema prints a JSON
+// Schema for the --rules file, so editors can complete and check it when
+// it refers to the schema with "$schema", and CI can validate it. import
+// prints the rules equivalent to a Netlify, Vercel, or nginx site's
+// configuration:
+//
+//	spa-server config schema > rules.schema.json
+//	spa-server config import ./dist > rules.json
+//	spa-server config import /etc/nginx/sites-available/app > rules.json
+func config(argv []string) int {
+	var cargs ConfigArguments
+
+	parser := flags.NewParser(&cargs, flags.Default)
+	parser.Usage = "config [OPTIONS] COMMAND"
+
+	_, err := parser.ParseArgs(argv)
+	if err != nil {
+		if flags.WroteHelp(err) {
+			return 0
+		}
+
+		return 1
+	}
+
+	// keep stdout for the output, so it can be redirected to a file
+	color.Output = color.Error
+
+	var out []byte
+
+	switch cargs.Positional.Command {
+	case "schema":
+		schema := jsonSchema(reflect.TypeOf(RulesFile{}))
+		schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+		schema["title"] = "spa-server rules"
+
+		out, err = json.MarshalIndent(schema, "", "  ")
+	case "import":
+		if len(cargs.Positional.Dir) == 0 {
+			logging.Error("import needs the site's DIR or an nginx configuration file")
+			return 1
+		}
+
+		var rf *RulesFile
+
+		rf, err = importRules(cargs.Positional.Dir)
+		if err == nil {
+			out, err = marshalRules(rf)
+		}
+	}
+
+	if err != nil {
+		logging.Error("%s", err)
+		return 1
+	}
+
+	fmt.Println(string(out))
+
+	return 0
+}
+
+// jsonSchema describes values of t. Struct fields are named by their json
+// tags and documented by their description, enum, and pattern tags.
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonSchema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		required := []string{}
+
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+
+			if len(name) == 0 {
+				name = field.Name
+			}
+
+			property := jsonSchema(field.Type)
+
+			if description := field.Tag.Get("description"); len(description) > 0 {
+				property["description"] = description
+			}
+
+			if enum := field.Tag.Get("enum"); len(enum) > 0 {
+				property["enum"] = strings.Split(enum, ",")
+			}
+
+			if pattern := field.Tag.Get("pattern"); len(pattern) > 0 {
+				property["pattern"] = pattern
+			}
+
+			properties[name] = property
+
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+
+		schema := map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+
+		return schema
+	}
+
+	return map[string]interface{}{}
+}
+
+// marshalRules formats rf for a --rules file, leaving <, >, and & in
+// headers like Link as they are.
+func marshalRules(rf *RulesFile) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+
+	err := enc.Encode(rf)
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), err
+}
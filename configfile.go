@@ -0,0 +1,503 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v3"
+)
+
+// configRules are the rules given inline in the --config file, used when
+// there's no --rules file.
+var configRules []byte
+
+// configSetting is a setting from the --config file: a flag's long name
+// with its values, or for inline rules, the rules.
+type configSetting struct {
+	name   string
+	values []string
+	rules  interface{}
+}
+
+// withConfig returns argv with the flags its --config file, if any, stands
+// for inserted in front, so flags given explicitly override them. A flag
+// given on the command line replaces the file's values for it rather than
+// adding to them, even if it's repeatable. DIR can be given as dir.
+func withConfig(argv []string) ([]string, error) {
+	path := ""
+
+	for i, arg := range argv {
+		if arg == "--" {
+			break
+		}
+
+		switch {
+		case strings.HasPrefix(arg, "--config="):
+			path = strings.TrimPrefix(arg, "--config=")
+		case arg == "--config" && i+1 < len(argv):
+			path = argv[i+1]
+		}
+	}
+
+	if len(path) == 0 {
+		return argv, nil
+	}
+
+	settings, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := flags.NewParser(&Arguments{}, flags.None)
+	given, positional := givenFlags(parser, argv)
+
+	var (
+		preset []string
+		dir    string
+	)
+
+	for _, s := range settings {
+		if s.name == "dir" {
+			if len(s.values) != 1 {
+				return nil, fmt.Errorf("%s: dir takes a single value", path)
+			}
+
+			dir = s.values[0]
+
+			continue
+		}
+
+		if s.name == "sites" {
+			s.name = "site"
+		}
+
+		opt := parser.FindOptionByLongName(s.name)
+		if opt == nil || s.name == "config" {
+			return nil, fmt.Errorf("%s: unknown setting %q", path, s.name)
+		}
+
+		if given[s.name] {
+			continue
+		}
+
+		if s.rules != nil {
+			if reflect.ValueOf(s.rules).Kind() == reflect.Slice {
+				// just the list of rules
+				s.rules = map[string]interface{}{"rules": s.rules}
+			}
+
+			configRules, err = json.Marshal(s.rules)
+			if err != nil {
+				return nil, fmt.Errorf("%s: rules: %w", path, err)
+			}
+
+			continue
+		}
+
+		kind := opt.Field().Type.Kind()
+		if kind != reflect.Slice && len(s.values) > 1 {
+			return nil, fmt.Errorf("%s: %s takes a single value", path, s.name)
+		}
+
+		for _, value := range s.values {
+			if kind == reflect.Bool {
+				on, err := strconv.ParseBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %s is true or false, not %q", path, s.name, value)
+				}
+
+				if on {
+					preset = append(preset, "--"+s.name)
+				}
+
+				continue
+			}
+
+			preset = append(preset, "--"+s.name+"="+value)
+		}
+	}
+
+	argv = append(preset, argv...)
+
+	if len(dir) > 0 && !positional {
+		if !containsArg(argv, "--") {
+			argv = append(argv, "--")
+		}
+
+		argv = append(argv, dir)
+	}
+
+	return argv, nil
+}
+
+// givenFlags returns the long names of the flags in argv, and whether it
+// has a positional argument.
+func givenFlags(parser *flags.Parser, argv []string) (map[string]bool, bool) {
+	given := map[string]bool{}
+
+	takesValue := func(opt *flags.Option) bool {
+		kind := opt.Field().Type.Kind()
+		if kind == reflect.Slice {
+			kind = opt.Field().Type.Elem().Kind()
+		}
+
+		return kind != reflect.Bool
+	}
+
+	for i := 0; i < len(argv); i++ {
+		arg := argv[i]
+
+		switch {
+		case arg == "--":
+			return given, i+1 < len(argv)
+		case strings.HasPrefix(arg, "--"):
+			name, _, inline := strings.Cut(arg[2:], "=")
+
+			opt := parser.FindOptionByLongName(name)
+			if opt == nil {
+				continue
+			}
+
+			given[opt.LongName] = true
+
+			if !inline && takesValue(opt) {
+				i++
+			}
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			// short flags can be combined, as in -cl or -p8080
+			for j, short := range arg[1:] {
+				opt := parser.FindOptionByShortName(short)
+				if opt == nil {
+					break
+				}
+
+				given[opt.LongName] = true
+
+				if takesValue(opt) {
+					if j+2 == len(arg) {
+						i++
+					}
+
+					break
+				}
+			}
+		default:
+			return given, true
+		}
+	}
+
+	return given, false
+}
+
+func containsArg(argv []string, arg string) bool {
+	for _, a := range argv {
+		if a == arg {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readConfig reads the settings in a YAML or TOML file, in the order
+// they're written. JSON is read as YAML.
+func readConfig(path string) ([]configSetting, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var settings []configSetting
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		settings, err = readYAMLConfig(raw)
+	case ".toml":
+		settings, err = readTOMLConfig(raw)
+	default:
+		return nil, fmt.Errorf("%s: expected a .yaml, .yml, .toml, or .json file", path)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return settings, nil
+}
+
+func readYAMLConfig(raw []byte) ([]configSetting, error) {
+	var doc yaml.Node
+
+	err := yaml.Unmarshal(raw, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: expected settings as name: value", root.Line)
+	}
+
+	settings := make([]configSetting, 0, len(root.Content)/2)
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		name, node := root.Content[i].Value, root.Content[i+1]
+		s := configSetting{name: name}
+
+		switch {
+		case name == "rules" && node.Kind != yaml.ScalarNode:
+			err = node.Decode(&s.rules)
+			if err != nil {
+				return nil, err
+			}
+		case node.Kind == yaml.ScalarNode:
+			if node.Tag == "!!null" {
+				continue
+			}
+
+			s.values = []string{node.Value}
+		case node.Kind == yaml.SequenceNode:
+			for _, item := range node.Content {
+				if item.Kind != yaml.ScalarNode {
+					return nil, fmt.Errorf("line %d: %s is a list of values", item.Line, name)
+				}
+
+				s.values = append(s.values, item.Value)
+			}
+		case node.Kind == yaml.MappingNode:
+			// KEY=VALUE flags, like proxy, in order
+			for j := 0; j+1 < len(node.Content); j += 2 {
+				key, value := node.Content[j], node.Content[j+1]
+
+				if value.Kind == yaml.MappingNode && isSites(name) {
+					site, err := yamlSite(key.Value, value)
+					if err != nil {
+						return nil, fmt.Errorf("line %d: %w", value.Line, err)
+					}
+
+					s.values = append(s.values, site)
+
+					continue
+				}
+
+				if value.Kind != yaml.ScalarNode {
+					return nil, fmt.Errorf("line %d: %s.%s is a single value", value.Line, name, key.Value)
+				}
+
+				s.values = append(s.values, key.Value+"="+value.Value)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unexpected value for %s", node.Line, name)
+		}
+
+		settings = append(settings, s)
+	}
+
+	return settings, nil
+}
+
+func readTOMLConfig(raw []byte) ([]configSetting, error) {
+	var doc map[string]interface{}
+
+	md, err := toml.Decode(string(raw), &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var settings []configSetting
+
+	// the map loses the order, the keys have it
+	for _, key := range md.Keys() {
+		if len(key) != 1 {
+			continue
+		}
+
+		name := key[0]
+		s := configSetting{name: name}
+
+		switch v := doc[name].(type) {
+		case []map[string]interface{}, []interface{}:
+			if name == "rules" {
+				s.rules = v
+				break
+			}
+
+			items, _ := v.([]interface{})
+			if len(items) == 0 && reflect.ValueOf(v).Len() > 0 {
+				return nil, fmt.Errorf("%s is a list of values", name)
+			}
+
+			for _, item := range items {
+				value, ok := tomlScalar(item)
+				if !ok {
+					return nil, fmt.Errorf("%s is a list of values", name)
+				}
+
+				s.values = append(s.values, value)
+			}
+		case map[string]interface{}:
+			if name == "rules" {
+				s.rules = v
+				break
+			}
+
+			// KEY=VALUE flags, like proxy, in order
+			for _, sub := range md.Keys() {
+				if len(sub) != 2 || sub[0] != name {
+					continue
+				}
+
+				if table, ok := v[sub[1]].(map[string]interface{}); ok && isSites(name) {
+					site, err := tomlSite(md, sub, table)
+					if err != nil {
+						return nil, err
+					}
+
+					s.values = append(s.values, site)
+
+					continue
+				}
+
+				value, ok := tomlScalar(v[sub[1]])
+				if !ok {
+					return nil, fmt.Errorf("%s.%s is a single value", name, sub[1])
+				}
+
+				s.values = append(s.values, sub[1]+"="+value)
+			}
+		default:
+			value, ok := tomlScalar(v)
+			if !ok {
+				return nil, fmt.Errorf("unexpected value for %s", name)
+			}
+
+			s.values = []string{value}
+		}
+
+		settings = append(settings, s)
+	}
+
+	return settings, nil
+}
+
+// isSites reports whether name is the setting for --site, which can also
+// be a table of hosts with their settings.
+func isSites(name string) bool {
+	return name == "site" || name == "sites"
+}
+
+// yamlSite returns the --site value for host's settings in node.
+func yamlSite(host string, node *yaml.Node) (string, error) {
+	var fields []configSetting
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		field := configSetting{name: node.Content[i].Value}
+		value := node.Content[i+1]
+
+		switch value.Kind {
+		case yaml.ScalarNode:
+			field.values = []string{value.Value}
+		case yaml.SequenceNode:
+			for _, item := range value.Content {
+				field.values = append(field.values, item.Value)
+			}
+		default:
+			return "", fmt.Errorf("unexpected value for site %s %s", host, field.name)
+		}
+
+		fields = append(fields, field)
+	}
+
+	return siteValue(host, fields)
+}
+
+// tomlSite returns the --site value for the settings in table, the table
+// at key.
+func tomlSite(md toml.MetaData, key toml.Key, table map[string]interface{}) (string, error) {
+	host := key[1]
+
+	var fields []configSetting
+
+	for _, sub := range md.Keys() {
+		if len(sub) != 3 || sub[0] != key[0] || sub[1] != host {
+			continue
+		}
+
+		field := configSetting{name: sub[2]}
+
+		items, ok := table[sub[2]].([]interface{})
+		if !ok {
+			items = []interface{}{table[sub[2]]}
+		}
+
+		for _, item := range items {
+			value, ok := tomlScalar(item)
+			if !ok {
+				return "", fmt.Errorf("unexpected value for site %s %s", host, field.name)
+			}
+
+			field.values = append(field.values, value)
+		}
+
+		fields = append(fields, field)
+	}
+
+	return siteValue(host, fields)
+}
+
+// siteValue formats a site's settings from the --config file as the
+// HOST=DIR,OPTION... value of --site.
+func siteValue(host string, fields []configSetting) (string, error) {
+	dir := ""
+
+	var opts []string
+
+	for _, field := range fields {
+		for _, value := range field.values {
+			switch {
+			case field.name == "dir":
+				dir = value
+			case field.name == "cache" && value == "true":
+				opts = append(opts, "cache")
+			case field.name == "cache" && value == "false":
+				// not cached
+			default:
+				opts = append(opts, field.name+"="+value)
+			}
+		}
+	}
+
+	if len(dir) == 0 {
+		return "", fmt.Errorf("site %s has no dir", host)
+	}
+
+	return strings.Join(append([]string{host + "=" + dir}, opts...), ","), nil
+}
+
+// tomlScalar formats a TOML string, number, or boolean as it would be
+// given as a flag.
+func tomlScalar(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	}
+
+	return "", false
+}
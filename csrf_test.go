@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRFGuard(t *testing.T) {
+	handler := NewCSRFGuard([]string{"https://admin.example.com/"}).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name   string
+		method string
+		header map[string]string
+		want   int
+	}{
+		{"cross-site post", http.MethodPost, map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"cross-site delete", http.MethodDelete, map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"cross-site referer", http.MethodPost, map[string]string{"Referer": "https://evil.example/form"}, http.StatusForbidden},
+		{"cross-site fetch metadata", http.MethodPut, map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"same-site fetch metadata", http.MethodPut, map[string]string{"Sec-Fetch-Site": "same-site"}, http.StatusForbidden},
+		{"null origin", http.MethodPost, map[string]string{"Origin": "null", "Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"same origin", http.MethodPost, map[string]string{"Origin": "http://app.example.com"}, http.StatusOK},
+		{"same origin referer", http.MethodPost, map[string]string{"Referer": "http://app.example.com/settings"}, http.StatusOK},
+		{"same origin fetch metadata", http.MethodPatch, map[string]string{"Sec-Fetch-Site": "same-origin"}, http.StatusOK},
+		{"trusted origin", http.MethodPost, map[string]string{"Origin": "https://ADMIN.example.com"}, http.StatusOK},
+		{"no browser context", http.MethodPost, nil, http.StatusOK},
+		{"cross-site get", http.MethodGet, map[string]string{"Origin": "https://evil.example"}, http.StatusOK},
+		{"cross-site head", http.MethodHead, map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusOK},
+		{"cross-site options", http.MethodOptions, map[string]string{"Origin": "https://evil.example"}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "http://app.example.com/api", nil)
+		for key, value := range tt.header {
+			req.Header.Set(key, value)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
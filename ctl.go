@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+	"github.com/jessevdk/go-flags"
+)
+
+// CtlArguments are the options for the ctl subcommand.
+type CtlArguments struct {
+	Server     string `short:"s" long:"server" description:"Base URL of the running server" default:"http://localhost"`
+	Token      string `short:"t" long:"token" env:"SPA_ADMIN_TOKEN" description:"Admin token with the scope the command needs, or file:PATH, env:NAME, or exec:COMMAND to read it from"`
+	Positional struct {
+		Command string `positional-arg-name:"COMMAND" description:"What to do: rollback" choice:"rollback" required:"true"`
+	} `positional-args:"yes"`
+}
+
+// ctlCommands maps each ctl command to the admin endpoint it posts to.
+var ctlCommands = map[string]string{
+	"rollback": "/_admin/rollback",
+}
+
+// ctl controls a running server through its admin API.
+//
+//	spa-server ctl --server https://example.com --token env:TOKEN rollback
+func ctl(argv []string) int {
+	var cargs CtlArguments
+
+	parser := flags.NewParser(&cargs, flags.Default)
+	parser.Usage = "ctl [OPTIONS] COMMAND"
+
+	_, err := parser.ParseArgs(argv)
+	if err != nil {
+		if flags.WroteHelp(err) {
+			return 0
+		}
+
+		return 1
+	}
+
+	token, _, err := resolveSecret(cargs.Token)
+	if err != nil {
+		logging.Error("--token: %s", err)
+		return 1
+	}
+
+	if len(token) == 0 {
+		logging.Error("ctl needs an admin --token")
+		return 1
+	}
+
+	target := strings.TrimSuffix(cargs.Server, "/") + ctlCommands[cargs.Positional.Command]
+
+	req, err := http.NewRequest(http.MethodPost, target, nil)
+	if err != nil {
+		logging.Error("%s", err)
+		return 1
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	client := &http.Client{Timeout: time.Minute}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		logging.Error("%s", err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		logging.Error("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+		return 1
+	}
+
+	logging.Success("%s: %s", cargs.Positional.Command, strings.TrimSpace(string(body)))
+
+	return 0
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/cache"
+	"github.com/coreyog/spa-server/internal/logging"
+	"github.com/coreyog/spa-server/internal/responder"
+)
+
+// dashboardPrefix is where --dashboard is served.
+const dashboardPrefix = "/_dashboard"
+
+// dashboardCookie carries the token for the dashboard, see
+// browserAuthorized.
+const dashboardCookie = "spa_dashboard"
+
+// dashboardRateWindow is how many seconds of request rate are kept.
+const dashboardRateWindow = 300
+
+// maxRecentErrors is how many error responses the dashboard lists.
+const maxRecentErrors = 50
+
+// maxDashboardDeploys is how many deploys the dashboard lists.
+const maxDashboardDeploys = 5
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// Dashboard serves a status page for operating the server without a
+// metrics stack: the request rate, cache usage, recent errors, and deploys,
+// refreshed every couple of seconds from /_dashboard/status.
+type Dashboard struct {
+	spa      *spaHandler
+	drainer  *Drainer
+	deployer *Deployer
+	files    http.Handler
+
+	mu     sync.Mutex
+	counts [dashboardRateWindow]rateCount
+	errors []recentError // newest last
+}
+
+// rateCount is the number of requests in one second.
+type rateCount struct {
+	second int64
+	count  int
+}
+
+// recentError is an error response listed on the dashboard.
+type recentError struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	URL    string    `json:"url"`
+	Status int       `json:"status"`
+}
+
+// dashboardStatus is the body of /_dashboard/status.
+type dashboardStatus struct {
+	Version   string           `json:"version"`
+	Uptime    string           `json:"uptime"`
+	Release   string           `json:"release,omitempty"`
+	InFlight  int64            `json:"in_flight"`
+	Aborted   int64            `json:"client_aborted"`
+	Rate      []int            `json:"rate"`
+	Rate1m    float64          `json:"rate_1m"`
+	Rate5m    float64          `json:"rate_5m"`
+	Cache     cache.Stats      `json:"cache"`
+	Fallbacks *fallbackSummary `json:"fallbacks,omitempty"`
+	Errors    []recentError    `json:"errors"`
+	Deploys   []*releaseDiff   `json:"deploys,omitempty"`
+}
+
+// NewDashboard creates the dashboard for spa. deployer may be nil.
+func NewDashboard(spa *spaHandler, drainer *Drainer, deployer *Deployer) *Dashboard {
+	sub, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+
+	return &Dashboard{
+		spa:      spa,
+		drainer:  drainer,
+		deployer: deployer,
+		files:    http.StripPrefix(dashboardPrefix, http.FileServer(http.FS(sub))),
+	}
+}
+
+// Wrap counts the requests handed to next, other than the server's own
+// endpoints, and remembers the ones that failed.
+func (d *Dashboard) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/readyz" || strings.HasPrefix(r.URL.Path, "/_") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sw := trackResponse(w)
+		next.ServeHTTP(sw, r)
+
+		d.record(time.Now(), r, sw.Status())
+	})
+}
+
+func (d *Dashboard) record(now time.Time, r *http.Request, status int) {
+	second := now.Unix()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	c := &d.counts[second%dashboardRateWindow]
+	if c.second != second {
+		*c = rateCount{second: second}
+	}
+
+	c.count++
+
+	if status < 400 {
+		return
+	}
+
+	d.errors = append(d.errors, recentError{
+		Time:   now.UTC().Truncate(time.Second),
+		Method: r.Method,
+		URL:    logging.RedactURL(r.URL),
+		Status: status,
+	})
+
+	if len(d.errors) > maxRecentErrors {
+		d.errors = d.errors[len(d.errors)-maxRecentErrors:]
+	}
+}
+
+// rate returns the requests in each of the last 60 seconds, oldest first,
+// and the average rates over the last minute and five minutes.
+func (d *Dashboard) rate(now time.Time) ([]int, float64, float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// the current second is still filling up
+	last := now.Unix() - 1
+
+	perSecond := make([]int, 60)
+	total1m, total5m := 0, 0
+
+	for i := int64(0); i < dashboardRateWindow; i++ {
+		second := last - i
+
+		c := d.counts[second%dashboardRateWindow]
+		if c.second != second {
+			continue
+		}
+
+		total5m += c.count
+
+		if i < 60 {
+			total1m += c.count
+			perSecond[59-i] = c.count
+		}
+	}
+
+	return perSecond, float64(total1m) / 60, float64(total5m) / dashboardRateWindow
+}
+
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !browserAuthorized(w, r, "stats", dashboardCookie, dashboardPrefix) {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.URL.Path == dashboardPrefix+"/status" {
+		d.serveStatus(w)
+		return
+	}
+
+	d.files.ServeHTTP(w, r)
+}
+
+// serveStatus reports everything the dashboard shows as JSON.
+func (d *Dashboard) serveStatus(w http.ResponseWriter) {
+	now := time.Now()
+
+	status := dashboardStatus{
+		Version:  serverVersion(),
+		Uptime:   time.Since(started).Round(time.Second).String(),
+		InFlight: atomic.LoadInt64(&d.drainer.inFlight),
+		Aborted:  responder.Aborted(),
+		Cache:    d.spa.store.Stats(),
+	}
+
+	status.Rate, status.Rate1m, status.Rate5m = d.rate(now)
+
+	if d.spa.fallbacks != nil {
+		status.Fallbacks = d.spa.fallbacks.Summary()
+	}
+
+	if target, err := os.Readlink(args.Positional.Directory); err == nil {
+		status.Release = target
+	}
+
+	d.mu.Lock()
+	status.Errors = make([]recentError, 0, len(d.errors))
+	for i := len(d.errors) - 1; i >= 0; i-- {
+		status.Errors = append(status.Errors, d.errors[i])
+	}
+	d.mu.Unlock()
+
+	if d.deployer != nil {
+		d.deployer.diffsMu.Lock()
+		for i := len(d.deployer.diffs) - 1; i >= 0 && len(status.Deploys) < maxDashboardDeploys; i-- {
+			status.Deploys = append(status.Deploys, d.deployer.diffs[i])
+		}
+		d.deployer.diffsMu.Unlock()
+	}
+
+	body, err := json.Marshal(status)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(body, '\n'))
+}
@@ -0,0 +1,105 @@
+// Polls /_dashboard/status and redraws the page.
+(function () {
+	"use strict";
+
+	var interval = 2000;
+
+	function text(id, value) {
+		document.getElementById(id).textContent = value;
+	}
+
+	function rows(id, head, items, cells) {
+		var table = document.getElementById(id);
+		table.textContent = "";
+
+		var tr = table.insertRow();
+		head.forEach(function (h) {
+			var th = document.createElement("th");
+			th.textContent = h;
+			tr.appendChild(th);
+		});
+
+		if (!items || items.length === 0) {
+			var td = table.insertRow().insertCell();
+			td.colSpan = head.length;
+			td.textContent = "None";
+			return;
+		}
+
+		items.forEach(function (item) {
+			var row = table.insertRow();
+			cells(item).forEach(function (value) {
+				var td = row.insertCell();
+				td.textContent = value;
+				if (typeof value === "number") {
+					td.className = "num";
+				}
+			});
+		});
+	}
+
+	function chart(rate) {
+		var el = document.getElementById("rate");
+		var max = Math.max.apply(null, rate.concat([1]));
+
+		el.textContent = "";
+		rate.forEach(function (n) {
+			var bar = document.createElement("div");
+			bar.style.height = (100 * n / max) + "%";
+			bar.title = n + " req/s";
+			el.appendChild(bar);
+		});
+	}
+
+	function render(s) {
+		text("version", s.version);
+		text("summary", "up " + s.uptime + ", " + s.in_flight + " in flight, " +
+			s.client_aborted + " aborted by clients" + (s.release ? ", release " + s.release : ""));
+		text("rates", s.rate_1m.toFixed(1) + "/s over 1m, " + s.rate_5m.toFixed(1) + "/s over 5m");
+		chart(s.rate);
+
+		rows("cache", ["Files", "File bytes", "Derived", "Derived bytes"], [s.cache], function (c) {
+			return [c.files, c.file_bytes, c.derived, c.derived_bytes];
+		});
+
+		rows("errors", ["Time", "Status", "Method", "URL"], s.errors, function (e) {
+			return [new Date(e.time).toLocaleTimeString(), e.status, e.method, e.url];
+		});
+
+		rows("deploys", ["Time", "Release", "Ref", "Added", "Removed", "Changed", "Size change"], s.deploys, function (d) {
+			return [new Date(d.time).toLocaleString(), d.release, d.ref || "", d.added.length,
+				d.removed.length, d.changed.length, d.size_delta];
+		});
+
+		document.getElementById("fallbacks-section").hidden = !s.fallbacks;
+		if (s.fallbacks) {
+			rows("fallbacks", ["Path", "Count"], s.fallbacks.top, function (f) {
+				return [f.path, f.count];
+			});
+		}
+	}
+
+	function poll() {
+		fetch("status", { credentials: "same-origin" })
+			.then(function (res) {
+				if (!res.ok) {
+					throw new Error(res.status + " " + res.statusText);
+				}
+
+				return res.json();
+			})
+			.then(function (s) {
+				document.body.classList.remove("stale");
+				render(s);
+			})
+			.catch(function (err) {
+				document.body.classList.add("stale");
+				text("summary", "Unable to reach the server: " + err.message);
+			})
+			.then(function () {
+				setTimeout(poll, interval);
+			});
+	}
+
+	poll();
+})();
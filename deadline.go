@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+	"golang.org/x/sync/singleflight"
+)
+
+// RequestDeadline bounds how long a request can take, including writing
+// the response, so a slow client downloading a large file can't hold its
+// goroutine and buffers indefinitely.
+type RequestDeadline struct {
+	timeout time.Duration
+}
+
+// NewRequestDeadline creates a deadline of timeout for every request.
+func NewRequestDeadline(timeout time.Duration) *RequestDeadline {
+	return &RequestDeadline{timeout: timeout}
+}
+
+// Wrap cancels the request's context and fails writes to the client once
+// the deadline passes.
+func (d *RequestDeadline) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+		defer cancel()
+
+		// not every writer supports deadlines, the context still applies
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(d.timeout))
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// doContext waits for g's call of fn for key like Do, but gives up with
+// ctx's error once ctx is done. The call itself carries on for any other
+// callers waiting on it.
+func doContext(ctx context.Context, g *singleflight.Group, key string, fn func() (interface{}, error)) (interface{}, error) {
+	select {
+	case res := <-g.DoChan(key, fn):
+		return res.Val, res.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// ctxReader stops reading once ctx is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr ctxReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return cr.r.Read(p)
+}
+
+// abandoned reports whether err means r's context ended before it could be
+// answered. Requests past their deadline get a 503, while a client that
+// went away gets nothing.
+func abandoned(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		logging.RequestError(r, "%s => ??? (timed out)", r.URL.Path)
+		http.Error(w, "request timed out", http.StatusServiceUnavailable)
+	case errors.Is(err, context.Canceled):
+		logging.RequestWarn(r, "%s => ??? (client went away)", r.URL.Path)
+	default:
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,811 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+	"github.com/coreyog/spa-server/internal/resolver"
+	"github.com/dustin/go-humanize"
+)
+
+// maxHookBody caps deploy notifications, GitHub's push payloads are well
+// under it.
+const maxHookBody = 1 << 20
+
+// maxArtifactSize caps both the download and the unpacked size of a
+// release.
+const maxArtifactSize = 1 << 30
+
+// maxDiffs is how many deploys /_admin/deploys reports.
+const maxDiffs = 20
+
+var refPattern = regexp.MustCompile(`^[A-Za-z0-9._/-]{1,128}$`)
+
+// validRef reports whether ref can be put in the source URL. Like git, it
+// allows no "..", so a ref can't climb out of where {ref} is.
+func validRef(ref string) bool {
+	return refPattern.MatchString(ref) && !strings.HasPrefix(ref, "/") && !strings.Contains(ref, "..")
+}
+
+// errNotModified is returned by deploy when a conditional fetch finds the
+// artifact hasn't changed since the last one.
+var errNotModified = errors.New("artifact not modified")
+
+// errUnchanged is returned by deploy when the artifact holds the same files
+// as the current release.
+var errUnchanged = errors.New("release unchanged")
+
+// Deployer handles /_hooks/deploy, downloading the artifact a notification
+// asks for into a new release and switching the hosted directory to it. It
+// can also poll the source, deploying whenever the artifact changes. The
+// hosted directory is a symlink to the current release, so switching is a
+// single rename and requests never see a half-written tree.
+type Deployer struct {
+	link     string
+	releases string
+	source   string
+	branch   string
+	secret   []byte
+	keep     int
+	client   *http.Client
+	switched func(changed []string)
+
+	mu sync.Mutex
+
+	// guarded by mu
+	ref          string
+	fetched      string
+	etag         string
+	lastModified string
+
+	diffsMu sync.Mutex
+	diffs   []*releaseDiff // guarded by diffsMu
+}
+
+// NewDeployer creates a deployer for the symlink link, fetching releases
+// from source into link + ".releases" and keeping the newest keep of them.
+// GitHub pushes are only deployed for branch. switched is called after each switch with the slash-separated paths that
+// changed, or nil if they're unknown, e.g. to drop cached files.
+func NewDeployer(link string, source string, branch string, secret string, keep int, switched func(changed []string)) (*Deployer, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return nil, fmt.Errorf("invalid deploy source %q, expected an http(s) URL", source)
+	}
+
+	if keep < 1 {
+		return nil, errors.New("--deploy-keep must be at least 1")
+	}
+
+	info, err := os.Lstat(link)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Mode()&os.ModeSymlink == 0 {
+		return nil, fmt.Errorf("%s must be a symlink to the current release to deploy into it", link)
+	}
+
+	releases := link + ".releases"
+
+	err = os.MkdirAll(releases, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Deployer{
+		link:     link,
+		releases: releases,
+		source:   source,
+		branch:   branch,
+		secret:   []byte(secret),
+		keep:     keep,
+		client:   &http.Client{Timeout: 10 * time.Minute},
+		switched: switched,
+	}, nil
+}
+
+func (d *Deployer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxHookBody))
+	if err != nil {
+		http.Error(w, "unable to read body", http.StatusBadRequest)
+		return
+	}
+
+	if !d.authorized(r, body) {
+		auditLog.Record(AuditEvent{Action: "deploy", Actor: r.RemoteAddr, Result: "denied"})
+		logging.Error("rejected deploy from %s", r.RemoteAddr)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+
+		return
+	}
+
+	if r.Header.Get("X-GitHub-Event") == "ping" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	ref, pushed := requestedRef(r, body)
+	if len(pushed) > 0 && pushed != "refs/heads/"+d.branch {
+		logging.Info("ignoring push to %s", pushed)
+		_, _ = fmt.Fprintln(w, "ignored")
+
+		return
+	}
+
+	if (len(ref) > 0 || strings.Contains(d.source, "{ref}")) && !validRef(ref) {
+		http.Error(w, "missing or invalid ref", http.StatusBadRequest)
+		return
+	}
+
+	if !d.mu.TryLock() {
+		http.Error(w, "a deploy is already in progress", http.StatusConflict)
+		return
+	}
+	defer d.mu.Unlock()
+
+	event := AuditEvent{
+		Action:  "deploy",
+		Actor:   deployActor(r, body),
+		Result:  "ok",
+		Details: map[string]string{"ref": ref},
+	}
+
+	if delivery := r.Header.Get("X-GitHub-Delivery"); len(delivery) > 0 {
+		event.Details["delivery"] = delivery
+	}
+
+	release, err := d.deploy(ref, false)
+	if errors.Is(err, errUnchanged) {
+		event.Details["release"] = "unchanged"
+		auditLog.Record(event)
+		logging.Info("deploy of %q left the release unchanged", ref)
+		_, _ = fmt.Fprintln(w, "unchanged")
+
+		return
+	}
+
+	if err != nil {
+		event.Result = "failed"
+		event.Details["error"] = err.Error()
+		auditLog.Record(event)
+		logging.Error("deploy failed: %s", err)
+		http.Error(w, "deploy failed: "+err.Error(), http.StatusBadGateway)
+
+		return
+	}
+
+	event.Details["release"] = release
+	auditLog.Record(event)
+	logging.Success("deployed %s", release)
+
+	_, _ = fmt.Fprintln(w, release)
+}
+
+// authorized accepts a GitHub webhook signature of the body, the secret
+// itself as a bearer token, or an admin token with the deploy scope.
+func (d *Deployer) authorized(r *http.Request, body []byte) bool {
+	if token, ok := adminTokenFor(r); ok {
+		return token.scopes["deploy"]
+	}
+
+	if len(d.secret) == 0 {
+		return false
+	}
+
+	if sig := r.Header.Get("X-Hub-Signature-256"); len(sig) > 0 {
+		mac := hmac.New(sha256.New, d.secret)
+		_, _ = mac.Write(body)
+
+		return hmac.Equal([]byte(sig), []byte("sha256="+hex.EncodeToString(mac.Sum(nil))))
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), d.secret) == 1
+}
+
+// deployActor identifies who asked for a deploy: the admin token used, the
+// GitHub user who pushed for a signed webhook, or else the address the
+// request came from.
+func deployActor(r *http.Request, body []byte) string {
+	if token, ok := adminTokenFor(r); ok {
+		return "token:" + token.name
+	}
+
+	var push struct {
+		Sender struct {
+			Login string `json:"login"`
+		} `json:"sender"`
+	}
+
+	if len(r.Header.Get("X-Hub-Signature-256")) > 0 && json.Unmarshal(body, &push) == nil && len(push.Sender.Login) > 0 {
+		return "github:" + push.Sender.Login
+	}
+
+	return r.RemoteAddr
+}
+
+// requestedRef returns the ref query parameter or, for GitHub push events,
+// the commit the push moved to along with the ref pushed to.
+func requestedRef(r *http.Request, body []byte) (string, string) {
+	if ref := r.URL.Query().Get("ref"); len(ref) > 0 {
+		return ref, ""
+	}
+
+	var push struct {
+		Ref   string `json:"ref"`
+		After string `json:"after"`
+	}
+
+	_ = json.Unmarshal(body, &push)
+
+	return push.After, push.Ref
+}
+
+// Sync checks the source for a new artifact every interval, deploying it
+// if its files differ from the current release. Sources with {ref} are
+// checked for the last deployed ref, so they follow branches and tags that
+// move.
+func (d *Deployer) Sync(interval time.Duration) {
+	for range time.Tick(interval) {
+		if !d.mu.TryLock() {
+			continue // a webhook deploy is running
+		}
+
+		if strings.Contains(d.source, "{ref}") && len(d.ref) == 0 {
+			d.mu.Unlock()
+			continue // nothing to check until the first deploy
+		}
+
+		release, err := d.deploy(d.ref, true)
+
+		switch {
+		case err == nil:
+			auditLog.Record(AuditEvent{Action: "deploy", Actor: "sync", Result: "ok", Details: map[string]string{"ref": d.ref, "release": release}})
+			logging.Success("synced %s", release)
+		case !errors.Is(err, errNotModified) && !errors.Is(err, errUnchanged):
+			auditLog.Record(AuditEvent{Action: "deploy", Actor: "sync", Result: "failed", Details: map[string]string{"ref": d.ref, "error": err.Error()}})
+			logging.Error("sync failed: %s", err)
+		}
+
+		d.mu.Unlock()
+	}
+}
+
+// deploy fetches and unpacks the artifact for ref, switches to it, and
+// returns the release's name. A conditional deploy only fetches the
+// artifact if it changed since the last fetch. d.mu must be held.
+func (d *Deployer) deploy(ref string, conditional bool) (string, error) {
+	url := strings.ReplaceAll(d.source, "{ref}", ref)
+
+	artifact, err := d.download(url, conditional)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(artifact)
+
+	prefix := time.Now().UTC().Format("20060102T150405Z")
+	if len(ref) > 0 {
+		prefix += "-" + strings.ReplaceAll(ref, "/", "_")
+	}
+
+	dir, err := ioutil.TempDir(d.releases, prefix+"-")
+	if err != nil {
+		return "", err
+	}
+
+	root, err := unpack(artifact, dir)
+	if err == nil {
+		err = checkRelease(root)
+	}
+
+	var diff *releaseDiff
+
+	if err == nil {
+		diff = d.diffRelease(root)
+		if diff != nil && len(diff.paths()) == 0 {
+			err = errUnchanged
+		}
+	}
+
+	if err == nil {
+		err = d.activate(root)
+	}
+
+	if err != nil {
+		_ = os.RemoveAll(dir)
+
+		if errors.Is(err, errUnchanged) {
+			d.ref = ref
+		} else {
+			d.fetched = "" // fetch it again next time rather than skip it
+		}
+
+		return "", err
+	}
+
+	d.ref = ref
+
+	var changed []string
+
+	if diff != nil {
+		diff.Release = filepath.Base(dir)
+		diff.Ref = ref
+		diff.Time = time.Now().UTC().Truncate(time.Second)
+
+		d.recordDiff(diff)
+		logging.Info("release %s: %s", diff.Release, diff)
+
+		changed = diff.paths()
+	}
+
+	d.switched(changed)
+	d.prune()
+
+	return filepath.Base(dir), nil
+}
+
+// download fetches url into a temporary file, remembering its validators so
+// a conditional download can return errNotModified instead.
+func (d *Deployer) download(url string, conditional bool) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if conditional && url == d.fetched {
+		if len(d.etag) > 0 {
+			req.Header.Set("If-None-Match", d.etag)
+		}
+
+		if len(d.lastModified) > 0 {
+			req.Header.Set("If-Modified-Since", d.lastModified)
+		}
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return "", errNotModified
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to fetch %s: %s", url, resp.Status)
+	}
+
+	f, err := ioutil.TempFile(d.releases, ".artifact-")
+	if err != nil {
+		return "", err
+	}
+
+	n, err := io.Copy(f, io.LimitReader(resp.Body, maxArtifactSize+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil && n > maxArtifactSize {
+		err = fmt.Errorf("artifact is larger than %d bytes", maxArtifactSize)
+	}
+
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+
+	d.fetched = url
+	d.etag = resp.Header.Get("ETag")
+	d.lastModified = resp.Header.Get("Last-Modified")
+
+	return f.Name(), nil
+}
+
+// releaseDiff summarizes what a deploy changed relative to the release it
+// replaced.
+type releaseDiff struct {
+	Release    string    `json:"release"`
+	Ref        string    `json:"ref,omitempty"`
+	Time       time.Time `json:"time"`
+	Added      []string  `json:"added"`
+	Removed    []string  `json:"removed"`
+	Changed    []string  `json:"changed"`
+	SizeBefore int64     `json:"size_before"`
+	SizeAfter  int64     `json:"size_after"`
+	SizeDelta  int64     `json:"size_delta"`
+}
+
+// paths returns every path the diff touches, sorted.
+func (diff *releaseDiff) paths() []string {
+	paths := make([]string, 0, len(diff.Added)+len(diff.Removed)+len(diff.Changed))
+	paths = append(paths, diff.Added...)
+	paths = append(paths, diff.Removed...)
+	paths = append(paths, diff.Changed...)
+
+	sort.Strings(paths)
+
+	return paths
+}
+
+// String summarizes the diff for the log.
+func (diff *releaseDiff) String() string {
+	sign := "+"
+	delta := diff.SizeDelta
+
+	if delta < 0 {
+		sign = "-"
+		delta = -delta
+	}
+
+	return fmt.Sprintf("%d added, %d removed, %d changed, %s%s (%s total)",
+		len(diff.Added), len(diff.Removed), len(diff.Changed),
+		sign, humanize.Bytes(uint64(delta)), humanize.Bytes(uint64(diff.SizeAfter)))
+}
+
+// diffRelease compares root to the current release, or returns nil if the
+// current release can't be read.
+func (d *Deployer) diffRelease(root string) *releaseDiff {
+	current, err := filepath.EvalSymlinks(d.link)
+	if err != nil {
+		logging.Warn("unable to compare releases: %s", err)
+		return nil
+	}
+
+	diff, err := diffTrees(current, root)
+	if err != nil {
+		logging.Warn("unable to compare releases: %s", err)
+		return nil
+	}
+
+	return diff
+}
+
+// diffTrees compares the files under after to those under before.
+func diffTrees(before string, after string) (*releaseDiff, error) {
+	beforeSums, err := fileSums(before)
+	if err != nil {
+		return nil, err
+	}
+
+	afterSums, err := fileSums(after)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &releaseDiff{Added: []string{}, Removed: []string{}, Changed: []string{}}
+
+	for rel, sum := range afterSums {
+		diff.SizeAfter += sum.size
+
+		if old, ok := beforeSums[rel]; !ok {
+			diff.Added = append(diff.Added, rel)
+		} else if old.hash != sum.hash {
+			diff.Changed = append(diff.Changed, rel)
+		}
+	}
+
+	for rel, sum := range beforeSums {
+		diff.SizeBefore += sum.size
+
+		if _, ok := afterSums[rel]; !ok {
+			diff.Removed = append(diff.Removed, rel)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+
+	diff.SizeDelta = diff.SizeAfter - diff.SizeBefore
+
+	return diff, nil
+}
+
+// fileSum is a file's hash and size.
+type fileSum struct {
+	hash [sha256.Size]byte
+	size int64
+}
+
+// fileSums hashes every regular file under root by its slash-separated
+// relative path.
+func fileSums(root string) (map[string]fileSum, error) {
+	sums := map[string]fileSum{}
+
+	err := filepath.WalkDir(root, func(fullpath string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+
+		content, err := ioutil.ReadFile(fullpath)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, fullpath)
+		if err != nil {
+			return err
+		}
+
+		sums[filepath.ToSlash(rel)] = fileSum{hash: sha256.Sum256(content), size: int64(len(content))}
+
+		return nil
+	})
+
+	return sums, err
+}
+
+// recordDiff keeps diff for /_admin/deploys, dropping the oldest past
+// maxDiffs.
+func (d *Deployer) recordDiff(diff *releaseDiff) {
+	d.diffsMu.Lock()
+	defer d.diffsMu.Unlock()
+
+	d.diffs = append(d.diffs, diff)
+	if len(d.diffs) > maxDiffs {
+		d.diffs = d.diffs[len(d.diffs)-maxDiffs:]
+	}
+}
+
+// ServeDiffs reports what the recent deploys changed, newest first.
+func (d *Deployer) ServeDiffs(w http.ResponseWriter, r *http.Request) {
+	d.diffsMu.Lock()
+
+	diffs := make([]*releaseDiff, 0, len(d.diffs))
+	for i := len(d.diffs) - 1; i >= 0; i-- {
+		diffs = append(diffs, d.diffs[i])
+	}
+
+	d.diffsMu.Unlock()
+
+	body, err := json.MarshalIndent(diffs, "", "  ")
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(append(body, '\n'))
+}
+
+// checkRelease makes sure root has a default doc before it's served.
+func checkRelease(root string) error {
+	res, err := resolver.New(root, args.DefaultDoc...)
+	if err != nil {
+		return err
+	}
+
+	if !res.Exists(res.DefaultPath()) {
+		return fmt.Errorf("release has no %s", strings.Join(args.DefaultDoc, " or "))
+	}
+
+	return nil
+}
+
+// activate points the hosted directory at target.
+func (d *Deployer) activate(target string) error {
+	return activateRelease(d.link, target)
+}
+
+// activateRelease points the symlink link at target by renaming a new
+// symlink over it, which replaces it atomically.
+func activateRelease(link string, target string) error {
+	next := link + ".next"
+	_ = os.Remove(next)
+
+	err := os.Symlink(target, next)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(next, link)
+}
+
+// prune removes all but the newest keep releases. Release names start with
+// their deploy time, so they sort oldest first.
+func (d *Deployer) prune() {
+	entries, err := os.ReadDir(d.releases)
+	if err != nil {
+		logging.Error("unable to prune releases: %s", err)
+		return
+	}
+
+	var names []string
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+
+	sort.Strings(names)
+
+	for len(names) > d.keep {
+		err = os.RemoveAll(filepath.Join(d.releases, names[0]))
+		if err != nil {
+			logging.Error("unable to prune release %s: %s", names[0], err)
+		}
+
+		names = names[1:]
+	}
+}
+
+// unpack extracts the .tar.gz or .zip artifact into dir and returns the
+// directory holding the site: dir itself, or its only subdirectory when the
+// archive wraps everything in one, as GitHub's archives do.
+func unpack(artifact string, dir string) (string, error) {
+	f, err := os.Open(artifact)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+
+	magic, _ := br.Peek(4)
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		err = untar(br, dir)
+	case bytes.Equal(magic, []byte("PK\x03\x04")):
+		err = unzip(artifact, dir)
+	default:
+		err = errors.New("unsupported artifact, expected a .tar.gz or .zip")
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return releaseRoot(dir)
+}
+
+func untar(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(gz)
+	remaining := int64(maxArtifactSize)
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// links and special files are skipped, a site doesn't need them
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = extractDir(dir, hdr.Name)
+		case tar.TypeReg:
+			err = extractFile(dir, hdr.Name, tr, &remaining)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func unzip(artifact string, dir string) error {
+	zr, err := zip.OpenReader(artifact)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	remaining := int64(maxArtifactSize)
+
+	for _, file := range zr.File {
+		mode := file.Mode()
+
+		switch {
+		case mode.IsDir():
+			err = extractDir(dir, file.Name)
+		case mode.IsRegular():
+			var rc io.ReadCloser
+
+			rc, err = file.Open()
+			if err != nil {
+				return err
+			}
+
+			err = extractFile(dir, file.Name, rc, &remaining)
+			_ = rc.Close()
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// extractTarget returns where the archive entry name belongs in dir,
+// rejecting names that would land outside it.
+func extractTarget(dir string, name string) (string, error) {
+	target := filepath.Join(dir, filepath.FromSlash(name))
+	if !resolver.Within(dir, target) {
+		return "", fmt.Errorf("unsafe path %q in artifact", name)
+	}
+
+	return target, nil
+}
+
+func extractDir(dir string, name string) error {
+	target, err := extractTarget(dir, name)
+	if err != nil {
+		return err
+	}
+
+	return os.MkdirAll(target, 0o755)
+}
+
+// extractFile writes r to name under dir, failing once more than remaining
+// bytes have been written across the archive.
+func extractFile(dir string, name string, r io.Reader, remaining *int64) error {
+	target, err := extractTarget(dir, name)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(target), 0o755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(f, io.LimitReader(r, *remaining+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	*remaining -= n
+	if *remaining < 0 {
+		return fmt.Errorf("artifact unpacks to more than %d bytes", maxArtifactSize)
+	}
+
+	return nil
+}
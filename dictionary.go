@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+	"github.com/coreyog/spa-server/internal/resolver"
+)
+
+// dictionaryEncodings are the compression dictionary content codings, in
+// the order they're preferred, with the extension of their precomputed
+// deltas.
+var dictionaryEncodings = []string{"dcb", "dcz"}
+
+// Dictionaries implements compression dictionary transport for bundles
+// that change with every deploy. Files matching a --dictionary pattern are
+// offered to browsers as dictionaries, and a browser holding one announces
+// its SHA-256 in Available-Dictionary on its next request for a matching
+// URL. If the build left a delta against that dictionary next to the file,
+// as FILE.HASH.dcb or FILE.HASH.dcz, it's served instead, so a returning
+// user downloads only what changed.
+type Dictionaries struct {
+	res      *resolver.Resolver
+	patterns []string
+}
+
+// NewDictionaries creates the dictionary transport for files under res
+// whose URLs match one of patterns.
+func NewDictionaries(res *resolver.Resolver, patterns []string) (*Dictionaries, error) {
+	for _, pattern := range patterns {
+		if !strings.HasPrefix(pattern, "/") {
+			return nil, fmt.Errorf("dictionary pattern %q must start with /", pattern)
+		}
+
+		_, err := path.Match(pattern, "/")
+		if err != nil {
+			return nil, fmt.Errorf("dictionary pattern %q: %w", pattern, err)
+		}
+	}
+
+	return &Dictionaries{res: res, patterns: patterns}, nil
+}
+
+// match returns the pattern urlPath matches, if any.
+func (d *Dictionaries) match(urlPath string) (string, bool) {
+	for _, pattern := range d.patterns {
+		if ok, _ := path.Match(pattern, urlPath); ok {
+			return pattern, true
+		}
+	}
+
+	return "", false
+}
+
+// Wrap serves deltas for matching requests that have one, and marks the
+// rest of the matching responses as dictionaries, before handing off to
+// next.
+func (d *Dictionaries) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pattern, ok := d.match(r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding, Available-Dictionary")
+		w.Header().Set("Use-As-Dictionary", fmt.Sprintf("match=%q", pattern))
+
+		if d.serveDelta(w, r) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveDelta answers r with a precomputed delta against the dictionary the
+// client has, reporting whether there was one.
+func (d *Dictionaries) serveDelta(w http.ResponseWriter, r *http.Request) bool {
+	hash, ok := availableDictionary(r)
+	if !ok {
+		return false
+	}
+
+	fullpath := d.res.Map(r.URL.Path)
+	if !d.res.Contains(filepath.Join(d.res.Dir, r.URL.Path)) || resolver.Reserved(r.URL.Path) {
+		return false
+	}
+
+	accept := r.Header.Get("Accept-Encoding")
+
+	for _, encoding := range dictionaryEncodings {
+		if !accepts(accept, encoding) {
+			continue
+		}
+
+		delta := fullpath + "." + hash + "." + encoding
+
+		f, err := os.Open(delta)
+		if err != nil {
+			continue
+		}
+
+		info, err := f.Stat()
+		if err != nil || info.IsDir() {
+			f.Close()
+			continue
+		}
+
+		explainf(r, "client has dictionary %s: serving the %s delta", hash[:12], encoding)
+
+		contentType := mime.TypeByExtension(filepath.Ext(fullpath))
+		if len(contentType) == 0 {
+			contentType = "application/octet-stream"
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Encoding", encoding)
+
+		// deltas are rebuilt with every deploy, so there's no Last-Modified
+		// worth revalidating against
+		http.ServeContent(w, r, "", time.Time{}, f)
+		f.Close()
+
+		logging.RequestSuccess(r, "%s => %s (%s delta)", r.URL.Path, d.res.Rel(delta), encoding)
+
+		return true
+	}
+
+	return false
+}
+
+// availableDictionary returns the hex SHA-256 from r's Available-Dictionary
+// header, which is a structured field byte sequence, e.g. :pZGm1Av0IEBK...=:
+func availableDictionary(r *http.Request) (string, bool) {
+	raw := strings.TrimSpace(r.Header.Get("Available-Dictionary"))
+	if len(raw) < 2 || raw[0] != ':' || raw[len(raw)-1] != ':' {
+		return "", false
+	}
+
+	sum, err := base64.StdEncoding.DecodeString(raw[1 : len(raw)-1])
+	if err != nil || len(sum) != 32 {
+		return "", false
+	}
+
+	return hex.EncodeToString(sum), true
+}
+
+// accepts reports whether an Accept or Accept-Encoding header allows value,
+// a media type or encoding, ignoring anything with q=0.
+func accepts(header string, value string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(name), value) {
+			continue
+		}
+
+		for _, param := range strings.Split(params, ";") {
+			key, val, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.TrimSpace(key) != "q" {
+				continue
+			}
+
+			q, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+			if err != nil || q <= 0 {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// Drainer tracks in-flight requests and coordinates graceful shutdown so
+// load balancers see the instance go unready before connections close.
+type Drainer struct {
+	inFlight int64
+	draining int32
+
+	// held is why the server isn't ready yet, if it's being held back
+	held atomic.Pointer[string]
+
+	doneOnce sync.Once
+	done     chan struct{}
+}
+
+// Draining returns a channel that's closed once the server starts
+// draining, for long-lived responses that have to end on their own.
+func (d *Drainer) Draining() <-chan struct{} {
+	d.doneOnce.Do(func() {
+		d.done = make(chan struct{})
+	})
+
+	return d.done
+}
+
+// Wrap counts requests while they are being served.
+func (d *Drainer) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&d.inFlight, 1)
+		defer atomic.AddInt64(&d.inFlight, -1)
+
+		if atomic.LoadInt32(&d.draining) == 1 {
+			w.Header().Set("Connection", "close")
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Hold makes /readyz fail with reason, while the server already serves,
+// until the returned func is called.
+func (d *Drainer) Hold(reason string) func() {
+	d.held.Store(&reason)
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			d.held.Store(nil)
+			logging.Success("ready")
+		})
+	}
+}
+
+// ServeReady responds 200 while serving normally, and 503 while held back
+// or once draining.
+func (d *Drainer) ServeReady(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if atomic.LoadInt32(&d.draining) == 1 {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+
+	if reason := d.held.Load(); reason != nil {
+		http.Error(w, *reason, http.StatusServiceUnavailable)
+		return
+	}
+
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
+// WaitAndShutdown blocks until SIGINT or SIGTERM, then marks the server as
+// draining, waits delay for load balancers to notice /readyz failing, and
+// shuts srv down, logging the remaining in-flight requests until they
+// finish or timeout expires.
+func (d *Drainer) WaitAndShutdown(srv *http.Server, delay time.Duration, timeout time.Duration) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	signal.Stop(sig)
+
+	atomic.StoreInt32(&d.draining, 1)
+	d.Draining()
+	close(d.done)
+	srv.SetKeepAlivesEnabled(false)
+
+	logging.Warn("draining, %d requests in flight", atomic.LoadInt64(&d.inFlight))
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Shutdown(ctx)
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				logging.Error("shutdown deadline hit with %d requests in flight", atomic.LoadInt64(&d.inFlight))
+				return
+			}
+
+			logging.Success("shutdown complete")
+
+			return
+		case <-ticker.C:
+			logging.Warn("draining, %d requests in flight", atomic.LoadInt64(&d.inFlight))
+		}
+	}
+}
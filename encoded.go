@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// EncodedPaths applies the --encoded-slash and --encoded-dot policies to
+// %2F and %2E in request paths. Proxies disagree on whether to decode them,
+// so the server has to be told which behavior the one in front of it
+// expects:
+//
+//   - "decode" treats them like / and ., the default
+//   - "reject" answers 400
+//   - "pass" keeps them encoded, as part of the file name
+type EncodedPaths struct {
+	slash string
+	dot   string
+}
+
+// NewEncodedPaths creates the policies for %2F and %2E.
+func NewEncodedPaths(slash string, dot string) *EncodedPaths {
+	return &EncodedPaths{slash: slash, dot: dot}
+}
+
+// Wrap applies the policies before handing off to next.
+func (ep *EncodedPaths) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw := r.URL.EscapedPath()
+		upper := strings.ToUpper(raw)
+
+		hasSlash := strings.Contains(upper, "%2F")
+		hasDot := strings.Contains(upper, "%2E")
+
+		if (hasSlash && ep.slash == "reject") || (hasDot && ep.dot == "reject") {
+			explainf(r, "rejected the encoded slash or dot in %s", raw)
+			logging.RequestError(r, "%s => ??? (400)", raw)
+			http.Error(w, "encoded slash or dot in path", http.StatusBadRequest)
+
+			return
+		}
+
+		keepSlash := hasSlash && ep.slash == "pass"
+		keepDot := hasDot && ep.dot == "pass"
+
+		if !keepSlash && !keepDot {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		path, err := unescapeExcept(raw, keepSlash, keepDot)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		explainf(r, "kept the encoded slashes or dots in %s", raw)
+
+		u := *r.URL
+		u.Path = path
+		u.RawPath = ""
+
+		r2 := r.Clone(r.Context())
+		r2.URL = &u
+
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// unescapeExcept decodes the escapes in raw other than %2F, if keepSlash,
+// and %2E, if keepDot, which are left as they are.
+func unescapeExcept(raw string, keepSlash bool, keepDot bool) (string, error) {
+	var out strings.Builder
+
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '%' {
+			out.WriteByte(raw[i])
+			continue
+		}
+
+		if i+2 >= len(raw) || !isHex(raw[i+1]) || !isHex(raw[i+2]) {
+			return "", fmt.Errorf("invalid escape in %q", raw)
+		}
+
+		b := unhex(raw[i+1])<<4 | unhex(raw[i+2])
+
+		if (b == '/' && keepSlash) || (b == '.' && keepDot) {
+			out.WriteString(raw[i : i+3])
+		} else {
+			out.WriteByte(b)
+		}
+
+		i += 2
+	}
+
+	return out.String(), nil
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c <= '9':
+		return c - '0'
+	case c <= 'F':
+		return c - 'A' + 10
+	default:
+		return c - 'a' + 10
+	}
+}
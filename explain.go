@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/coreyog/spa-server/internal/logging"
+	"github.com/jessevdk/go-flags"
+)
+
+type explainKey struct{}
+
+// explanation collects the steps taken to answer one request.
+type explanation struct {
+	steps []string
+}
+
+// withExplanation returns a copy of r whose handling is recorded in the
+// returned explanation.
+func withExplanation(r *http.Request) (*http.Request, *explanation) {
+	e := &explanation{}
+	return r.WithContext(context.WithValue(r.Context(), explainKey{}, e)), e
+}
+
+// explainf records a step in r's explanation, if it's being explained.
+func explainf(r *http.Request, format string, a ...interface{}) {
+	if e, ok := r.Context().Value(explainKey{}).(*explanation); ok {
+		e.steps = append(e.steps, fmt.Sprintf(format, a...))
+	}
+}
+
+// explainHeader is the response header --explain-header adds.
+const explainHeader = "X-Spa-Explain"
+
+// explainHeaders adds each request's explanation to its response as
+// X-Spa-Explain headers, one per step, when the request asks for it with
+// an X-Spa-Explain header of its own. Steps avoid commas so they survive
+// proxies joining the headers into one.
+func explainHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.Header.Get(explainHeader)) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r, e := withExplanation(r)
+		next.ServeHTTP(&explainWriter{ResponseWriter: w, explanation: e}, r)
+	})
+}
+
+// explainWriter adds the explanation's steps to the headers just before
+// they're sent.
+type explainWriter struct {
+	http.ResponseWriter
+	explanation *explanation
+	wrote       bool
+}
+
+func (ew *explainWriter) WriteHeader(status int) {
+	if !ew.wrote {
+		ew.wrote = true
+
+		for _, step := range ew.explanation.steps {
+			ew.Header().Add(explainHeader, step)
+		}
+	}
+
+	ew.ResponseWriter.WriteHeader(status)
+}
+
+func (ew *explainWriter) Write(b []byte) (int, error) {
+	if !ew.wrote {
+		ew.WriteHeader(http.StatusOK)
+	}
+
+	return ew.ResponseWriter.Write(b)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (ew *explainWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
+
+// explain answers a single request offline, with the server's usual
+// options, and prints every step taken to resolve it followed by the
+// response's status and headers.
+//
+//	spa-server explain --cache ./dist /some/path
+func explain(argv []string) int {
+	parser := flags.NewParser(&args, flags.Default)
+	parser.Usage = "explain [OPTIONS] DIR PATH"
+
+	rest, err := parser.ParseArgs(withProfile(argv))
+	if err != nil {
+		if flags.WroteHelp(err) {
+			return 0
+		}
+
+		return 1
+	}
+
+	if len(rest) != 1 || !strings.HasPrefix(rest[0], "/") {
+		logging.Error("expected the path to explain after DIR, e.g. /some/path")
+		return 1
+	}
+
+	if isUpstream(args.Positional.Directory) {
+		logging.Error("explain needs a local directory")
+		return 1
+	}
+
+	args.Positional.Directory, err = filepath.Abs(args.Positional.Directory)
+	if err != nil {
+		logging.Error("%s", err)
+		return 1
+	}
+
+	handler, cleanup := newHandler(&Drainer{})
+	defer cleanup()
+
+	req, e := withExplanation(httptest.NewRequest(http.MethodGet, rest[0], nil))
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	fmt.Println()
+
+	for i, step := range e.steps {
+		fmt.Printf("%2d. %s\n", i+1, step)
+	}
+
+	fmt.Printf("\n%d %s\n", rec.Code, http.StatusText(rec.Code))
+
+	names := make([]string, 0, len(rec.Header()))
+	for name := range rec.Header() {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, value := range rec.Header()[name] {
+			fmt.Printf("%s: %s\n", name, value)
+		}
+	}
+
+	fmt.Printf("(%d byte body)\n", rec.Body.Len())
+
+	return 0
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// maxFallbackPaths bounds the distinct paths counted, so scanners probing
+// random URLs can't grow the counts without limit. Fallbacks past it are
+// only counted in the total.
+const maxFallbackPaths = 10000
+
+// fallbackTop is how many paths the stats and the periodic log report.
+const fallbackTop = 10
+
+// FallbackStats counts the paths that weren't found and fell back to the
+// default doc. Most are client-side routes, but the top of the list is
+// where broken deep links, missing assets, and typo'd routes show up.
+type FallbackStats struct {
+	mu     sync.Mutex
+	total  int64
+	counts map[string]int
+
+	// since the last report
+	recentTotal int64
+	recent      map[string]int
+}
+
+// fallbackCount is how many times a path fell back.
+type fallbackCount struct {
+	Path  string `json:"path"`
+	Count int    `json:"count"`
+}
+
+// fallbackSummary is the fallbacks section of /_admin/stats.
+type fallbackSummary struct {
+	Total int64           `json:"total"`
+	Top   []fallbackCount `json:"top"`
+}
+
+// NewFallbackStats creates empty fallback counts.
+func NewFallbackStats() *FallbackStats {
+	return &FallbackStats{counts: map[string]int{}, recent: map[string]int{}}
+}
+
+// Record counts a fallback for urlPath.
+func (fs *FallbackStats) Record(urlPath string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.total++
+	fs.recentTotal++
+
+	countPath(fs.counts, urlPath)
+	countPath(fs.recent, urlPath)
+}
+
+func countPath(counts map[string]int, urlPath string) {
+	if _, ok := counts[urlPath]; ok || len(counts) < maxFallbackPaths {
+		counts[urlPath]++
+	}
+}
+
+// Summary returns the total and the paths that fell back most since the
+// server started, or nil if nothing has.
+func (fs *FallbackStats) Summary() *fallbackSummary {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.total == 0 {
+		return nil
+	}
+
+	return &fallbackSummary{Total: fs.total, Top: topFallbacks(fs.counts, fallbackTop)}
+}
+
+// Report logs the paths that fell back most since the last report.
+func (fs *FallbackStats) Report(since time.Duration) {
+	fs.mu.Lock()
+	total := fs.recentTotal
+	top := topFallbacks(fs.recent, fallbackTop)
+	fs.recentTotal = 0
+	fs.recent = map[string]int{}
+	fs.mu.Unlock()
+
+	if total == 0 {
+		return
+	}
+
+	parts := make([]string, len(top))
+	for i, fc := range top {
+		parts[i] = fmt.Sprintf("%s (%d)", fc.Path, fc.Count)
+	}
+
+	logging.Info("%d fallbacks in the last %s, most often: %s", total, since, strings.Join(parts, ", "))
+}
+
+// Run logs a report every interval for as long as the server runs.
+func (fs *FallbackStats) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		fs.Report(interval)
+	}
+}
+
+// topFallbacks returns the n most counted paths, most first.
+func topFallbacks(counts map[string]int, n int) []fallbackCount {
+	top := make([]fallbackCount, 0, len(counts))
+	for p, count := range counts {
+		top = append(top, fallbackCount{Path: p, Count: count})
+	}
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+
+		return top[i].Path < top[j].Path
+	})
+
+	if len(top) > n {
+		top = top[:n]
+	}
+
+	return top
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+)
+
+type prefixKey struct{}
+
+// ForwardedPrefix honors X-Forwarded-Prefix from trusted proxies, so an
+// ingress can expose the server under a path like /app while it still
+// serves from /.
+type ForwardedPrefix struct {
+	trusted []*net.IPNet
+}
+
+// NewForwardedPrefix creates a ForwardedPrefix trusting proxies at the given
+// addresses or CIDR ranges.
+func NewForwardedPrefix(trusted []string) (*ForwardedPrefix, error) {
+	fp := &ForwardedPrefix{}
+
+	for _, addr := range trusted {
+		if !strings.Contains(addr, "/") {
+			if strings.Contains(addr, ":") {
+				addr += "/128"
+			} else {
+				addr += "/32"
+			}
+		}
+
+		_, ipNet, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q, expected an IP or CIDR", addr)
+		}
+
+		fp.trusted = append(fp.trusted, ipNet)
+	}
+
+	return fp, nil
+}
+
+func (fp *ForwardedPrefix) trusts(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, ipNet := range fp.trusted {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Wrap records the forwarded prefix of requests from trusted proxies and
+// prepends it to the Location of any redirect relative to the site root.
+func (fp *ForwardedPrefix) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !fp.trusts(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		prefix := cleanPrefix(r.Header.Get("X-Forwarded-Prefix"))
+		if len(prefix) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), prefixKey{}, prefix))
+		next.ServeHTTP(&prefixWriter{ResponseWriter: w, prefix: prefix}, r)
+	})
+}
+
+// cleanPrefix normalizes a forwarded prefix to "/a/b" form, returning an
+// empty string for "/" or anything that isn't a plain absolute path.
+func cleanPrefix(raw string) string {
+	// proxies that append rather than replace send a list, the first is
+	// the one the client saw
+	raw = strings.TrimSpace(strings.Split(raw, ",")[0])
+
+	if !strings.HasPrefix(raw, "/") || strings.HasPrefix(raw, "//") || strings.ContainsAny(raw, "\\?#\r\n\x00") {
+		return ""
+	}
+
+	prefix := path.Clean(raw)
+	if prefix == "/" {
+		return ""
+	}
+
+	return prefix
+}
+
+// forwardedPrefix returns the path a trusted proxy exposes the site under,
+// e.g. "/app", or an empty string when it's served at the root.
+func forwardedPrefix(r *http.Request) string {
+	prefix, _ := r.Context().Value(prefixKey{}).(string)
+	return prefix
+}
+
+// prefixWriter adds a forwarded prefix to root-relative redirects.
+type prefixWriter struct {
+	http.ResponseWriter
+	prefix string
+}
+
+func (pw *prefixWriter) WriteHeader(status int) {
+	loc := pw.Header().Get("Location")
+	if strings.HasPrefix(loc, "/") && !strings.HasPrefix(loc, "//") {
+		pw.Header().Set("Location", pw.prefix+loc)
+	}
+
+	pw.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (pw *prefixWriter) Unwrap() http.ResponseWriter {
+	return pw.ResponseWriter
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/coreyog/spa-server/internal/logging"
+	"github.com/oschwald/geoip2-golang"
+)
+
+// GeoFilter looks up the country of each request's remote address and
+// optionally blocks or redirects requests from configured countries.
+type GeoFilter struct {
+	db        *geoip2.Reader
+	allow     map[string]bool
+	block     map[string]bool
+	redirects map[string]string
+
+	// blockUnknown blocks requests whose country isn't known, such as
+	// from loopback and private addresses, which are let through otherwise
+	blockUnknown bool
+}
+
+// NewGeoFilter opens the MaxMind database at path and parses the allow,
+// block, and redirect (CC=URL) country lists. Requests from an unknown
+// country are only blocked with blockUnknown.
+func NewGeoFilter(path string, allow []string, block []string, redirects []string, blockUnknown bool) (*GeoFilter, error) {
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &GeoFilter{
+		db:        db,
+		allow:     map[string]bool{},
+		block:     map[string]bool{},
+		redirects: map[string]string{},
+
+		blockUnknown: blockUnknown,
+	}
+
+	for _, cc := range allow {
+		g.allow[strings.ToUpper(cc)] = true
+	}
+
+	for _, cc := range block {
+		g.block[strings.ToUpper(cc)] = true
+	}
+
+	for _, rule := range redirects {
+		parts := strings.SplitN(rule, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("invalid geo redirect %q, expected CC=URL", rule)
+		}
+
+		g.redirects[strings.ToUpper(parts[0])] = parts[1]
+	}
+
+	return g, nil
+}
+
+// Country returns the ISO country code for the remote address of r, or an
+// empty string if it can't be determined.
+func (g *GeoFilter) Country(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+
+	record, err := g.db.Country(ip)
+	if err != nil {
+		return ""
+	}
+
+	return record.Country.IsoCode
+}
+
+// blocked reports whether requests from cc are blocked.
+func (g *GeoFilter) blocked(cc string) bool {
+	if len(cc) == 0 {
+		return g.blockUnknown
+	}
+
+	return g.block[cc] || (len(g.allow) > 0 && !g.allow[cc])
+}
+
+// Wrap tags each request with its country and applies the configured
+// allow/block/redirect rules before handing off to next. /readyz is left
+// alone, the load balancer's probes come from addresses without a country.
+func (g *GeoFilter) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/readyz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cc := g.Country(r)
+
+		if target, ok := g.redirects[cc]; ok {
+			logging.RequestWarn(r, "[%s] %s => %s (geo redirect)", cc, r.URL.Path, target)
+			http.Redirect(w, r, target, http.StatusFound)
+
+			return
+		}
+
+		if g.blocked(cc) {
+			logging.RequestError(r, "[%s] %s => ??? (geo blocked)", cc, r.URL.Path)
+			http.Error(w, http.StatusText(http.StatusUnavailableForLegalReasons), http.StatusUnavailableForLegalReasons)
+
+			return
+		}
+
+		if len(cc) > 0 {
+			r = logging.WithTag(r, "["+cc+"]")
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Close releases the underlying database.
+func (g *GeoFilter) Close() error {
+	return g.db.Close()
+}
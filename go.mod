@@ -1,9 +1,28 @@
 module github.com/coreyog/spa-server
 
-go 1.16
+go 1.21
 
 require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/andybalholm/brotli v1.1.1
 	github.com/dustin/go-humanize v1.0.0
 	github.com/fatih/color v1.13.0
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/jessevdk/go-flags v1.5.0
+	github.com/oschwald/geoip2-golang v1.13.0
+	github.com/tdewolff/minify/v2 v2.21.0
+	golang.org/x/crypto v0.27.0
+	golang.org/x/image v0.18.0
+	golang.org/x/sync v0.8.0
+	golang.org/x/sys v0.25.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/mattn/go-colorable v0.1.9 // indirect
+	github.com/mattn/go-isatty v0.0.14 // indirect
+	github.com/oschwald/maxminddb-golang v1.13.0 // indirect
+	github.com/tdewolff/parse/v2 v2.7.18 // indirect
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/text v0.18.0 // indirect
 )
@@ -0,0 +1,1344 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/bufpool"
+	"github.com/coreyog/spa-server/internal/cache"
+	"github.com/coreyog/spa-server/internal/index"
+	"github.com/coreyog/spa-server/internal/logging"
+	"github.com/coreyog/spa-server/internal/resolver"
+	"github.com/coreyog/spa-server/internal/responder"
+	"github.com/dustin/go-humanize"
+	"golang.org/x/sync/singleflight"
+)
+
+// newHandler builds the server's handler from args, loading the cache if
+// asked to. drainer's /readyz is mounted alongside /_version and it tracks
+// every request. The returned cleanup releases any resources the handler
+// holds.
+func newHandler(drainer *Drainer) (http.Handler, func()) {
+	variantSize, err := humanize.ParseBytes(args.VariantCacheSize)
+	if err != nil {
+		panic(err)
+	}
+
+	var disk *cache.Disk
+
+	if len(args.DiskCache) > 0 {
+		diskSize, err := humanize.ParseBytes(args.DiskCacheSize)
+		if err != nil {
+			panic(err)
+		}
+
+		disk, err = cache.NewDisk(args.DiskCache, diskSize)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	res, err := resolver.New(args.Positional.Directory, args.DefaultDoc...)
+	if err != nil {
+		panic(err)
+	}
+
+	err = logging.SetRedaction(args.LogRedactParams, args.LogRedactHeaders)
+	if err != nil {
+		panic(err)
+	}
+
+	if args.CDNMode {
+		err = setupCDNMode()
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// loading the cache applies these
+	substitutions, err = parseSubstitutions(args.Replace)
+	if err != nil {
+		panic(err)
+	}
+
+	peers = nil
+
+	if len(args.Peers) > 0 {
+		args.MemCache = true // peers share their caches
+
+		peers, err = NewPeerPool(args.PeerSelf, args.Peers)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	spa := &spaHandler{
+		res:   res,
+		store: cache.New(variantSize, disk),
+		types: &responder.Types{},
+		loads: &singleflight.Group{},
+	}
+
+	if len(args.CacheLimit) > 0 {
+		limit, err := humanize.ParseBytes(args.CacheLimit)
+		if err != nil {
+			panic(err)
+		}
+
+		spa.store.LimitFiles(limit)
+	}
+
+	if len(args.NoCache) > 0 {
+		exclude, err := noCacheMatcher(args.NoCache, res)
+		if err != nil {
+			panic(err)
+		}
+
+		spa.store.Exclude(exclude)
+	}
+
+	// a cached default doc counts as existing
+	res.Exists = func(fullpath string) bool {
+		return fileExists(fullpath, spa.store)
+	}
+
+	if len(args.IndexSnapshot) > 0 || args.IndexHashes {
+		args.Index = true
+	}
+
+	if args.Index {
+		args.MemCache = true // bodies are cached as they're requested
+
+		start := time.Now()
+
+		if len(args.IndexSnapshot) > 0 {
+			logging.Info("loading index snapshot...")
+
+			spa.index, err = index.Load(args.IndexSnapshot, args.Positional.Directory, args.IndexHashes)
+			if err == nil {
+				// serve from the snapshot while catching up with the disk
+				go spa.reindex()
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				logging.Warn("%s, rebuilding", err)
+			}
+		}
+
+		if spa.index == nil {
+			logging.Info("indexing...")
+
+			spa.index, err = index.Build(args.Positional.Directory, args.IndexHashes)
+			if err != nil {
+				panic(err)
+			}
+
+			spa.saveIndex()
+		}
+
+		dur := time.Since(start)
+
+		logging.Success("indexed %d files, %s (%s)", spa.index.Len(), humanize.Bytes(uint64(spa.index.Size())), dur)
+
+		res.Exists = func(fullpath string) bool {
+			_, ok := spa.index.Get(fullpath)
+			return ok || spa.store.Has(fullpath)
+		}
+	}
+
+	if len(args.MaxMemory) > 0 {
+		limit, err := humanize.ParseBytes(args.MaxMemory)
+		if err != nil {
+			panic(err)
+		}
+
+		limitMemory(spa.store, limit)
+	}
+
+	stopWarming := func() {}
+
+	// with --lazy-warm, /readyz waits for --ready-after
+	ready := func() {}
+	if args.LazyWarm && args.ReadyAfter != "start" && (args.LoadCache || len(args.WarmFromLog) > 0) {
+		ready = drainer.Hold("warming")
+	}
+
+	readyDefaultDoc := func() {
+		if args.ReadyAfter == "default-doc" {
+			cacheDefaultDoc(spa.store, spa.types, res)
+			ready()
+		}
+	}
+
+	if args.LoadCache {
+		args.MemCache = true // if pre-caching, we are definitely caching
+
+		stopWarming = warmUp("pre-caching", ready, func(ctx context.Context) (string, error) {
+			readyDefaultDoc()
+
+			size, err := precache(ctx, spa.store, spa.types, args.Positional.Directory)
+			return "pre-cached " + humanize.Bytes(size), err
+		})
+	}
+
+	if len(args.WarmFromLog) > 0 && !args.LoadCache {
+		args.MemCache = true // warming is pointless without the cache
+
+		stopWarming = warmUp("warming from log", ready, func(ctx context.Context) (string, error) {
+			readyDefaultDoc()
+
+			count, size, err := warmFromLog(ctx, spa.store, spa.types, args.WarmFromLog, res)
+			return fmt.Sprintf("warmed %d files, %s", count, humanize.Bytes(size)), err
+		})
+	}
+
+	slotRules, err = parseSlotRules(args.Slots)
+	if err != nil {
+		panic(err)
+	}
+
+	// loaded ahead of the endpoints reading files, which check their auth
+	var rules *Rules
+
+	if len(args.Rules) > 0 || configRules != nil || len(args.MaxAge) > 0 {
+		rules = &Rules{}
+
+		switch {
+		case len(args.Rules) > 0:
+			rules, err = LoadRules(args.Rules)
+		case configRules != nil:
+			rules, err = parseRules(args.Config, configRules)
+		}
+
+		if err != nil {
+			panic(err)
+		}
+
+		maxAge, err := maxAgeRules(args.MaxAge)
+		if err != nil {
+			panic(err)
+		}
+
+		rules.rules = append(maxAge, rules.rules...)
+		spa.rules = rules
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/readyz", methods(http.HandlerFunc(drainer.ServeReady), http.MethodGet, http.MethodHead))
+	mux.Handle("/_version", methods(http.HandlerFunc(serveVersion), http.MethodGet, http.MethodHead))
+
+	var slo *SLOTracker
+
+	if args.SLO {
+		for _, target := range []float64{args.SLOAvailability, args.SLOLatencyTarget} {
+			if target <= 0 || target >= 100 {
+				panic(fmt.Sprintf("SLO target %g%% must be between 0 and 100", target))
+			}
+		}
+
+		slo = NewSLOTracker(args.SLOAvailability, args.SLOLatency, args.SLOLatencyTarget)
+		mux.Handle("/_slo", methods(slo, http.MethodGet, http.MethodHead))
+	}
+
+	if args.ImageResize {
+		size, err := humanize.ParseBytes(args.ImageCacheSize)
+		if err != nil {
+			panic(err)
+		}
+
+		mux.Handle("/_img", methods(rules.GuardFile("src", NewImageResizer(args.Positional.Directory, args.ImageMaxDim, size, disk)), http.MethodGet, http.MethodHead))
+	}
+
+	adminTokens, err = parseAdminTokens(args.AdminTokens)
+	if err != nil {
+		panic(err)
+	}
+
+	if len(adminTokens) > 0 {
+		mux.Handle("/_admin/stats", methods(requireScope("stats", spa.serveStats(drainer)), http.MethodGet, http.MethodHead))
+		mux.Handle("/_admin/purge", methods(requireScope("purge", http.HandlerFunc(spa.servePurge)), http.MethodPost))
+	}
+
+	var deployer *Deployer
+
+	if len(args.DeploySource) > 0 {
+		if len(args.DeploySecret) == 0 && !hasAdminScope("deploy") && args.SyncInterval <= 0 {
+			panic("--deploy-source needs a --deploy-secret, a deploy --admin-token, or a --sync-interval")
+		}
+
+		deployer, err = NewDeployer(args.Positional.Directory, args.DeploySource, args.DeployBranch, args.DeploySecret, args.DeployKeep, spa.invalidate)
+		if err != nil {
+			panic(err)
+		}
+
+		if len(args.DeploySecret) > 0 || hasAdminScope("deploy") {
+			mux.Handle("/_hooks/deploy", methods(deployer, http.MethodPost))
+		}
+
+		if len(adminTokens) > 0 {
+			mux.Handle("/_admin/deploys", methods(requireScope("stats", http.HandlerFunc(deployer.ServeDiffs)), http.MethodGet, http.MethodHead))
+		}
+
+		if args.SyncInterval > 0 {
+			go deployer.Sync(args.SyncInterval)
+		}
+	}
+
+	if hasAdminScope("deploy") {
+		// only a symlinked DIR has releases to roll back to
+		if rollback, err := NewRollback(args.Positional.Directory, deployer, spa.rewarm); err == nil {
+			mux.Handle("/_admin/rollback", methods(requireScope("deploy", rollback), http.MethodPost))
+		}
+	}
+
+	if args.Previous {
+		if !hasAdminScope("previous") {
+			panic("--previous needs an --admin-token with the previous scope")
+		}
+
+		previous, err := NewPrevious(args.Positional.Directory, func(dir string) (http.Handler, error) {
+			return newBuildHandler(dir, variantSize, spa.types)
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		mux.Handle(previousPrefix+"/", methods(previous, http.MethodGet, http.MethodHead))
+	}
+
+	if args.SkewGrace > 0 || args.SkewReload {
+		spa.skew, err = NewSkewGuard(args.Positional.Directory, args.SkewGrace, args.SkewReload, func(dir string) (http.Handler, error) {
+			return newBuildHandler(dir, variantSize, spa.types)
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if args.ChunkReload || len(args.ChunkReloadScript) > 0 {
+		spa.chunks, err = NewChunkReload(args.ChunkReloadScript)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	spa.fallbacks = NewFallbackStats()
+
+	if args.FallbackReport > 0 {
+		go spa.fallbacks.Run(args.FallbackReport)
+	}
+
+	if args.ReferrerStats {
+		if !hasAdminScope("stats") {
+			panic("--referrer-stats needs an --admin-token with the stats scope to read them")
+		}
+
+		spa.referrers = NewReferrerStats(res)
+		mux.Handle("/_admin/referrers", methods(requireScope("stats", spa.referrers), http.MethodGet, http.MethodHead))
+	}
+
+	if args.Analytics {
+		if !hasAdminScope("analytics") {
+			panic("--analytics needs an --admin-token with the analytics scope to read them")
+		}
+
+		if args.AnalyticsDays < 1 {
+			panic("--analytics-days must be at least 1")
+		}
+
+		spa.analytics = NewAnalytics(args.AnalyticsDays)
+		mux.Handle(analyticsPrefix, methods(spa.analytics, http.MethodGet, http.MethodHead))
+		mux.Handle(analyticsPrefix+"/view", methods(http.HandlerFunc(spa.analytics.ServeBeacon), http.MethodPost))
+	}
+
+	var dashboard *Dashboard
+
+	if args.Dashboard {
+		if !hasAdminScope("stats") {
+			panic("--dashboard needs an --admin-token with the stats scope to view it")
+		}
+
+		dashboard = NewDashboard(spa, drainer, deployer)
+		mux.Handle(dashboardPrefix+"/", methods(dashboard, http.MethodGet, http.MethodHead))
+	}
+
+	if args.Ping {
+		pinger := NewPinger(args.Positional.Directory, drainer)
+		go pinger.Run()
+
+		mux.Handle("/_ping", methods(pinger, http.MethodGet, http.MethodHead))
+	}
+
+	var watcher *Watcher
+
+	if args.Watch || args.LiveReload {
+		var reload *LiveReload
+
+		if args.LiveReload {
+			reload = NewLiveReload(drainer)
+			mux.Handle(liveReloadPath, methods(reload, http.MethodGet, http.MethodHead))
+		}
+
+		watcher, err = NewWatcher(args.Positional.Directory, func(changed []string) {
+			if changed == nil {
+				logging.Warn("files changed, purging the cache")
+			} else {
+				logging.Info("%d files changed", len(changed))
+			}
+
+			spa.invalidate(changed)
+
+			if reload != nil {
+				reload.Reload(changed)
+			}
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		go watcher.Run()
+	}
+
+	if args.PrefetchLearn {
+		var warm func(relPath string)
+
+		if args.PrefetchWarm && args.MemCache {
+			warm = func(relPath string) {
+				fullpath := filepath.Join(args.Positional.Directory, relPath)
+				if !spa.store.Has(fullpath) {
+					spa.store.Refresh(fullpath, spa.load)
+				}
+			}
+		}
+
+		spa.learner = NewPrefetchLearner(args.PrefetchWindow, args.PrefetchTop, warm)
+	}
+
+	if peers != nil {
+		mux.Handle("/_peer", methods(rules.RefuseProtected("path", http.HandlerFunc(spa.servePeer)), http.MethodGet, http.MethodHead))
+	}
+
+	var root http.Handler = spa
+
+	if len(args.Upstream) > 0 {
+		mirror, err := NewMirror(args.Upstream, res, args.MirrorTTL, spa.invalidate)
+		if err != nil {
+			panic(err)
+		}
+
+		if !mirror.Fetch(context.Background(), res.DefaultPath()) {
+			logging.Warn("%s has no %s yet", args.Upstream, res.Rel(res.DefaultPath()))
+		}
+
+		root = mirror.Wrap(root)
+	}
+
+	if len(args.Dictionaries) > 0 {
+		dicts, err := NewDictionaries(res, args.Dictionaries)
+		if err != nil {
+			panic(err)
+		}
+
+		root = dicts.Wrap(root)
+	}
+
+	if len(args.Builds) > 0 {
+		if peers != nil {
+			panic("--builds can't be combined with --peer")
+		}
+
+		dir, err := filepath.Abs(args.Builds)
+		if err != nil {
+			panic(err)
+		}
+
+		builds, err := NewBuilds(dir, args.BuildParam, args.BuildHeader, args.BuildsKeep, func(dir string) (http.Handler, error) {
+			return newBuildHandler(dir, variantSize, spa.types)
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		go builds.Run(time.Hour)
+
+		root = builds.Wrap(root)
+	}
+
+	if rules != nil {
+		root = rules.Wrap(root)
+	}
+
+	for _, value := range args.Proxy {
+		proxy, err := NewProxy(value)
+		if err != nil {
+			panic(err)
+		}
+
+		proxy.Handle(mux)
+	}
+
+	mux.Handle("/", methods(root, http.MethodGet, http.MethodHead))
+
+	var handler http.Handler = mux
+
+	if len(args.Sites) > 0 {
+		sites, err := NewSites(args.Sites, mux)
+		if err != nil {
+			panic(err)
+		}
+
+		handler = sites.Wrap(handler)
+	}
+
+	cleanup := func() {}
+
+	if args.CDNMode {
+		handler = cdnHeaders(handler)
+	}
+
+	if args.EncodedSlash != "decode" || args.EncodedDot != "decode" {
+		handler = NewEncodedPaths(args.EncodedSlash, args.EncodedDot).Wrap(handler)
+	}
+
+	if len(args.StripQuery) > 0 {
+		handler = NewQueryStripper(args.StripQuery).Wrap(handler)
+	}
+
+	if args.CSRFCheck {
+		handler = NewCSRFGuard(args.CSRFAllowOrigin).Wrap(handler)
+	}
+
+	if len(args.GeoIPDB) > 0 {
+		geo, err := NewGeoFilter(args.GeoIPDB, args.GeoAllow, args.GeoBlock, args.GeoRedirect, args.GeoBlockUnknown)
+		if err != nil {
+			panic(err)
+		}
+
+		cleanup = func() {
+			_ = geo.Close()
+		}
+
+		handler = geo.Wrap(handler)
+	}
+
+	if len(args.AffinityCookie) > 0 {
+		handler = NewAffinity(args.AffinityCookie, args.InstanceID).Wrap(handler)
+	}
+
+	if len(args.TrustedProxies) > 0 {
+		fp, err := NewForwardedPrefix(args.TrustedProxies)
+		if err != nil {
+			panic(err)
+		}
+
+		handler = fp.Wrap(handler)
+	}
+
+	if len(args.Alerts) > 0 {
+		rules, err := parseAlertRules(args.Alerts)
+		if err != nil {
+			panic(err)
+		}
+
+		alerter := NewAlerter(rules, args.AlertMinRequests, args.AlertWebhook, args.AlertExec)
+		go alerter.Run()
+
+		handler = alerter.Wrap(handler)
+	}
+
+	if dashboard != nil {
+		handler = dashboard.Wrap(handler)
+	}
+
+	if slo != nil {
+		handler = slo.Wrap(handler)
+	}
+
+	if args.ExplainHeader {
+		handler = explainHeaders(handler)
+	}
+
+	handler = trackResponses(handler)
+
+	if args.RequestTimeout > 0 {
+		handler = NewRequestDeadline(args.RequestTimeout).Wrap(handler)
+	}
+
+	handler = drainer.Wrap(handler)
+	handler = NewHardener(args.MaxHeaders, URLLimits{
+		URL:    args.MaxURLLength,
+		Path:   args.MaxPathLength,
+		Query:  args.MaxQueryLength,
+		Params: args.MaxQueryParams,
+	}).Wrap(handler)
+
+	if args.LogFormat != "pretty" {
+		handler = NewAccessLog(args.LogFormat, accessOutput).Wrap(handler)
+	}
+
+	if watcher != nil {
+		release := cleanup
+		cleanup = func() {
+			release()
+			_ = watcher.Close()
+		}
+	}
+
+	release := cleanup
+	cleanup = func() {
+		stopWarming()
+		release()
+	}
+
+	return handler, cleanup
+}
+
+// spaHandler serves files from the hosted directory, falling back to the
+// default doc for anything that doesn't exist.
+type spaHandler struct {
+	res       *resolver.Resolver
+	store     *cache.Cache
+	types     *responder.Types
+	loads     *singleflight.Group
+	learner   *PrefetchLearner
+	fallbacks *FallbackStats
+	referrers *ReferrerStats
+	analytics *Analytics
+	skew      *SkewGuard
+	chunks    *ChunkReload
+	index     *index.Index
+	rules     *Rules
+}
+
+func (h *spaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defaultDoc := h.res.DefaultPath()
+
+	// parse URL down to the file being asked for
+	path := r.URL.Path
+	origPath := path
+
+	if args.I18nRedirect && path == "/" {
+		if locale := negotiateLocale(w, r); len(locale) > 0 {
+			if !args.I18nRewrite {
+				logging.RequestWarn(r, "%s => /%s/ (302)", origPath, locale)
+				http.Redirect(w, r, "/"+locale+"/", http.StatusFound)
+
+				return
+			}
+
+			path = "/" + locale + "/"
+			explainf(r, "negotiated locale %s: serving %s", locale, path)
+		}
+	}
+
+	explainf(r, "decoded path %s from %s", path, r.URL.EscapedPath())
+
+	fullpath := h.res.Map(path)
+
+	if !h.res.Contains(filepath.Join(h.res.Dir, path)) || resolver.Reserved(path) {
+		explainf(r, "%s escapes the directory or is reserved: mapped to the default doc", path)
+	}
+
+	explainf(r, "mapped to %s", h.res.Rel(fullpath))
+
+	if args.I18nFiles {
+		if localized := localizedFile(w, r, fullpath, h.store); localized != fullpath {
+			explainf(r, "localized to %s", h.res.Rel(localized))
+			fullpath = localized
+		}
+	}
+
+	if args.ImageVariants {
+		if variant := imageVariant(w, r, fullpath, h.store); variant != fullpath {
+			explainf(r, "using image variant %s", h.res.Rel(variant))
+			fullpath = variant
+		}
+	}
+
+	rewritten := false
+
+again:
+	relPath := h.res.Rel(fullpath)
+
+	explainf(r, "trying %s", relPath)
+
+	cacheable := args.MemCache && !h.store.Excluded(fullpath)
+
+	if h.index != nil {
+		if meta, ok := h.index.Get(fullpath); ok && notModifiedIndexed(w, r, meta) {
+			explainf(r, "unchanged since the client's copy according to the index")
+			logging.RequestSuccess(r, "%s => %s (304)", origPath, relPath)
+			return
+		}
+	}
+
+	// the owner refuses files behind --rules auth, they're read here
+	owned := peers == nil || peers.Owns(relPath) || h.rules.protects(relPath)
+
+	key := cache.Key{Path: fullpath}
+	ttl := args.CacheTTL
+
+	if !owned {
+		// a copy of the owner's entry, bounded along with derived entries
+		key.Variant = peerVariant
+
+		if ttl <= 0 {
+			ttl = peerCopyTTL
+		}
+	}
+
+	// check if we have a cached version
+	if cacheable {
+		entry, ok := h.store.Load(key)
+		if ok && ttl > 0 && time.Since(entry.Loaded) > ttl {
+			if args.StaleWhileRevalidate && owned {
+				explainf(r, "cached copy expired: serving it while it refreshes")
+				h.store.Refresh(fullpath, h.load)
+			} else {
+				explainf(r, "cached copy expired")
+				ok = false // expired, reload it from disk below
+			}
+		}
+
+		if ok {
+			explainf(r, "cache hit (%s)", entry.ContentType)
+
+			logHit := logging.RequestSuccess // used a cached version
+			if origPath != relPath {
+				logHit = logging.RequestWarn // corrected to default doc
+			}
+
+			logHit(r, "%s => %s (%s)", origPath, relPath, entry.ContentType)
+
+			h.observe(w, r, fullpath == defaultDoc, origPath, relPath, entry.ContentType)
+
+			responder.Write(w, r, encode(w, r, h.store, fullpath, personalize(w, r, h.store, fullpath, entry)))
+
+			return
+		}
+	}
+
+	var loaded interface{}
+	var stream *os.File
+	var err error
+
+	switch {
+	case args.MemCache && !cacheable:
+		explainf(r, "reading from disk (excluded by --no-cache)")
+	case !owned:
+		explainf(r, "cache miss: fetching from peer %s", peers.ring.Get(relPath))
+	case args.MemCache:
+		explainf(r, "cache miss: reading from disk")
+	default:
+		explainf(r, "reading from disk (cache off)")
+	}
+
+	if cacheable {
+		// concurrent misses for the same file share a single read
+		loaded, err = doContext(r.Context(), h.loads, fullpath, func() (interface{}, error) {
+			if !owned {
+				return peers.Fetch(relPath, fullpath, h.load)
+			}
+
+			return h.load(fullpath)
+		})
+	} else {
+		// nothing outlives the response, so read into a pooled buffer
+		buf := bufpool.Get()
+		defer bufpool.Put(buf)
+
+		loaded, stream, err = h.read(r.Context(), fullpath, buf)
+	}
+
+	if abandoned(w, r, err) {
+		return
+	}
+
+	var pathErr *fs.PathError
+	notFound := errors.As(err, &pathErr) && pathErr.Op == "open"
+
+	if err != nil && !notFound && isDir(fullpath) {
+		if args.DirRequests == "redirect" && !strings.HasSuffix(r.URL.Path, "/") {
+			target := &url.URL{Path: r.URL.Path + "/", RawQuery: r.URL.RawQuery}
+
+			explainf(r, "%s is a directory: redirecting to add a slash", relPath)
+
+			logging.RequestWarn(r, "%s => %s (301)", origPath, logging.RedactURL(target))
+			http.Redirect(w, r, target.RequestURI(), http.StatusMovedPermanently)
+
+			return
+		}
+
+		explainf(r, "%s is a directory", relPath)
+
+		notFound = true
+	}
+
+	if notFound {
+		logging.RequestError(r, "unable to open file: %s", fullpath)
+
+		if rewrite := ruleRewrite(r); len(rewrite) > 0 && !rewritten {
+			explainf(r, "not found: rewritten to %s by --rules", rewrite)
+
+			rewritten = true
+			fullpath = h.res.Map(rewrite)
+
+			goto again
+		}
+
+		fallback := defaultDoc
+		if rf := ruleFallback(r); rf == "nearest" || (len(rf) == 0 && args.DirFallback) {
+			fallback = h.res.NearestIndex(fullpath)
+		}
+
+		if h.skew != nil && fullpath != fallback && h.skew.Serve(w, r, relPath) {
+			return
+		}
+
+		if h.chunks != nil && fullpath != fallback && h.chunks.Serve(w, r, relPath) {
+			return
+		}
+
+		if args.CDNMode {
+			explainf(r, "not found and --cdn-mode has no fallback")
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			logging.RequestError(r, "%s => ??? (404)", origPath)
+
+			return
+		} else if ruleFallback(r) == "none" {
+			explainf(r, "not found and --rules turn the fallback off")
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			logging.RequestError(r, "%s => ??? (404)", origPath)
+
+			return
+		} else if fullpath != fallback {
+			explainf(r, "not found: falling back to %s", h.res.Rel(fallback))
+
+			dropRuleCache(r)
+
+			if h.fallbacks != nil {
+				h.fallbacks.Record(origPath)
+			}
+
+			fullpath = fallback
+
+			goto again
+		} else {
+			explainf(r, "not found and neither is the fallback")
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			logging.RequestError(r, "%s => ??? (404)", origPath)
+
+			return
+		}
+	}
+
+	if err != nil {
+		explainf(r, "unable to read: %s", err)
+		logging.Error("unable to read file: %s", fullpath)
+		http.Error(w, "unable to read file", http.StatusInternalServerError)
+		logging.RequestError(r, "%s => ??? (404)", origPath)
+		return
+	}
+
+	entry := loaded.(*cache.Entry)
+
+	if stream != nil {
+		defer stream.Close()
+
+		logging.RequestInfo(r, "%s => %s (%s)", origPath, relPath, logging.Highlight("streamed"))
+
+		explainf(r, "streaming %s from disk (%s)", relPath, entry.ContentType)
+		h.observe(w, r, fullpath == defaultDoc, origPath, relPath, entry.ContentType)
+
+		responder.Stream(w, r, entry, stream)
+
+		return
+	}
+
+	explainf(r, "loaded %s (%d bytes of %s)", relPath, len(entry.Content), entry.ContentType)
+
+	if cacheable {
+		explainf(r, "added to the cache")
+		h.store.Store(key, entry)
+	}
+
+	logMiss := logging.RequestInfo
+	if origPath != relPath {
+		logMiss = logging.RequestWarn
+	}
+
+	if !owned {
+		logMiss(r, "%s => %s (%s)", origPath, relPath, logging.Highlight("from peer"))
+	} else if args.MemCache {
+		logMiss(r, "%s => %s (%s)", origPath, relPath, logging.Highlight("added to cache"))
+	} else {
+		logMiss(r, "%s => %s", origPath, relPath)
+	}
+
+	h.observe(w, r, fullpath == defaultDoc, origPath, relPath, entry.ContentType)
+
+	responder.Write(w, r, encode(w, r, h.store, fullpath, personalize(w, r, h.store, fullpath, entry)))
+}
+
+// observe hands a response about to be written to whatever learns from
+// them: the prefetch learner, the referrer stats, and the analytics.
+func (h *spaHandler) observe(w http.ResponseWriter, r *http.Request, isDoc bool, origPath string, relPath string, contentType string) {
+	if h.learner != nil {
+		h.learner.Observe(w, r, isDoc, relPath)
+	}
+
+	if h.referrers != nil {
+		h.referrers.Observe(r, relPath, contentType)
+	}
+
+	if h.analytics != nil && strings.HasPrefix(contentType, "text/html") {
+		h.analytics.View(r, origPath)
+	}
+}
+
+// notModifiedIndexed sets Last-Modified, and an ETag if the index has the
+// file's hash, and answers 304 if r shows the client already has that
+// version. Content that's transformed when loaded gets its own weak ETag
+// later, replacing the one set here. As usual If-None-Match takes
+// precedence over If-Modified-Since.
+func notModifiedIndexed(w http.ResponseWriter, r *http.Request, meta index.Meta) bool {
+	modTime := meta.ModTime
+
+	w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+
+	if len(meta.Hash) > 0 {
+		etag := `"` + meta.Hash + `"`
+		w.Header().Set("ETag", etag)
+
+		if responder.NotModified(r, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	if len(r.Header.Get("If-None-Match")) > 0 || !responder.NotModifiedSince(r, modTime) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+
+	return true
+}
+
+// isDir reports whether fullpath is a directory.
+func isDir(fullpath string) bool {
+	info, err := os.Stat(fullpath)
+	return err == nil && info.IsDir()
+}
+
+// load reads fullpath from disk and builds its cache entry.
+func (h *spaHandler) load(fullpath string) (*cache.Entry, error) {
+	return loadEntry(fullpath, h.types)
+}
+
+// read reads fullpath into buf and builds an entry for it, giving up once
+// ctx is done. The entry may reference buf's memory so it must not be kept
+// once buf is reused. Files of types that are never transformed or
+// compressed, e.g. video, aren't read: their entry has no content and the
+// file is returned open, at its start, to be streamed and closed by the
+// caller.
+func (h *spaHandler) read(ctx context.Context, fullpath string, buf *bytes.Buffer) (*cache.Entry, *os.File, error) {
+	file, err := os.Open(fullpath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	// enough to sniff the type
+	_, err = io.CopyN(buf, ctxReader{ctx: ctx, r: file}, 512)
+	if err != nil && !errors.Is(err, io.EOF) {
+		file.Close()
+		return nil, nil, &fs.PathError{Op: "read", Path: fullpath, Err: err}
+	}
+
+	contentType := h.types.Detect(fullpath, buf.Bytes())
+
+	// nothing is kept, so the file's size and time are cheaper than a hash
+	etag := responder.FileETag(info.ModTime(), info.Size())
+
+	if !compressible(contentType) {
+		_, err = file.Seek(0, io.SeekStart)
+		if err != nil {
+			file.Close()
+			return nil, nil, &fs.PathError{Op: "seek", Path: fullpath, Err: err}
+		}
+
+		return &cache.Entry{ContentType: contentType, ETag: etag, Loaded: time.Now(), ModTime: info.ModTime()}, file, nil
+	}
+
+	defer file.Close()
+
+	_, err = buf.ReadFrom(ctxReader{ctx: ctx, r: file})
+	if err != nil {
+		return nil, nil, &fs.PathError{Op: "read", Path: fullpath, Err: err}
+	}
+
+	raw := buf.Bytes()
+
+	entry := newCacheEntry(fullpath, contentType, raw)
+	entry.ModTime = info.ModTime()
+
+	if len(entry.ETag) == 0 {
+		entry.ETag = etag
+	}
+
+	return entry, nil, nil
+}
+
+// loadEntry reads fullpath from disk and builds its cache entry, with a
+// strong ETag from its content unless it was transformed.
+func loadEntry(fullpath string, types *responder.Types) (*cache.Entry, error) {
+	file, err := os.Open(fullpath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := io.ReadAll(file)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: fullpath, Err: err}
+	}
+
+	entry := newCacheEntry(fullpath, types.Detect(fullpath, raw), raw)
+	entry.ModTime = info.ModTime()
+
+	if len(entry.ETag) == 0 {
+		entry.ETag = responder.StrongETag(raw)
+	}
+
+	return entry, nil
+}
+
+// newCacheEntry applies the load-time transforms (SSI, minification) to raw.
+// Transformed content gets a weak ETag since it's derived from the file
+// rather than being the file itself.
+func newCacheEntry(fullpath string, contentType string, raw []byte) *cache.Entry {
+	loaded := raw
+
+	if args.SSI {
+		raw = expandTemplate(fullpath, contentType, raw)
+	}
+
+	raw = substitute(contentType, raw)
+
+	if args.LiveReload {
+		raw = injectLiveReload(contentType, raw)
+	}
+
+	if args.MemCache && args.Minify {
+		raw = minifyContent(fullpath, contentType, raw, args.MinifySkip)
+	}
+
+	entry := &cache.Entry{
+		Content:     raw,
+		ContentType: contentType,
+		Loaded:      time.Now(),
+	}
+
+	if !bytes.Equal(loaded, raw) {
+		entry.ETag = responder.WeakETag(raw)
+	}
+
+	return entry
+}
+
+// personalize applies the per-request transforms to entry. Cached content
+// is never modified in place; with memcache enabled, the personalized copy
+// is cached as a variant of fullpath.
+func personalize(w http.ResponseWriter, r *http.Request, store *cache.Cache, fullpath string, entry *cache.Entry) *cache.Entry {
+	slots := slotValues(w, r, entry.ContentType, entry.Content)
+
+	var query, base, mount string
+	if strings.HasPrefix(entry.ContentType, "text/html") {
+		if args.StripQueryInject {
+			query = originalQuery(r)
+		}
+
+		base = baseHref(w, r)
+		mount = mountPrefix(r)
+	}
+
+	if len(slots) == 0 && len(query) == 0 && len(base) == 0 {
+		return entry
+	}
+
+	// the base entry's load time keeps variants from outliving a refresh
+	variant := &strings.Builder{}
+	variant.WriteString(strconv.FormatInt(entry.Loaded.UnixNano(), 36) + "|")
+
+	for _, s := range slots {
+		variant.WriteString(s.Name + "=" + s.Value + "&")
+	}
+
+	variant.WriteString("?" + query + "#" + base)
+
+	key := cache.Key{Path: fullpath, Variant: variant.String()}
+
+	if args.MemCache {
+		if cached, ok := store.Load(key); ok {
+			return cached
+		}
+	}
+
+	content := applySlots(entry.Content, slots)
+
+	if len(query) > 0 {
+		content = injectOriginalQuery(query, content)
+	}
+
+	if len(mount) > 0 {
+		// the base already ends with the mount, before it is the site root
+		content = mountURLs(content, strings.TrimSuffix(base, strings.TrimPrefix(mount, "/")+"/"), mount)
+	}
+
+	if len(base) > 0 {
+		content = setBaseHref(content, base)
+	}
+
+	personalized := &cache.Entry{
+		Content:     content,
+		ContentType: entry.ContentType,
+		ETag:        responder.WeakETag(content),
+	}
+
+	if args.MemCache {
+		store.Store(key, personalized)
+	}
+
+	return personalized
+}
+
+// invalidate drops the cached copies of the changed files, given as
+// slash-separated paths relative to the directory, or of every file if
+// changed is nil. Pre-cached files are reloaded instead.
+func (h *spaHandler) invalidate(changed []string) {
+	if changed == nil {
+		h.store.Purge()
+
+		if h.index != nil {
+			h.reindex()
+		}
+
+		if args.LoadCache {
+			_, err := precache(context.Background(), h.store, h.types, args.Positional.Directory)
+			if err != nil {
+				logging.Error("unable to pre-cache: %s", err)
+			}
+		}
+
+		return
+	}
+
+	for _, rel := range changed {
+		fullpath := filepath.Join(args.Positional.Directory, filepath.FromSlash(rel))
+
+		owned := peers == nil || peers.Owns("/"+rel)
+
+		if h.index != nil {
+			h.index.Update(fullpath)
+		}
+
+		if _, err := os.Stat(fullpath); err == nil && args.LoadCache && owned {
+			h.store.Refresh(fullpath, h.load)
+		} else {
+			h.store.Delete(fullpath)
+		}
+
+		if !owned {
+			h.store.Forget(cache.Key{Path: fullpath, Variant: peerVariant})
+		}
+	}
+}
+
+// rewarm replaces the cached copies of the changed files with the ones now
+// on disk before returning, so a rollback doesn't serve stale files or
+// start cold, and invalidates the rest.
+func (h *spaHandler) rewarm(changed []string) {
+	if changed == nil {
+		h.invalidate(nil)
+		return
+	}
+
+	var stale []string
+
+	for _, rel := range changed {
+		fullpath := filepath.Join(args.Positional.Directory, filepath.FromSlash(rel))
+
+		if !h.store.Has(fullpath) || (peers != nil && !peers.Owns("/"+rel)) {
+			stale = append(stale, rel)
+			continue
+		}
+
+		entry, err := h.load(fullpath)
+		if err != nil {
+			stale = append(stale, rel)
+			continue
+		}
+
+		if h.index != nil {
+			h.index.Update(fullpath)
+		}
+
+		h.store.Store(cache.Key{Path: fullpath}, entry)
+	}
+
+	h.invalidate(stale)
+}
+
+// reindex walks the directory again to bring the index up to date, then
+// saves it to the snapshot, if there is one.
+func (h *spaHandler) reindex() {
+	err := h.index.Rebuild()
+	if err != nil {
+		logging.Error("unable to index: %s", err)
+		return
+	}
+
+	h.saveIndex()
+}
+
+// saveIndex writes the index to --index-snapshot.
+func (h *spaHandler) saveIndex() {
+	if len(args.IndexSnapshot) == 0 {
+		return
+	}
+
+	err := h.index.Save(args.IndexSnapshot)
+	if err != nil {
+		logging.Error("unable to save index snapshot: %s", err)
+	}
+}
+
+// precache loads every file under dir into store, except those another
+// peer caches or --no-cache excludes, returning the bytes loaded. The files
+// are listed first so it can log how far along it is every few seconds, and
+// it stops with ctx's error once ctx is done.
+func precache(ctx context.Context, store *cache.Cache, types *responder.Types, dir string) (size uint64, err error) {
+	files, total, err := precacheList(ctx, store, dir)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+
+	progress := time.NewTicker(warmProgress)
+	defer progress.Stop()
+
+	for i, fullpath := range files {
+		select {
+		case <-ctx.Done():
+			return size, ctx.Err()
+		case <-progress.C:
+			eta := "unknown"
+			if size > 0 {
+				elapsed := time.Since(start)
+				eta = time.Duration(float64(elapsed) / float64(size) * float64(total-size)).Round(time.Second).String()
+			}
+
+			logging.Info("pre-caching: %d of %d files, %s of %s, %s left", i, len(files), humanize.Bytes(size), humanize.Bytes(total), eta)
+		default:
+		}
+
+		entry, err := loadEntry(fullpath, types)
+		if err != nil {
+			return size, err
+		}
+
+		size += uint64(len(entry.Content))
+
+		store.Store(cache.Key{Path: fullpath}, entry)
+	}
+
+	return size, nil
+}
+
+// cacheDefaultDoc loads the default doc into store ahead of the rest, so
+// the app's entry point is served from memory once --ready-after
+// default-doc reports ready. Not finding it isn't fatal, requests fall back
+// to the disk.
+func cacheDefaultDoc(store *cache.Cache, types *responder.Types, res *resolver.Resolver) {
+	fullpath := res.DefaultPath()
+	if store.Excluded(fullpath) {
+		return
+	}
+
+	entry, err := loadEntry(fullpath, types)
+	if err != nil {
+		logging.Warn("unable to cache the default doc: %s", err)
+		return
+	}
+
+	store.Store(cache.Key{Path: fullpath}, entry)
+}
+
+// precacheList returns the files under dir precache loads and their total
+// size.
+func precacheList(ctx context.Context, store *cache.Cache, dir string) (files []string, total uint64, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return nil, 0, ctx.Err()
+		}
+
+		fullpath := filepath.Join(dir, entry.Name())
+
+		if entry.IsDir() {
+			sub, size, err := precacheList(ctx, store, fullpath)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			files = append(files, sub...)
+			total += size
+
+			continue
+		}
+
+		if store.Excluded(fullpath) {
+			continue
+		}
+
+		if peers != nil && !peers.Owns(filepath.ToSlash(strings.TrimPrefix(fullpath, args.Positional.Directory))) {
+			continue // another peer caches it
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		files = append(files, fullpath)
+		total += uint64(info.Size())
+	}
+
+	return files, total, nil
+}
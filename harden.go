@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// maxLoggedPath keeps rejected URLs from flooding the log.
+const maxLoggedPath = 128
+
+// Hardener rejects requests with oversized URIs or too many headers.
+// Requests framing their body in ambiguous ways are rejected before they
+// get here by framingListener, since net/http hides the conflict.
+type Hardener struct {
+	maxHeaders int
+	limits     URLLimits
+}
+
+// URLLimits caps the parts of a request URI. Zero disables a limit.
+type URLLimits struct {
+	URL    int // bytes in the whole request URI
+	Path   int // bytes in the escaped path
+	Query  int // bytes in the raw query string
+	Params int // query parameters
+}
+
+// NewHardener creates a Hardener allowing at most maxHeaders header fields,
+// zero for no limit, and URIs within limits.
+func NewHardener(maxHeaders int, limits URLLimits) *Hardener {
+	return &Hardener{
+		maxHeaders: maxHeaders,
+		limits:     limits,
+	}
+}
+
+// checkURL returns why r's URI exceeds the limits, or an empty string if it
+// doesn't.
+func (h *Hardener) checkURL(r *http.Request) string {
+	switch {
+	case h.limits.URL > 0 && len(r.RequestURI) > h.limits.URL:
+		return "url too long"
+	case h.limits.Path > 0 && len(r.URL.EscapedPath()) > h.limits.Path:
+		return "path too long"
+	case h.limits.Query > 0 && len(r.URL.RawQuery) > h.limits.Query:
+		return "query too long"
+	case h.limits.Params > 0 && countParams(r.URL.RawQuery) > h.limits.Params:
+		return "too many query parameters"
+	}
+
+	return ""
+}
+
+// countParams counts the parameters in a raw query without decoding it.
+func countParams(rawQuery string) int {
+	count := 0
+
+	for _, param := range strings.Split(rawQuery, "&") {
+		if len(param) > 0 {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Wrap checks each request before handing off to next.
+func (h *Hardener) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reason := h.checkURL(r); len(reason) > 0 {
+			h.reject(w, r, http.StatusRequestURITooLong, reason)
+			return
+		}
+
+		if h.maxHeaders > 0 {
+			count := 0
+			for _, values := range r.Header {
+				count += len(values)
+			}
+
+			if count > h.maxHeaders {
+				h.reject(w, r, http.StatusRequestHeaderFieldsTooLarge, "too many headers")
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *Hardener) reject(w http.ResponseWriter, r *http.Request, status int, reason string) {
+	path := r.URL.Path
+	if len(path) > maxLoggedPath {
+		path = path[:maxLoggedPath] + "..."
+	}
+
+	logging.RequestError(r, "%s => ??? (%d %s)", path, status, reason)
+
+	w.Header().Set("Connection", "close")
+	http.Error(w, http.StatusText(status), status)
+}
+
+// maxFramingLine caps the request heads and chunk lines framingConn holds
+// on to. Longer ones are left to net/http's own limits.
+const maxFramingLine = 1 << 20
+
+// framingListener rejects HTTP/1 requests that frame their body in
+// ambiguous ways, the usual ingredients of request smuggling: both
+// Transfer-Encoding and Content-Length, or either one repeated. net/http
+// would go by the chunked coding or the single value and hide the conflict
+// from handlers, while a proxy in front might have gone by the other. TLS
+// connections are left to net/http, which needs them unwrapped for HTTP/2.
+type framingListener struct {
+	net.Listener
+}
+
+// Accept waits for the next connection and checks the requests on it.
+func (l framingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &framingConn{Conn: conn}, nil
+}
+
+// framingConn holds each request head back from net/http until it's been
+// checked, then follows the body to find where the next head starts.
+type framingConn struct {
+	net.Conn
+
+	pending []byte // checked bytes for net/http
+	head    []byte // the head being read
+	line    []byte // the chunk size or trailer line being read
+
+	body        int64 // bytes left in the body, or the chunk and its CRLF
+	chunked     bool
+	trailer     bool
+	uninspected bool // e.g. after an upgrade or a rejected request
+}
+
+func (c *framingConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		buf := make([]byte, max(len(p), 4096))
+
+		n, err := c.Conn.Read(buf)
+		c.feed(buf[:n])
+
+		if err != nil && len(c.pending) == 0 {
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+
+	return n, nil
+}
+
+// feed moves data along to pending as far as it's been checked.
+func (c *framingConn) feed(data []byte) {
+	for len(data) > 0 {
+		switch {
+		case c.uninspected:
+			c.pending = append(c.pending, data...)
+			return
+		case c.body > 0:
+			n := min(int64(len(data)), c.body)
+			c.pending = append(c.pending, data[:n]...)
+			c.body -= n
+			data = data[n:]
+		case c.chunked:
+			data = c.feedChunk(data)
+		default:
+			data = c.feedHead(data)
+		}
+	}
+}
+
+// feedHead collects a request head, checking its framing once it's whole.
+func (c *framingConn) feedHead(data []byte) []byte {
+	c.head = append(c.head, data...)
+
+	end := headEnd(c.head)
+	if end < 0 {
+		if len(c.head) > maxFramingLine {
+			c.uninspected = true
+			c.pending = append(c.pending, c.head...)
+			c.head = nil
+		}
+
+		return nil
+	}
+
+	head, rest := c.head[:end], c.head[end:]
+	c.head = nil
+
+	reason := c.frame(head)
+	if len(reason) > 0 {
+		host, _, _ := net.SplitHostPort(c.RemoteAddr().String())
+		logging.Error("%s => ??? (400 %s)", host, reason)
+
+		// a request net/http can't parse, so it answers with 400 and
+		// closes the connection itself, after any responses still due
+		c.pending = append(c.pending, "\x00\r\n\r\n"...)
+		c.uninspected = true
+
+		return nil
+	}
+
+	c.pending = append(c.pending, head...)
+
+	return append([]byte(nil), rest...)
+}
+
+// headEnd returns where the blank line ending the head in buf ends, or -1.
+func headEnd(buf []byte) int {
+	for i := 0; i < len(buf); i++ {
+		if buf[i] != '\n' {
+			continue
+		}
+
+		switch {
+		case i+1 < len(buf) && buf[i+1] == '\n':
+			return i + 2
+		case i+2 < len(buf) && buf[i+1] == '\r' && buf[i+2] == '\n':
+			return i + 3
+		}
+	}
+
+	return -1
+}
+
+// frame checks head's framing headers and sets up reading its body,
+// returning why it's ambiguous, if it is.
+func (c *framingConn) frame(head []byte) string {
+	var lengths, codings []string
+
+	lines := strings.Split(string(head), "\n")
+
+	if method, _, _ := strings.Cut(lines[0], " "); method == "PRI" || method == "CONNECT" {
+		c.uninspected = true
+		return ""
+	}
+
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(strings.TrimRight(line, "\r"), ":")
+		if !ok {
+			continue
+		}
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "content-length":
+			for _, v := range strings.Split(value, ",") {
+				lengths = append(lengths, strings.TrimSpace(v))
+			}
+		case "transfer-encoding":
+			codings = append(codings, strings.TrimSpace(value))
+		case "upgrade":
+			// the connection is something else once it's switched
+			c.uninspected = true
+		}
+	}
+
+	switch {
+	case len(codings) > 0 && len(lengths) > 0:
+		return "both transfer-encoding and content-length"
+	case len(lengths) > 1:
+		return "repeated content-length"
+	case len(codings) > 1:
+		return "repeated transfer-encoding"
+	case len(codings) == 1:
+		if !strings.EqualFold(codings[0], "chunked") {
+			// net/http refuses it
+			c.uninspected = true
+		}
+
+		c.chunked = true
+	case len(lengths) == 1:
+		n, err := strconv.ParseInt(lengths[0], 10, 64)
+		if err != nil || n < 0 {
+			c.uninspected = true
+		}
+
+		c.body = n
+	}
+
+	return ""
+}
+
+// feedChunk follows a chunked body: size lines, the chunks with their
+// CRLFs, and the trailer ending with a blank line.
+func (c *framingConn) feedChunk(data []byte) []byte {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		c.line = append(c.line, data...)
+		c.pending = append(c.pending, data...)
+
+		if len(c.line) > maxFramingLine {
+			c.uninspected = true
+		}
+
+		return nil
+	}
+
+	line := strings.TrimRight(string(append(c.line, data[:i]...)), "\r")
+	c.line = c.line[:0]
+	c.pending = append(c.pending, data[:i+1]...)
+
+	switch {
+	case c.trailer && len(line) == 0:
+		c.chunked, c.trailer = false, false
+	case c.trailer:
+		// a trailer field
+	default:
+		sizeHex, _, _ := strings.Cut(line, ";")
+
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeHex), 16, 64)
+		if err != nil || size < 0 || size > 1<<40 {
+			c.uninspected = true // net/http rejects it
+			break
+		}
+
+		if size == 0 {
+			c.trailer = true
+			break
+		}
+
+		c.body = size + 2
+	}
+
+	return data[i+1:]
+}
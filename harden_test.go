@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFramingListener(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = io.WriteString(w, r.Method+" "+r.URL.Path+" "+string(body))
+	}))
+	srv.Listener = framingListener{Listener: srv.Listener}
+	srv.Start()
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		raw  string
+		want []string // the status and body of each response
+	}{
+		{
+			name: "transfer-encoding and content-length",
+			raw:  "POST /a HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\nContent-Length: 4\r\n\r\n0\r\n\r\n",
+			want: []string{"400 400 Bad Request"},
+		},
+		{
+			name: "repeated content-length",
+			raw:  "POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 4\r\nContent-Length: 4\r\n\r\nbody",
+			want: []string{"400 400 Bad Request"},
+		},
+		{
+			name: "content-length list",
+			raw:  "POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 4, 4\r\n\r\nbody",
+			want: []string{"400 400 Bad Request"},
+		},
+		{
+			name: "smuggled after a chunked body",
+			raw: "POST /a HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n4\r\nbody\r\n0\r\n\r\n" +
+				"POST /b HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\nContent-Length: 4\r\n\r\n0\r\n\r\n",
+			want: []string{"200 POST /a body", "400 400 Bad Request"},
+		},
+		{
+			name: "pipelined",
+			raw: "POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 4\r\n\r\nbody" +
+				"POST /b HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n2;ext=1\r\nhi\r\n0\r\nX-Trailer: 1\r\n\r\n" +
+				"GET /c HTTP/1.1\r\nHost: x\r\n\r\n",
+			want: []string{"200 POST /a body", "200 POST /b hi", "200 GET /c "},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			_, err = io.WriteString(conn, tt.raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			br := bufio.NewReader(conn)
+
+			for _, want := range tt.want {
+				resp, err := http.ReadResponse(br, nil)
+				if err != nil {
+					t.Fatalf("reading the response for %q: %s", want, err)
+				}
+
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+
+				got := strings.TrimSpace(strings.Fields(resp.Status)[0] + " " + string(body))
+				if got != strings.TrimSpace(want) {
+					t.Errorf("got %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coreyog/spa-server/internal/cache"
+	"github.com/coreyog/spa-server/internal/resolver"
+)
+
+// parseAcceptLanguage returns the language tags in an Accept-Language header
+// ordered by preference, dropping wildcards and q=0 entries.
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+
+	var tags []weighted
+
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+
+		tag := strings.TrimSpace(fields[0])
+		if len(tag) == 0 || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if val, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = val
+				}
+			}
+		}
+
+		if q <= 0 {
+			continue
+		}
+
+		tags = append(tags, weighted{tag, q})
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].q > tags[j].q
+	})
+
+	out := make([]string, 0, len(tags))
+	for _, t := range tags {
+		out = append(out, t.tag)
+	}
+
+	return out
+}
+
+// localeCandidates expands a language tag into the spellings a localized
+// build might use, most specific first: en-US => en-US, en-us, en.
+func localeCandidates(tag string) []string {
+	candidates := []string{tag}
+
+	if lower := strings.ToLower(tag); lower != tag {
+		candidates = append(candidates, lower)
+	}
+
+	if i := strings.Index(tag, "-"); i > 0 {
+		candidates = append(candidates, strings.ToLower(tag[:i]))
+	}
+
+	return candidates
+}
+
+// preferredLanguages lists the request's languages in preference order,
+// starting with the locale cookie if it's set.
+func preferredLanguages(r *http.Request) []string {
+	var tags []string
+	if c, err := r.Cookie(args.I18nCookie); err == nil && len(c.Value) > 0 {
+		tags = append(tags, c.Value)
+	}
+
+	return append(tags, parseAcceptLanguage(r.Header.Get("Accept-Language"))...)
+}
+
+// negotiateLocale picks the localized build directory for a request to /,
+// preferring the locale cookie over Accept-Language. An empty string means
+// no localized build matched.
+func negotiateLocale(w http.ResponseWriter, r *http.Request) string {
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Add("Vary", "Cookie")
+
+	for _, tag := range preferredLanguages(r) {
+		for _, locale := range localeCandidates(tag) {
+			if strings.ContainsAny(locale, `/\.`) || resolver.Reserved(locale) {
+				continue
+			}
+
+			for _, doc := range args.DefaultDoc {
+				info, err := os.Stat(filepath.Join(args.Positional.Directory, locale, doc))
+				if err == nil && !info.IsDir() {
+					return locale
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// localizedFile swaps fullpath for a localized sibling matching the
+// request's languages, e.g. about.html => about.de.html. Only HTML is
+// localized, so hashed assets keep caching without Vary.
+func localizedFile(w http.ResponseWriter, r *http.Request, fullpath string, store *cache.Cache) string {
+	ext := filepath.Ext(fullpath)
+	if !strings.EqualFold(ext, ".html") && !strings.EqualFold(ext, ".htm") {
+		return fullpath
+	}
+
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Add("Vary", "Cookie")
+
+	base := strings.TrimSuffix(fullpath, ext)
+
+	for _, tag := range preferredLanguages(r) {
+		for _, locale := range localeCandidates(tag) {
+			if strings.ContainsAny(locale, `/\.`) || resolver.Reserved(locale) {
+				continue
+			}
+
+			candidate := base + "." + locale + ext
+			if fileExists(candidate, store) {
+				return candidate
+			}
+		}
+	}
+
+	return fullpath
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// importRules converts the hosting platform configuration in dir into
+// rules: Netlify's _redirects and _headers, vercel.json, and nginx.conf, or
+// the nginx configuration dir names. Anything that has no equivalent is
+// logged and left out.
+func importRules(dir string) (*RulesFile, error) {
+	rf := &RulesFile{Rules: []Rule{}}
+	found := false
+
+	importers := []struct {
+		file string
+		read func(path string) ([]Rule, error)
+	}{
+		{"_headers", importNetlifyHeaders},
+		{"_redirects", importNetlifyRedirects},
+		{"vercel.json", importVercel},
+		{"nginx.conf", importNginx},
+	}
+
+	// a file rather than a directory is an nginx configuration, which is
+	// rarely called nginx.conf when it's for a single site
+	if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+		importers = importers[3:]
+		importers[0].file = filepath.Base(dir)
+		dir = filepath.Dir(dir)
+	}
+
+	for _, importer := range importers {
+		path := filepath.Join(dir, importer.file)
+
+		rules, err := importer.read(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		logging.Info("imported %d rules from %s", len(rules), path)
+
+		found = true
+		rf.Rules = append(rf.Rules, rules...)
+	}
+
+	if !found {
+		return nil, fmt.Errorf("no _redirects, _headers, vercel.json, or nginx.conf in %s", dir)
+	}
+
+	// check the result the way --rules will
+	for i := range rf.Rules {
+		rule := rf.Rules[i]
+
+		_, err := compileRule(rule.Match)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: match %q: %w", i+1, rule.Match, err)
+		}
+	}
+
+	return rf, nil
+}
+
+// netlifyPath converts a Netlify path, where * is a splat matching
+// everything after it, into a rule glob.
+func netlifyPath(p string) (string, bool) {
+	if p == "*" || p == "/*" {
+		return "/**", true
+	}
+
+	if dir, ok := strings.CutSuffix(p, "/*"); ok {
+		p = dir + "/**"
+	}
+
+	if strings.Contains(strings.TrimSuffix(p, "/**"), "*") {
+		return "", false
+	}
+
+	return p, strings.HasPrefix(p, "/")
+}
+
+// importNetlifyHeaders reads a Netlify _headers file: a path on its own
+// line followed by indented "Name: value" lines.
+func importNetlifyHeaders(path string) ([]Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []Rule
+	var current *Rule
+
+	scanner := bufio.NewScanner(f)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if line[0] != ' ' && line[0] != '\t' {
+			match, ok := netlifyPath(trimmed)
+			if !ok {
+				logging.Warn("%s:%d: skipping %s, only a trailing * is supported", path, n, trimmed)
+				current = nil
+
+				continue
+			}
+
+			rules = append(rules, Rule{Match: match, Headers: map[string]string{}})
+			current = &rules[len(rules)-1]
+
+			continue
+		}
+
+		if current == nil {
+			continue
+		}
+
+		name, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected Name: value", n)
+		}
+
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+
+		// repeated headers are joined, as Netlify does
+		if prev, ok := current.Headers[name]; ok {
+			value = prev + ", " + value
+		}
+
+		current.Headers[name] = value
+	}
+
+	return rules, scanner.Err()
+}
+
+// importNetlifyRedirects reads a Netlify _redirects file: one redirect per
+// line as FROM TO [STATUS][!].
+func importNetlifyRedirects(path string) ([]Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []Rule
+
+	scanner := bufio.NewScanner(f)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		skip := func(reason string) {
+			logging.Warn("%s:%d: skipping %s, %s", path, n, strings.Join(fields, " "), reason)
+		}
+
+		if len(fields) < 2 || strings.Contains(fields[1], "=") {
+			skip("query parameter matching isn't supported")
+			continue
+		}
+
+		match, ok := netlifyPath(fields[0])
+		if !ok {
+			skip("only paths with a trailing * are supported")
+			continue
+		}
+
+		status := http.StatusMovedPermanently
+		forced := false
+
+		if len(fields) > 2 {
+			code := fields[2]
+			code, forced = strings.CutSuffix(code, "!")
+
+			status, err = strconv.Atoi(code)
+			if err != nil {
+				skip("unknown status " + fields[2])
+				continue
+			}
+		}
+
+		if len(fields) > 3 {
+			skip("conditions like Country and Role aren't supported")
+			continue
+		}
+
+		to := fields[1]
+
+		switch {
+		case status == 200 && !strings.HasPrefix(to, "/"):
+			skip("proxying to another site isn't supported")
+			continue
+		case status == 200:
+			if forced {
+				logging.Warn("%s:%d: %s only applies when the file doesn't exist, forcing isn't supported", path, n, fields[0])
+			}
+
+			rules = append(rules, Rule{Match: match, Rewrite: to})
+		case status >= 300 && status < 400:
+			rule := Rule{Match: match, Redirect: to}
+			if status != http.StatusMovedPermanently {
+				rule.Status = status
+			}
+
+			rules = append(rules, rule)
+		default:
+			skip(fmt.Sprintf("status %d isn't supported", status))
+		}
+	}
+
+	return rules, scanner.Err()
+}
+
+// vercelConfig is the part of vercel.json that has rule equivalents.
+type vercelConfig struct {
+	Redirects []struct {
+		Source      string          `json:"source"`
+		Destination string          `json:"destination"`
+		Permanent   *bool           `json:"permanent"`
+		StatusCode  int             `json:"statusCode"`
+		Has         json.RawMessage `json:"has"`
+		Missing     json.RawMessage `json:"missing"`
+	} `json:"redirects"`
+	Rewrites []struct {
+		Source      string          `json:"source"`
+		Destination string          `json:"destination"`
+		Has         json.RawMessage `json:"has"`
+		Missing     json.RawMessage `json:"missing"`
+	} `json:"rewrites"`
+	Headers []struct {
+		Source  string `json:"source"`
+		Headers []struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		} `json:"headers"`
+		Has     json.RawMessage `json:"has"`
+		Missing json.RawMessage `json:"missing"`
+	} `json:"headers"`
+	CleanURLs     *bool `json:"cleanUrls"`
+	TrailingSlash *bool `json:"trailingSlash"`
+}
+
+// vercelSplat matches a trailing catch-all parameter, /:path*, /:path(.*),
+// or an unnamed /(.*), which becomes /** in a rule.
+var vercelSplat = regexp.MustCompile(`/(?::([A-Za-z_][A-Za-z0-9_]*)(\*|\+|\(\.\*\))|\(\.\*\))$`)
+
+// vercelPath converts a Vercel source into a rule glob, and its
+// destination's reference to a catch-all parameter into :splat.
+func vercelPath(source string, destination string) (string, string, bool) {
+	if m := vercelSplat.FindStringSubmatch(source); m != nil {
+		source = strings.TrimSuffix(source, m[0]) + "/**"
+
+		if len(m[1]) > 0 {
+			destination = regexp.MustCompile(`:`+m[1]+`(\*|\+|\(\.\*\))?`).ReplaceAllString(destination, ":splat")
+		} else {
+			destination = strings.ReplaceAll(destination, "$1", ":splat")
+		}
+	}
+
+	if strings.ContainsAny(strings.TrimSuffix(source, "/**"), "*+()?") {
+		return "", "", false
+	}
+
+	return source, destination, strings.HasPrefix(source, "/")
+}
+
+// importVercel reads the redirects, rewrites, and headers of vercel.json.
+func importVercel(path string) ([]Rule, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var vc vercelConfig
+
+	err = json.Unmarshal(raw, &vc)
+	if err != nil {
+		return nil, err
+	}
+
+	if vc.CleanURLs != nil || vc.TrailingSlash != nil {
+		logging.Warn("%s: cleanUrls and trailingSlash aren't supported, see --dir-requests", path)
+	}
+
+	// unsupported returns why a route can't be imported, if it can't
+	unsupported := func(source string, has json.RawMessage, missing json.RawMessage) string {
+		if len(has) > 0 || len(missing) > 0 {
+			return "has and missing conditions aren't supported"
+		}
+
+		if _, _, ok := vercelPath(source, ""); !ok {
+			return "only plain paths and a trailing catch-all are supported"
+		}
+
+		return ""
+	}
+
+	var rules []Rule
+
+	for _, h := range vc.Headers {
+		if reason := unsupported(h.Source, h.Has, h.Missing); len(reason) > 0 {
+			logging.Warn("%s: skipping headers for %s, %s", path, h.Source, reason)
+			continue
+		}
+
+		match, _, _ := vercelPath(h.Source, "")
+
+		rule := Rule{Match: match, Headers: map[string]string{}}
+		for _, header := range h.Headers {
+			rule.Headers[header.Key] = header.Value
+		}
+
+		rules = append(rules, rule)
+	}
+
+	for _, rd := range vc.Redirects {
+		if reason := unsupported(rd.Source, rd.Has, rd.Missing); len(reason) > 0 {
+			logging.Warn("%s: skipping the redirect from %s, %s", path, rd.Source, reason)
+			continue
+		}
+
+		match, to, _ := vercelPath(rd.Source, rd.Destination)
+
+		// Vercel's redirects are permanent (308) unless they say otherwise
+		rule := Rule{Match: match, Redirect: to, Status: 308}
+
+		switch {
+		case rd.StatusCode != 0:
+			rule.Status = rd.StatusCode
+		case rd.Permanent != nil && !*rd.Permanent:
+			rule.Status = 307
+		}
+
+		rules = append(rules, rule)
+	}
+
+	for _, rw := range vc.Rewrites {
+		if reason := unsupported(rw.Source, rw.Has, rw.Missing); len(reason) > 0 {
+			logging.Warn("%s: skipping the rewrite of %s, %s", path, rw.Source, reason)
+			continue
+		}
+
+		match, to, _ := vercelPath(rw.Source, rw.Destination)
+
+		if !strings.HasPrefix(to, "/") {
+			logging.Warn("%s: skipping the rewrite of %s, proxying to another site isn't supported", path, rw.Source)
+			continue
+		}
+
+		rules = append(rules, Rule{Match: match, Rewrite: to})
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,34 @@
+// Package bufpool shares byte buffers between requests to cut allocation
+// churn on hot paths.
+package bufpool
+
+import (
+	"bytes"
+	"sync"
+)
+
+// MaxPooled keeps the pool from pinning the memory of an occasional huge
+// file.
+const MaxPooled = 4 * 1024 * 1024
+
+var pool = sync.Pool{
+	New: func() interface{} {
+		return &bytes.Buffer{}
+	},
+}
+
+// Get returns an empty buffer from the pool.
+func Get() *bytes.Buffer {
+	return pool.Get().(*bytes.Buffer)
+}
+
+// Put returns buf to the pool. Nothing may reference its contents
+// afterwards.
+func Put(buf *bytes.Buffer) {
+	if buf.Cap() > MaxPooled {
+		return
+	}
+
+	buf.Reset()
+	pool.Put(buf)
+}
@@ -0,0 +1,247 @@
+// Package cache holds file contents and the representations derived from
+// them (compressed, personalized, resized) in memory and, optionally, on
+// disk.
+package cache
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// Entry is one cached response body.
+type Entry struct {
+	Content     []byte
+	ContentType string
+	ETag        string
+	Loaded      time.Time
+
+	// ModTime is when the file was last modified, if known, for
+	// Last-Modified and If-Modified-Since.
+	ModTime time.Time
+}
+
+// Key identifies one representation of a file: the file itself, or a
+// variant of it produced for a particular content encoding or request.
+type Key struct {
+	Path     string
+	Encoding string
+	Variant  string
+}
+
+func (k Key) base() bool {
+	return len(k.Encoding) == 0 && len(k.Variant) == 0
+}
+
+func (k Key) String() string {
+	return k.Path + "\x00" + k.Encoding + "\x00" + k.Variant
+}
+
+// Cache holds file contents and the representations derived from them.
+// Files are kept until replaced, unless they're limited; derived entries
+// live in a byte-bounded LRU since request-driven variants have no natural
+// bound. Encoded representations are also kept in the disk tier, if there
+// is one.
+type Cache struct {
+	files      sync.Map // map[string]*Entry
+	limited    *LRU     // the files instead, once they're limited
+	derived    *LRU
+	disk       *Disk
+	exclude    func(fullpath string) bool
+	refreshing sync.Map // paths with a background reload in progress
+}
+
+// New creates a cache keeping at most derivedLimit bytes of derived
+// representations in memory. disk may be nil.
+func New(derivedLimit uint64, disk *Disk) *Cache {
+	return &Cache{
+		derived: NewLRU(derivedLimit),
+		disk:    disk,
+	}
+}
+
+// LimitFiles keeps at most limit bytes of files in memory, evicting the
+// least recently used ones to make room. Files larger than limit aren't
+// kept at all. It must be called before the cache is used.
+func (c *Cache) LimitFiles(limit uint64) {
+	c.limited = NewLRU(limit)
+}
+
+// Exclude keeps the files exclude reports true for, and everything derived
+// from them, out of the cache entirely, e.g. large media that would push
+// everything else out. It must be called before the cache is used.
+func (c *Cache) Exclude(exclude func(fullpath string) bool) {
+	c.exclude = exclude
+}
+
+// Excluded reports whether the file at fullpath is kept out of the cache.
+func (c *Cache) Excluded(fullpath string) bool {
+	return c.exclude != nil && c.exclude(fullpath)
+}
+
+// Load returns the entry for key.
+func (c *Cache) Load(key Key) (*Entry, bool) {
+	if key.base() && c.limited != nil {
+		return c.limited.Get(key.Path)
+	}
+
+	if key.base() {
+		cached, ok := c.files.Load(key.Path)
+		if !ok {
+			return nil, false
+		}
+
+		return cached.(*Entry), true
+	}
+
+	entry, ok := c.derived.Get(key.String())
+	if ok || c.disk == nil || len(key.Encoding) == 0 {
+		return entry, ok
+	}
+
+	entry, ok = c.disk.Get(key.String())
+	if ok {
+		c.derived.Add(key.String(), entry)
+	}
+
+	return entry, ok
+}
+
+// Store saves entry under key.
+func (c *Cache) Store(key Key, entry *Entry) {
+	if c.Excluded(key.Path) {
+		return
+	}
+
+	if key.base() && c.limited != nil {
+		c.limited.Add(key.Path, entry)
+		return
+	}
+
+	if key.base() {
+		c.files.Store(key.Path, entry)
+		return
+	}
+
+	c.derived.Add(key.String(), entry)
+
+	if c.disk != nil && len(key.Encoding) > 0 {
+		c.disk.Put(key.String(), entry)
+	}
+}
+
+// Has reports whether the file at fullpath is cached.
+func (c *Cache) Has(fullpath string) bool {
+	if c.limited != nil {
+		return c.limited.Has(fullpath)
+	}
+
+	_, ok := c.files.Load(fullpath)
+	return ok
+}
+
+// Delete removes the file at fullpath from the cache.
+func (c *Cache) Delete(fullpath string) {
+	if c.limited != nil {
+		c.limited.Remove(fullpath)
+		return
+	}
+
+	c.files.Delete(fullpath)
+}
+
+// Forget removes the derived entry for key from memory.
+func (c *Cache) Forget(key Key) {
+	c.derived.Remove(key.String())
+}
+
+// Refresh reloads fullpath with load in the background, replacing its
+// entry, or removing it if it can no longer be loaded. Concurrent refreshes
+// of the same path are collapsed into one.
+func (c *Cache) Refresh(fullpath string, load func(fullpath string) (*Entry, error)) {
+	if _, busy := c.refreshing.LoadOrStore(fullpath, true); busy {
+		return
+	}
+
+	go func() {
+		defer c.refreshing.Delete(fullpath)
+
+		entry, err := load(fullpath)
+		if err != nil {
+			logging.Error("unable to refresh %s: %s", fullpath, err)
+			c.Delete(fullpath)
+
+			return
+		}
+
+		c.Store(Key{Path: fullpath}, entry)
+	}()
+}
+
+// Stats describes what a cache holds.
+type Stats struct {
+	Files        int    `json:"files"`
+	FileBytes    uint64 `json:"file_bytes"`
+	Derived      int    `json:"derived"`
+	DerivedBytes uint64 `json:"derived_bytes"`
+}
+
+// Stats counts the files and derived entries held in memory.
+func (c *Cache) Stats() Stats {
+	var stats Stats
+
+	if c.limited != nil {
+		stats.Files, stats.FileBytes = c.limited.Len()
+	}
+
+	c.files.Range(func(_, value interface{}) bool {
+		stats.Files++
+		stats.FileBytes += uint64(len(value.(*Entry).Content))
+
+		return true
+	})
+
+	stats.Derived, stats.DerivedBytes = c.derived.Len()
+
+	return stats
+}
+
+// Purge drops every file and derived entry held in memory, e.g. after the
+// hosted directory is replaced. Disk entries are kept.
+func (c *Cache) Purge() {
+	c.derived.Release(math.MaxUint64)
+
+	if c.limited != nil {
+		c.limited.Release(math.MaxUint64)
+	}
+
+	c.files.Range(func(key, _ interface{}) bool {
+		c.files.Delete(key)
+		return true
+	})
+}
+
+// Release frees at least want bytes if it can, dropping derived entries
+// before files since they're cheaper to rebuild, and returns the bytes freed.
+func (c *Cache) Release(want uint64) uint64 {
+	freed := c.derived.Release(want)
+
+	if c.limited != nil && freed < want {
+		freed += c.limited.Release(want - freed)
+	}
+
+	c.files.Range(func(key, value interface{}) bool {
+		if freed >= want {
+			return false
+		}
+
+		c.files.Delete(key)
+		freed += uint64(len(value.(*Entry).Content))
+
+		return true
+	})
+
+	return freed
+}
@@ -0,0 +1,288 @@
+package cache_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/cache"
+	"github.com/fatih/color"
+)
+
+func TestMain(m *testing.M) {
+	// failed refreshes are logged
+	color.Output = ioutil.Discard
+
+	os.Exit(m.Run())
+}
+
+func entryOf(size int) *cache.Entry {
+	return &cache.Entry{Content: bytes.Repeat([]byte{'x'}, size), ContentType: "text/plain"}
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+
+	lru := cache.NewLRU(30)
+	lru.Add("a", entryOf(10))
+	lru.Add("b", entryOf(10))
+	lru.Add("c", entryOf(10))
+
+	// touch a so b is the oldest
+	if _, ok := lru.Get("a"); !ok {
+		t.Fatal("a missing")
+	}
+
+	lru.Add("d", entryOf(10))
+
+	if _, ok := lru.Get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := lru.Get(key); !ok {
+			t.Errorf("%s should still be cached", key)
+		}
+	}
+}
+
+func TestLRUSkipsOversizedEntries(t *testing.T) {
+	t.Parallel()
+
+	lru := cache.NewLRU(10)
+	lru.Add("big", entryOf(11))
+
+	if _, ok := lru.Get("big"); ok {
+		t.Error("entry larger than the limit was cached")
+	}
+}
+
+func TestLRURelease(t *testing.T) {
+	t.Parallel()
+
+	lru := cache.NewLRU(100)
+	lru.Add("a", entryOf(10))
+	lru.Add("b", entryOf(10))
+	lru.Add("c", entryOf(10))
+
+	if freed := lru.Release(15); freed != 20 {
+		t.Errorf("freed %d, want 20", freed)
+	}
+
+	if _, ok := lru.Get("c"); !ok {
+		t.Error("most recent entry should survive a partial release")
+	}
+}
+
+func TestCacheBaseAndDerived(t *testing.T) {
+	t.Parallel()
+
+	store := cache.New(15, nil)
+
+	base := cache.Key{Path: "/srv/index.html"}
+	store.Store(base, entryOf(10))
+
+	if !store.Has(base.Path) {
+		t.Fatal("base entry missing")
+	}
+
+	// derived entries share the LRU budget, files don't
+	store.Store(cache.Key{Path: base.Path, Variant: "1"}, entryOf(10))
+	store.Store(cache.Key{Path: base.Path, Variant: "2"}, entryOf(10))
+
+	if _, ok := store.Load(cache.Key{Path: base.Path, Variant: "1"}); ok {
+		t.Error("oldest variant should have been evicted")
+	}
+
+	if _, ok := store.Load(cache.Key{Path: base.Path, Variant: "2"}); !ok {
+		t.Error("newest variant missing")
+	}
+
+	if _, ok := store.Load(base); !ok {
+		t.Error("base entry evicted by variants")
+	}
+
+	store.Delete(base.Path)
+
+	if store.Has(base.Path) {
+		t.Error("base entry survived Delete")
+	}
+}
+
+func TestCacheReleasesDerivedFirst(t *testing.T) {
+	t.Parallel()
+
+	store := cache.New(100, nil)
+	store.Store(cache.Key{Path: "/a"}, entryOf(10))
+	store.Store(cache.Key{Path: "/a", Encoding: "gzip"}, entryOf(10))
+
+	if freed := store.Release(5); freed != 10 {
+		t.Errorf("freed %d, want 10", freed)
+	}
+
+	if !store.Has("/a") {
+		t.Error("file released before derived entry")
+	}
+
+	if freed := store.Release(5); freed != 10 || store.Has("/a") {
+		t.Error("file not released once derived entries were gone")
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	t.Parallel()
+
+	store := cache.New(100, nil)
+
+	store.Store(cache.Key{Path: "/srv/index.html"}, entryOf(10))
+	store.Store(cache.Key{Path: "/srv/app.js"}, entryOf(20))
+	store.Store(cache.Key{Path: "/srv/index.html", Variant: "1"}, entryOf(5))
+
+	want := cache.Stats{Files: 2, FileBytes: 30, Derived: 1, DerivedBytes: 5}
+	if got := store.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCachePurge(t *testing.T) {
+	t.Parallel()
+
+	store := cache.New(100, nil)
+
+	base := cache.Key{Path: "/srv/index.html"}
+	variant := cache.Key{Path: base.Path, Variant: "1"}
+
+	store.Store(base, entryOf(10))
+	store.Store(variant, entryOf(10))
+	store.Purge()
+
+	if _, ok := store.Load(base); ok {
+		t.Error("base entry survived Purge")
+	}
+
+	if _, ok := store.Load(variant); ok {
+		t.Error("variant survived Purge")
+	}
+}
+
+func TestCacheLimitFiles(t *testing.T) {
+	t.Parallel()
+
+	store := cache.New(100, nil)
+	store.LimitFiles(20)
+
+	store.Store(cache.Key{Path: "/a"}, entryOf(10))
+	store.Store(cache.Key{Path: "/b"}, entryOf(10))
+
+	// touch a so b is the oldest
+	if _, ok := store.Load(cache.Key{Path: "/a"}); !ok {
+		t.Fatal("/a missing")
+	}
+
+	store.Store(cache.Key{Path: "/c"}, entryOf(10))
+	store.Store(cache.Key{Path: "/big"}, entryOf(21))
+
+	for path, want := range map[string]bool{"/a": true, "/b": false, "/c": true, "/big": false} {
+		if store.Has(path) != want {
+			t.Errorf("Has(%s) = %t, want %t", path, !want, want)
+		}
+	}
+
+	want := cache.Stats{Files: 2, FileBytes: 20}
+	if got := store.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+
+	store.Delete("/a")
+
+	if store.Has("/a") {
+		t.Error("/a survived Delete")
+	}
+}
+
+func TestCacheExclude(t *testing.T) {
+	t.Parallel()
+
+	store := cache.New(100, nil)
+	store.Exclude(func(fullpath string) bool {
+		return fullpath == "/movie.mp4"
+	})
+
+	store.Store(cache.Key{Path: "/movie.mp4"}, entryOf(10))
+	store.Store(cache.Key{Path: "/movie.mp4", Encoding: "gzip"}, entryOf(5))
+	store.Store(cache.Key{Path: "/index.html"}, entryOf(10))
+
+	if !store.Excluded("/movie.mp4") || store.Excluded("/index.html") {
+		t.Error("Excluded doesn't follow the exclude func")
+	}
+
+	want := cache.Stats{Files: 1, FileBytes: 10}
+	if got := store.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCacheRefresh(t *testing.T) {
+	t.Parallel()
+
+	store := cache.New(0, nil)
+	store.Store(cache.Key{Path: "/a"}, entryOf(1))
+	store.Store(cache.Key{Path: "/gone"}, entryOf(1))
+
+	store.Refresh("/a", func(string) (*cache.Entry, error) {
+		return entryOf(2), nil
+	})
+
+	store.Refresh("/gone", func(string) (*cache.Entry, error) {
+		return nil, errors.New("removed")
+	})
+
+	deadline := time.Now().Add(time.Second)
+
+	for time.Now().Before(deadline) {
+		entry, _ := store.Load(cache.Key{Path: "/a"})
+		if len(entry.Content) == 2 && !store.Has("/gone") {
+			return
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Error("refresh did not replace /a and remove /gone")
+}
+
+func TestDiskRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	disk, err := cache.NewDisk(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &cache.Entry{Content: []byte("compressed"), ContentType: "text/css", ETag: `W/"1"`}
+	disk.Put("key", want)
+
+	// a fresh Disk on the same directory sees the entry
+	reopened, err := cache.NewDisk(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := reopened.Get("key")
+	if !ok {
+		t.Fatal("entry missing after reopen")
+	}
+
+	if !bytes.Equal(got.Content, want.Content) || got.ContentType != want.ContentType || got.ETag != want.ETag {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if _, ok := reopened.Get("missing"); ok {
+		t.Error("unexpected hit for a missing key")
+	}
+}
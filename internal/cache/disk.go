@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/bufpool"
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// Disk is a size-bounded cache of derived artifacts (resized images,
+// compressed variants) on disk, so expensive work survives restarts without
+// being held in memory. The least recently used files are evicted first.
+type Disk struct {
+	mu    sync.Mutex
+	dir   string
+	limit uint64
+	size  uint64
+}
+
+// NewDisk opens (creating if needed) a disk cache in dir holding at most
+// limit bytes.
+func NewDisk(dir string, limit uint64) (*Disk, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	dc := &Disk{
+		dir:   dir,
+		limit: limit,
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		info, err := file.Info()
+		if err == nil && !info.IsDir() {
+			dc.size += uint64(info.Size())
+		}
+	}
+
+	dc.mu.Lock()
+	dc.evict()
+	dc.mu.Unlock()
+
+	return dc, nil
+}
+
+func (dc *Disk) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(dc.dir, hex.EncodeToString(sum[:]))
+}
+
+// Get loads the entry stored under key.
+func (dc *Disk) Get(key string) (*Entry, bool) {
+	path := dc.path(key)
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	// files are "content type\netag\ncontent"
+	reader := bufio.NewReader(bytes.NewReader(raw))
+
+	contentType, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, false
+	}
+
+	etag, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, false
+	}
+
+	now := time.Now()
+	_ = os.Chtimes(path, now, now) // mark as recently used
+
+	header := len(contentType) + len(etag)
+
+	return &Entry{
+		Content:     raw[header:],
+		ContentType: contentType[:len(contentType)-1],
+		ETag:        etag[:len(etag)-1],
+		Loaded:      now,
+	}, true
+}
+
+// Put stores entry under key, evicting old entries to stay within the limit.
+func (dc *Disk) Put(key string, entry *Entry) {
+	buf := bufpool.Get()
+	defer bufpool.Put(buf)
+
+	buf.WriteString(entry.ContentType + "\n" + entry.ETag + "\n")
+	buf.Write(entry.Content)
+
+	if uint64(buf.Len()) > dc.limit {
+		return
+	}
+
+	path := dc.path(key)
+
+	// write then rename so readers never see a partial file
+	tmp, err := ioutil.TempFile(dc.dir, ".tmp-")
+	if err != nil {
+		logging.Error("unable to write disk cache: %s", err)
+		return
+	}
+
+	_, err = tmp.Write(buf.Bytes())
+	tmp.Close()
+
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		logging.Error("unable to write disk cache: %s", err)
+
+		return
+	}
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	if info, err := os.Stat(path); err == nil {
+		dc.size -= uint64(info.Size())
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		logging.Error("unable to write disk cache: %s", err)
+
+		return
+	}
+
+	dc.size += uint64(buf.Len())
+	dc.evict()
+}
+
+// evict removes the least recently used files until the cache fits within
+// its limit. dc.mu must be held.
+func (dc *Disk) evict() {
+	if dc.size <= dc.limit {
+		return
+	}
+
+	files, err := os.ReadDir(dc.dir)
+	if err != nil {
+		return
+	}
+
+	infos := make([]os.FileInfo, 0, len(files))
+
+	for _, file := range files {
+		info, err := file.Info()
+		if err == nil && !info.IsDir() {
+			infos = append(infos, info)
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+
+	for _, info := range infos {
+		if dc.size <= dc.limit {
+			return
+		}
+
+		err = os.Remove(filepath.Join(dc.dir, info.Name()))
+		if err == nil {
+			dc.size -= uint64(info.Size())
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// LRU is a byte-bounded least-recently-used cache of entries.
+type LRU struct {
+	mu    sync.Mutex
+	limit uint64
+	size  uint64
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type lruItem struct {
+	key   string
+	entry *Entry
+}
+
+// NewLRU creates a cache holding at most limit bytes of content.
+func NewLRU(limit uint64) *LRU {
+	return &LRU{
+		limit: limit,
+		ll:    list.New(),
+		items: map[string]*list.Element{},
+	}
+}
+
+// Get returns the entry for key and marks it as recently used.
+func (c *LRU) Get(key string) (*Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	c.ll.MoveToFront(el)
+
+	return el.Value.(*lruItem).entry, true
+}
+
+// Has reports whether there's an entry for key, without marking it as
+// recently used.
+func (c *LRU) Has(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.items[key]
+
+	return ok
+}
+
+// Add stores entry under key, evicting the least recently used entries
+// until the cache fits within its limit. Entries larger than the limit are
+// not stored at all.
+func (c *LRU) Add(key string, entry *Entry) {
+	size := uint64(len(entry.Content))
+	if size > c.limit {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.size -= uint64(len(el.Value.(*lruItem).entry.Content))
+		el.Value.(*lruItem).entry = entry
+		c.ll.MoveToFront(el)
+	} else {
+		c.items[key] = c.ll.PushFront(&lruItem{key: key, entry: entry})
+	}
+
+	c.size += size
+
+	for c.size > c.limit {
+		oldest := c.ll.Back()
+		item := oldest.Value.(*lruItem)
+
+		c.ll.Remove(oldest)
+		delete(c.items, item.key)
+		c.size -= uint64(len(item.entry.Content))
+	}
+}
+
+// Remove drops the entry for key, if there is one.
+func (c *LRU) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return
+	}
+
+	c.ll.Remove(el)
+	delete(c.items, key)
+	c.size -= uint64(len(el.Value.(*lruItem).entry.Content))
+}
+
+// Release evicts least recently used entries until at least want bytes have
+// been freed or the cache is empty, returning the bytes freed.
+func (c *LRU) Release(want uint64) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var freed uint64
+
+	for freed < want && c.ll.Len() > 0 {
+		oldest := c.ll.Back()
+		item := oldest.Value.(*lruItem)
+
+		c.ll.Remove(oldest)
+		delete(c.items, item.key)
+
+		size := uint64(len(item.entry.Content))
+		c.size -= size
+		freed += size
+	}
+
+	return freed
+}
+
+// Len returns the number of entries and the bytes of content they hold.
+func (c *LRU) Len() (int, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ll.Len(), c.size
+}
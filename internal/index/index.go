@@ -0,0 +1,219 @@
+// Package index keeps the metadata of every file in a directory tree, so a
+// huge site can answer existence checks and conditional requests without
+// reading, or even stat-ing, its files.
+package index
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Meta is what's known about a file without reading it. ContentType comes
+// from the extension and is empty when the content would have to be
+// sniffed. Hash is only set when the index keeps content hashes.
+type Meta struct {
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+	Hash        string
+}
+
+// Index holds the Meta of every regular file under a directory, keyed by
+// full path.
+type Index struct {
+	dir    string
+	hashes bool
+
+	mu    sync.RWMutex
+	files map[string]Meta
+	size  int64
+}
+
+// Build walks dir, following symlinks, and indexes every file in it. With
+// hashes, each file is also read to hash its content.
+func Build(dir string, hashes bool) (*Index, error) {
+	ix := &Index{dir: dir, hashes: hashes, files: map[string]Meta{}}
+
+	err := ix.Rebuild()
+	if err != nil {
+		return nil, err
+	}
+
+	return ix, nil
+}
+
+// Rebuild walks the directory again, replacing the whole index once done.
+// Hashes are carried over for files whose size and modification time
+// haven't changed.
+func (ix *Index) Rebuild() error {
+	ix.mu.RLock()
+	prev := ix.files
+	ix.mu.RUnlock()
+
+	files := map[string]Meta{}
+
+	size, err := ix.walk(ix.dir, prev, files)
+	if err != nil {
+		return err
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.files = files
+	ix.size = size
+
+	return nil
+}
+
+func (ix *Index) walk(dir string, prev map[string]Meta, files map[string]Meta) (size int64, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range entries {
+		fullpath := filepath.Join(dir, entry.Name())
+
+		info, err := stat(fullpath, entry)
+		if err != nil {
+			return 0, err
+		}
+
+		if info.IsDir() {
+			s, err := ix.walk(fullpath, prev, files)
+			if err != nil {
+				return 0, err
+			}
+
+			size += s
+
+			continue
+		}
+
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		meta, err := ix.metaOf(fullpath, info, prev[fullpath])
+		if err != nil {
+			return 0, err
+		}
+
+		files[fullpath] = meta
+		size += meta.Size
+	}
+
+	return size, nil
+}
+
+// stat returns entry's info, following it if it's a symlink.
+func stat(fullpath string, entry fs.DirEntry) (fs.FileInfo, error) {
+	if entry.Type()&fs.ModeSymlink != 0 {
+		return os.Stat(fullpath)
+	}
+
+	return entry.Info()
+}
+
+// metaOf builds fullpath's Meta from info, hashing it if the index keeps
+// hashes and prev's is out of date.
+func (ix *Index) metaOf(fullpath string, info fs.FileInfo, prev Meta) (Meta, error) {
+	meta := Meta{
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+		ContentType: mime.TypeByExtension(filepath.Ext(fullpath)),
+	}
+
+	if !ix.hashes {
+		return meta, nil
+	}
+
+	if len(prev.Hash) > 0 && prev.Size == meta.Size && prev.ModTime.Equal(meta.ModTime) {
+		meta.Hash = prev.Hash
+		return meta, nil
+	}
+
+	hash, err := hashFile(fullpath)
+	if err != nil {
+		return Meta{}, err
+	}
+
+	meta.Hash = hash
+
+	return meta, nil
+}
+
+// hashFile returns the hex of the first 128 bits of fullpath's SHA-256.
+func hashFile(fullpath string) (string, error) {
+	f, err := os.Open(fullpath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
+
+// Get returns fullpath's Meta, if it's an indexed file.
+func (ix *Index) Get(fullpath string) (Meta, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	meta, ok := ix.files[fullpath]
+
+	return meta, ok
+}
+
+// Update indexes fullpath again after it was added, changed, or removed.
+func (ix *Index) Update(fullpath string) {
+	var meta Meta
+
+	info, err := os.Stat(fullpath)
+	if err == nil && info.Mode().IsRegular() {
+		meta, err = ix.metaOf(fullpath, info, Meta{})
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.size -= ix.files[fullpath].Size
+
+	if err != nil || !info.Mode().IsRegular() {
+		delete(ix.files, fullpath)
+		return
+	}
+
+	ix.files[fullpath] = meta
+	ix.size += meta.Size
+}
+
+// Len returns the number of indexed files.
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	return len(ix.files)
+}
+
+// Size returns the total size of the indexed files.
+func (ix *Index) Size() int64 {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	return ix.size
+}
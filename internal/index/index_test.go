@@ -0,0 +1,136 @@
+package index_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreyog/spa-server/internal/index"
+)
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(path, []byte(content), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "index.html"), "<html>")
+	writeFile(t, filepath.Join(dir, "assets", "app.js"), "app()")
+
+	ix, err := index.Build(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ix.Len() != 2 || ix.Size() != 11 {
+		t.Errorf("indexed %d files, %d bytes, want 2 files, 11 bytes", ix.Len(), ix.Size())
+	}
+
+	meta, ok := ix.Get(filepath.Join(dir, "assets", "app.js"))
+	if !ok {
+		t.Fatal("assets/app.js not indexed")
+	}
+
+	if meta.Size != 5 || meta.ModTime.IsZero() {
+		t.Errorf("meta = %+v", meta)
+	}
+
+	if _, ok := ix.Get(filepath.Join(dir, "assets")); ok {
+		t.Error("directory indexed as a file")
+	}
+}
+
+func TestBuildFollowsSymlinks(t *testing.T) {
+	t.Parallel()
+
+	release := t.TempDir()
+	writeFile(t, filepath.Join(release, "index.html"), "<html>")
+
+	link := filepath.Join(t.TempDir(), "current")
+
+	err := os.Symlink(release, link)
+	if err != nil {
+		t.Skip(err)
+	}
+
+	ix, err := index.Build(link, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := ix.Get(filepath.Join(link, "index.html")); !ok {
+		t.Error("index.html not indexed through the symlink")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	writeFile(t, path, "a")
+
+	ix, err := index.Build(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, path, "aaa")
+	ix.Update(path)
+
+	if meta, _ := ix.Get(path); meta.Size != 3 || ix.Size() != 3 {
+		t.Errorf("after change size = %d, total %d, want 3", meta.Size, ix.Size())
+	}
+
+	added := filepath.Join(dir, "b.txt")
+	writeFile(t, added, "b")
+	ix.Update(added)
+
+	if ix.Len() != 2 {
+		t.Errorf("after add Len = %d, want 2", ix.Len())
+	}
+
+	_ = os.Remove(path)
+	ix.Update(path)
+
+	if _, ok := ix.Get(path); ok || ix.Size() != 1 {
+		t.Errorf("after remove total = %d, want 1", ix.Size())
+	}
+}
+
+func TestHashes(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	writeFile(t, path, "a")
+
+	ix, err := index.Build(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, _ := ix.Get(path)
+	if len(before.Hash) != 32 {
+		t.Fatalf("hash = %q, want 32 hex digits", before.Hash)
+	}
+
+	writeFile(t, path, "b")
+	ix.Update(path)
+
+	if after, _ := ix.Get(path); after.Hash == before.Hash {
+		t.Error("hash unchanged after the content changed")
+	}
+}
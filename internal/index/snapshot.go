@@ -0,0 +1,90 @@
+package index
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// snapshotVersion changes whenever the snapshot format does, so old
+// snapshots are rebuilt rather than misread.
+const snapshotVersion = 1
+
+// ErrStaleSnapshot is returned by Load when the snapshot was taken of a
+// different directory or with different settings.
+var ErrStaleSnapshot = errors.New("index snapshot doesn't match")
+
+// snapshot is the gob-encoded form of an index.
+type snapshot struct {
+	Version int
+	Dir     string
+	Hashes  bool
+	Files   map[string]Meta
+}
+
+// Save writes the index to path, replacing any previous snapshot in one
+// rename so a crash never leaves half of one.
+func (ix *Index) Save(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".index-")
+	if err != nil {
+		return err
+	}
+
+	ix.mu.RLock()
+	err = gob.NewEncoder(tmp).Encode(snapshot{
+		Version: snapshotVersion,
+		Dir:     ix.dir,
+		Hashes:  ix.hashes,
+		Files:   ix.files,
+	})
+	ix.mu.RUnlock()
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+
+	return err
+}
+
+// Load reads the index of dir that Save wrote to path, without walking
+// dir. It returns ErrStaleSnapshot if the snapshot is of something else.
+func Load(path string, dir string, hashes bool) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var snap snapshot
+
+	err = gob.NewDecoder(f).Decode(&snap)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	if snap.Version != snapshotVersion || snap.Dir != dir || snap.Hashes != hashes {
+		return nil, ErrStaleSnapshot
+	}
+
+	if snap.Files == nil {
+		snap.Files = map[string]Meta{}
+	}
+
+	ix := &Index{dir: dir, hashes: hashes, files: snap.Files}
+
+	for _, meta := range snap.Files {
+		ix.size += meta.Size
+	}
+
+	return ix, nil
+}
@@ -0,0 +1,68 @@
+package index_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreyog/spa-server/internal/index"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "index.html")
+	writeFile(t, path, "<html>")
+
+	ix, err := index.Build(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snap := filepath.Join(t.TempDir(), "index.snap")
+
+	err = ix.Save(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := index.Load(snap, dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := ix.Get(path)
+	got, ok := loaded.Get(path)
+
+	if !ok || got.Hash != want.Hash || !got.ModTime.Equal(want.ModTime) || loaded.Size() != ix.Size() {
+		t.Errorf("loaded %+v (total %d), want %+v (total %d)", got, loaded.Size(), want, ix.Size())
+	}
+}
+
+func TestSnapshotStale(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "index.html"), "<html>")
+
+	ix, err := index.Build(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snap := filepath.Join(t.TempDir(), "index.snap")
+
+	err = ix.Save(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := index.Load(snap, t.TempDir(), false); !errors.Is(err, index.ErrStaleSnapshot) {
+		t.Errorf("other directory: err = %v, want ErrStaleSnapshot", err)
+	}
+
+	if _, err := index.Load(snap, dir, true); !errors.Is(err, index.ErrStaleSnapshot) {
+		t.Errorf("hashes wanted: err = %v, want ErrStaleSnapshot", err)
+	}
+}
@@ -0,0 +1,153 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// LevelSuccess is between Info and Warn, for lines worth a glance such as
+// cache hits. The color handler shows it in green; text and JSON output
+// report it as INFO so the usual level filters still find it.
+const LevelSuccess = slog.LevelInfo + 2
+
+// ColorHandler writes each record as a line holding just the message,
+// colored by level, followed by its attributes as key=value pairs. It's
+// meant for a terminal, which is why there's no time or level.
+type ColorHandler struct {
+	w     io.Writer
+	level slog.Leveler
+
+	mu     *sync.Mutex
+	attrs  string // preformatted attributes from WithAttrs
+	prefix string // the open groups, as a key prefix
+}
+
+// NewColorHandler creates a handler writing records at level or above to
+// w. Colors follow color.NoColor, so they're left out when the output isn't
+// a terminal.
+func NewColorHandler(w io.Writer, level slog.Leveler) *ColorHandler {
+	if level == nil {
+		level = slog.LevelInfo
+	}
+
+	return &ColorHandler{w: w, level: level, mu: &sync.Mutex{}}
+}
+
+// Enabled reports whether level is at or above the handler's level.
+func (h *ColorHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle writes r as a line.
+func (h *ColorHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+
+	b.WriteString(strings.TrimSuffix(r.Message, "\n"))
+	b.WriteString(h.attrs)
+
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(&b, h.prefix, a)
+		return true
+	})
+
+	line := b.String()
+
+	switch {
+	case r.Level >= slog.LevelError:
+		line = color.RedString("%s", line)
+	case r.Level >= slog.LevelWarn:
+		line = color.YellowString("%s", line)
+	case r.Level >= LevelSuccess:
+		line = color.GreenString("%s", line)
+	case r.Level < slog.LevelInfo:
+		line = color.New(color.Faint).Sprint(line)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, err := io.WriteString(h.w, line+"\n")
+
+	return err
+}
+
+// WithAttrs returns a handler adding attrs to every line.
+func (h *ColorHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+
+	for _, a := range attrs {
+		appendAttr(&b, h.prefix, a)
+	}
+
+	next := *h
+	next.attrs += b.String()
+
+	return &next
+}
+
+// WithGroup returns a handler qualifying the keys of later attributes with
+// name.
+func (h *ColorHandler) WithGroup(name string) slog.Handler {
+	if len(name) == 0 {
+		return h
+	}
+
+	next := *h
+	next.prefix += name + "."
+
+	return &next
+}
+
+// appendAttr writes a as " key=value", flattening groups into dotted keys.
+func appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		if len(a.Key) > 0 {
+			prefix += a.Key + "."
+		}
+
+		for _, ga := range a.Value.Group() {
+			appendAttr(b, prefix, ga)
+		}
+
+		return
+	}
+
+	var value string
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		value = a.Value.String()
+		if len(value) == 0 || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+	case slog.KindTime:
+		value = a.Value.Time().Format(time.RFC3339)
+	default:
+		value = fmt.Sprint(a.Value.Any())
+	}
+
+	b.WriteString(" " + prefix + a.Key + "=" + value)
+}
+
+// replaceLevel reports LevelSuccess as INFO for the text and JSON handlers.
+func replaceLevel(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 && a.Key == slog.LevelKey && a.Value.Any() == LevelSuccess {
+		a.Value = slog.StringValue(slog.LevelInfo.String())
+	}
+
+	return a
+}
@@ -0,0 +1,194 @@
+// Package logging writes the server's log lines through log/slog, colored
+// for a terminal by default, and carries per-request tags (country,
+// instance, ...) that prefix them.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/fatih/color"
+)
+
+type tagsKey struct{}
+
+// WithTag returns a copy of r whose log lines are prefixed with tag, after
+// any tags already on r.
+func WithTag(r *http.Request, tag string) *http.Request {
+	tags, _ := r.Context().Value(tagsKey{}).([]string)
+
+	// copy so sibling requests never share a backing array
+	next := make([]string, 0, len(tags)+1)
+	next = append(next, tags...)
+	next = append(next, tag)
+
+	return r.WithContext(context.WithValue(r.Context(), tagsKey{}, next))
+}
+
+// Prefix returns the tags on r formatted as a log line prefix, e.g.
+// "{web-1} [US] ", or an empty string if there are none.
+func Prefix(r *http.Request) string {
+	tags, _ := r.Context().Value(tagsKey{}).([]string)
+	if len(tags) == 0 {
+		return ""
+	}
+
+	return strings.Join(tags, " ") + " "
+}
+
+// output writes to color.Output as it is at the time, so redirecting it,
+// e.g. to stderr or to discard the log in tests, still works.
+type output struct{}
+
+func (output) Write(p []byte) (int, error) {
+	return color.Output.Write(p)
+}
+
+// Output is where the lines go: color.Output as it is at the time of each
+// write.
+var Output io.Writer = output{}
+
+var logger atomic.Pointer[slog.Logger]
+
+// accessLogged is set when there's an access log, which replaces the
+// request lines.
+var accessLogged atomic.Bool
+
+func init() {
+	logger.Store(slog.New(NewColorHandler(Output, slog.LevelInfo)))
+}
+
+// Logger returns the logger the lines go through, for subsystems that log
+// structured fields of their own.
+func Logger() *slog.Logger {
+	return logger.Load()
+}
+
+// SetLogger sends the lines through l from now on.
+func SetLogger(l *slog.Logger) {
+	logger.Store(l)
+}
+
+// New creates a logger writing to w in format, pretty for the colored lines
+// of a terminal or text or json for slog's handlers, dropping records below
+// level.
+func New(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: replaceLevel}
+
+	switch format {
+	case "pretty":
+		return slog.New(NewColorHandler(w, level)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+
+	return nil, fmt.Errorf("unknown log format %q, expected pretty, text, or json", format)
+}
+
+// Configure sends the lines to color.Output in format from now on, dropping
+// those below level, one of debug, info, warn, or error. Colors are turned
+// off for text and json so highlighted values come out plain.
+func Configure(format string, level string) error {
+	var lvl slog.Level
+
+	err := lvl.UnmarshalText([]byte(level))
+	if err != nil {
+		return err
+	}
+
+	l, err := New(Output, format, lvl)
+	if err != nil {
+		return err
+	}
+
+	if format != "pretty" {
+		color.NoColor = true
+	}
+
+	SetLogger(l)
+
+	return nil
+}
+
+func logf(level slog.Level, format string, a ...interface{}) {
+	l := Logger()
+	if !l.Enabled(context.Background(), level) {
+		return
+	}
+
+	l.Log(context.Background(), level, strings.TrimSuffix(fmt.Sprintf(format, a...), "\n"))
+}
+
+// SetAccessLogged tells Request whether there's an access log with a line
+// for every request, in which case the request lines are only written at
+// debug level.
+func SetAccessLogged(logged bool) {
+	accessLogged.Store(logged)
+}
+
+// Request logs a line at level about how r was handled, after r's tags.
+// These lines are the request log of the pretty format.
+func Request(r *http.Request, level slog.Level, format string, a ...interface{}) {
+	if accessLogged.Load() {
+		level = slog.LevelDebug
+	}
+
+	logf(level, "%s%s", Prefix(r), fmt.Sprintf(format, a...))
+}
+
+// RequestInfo logs a plain request line.
+func RequestInfo(r *http.Request, format string, a ...interface{}) {
+	Request(r, slog.LevelInfo, format, a...)
+}
+
+// RequestSuccess logs a green request line, e.g. a cache hit.
+func RequestSuccess(r *http.Request, format string, a ...interface{}) {
+	Request(r, LevelSuccess, format, a...)
+}
+
+// RequestWarn logs a yellow request line, e.g. a fallback or a redirect.
+func RequestWarn(r *http.Request, format string, a ...interface{}) {
+	Request(r, slog.LevelWarn, format, a...)
+}
+
+// RequestError logs a red request line, e.g. a 404.
+func RequestError(r *http.Request, format string, a ...interface{}) {
+	Request(r, slog.LevelError, format, a...)
+}
+
+// Debug logs a line only shown with --log-level debug.
+func Debug(format string, a ...interface{}) {
+	logf(slog.LevelDebug, format, a...)
+}
+
+// Info logs a plain line.
+func Info(format string, a ...interface{}) {
+	logf(slog.LevelInfo, format, a...)
+}
+
+// Success logs a green line, e.g. a cache hit.
+func Success(format string, a ...interface{}) {
+	logf(LevelSuccess, format, a...)
+}
+
+// Warn logs a yellow line, e.g. a request that was rewritten.
+func Warn(format string, a ...interface{}) {
+	logf(slog.LevelWarn, format, a...)
+}
+
+// Error logs a red line.
+func Error(format string, a ...interface{}) {
+	logf(slog.LevelError, format, a...)
+}
+
+// Highlight formats a string to stand out within a log line.
+func Highlight(format string, a ...interface{}) string {
+	return color.MagentaString(format, a...)
+}
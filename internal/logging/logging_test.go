@@ -0,0 +1,88 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+func TestPrefix(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	if got := Prefix(r); got != "" {
+		t.Fatalf("untagged request has prefix %q", got)
+	}
+
+	r = WithTag(r, "{web-1}")
+	r = WithTag(r, "[US]")
+
+	if got := Prefix(r); got != "{web-1} [US] " {
+		t.Fatalf("unexpected prefix %q", got)
+	}
+}
+
+func TestWithTagDoesNotShareTags(t *testing.T) {
+	t.Parallel()
+
+	base := WithTag(httptest.NewRequest("GET", "/", nil), "a")
+
+	first := WithTag(base, "b")
+	second := WithTag(base, "c")
+
+	if got := Prefix(first); got != "a b " {
+		t.Fatalf("unexpected prefix %q", got)
+	}
+
+	if got := Prefix(second); got != "a c " {
+		t.Fatalf("unexpected prefix %q", got)
+	}
+
+	if got := Prefix(base); got != "a " {
+		t.Fatalf("unexpected prefix %q", got)
+	}
+}
+
+func TestColorHandler(t *testing.T) {
+	color.NoColor = true
+
+	buf := &bytes.Buffer{}
+	l := slog.New(NewColorHandler(buf, slog.LevelInfo)).With("instance", "web-1").WithGroup("req")
+
+	l.Debug("hidden")
+	l.Info("served", "path", "/app.js", "bytes", 95)
+	l.Warn("slow", slog.Group("timing", "total", time.Second), "note", "two words")
+
+	want := "served instance=web-1 req.path=/app.js req.bytes=95\n" +
+		"slow instance=web-1 req.timing.total=1s req.note=\"two words\"\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestNewReportsSuccessAsInfo(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+
+	l, err := New(buf, "json", slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.Log(context.Background(), LevelSuccess, "hit")
+
+	if !strings.Contains(buf.String(), `"level":"INFO","msg":"hit"`) {
+		t.Errorf("unexpected line %s", buf)
+	}
+
+	if _, err := New(buf, "xml", slog.LevelInfo); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
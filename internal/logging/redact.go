@@ -0,0 +1,118 @@
+package logging
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces the values of redacted query parameters and headers.
+const Redacted = "REDACTED"
+
+// defaultParams match the query parameters that commonly carry credentials.
+var defaultParams = []string{
+	`.*token`,
+	`code`,
+	`.*api[_-]?key`,
+	`key`,
+	`secret`,
+	`password`,
+	`passwd`,
+	`sig`,
+	`signature`,
+	`x-amz-(credential|security-token|signature)`,
+	`x-goog-(credential|signature)`,
+}
+
+// defaultHeaders carry credentials or session state.
+var defaultHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+var (
+	redactMu      sync.RWMutex
+	redactParams  = compileParams(nil)
+	redactHeaders = canonicalHeaders(nil)
+)
+
+// SetRedaction adds params, patterns matched against whole query parameter
+// names ignoring case, and headers to what's redacted by default.
+func SetRedaction(params []string, headers []string) error {
+	for _, p := range params {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("invalid redacted parameter pattern %q: %w", p, err)
+		}
+	}
+
+	redactMu.Lock()
+	defer redactMu.Unlock()
+
+	redactParams = compileParams(params)
+	redactHeaders = canonicalHeaders(headers)
+
+	return nil
+}
+
+func compileParams(extra []string) *regexp.Regexp {
+	patterns := append(append([]string{}, defaultParams...), extra...)
+	return regexp.MustCompile(`(?i)^(?:` + strings.Join(patterns, "|") + `)$`)
+}
+
+func canonicalHeaders(extra []string) map[string]bool {
+	names := map[string]bool{}
+
+	for _, name := range append(append([]string{}, defaultHeaders...), extra...) {
+		names[http.CanonicalHeaderKey(name)] = true
+	}
+
+	return names
+}
+
+// RedactURL returns u's path and query for logging, with the values of
+// redacted query parameters replaced. The order and encoding of the rest of
+// the query is kept.
+func RedactURL(u *url.URL) string {
+	if len(u.RawQuery) == 0 {
+		return u.EscapedPath()
+	}
+
+	redactMu.RLock()
+	defer redactMu.RUnlock()
+
+	pairs := strings.Split(u.RawQuery, "&")
+
+	for i, pair := range pairs {
+		name, _, hasValue := strings.Cut(pair, "=")
+
+		unescaped, err := url.QueryUnescape(name)
+		if err != nil {
+			unescaped = name
+		}
+
+		if hasValue && redactParams.MatchString(unescaped) {
+			pairs[i] = name + "=" + Redacted
+		}
+	}
+
+	return u.EscapedPath() + "?" + strings.Join(pairs, "&")
+}
+
+// RedactHeaders returns a copy of h for logging with the values of redacted
+// headers replaced.
+func RedactHeaders(h http.Header) http.Header {
+	redactMu.RLock()
+	defer redactMu.RUnlock()
+
+	out := make(http.Header, len(h))
+
+	for name, values := range h {
+		if redactHeaders[http.CanonicalHeaderKey(name)] {
+			out[name] = []string{Redacted}
+		} else {
+			out[name] = values
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestRedactURL(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"/app":                                  "/app",
+		"/app?page=2":                           "/app?page=2",
+		"/cb?code=abc&state=xyz":                "/cb?code=REDACTED&state=xyz",
+		"/a?access_token=1&b=2&API_KEY=3":       "/a?access_token=REDACTED&b=2&API_KEY=REDACTED",
+		"/a?keyboard=qwerty":                    "/a?keyboard=qwerty",
+		"/a?X-Amz-Signature=f00&X-Amz-Date=now": "/a?X-Amz-Signature=REDACTED&X-Amz-Date=now",
+		"/a?%74oken=1":                          "/a?%74oken=REDACTED",
+		"/a?token":                              "/a?token",
+	}
+
+	for raw, want := range tests {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := RedactURL(u); got != want {
+			t.Errorf("RedactURL(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	t.Parallel()
+
+	h := http.Header{
+		"Authorization": {"Bearer secret"},
+		"Cookie":        {"session=1"},
+		"Accept":        {"text/html"},
+	}
+
+	got := RedactHeaders(h)
+
+	if got.Get("Authorization") != Redacted || got.Get("Cookie") != Redacted {
+		t.Errorf("credentials not redacted: %v", got)
+	}
+
+	if got.Get("Accept") != "text/html" {
+		t.Errorf("Accept = %q", got.Get("Accept"))
+	}
+
+	if h.Get("Authorization") != "Bearer secret" {
+		t.Error("RedactHeaders modified its argument")
+	}
+}
@@ -0,0 +1,103 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is a log file that's rotated once it reaches a size: FILE is
+// renamed to FILE.1, FILE.1 to FILE.2, and so on, dropping the oldest past
+// the number kept, and a new FILE is started.
+type RotatingFile struct {
+	path    string
+	maxSize int64
+	keep    int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotating opens the log file at path for appending, rotating it once
+// it's bigger than maxSize bytes and keeping keep rotated files.
+func OpenRotating(path string, maxSize int64, keep int) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, maxSize: maxSize, keep: keep}
+
+	err := rf.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	rf.file = file
+	rf.size = info.Size()
+
+	return nil
+}
+
+// Write appends p, rotating the file first if p would take it past its
+// size. Lines are never split across files.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		err := rf.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+
+	return n, err
+}
+
+// rotate shifts the rotated files along and starts a new one.
+func (rf *RotatingFile) rotate() error {
+	err := rf.file.Close()
+	if err != nil {
+		return err
+	}
+
+	if rf.keep < 1 {
+		err = os.Remove(rf.path)
+	} else {
+		for i := rf.keep - 1; i > 0; i-- {
+			// gaps are fine, e.g. before the first few rotations
+			_ = os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+		}
+
+		err = os.Rename(rf.path, rf.path+".1")
+	}
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return rf.open()
+}
+
+// Close closes the file.
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	return rf.file.Close()
+}
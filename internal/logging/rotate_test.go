@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "access.log")
+
+	rf, err := OpenRotating(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"access.log":   "four\nfive\n",
+		"access.log.1": "three\n",
+		"access.log.2": "one\ntwo\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(filepath.Dir(path), name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	matches, _ := filepath.Glob(path + ".*")
+	if len(matches) != 2 {
+		t.Errorf("kept %s, want 2 rotated files", strings.Join(matches, ", "))
+	}
+}
+
+func TestRotatingFileAppends(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "access.log")
+
+	if err := os.WriteFile(path, []byte("earlier\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rf, err := OpenRotating(path, 1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = rf.Write([]byte("later\n"))
+	_ = rf.Close()
+
+	got, _ := os.ReadFile(path)
+	if string(got) != "earlier\nlater\n" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,92 @@
+package resolver_test
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreyog/spa-server/internal/resolver"
+)
+
+// traversalSeeds are request targets that have broken path containment in
+// other static file servers.
+var traversalSeeds = []string{
+	"/",
+	"/index.html",
+	"/../../etc/passwd",
+	"/%2e%2e/%2e%2e/etc/passwd",
+	"/%252e%252e/etc/passwd",
+	"/..%2f..%2fetc/passwd",
+	"/..%5c..%5cwindows%5cwin.ini",
+	"/..\\..\\windows\\win.ini",
+	"/%c0%ae%c0%ae/etc/passwd",
+	"/\u2025/etc/passwd",
+	"/\uff0e\uff0e/etc/passwd",
+	"/app.js%00.html",
+	"/app.js\x00.html",
+	"/CON",
+	"/nul.txt",
+	"/docs/COM1:",
+	"/C:/Windows/win.ini",
+	"//server/share/file",
+	"/site2/../site/index.html",
+	"/docs/./../../",
+}
+
+func FuzzMap(f *testing.F) {
+	for _, seed := range traversalSeeds {
+		f.Add(seed)
+	}
+
+	dir := filepath.FromSlash("/srv/site")
+
+	res, err := resolver.New(dir, "index.html")
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Fuzz(func(t *testing.T, target string) {
+		u, err := url.ParseRequestURI(target)
+		if err != nil {
+			return
+		}
+
+		for _, path := range []string{target, u.Path} {
+			fullpath := res.Map(path)
+
+			if !res.Contains(fullpath) {
+				t.Fatalf("Map(%q) = %q escapes %q", path, fullpath, dir)
+			}
+
+			rel, err := filepath.Rel(dir, fullpath)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				t.Fatalf("Map(%q) = %q is outside %q", path, fullpath, dir)
+			}
+		}
+	})
+}
+
+func FuzzContains(f *testing.F) {
+	for _, seed := range traversalSeeds {
+		f.Add(filepath.FromSlash("/srv/site") + seed)
+	}
+
+	dir := filepath.FromSlash("/srv/site")
+
+	res, err := resolver.New(dir, "index.html")
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Fuzz(func(t *testing.T, fullpath string) {
+		if !res.Contains(fullpath) {
+			return
+		}
+
+		rel, err := filepath.Rel(dir, filepath.Clean(fullpath))
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			t.Fatalf("Contains(%q) is true but it resolves outside %q", fullpath, dir)
+		}
+	})
+}
@@ -0,0 +1,160 @@
+// Package resolver maps request paths onto the files they ask for in the
+// hosted directory.
+package resolver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrDefaultDocOutside is returned by New when the default doc would be
+// served from outside the hosted directory.
+var ErrDefaultDocOutside = errors.New("default doc is not in the directory")
+
+// ErrNoDefaultDoc is returned by New when it isn't given a default doc.
+var ErrNoDefaultDoc = errors.New("no default doc")
+
+// Resolver maps URL paths onto files under Dir. Directories map to the
+// first of DefaultDocs they contain and anything that escapes Dir maps to
+// the top-level default doc, which is what a single-page app wants for its
+// client-side routes.
+type Resolver struct {
+	Dir         string
+	DefaultDocs []string
+
+	// Exists reports whether a regular file exists at fullpath. It's only
+	// consulted to choose between several default docs and defaults to
+	// checking the file system.
+	Exists func(fullpath string) bool
+}
+
+// New creates a resolver for the absolute directory dir, trying
+// defaultDocs in order for each directory.
+func New(dir string, defaultDocs ...string) (*Resolver, error) {
+	if len(defaultDocs) == 0 {
+		return nil, ErrNoDefaultDoc
+	}
+
+	res := &Resolver{
+		Dir:         dir,
+		DefaultDocs: defaultDocs,
+		Exists:      isFile,
+	}
+
+	for _, doc := range defaultDocs {
+		if !res.Contains(filepath.Join(dir, doc)) {
+			return nil, ErrDefaultDocOutside
+		}
+	}
+
+	return res, nil
+}
+
+func isFile(fullpath string) bool {
+	info, err := os.Stat(fullpath)
+	return err == nil && !info.IsDir()
+}
+
+// DefaultPath returns the full path of the top-level default doc.
+func (res *Resolver) DefaultPath() string {
+	return res.Index(res.Dir)
+}
+
+// Index returns the full path of the first default doc that exists in dir,
+// or of the first default doc if none of them do.
+func (res *Resolver) Index(dir string) string {
+	first := filepath.Join(dir, res.DefaultDocs[0])
+
+	if len(res.DefaultDocs) == 1 {
+		return first
+	}
+
+	if index, ok := res.findIndex(dir); ok {
+		return index
+	}
+
+	return first
+}
+
+func (res *Resolver) findIndex(dir string) (string, bool) {
+	for _, doc := range res.DefaultDocs {
+		fullpath := filepath.Join(dir, doc)
+		if res.Exists(fullpath) {
+			return fullpath, true
+		}
+	}
+
+	return "", false
+}
+
+// NearestIndex returns the default doc of the closest directory enclosing
+// fullpath that has one, so each app in a subdirectory handles its own
+// client-side routes. It's the top-level default doc if none do.
+func (res *Resolver) NearestIndex(fullpath string) string {
+	if !res.Contains(fullpath) {
+		return res.DefaultPath()
+	}
+
+	for dir := filepath.Dir(fullpath); res.Contains(dir) && dir != res.Dir; dir = filepath.Dir(dir) {
+		if index, ok := res.findIndex(dir); ok && index != fullpath {
+			return index
+		}
+	}
+
+	return res.DefaultPath()
+}
+
+// Map returns the full path of the file urlPath asks for. On Windows, paths
+// naming devices or streams rather than plain files map to the default doc.
+func (res *Resolver) Map(urlPath string) string {
+	if Reserved(urlPath) {
+		return res.DefaultPath()
+	}
+
+	fullpath := filepath.Join(res.Dir, urlPath)
+	if !res.Contains(fullpath) {
+		return res.DefaultPath()
+	}
+
+	if strings.HasSuffix(urlPath, "/") {
+		return res.Index(fullpath)
+	}
+
+	return fullpath
+}
+
+// Contains reports whether fullpath is Dir or inside it once cleaned.
+func (res *Resolver) Contains(fullpath string) bool {
+	return Within(res.Dir, fullpath)
+}
+
+// Within reports whether fullpath is dir or inside it once both are
+// cleaned. Windows paths are compared case-insensitively, as the file
+// system does.
+func Within(dir string, fullpath string) bool {
+	dir = filepath.Clean(dir)
+	fullpath = filepath.Clean(fullpath)
+
+	if windows {
+		dir = strings.ToLower(dir)
+		fullpath = strings.ToLower(fullpath)
+	}
+
+	if fullpath == dir {
+		return true
+	}
+
+	if !strings.HasSuffix(dir, string(os.PathSeparator)) {
+		dir += string(os.PathSeparator)
+	}
+
+	return strings.HasPrefix(fullpath, dir)
+}
+
+// Rel returns fullpath relative to Dir as a slash-separated path with a
+// leading slash, so it can be compared with the request path.
+func (res *Resolver) Rel(fullpath string) string {
+	return filepath.ToSlash(strings.TrimPrefix(fullpath, strings.TrimSuffix(res.Dir, string(os.PathSeparator))))
+}
@@ -0,0 +1,181 @@
+package resolver_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/coreyog/spa-server/internal/resolver"
+)
+
+func TestNewRejectsEscapingDefaultDoc(t *testing.T) {
+	t.Parallel()
+
+	_, err := resolver.New(filepath.FromSlash("/srv/site"), "../index.html")
+	if err != resolver.ErrDefaultDocOutside {
+		t.Fatalf("expected ErrDefaultDocOutside, got %v", err)
+	}
+}
+
+func TestMap(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.FromSlash("/srv/site")
+
+	res, err := resolver.New(dir, "index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "/srv/site/index.html"},
+		{"/app.js", "/srv/site/app.js"},
+		{"/docs/", "/srv/site/docs/index.html"},
+		{"/docs/guide", "/srv/site/docs/guide"},
+		{"/a/../b.css", "/srv/site/b.css"},
+		{"/../../etc/passwd", "/srv/site/index.html"},
+		{"../site2/secret", "/srv/site/index.html"},
+	}
+
+	for _, tt := range tests {
+		if got := res.Map(tt.path); got != filepath.FromSlash(tt.want) {
+			t.Errorf("Map(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	t.Parallel()
+
+	res, err := resolver.New(filepath.FromSlash("/srv/site"), "index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]bool{
+		"/srv/site":         true,
+		"/srv/site/app.js":  true,
+		"/srv/site2/app.js": false,
+		"/srv/sitemap.xml":  false,
+		"/etc/passwd":       false,
+	}
+
+	for path, want := range tests {
+		if got := res.Contains(filepath.FromSlash(path)); got != want {
+			t.Errorf("Contains(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestRel(t *testing.T) {
+	t.Parallel()
+
+	res, err := resolver.New(filepath.FromSlash("/srv/site"), "index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := res.Rel(filepath.FromSlash("/srv/site/docs/index.html"))
+	if want := "/docs/index.html"; got != want {
+		t.Errorf("Rel = %q, want %q", got, want)
+	}
+}
+
+func TestMapDefaultDocsInOrder(t *testing.T) {
+	t.Parallel()
+
+	res, err := resolver.New(filepath.FromSlash("/srv/site"), "index.html", "index.htm", "default.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	existing := map[string]bool{
+		filepath.FromSlash("/srv/site/index.html"):         true,
+		filepath.FromSlash("/srv/site/old/default.html"):   true,
+		filepath.FromSlash("/srv/site/mixed/index.htm"):    true,
+		filepath.FromSlash("/srv/site/mixed/default.html"): true,
+	}
+
+	res.Exists = func(fullpath string) bool {
+		return existing[fullpath]
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "/srv/site/index.html"},
+		{"/old/", "/srv/site/old/default.html"},
+		{"/mixed/", "/srv/site/mixed/index.htm"},
+		{"/empty/", "/srv/site/empty/index.html"},
+		{"/../", "/srv/site/index.html"},
+	}
+
+	for _, tt := range tests {
+		if got := res.Map(tt.path); got != filepath.FromSlash(tt.want) {
+			t.Errorf("Map(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultPathFallsBackToFirstDoc(t *testing.T) {
+	t.Parallel()
+
+	res, err := resolver.New(filepath.FromSlash("/srv/site"), "index.html", "index.htm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res.Exists = func(string) bool { return false }
+
+	if got, want := res.DefaultPath(), filepath.FromSlash("/srv/site/index.html"); got != want {
+		t.Errorf("DefaultPath = %q, want %q", got, want)
+	}
+}
+
+func TestNewRequiresDefaultDoc(t *testing.T) {
+	t.Parallel()
+
+	_, err := resolver.New(filepath.FromSlash("/srv/site"))
+	if err != resolver.ErrNoDefaultDoc {
+		t.Fatalf("expected ErrNoDefaultDoc, got %v", err)
+	}
+}
+
+func TestNearestIndex(t *testing.T) {
+	t.Parallel()
+
+	res, err := resolver.New(filepath.FromSlash("/srv/site"), "index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	existing := map[string]bool{
+		filepath.FromSlash("/srv/site/index.html"):       true,
+		filepath.FromSlash("/srv/site/admin/index.html"): true,
+	}
+
+	res.Exists = func(fullpath string) bool {
+		return existing[fullpath]
+	}
+
+	tests := []struct {
+		fullpath string
+		want     string
+	}{
+		{"/srv/site/app/dashboard", "/srv/site/index.html"},
+		{"/srv/site/admin/users/42", "/srv/site/admin/index.html"},
+		{"/srv/site/admin/settings", "/srv/site/admin/index.html"},
+		{"/srv/site/admin/index.html", "/srv/site/index.html"},
+		{"/srv/site/administrator/x", "/srv/site/index.html"},
+		{"/srv/other/admin/x", "/srv/site/index.html"},
+	}
+
+	for _, tt := range tests {
+		if got := res.NearestIndex(filepath.FromSlash(tt.fullpath)); got != filepath.FromSlash(tt.want) {
+			t.Errorf("NearestIndex(%q) = %q, want %q", tt.fullpath, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+//go:build windows
+
+package resolver_test
+
+import (
+	"testing"
+
+	"github.com/coreyog/spa-server/internal/resolver"
+)
+
+func TestMapWindows(t *testing.T) {
+	t.Parallel()
+
+	res, err := resolver.New(`C:\srv\site`, "index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", `C:\srv\site\index.html`},
+		{"/docs/", `C:\srv\site\docs\index.html`},
+		{"/app.js", `C:\srv\site\app.js`},
+		{`/docs\guide.html`, `C:\srv\site\docs\guide.html`},
+		{`/..\..\Windows\win.ini`, `C:\srv\site\index.html`},
+		{`/docs\..\..\site2\app.js`, `C:\srv\site\index.html`},
+		{"/C:/Windows/win.ini", `C:\srv\site\index.html`},
+		{"/D:secret.txt", `C:\srv\site\index.html`},
+		{"//server/share/app.js", `C:\srv\site\server\share\app.js`},
+		{"/CON", `C:\srv\site\index.html`},
+		{"/nul.txt", `C:\srv\site\index.html`},
+		{"/index.html::$DATA", `C:\srv\site\index.html`},
+		{"/app.js.", `C:\srv\site\index.html`},
+		{"/app.js%20", `C:\srv\site\app.js%20`},
+	}
+
+	for _, tt := range tests {
+		if got := res.Map(tt.path); got != tt.want {
+			t.Errorf("Map(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestWithinWindows(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		dir      string
+		fullpath string
+		want     bool
+	}{
+		{`C:\srv\site`, `C:\srv\site\app.js`, true},
+		{`C:\srv\site`, `c:\SRV\Site\app.js`, true},
+		{`C:\srv\site\`, `C:\srv\site`, true},
+		{`C:\srv\site`, `C:\srv\site2\app.js`, false},
+		{`C:\srv\site`, `D:\srv\site\app.js`, false},
+		{`C:\srv\site`, `C:\srv\site\..\secret.txt`, false},
+		{`C:\srv\site`, `\\server\share\srv\site\app.js`, false},
+	}
+
+	for _, tt := range tests {
+		if got := resolver.Within(tt.dir, tt.fullpath); got != tt.want {
+			t.Errorf("Within(%q, %q) = %v, want %v", tt.dir, tt.fullpath, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package resolver
+
+import (
+	"runtime"
+	"strings"
+)
+
+// windows enables the extra checks for names Windows treats specially.
+var windows = runtime.GOOS == "windows"
+
+// reservedNames are the DOS device names Windows opens in place of a file
+// in any directory, with or without an extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"CONIN$": true, "CONOUT$": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+	"COM¹": true, "COM²": true, "COM³": true,
+	"LPT¹": true, "LPT²": true, "LPT³": true,
+}
+
+// Reserved reports whether urlPath names something other than a plain file
+// on this platform. It's only ever true on Windows, see windowsUnsafe.
+func Reserved(urlPath string) bool {
+	return windows && windowsUnsafe(urlPath)
+}
+
+// windowsUnsafe reports whether urlPath has a segment Windows wouldn't
+// open as the plain file it names: device names (NUL, com1.txt), drive
+// letters and alternate data streams (C:, index.html::$DATA), and names
+// with trailing dots or spaces, which Windows silently strips so that
+// "app.js." opens app.js behind the back of any extension check.
+func windowsUnsafe(urlPath string) bool {
+	segments := strings.FieldsFunc(urlPath, func(r rune) bool {
+		return r == '/' || r == '\\'
+	})
+
+	for _, seg := range segments {
+		if seg == "." || seg == ".." {
+			continue
+		}
+
+		if strings.ContainsAny(seg, ":\x00") || strings.HasSuffix(seg, ".") || strings.HasSuffix(seg, " ") {
+			return true
+		}
+
+		name := seg
+		if i := strings.IndexByte(name, '.'); i >= 0 {
+			name = name[:i]
+		}
+
+		if reservedNames[strings.ToUpper(strings.TrimRight(name, " "))] {
+			return true
+		}
+	}
+
+	return false
+}
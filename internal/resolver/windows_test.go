@@ -0,0 +1,42 @@
+package resolver
+
+import "testing"
+
+// windowsUnsafe is plain string handling, so it's tested on every platform
+// even though only Windows uses it.
+func TestWindowsUnsafe(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]bool{
+		"/":                    false,
+		"/index.html":          false,
+		"/docs/guide.html":     false,
+		"/console.js":          false,
+		"/nullable/index.html": false,
+		"/com10.txt":           false,
+		"/../app.js":           false,
+		"/CON":                 true,
+		"/con":                 true,
+		"/nul.txt":             true,
+		"/docs/Com1.json":      true,
+		"/lpt9":                true,
+		"/aux.tar.gz":          true,
+		"/prn /x":              true,
+		"/CONIN$":              true,
+		"/assets/COM¹.png":     true,
+		"/nul/index.html":      true,
+		"/C:/Windows/win.ini":  true,
+		"/index.html::$DATA":   true,
+		"/app.js.":             true,
+		"/app.js ":             true,
+		"/docs./index.html":    true,
+		`/assets\con\logo.png`: true,
+		"/app.js\x00.png":      true,
+	}
+
+	for path, want := range tests {
+		if got := windowsUnsafe(path); got != want {
+			t.Errorf("windowsUnsafe(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
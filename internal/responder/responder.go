@@ -0,0 +1,239 @@
+// Package responder writes cached entries as HTTP responses.
+package responder
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/cache"
+)
+
+// Write writes entry as the response, or a 304 if the client already has
+// it. As usual If-None-Match takes precedence over If-Modified-Since.
+func Write(w http.ResponseWriter, r *http.Request, entry *cache.Entry) {
+	if !entry.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", entry.ModTime.UTC().Format(http.TimeFormat))
+	}
+
+	if len(entry.ETag) > 0 {
+		w.Header().Set("ETag", entry.ETag)
+	}
+
+	if NotModified(r, entry.ETag) || (len(r.Header.Get("If-None-Match")) == 0 && NotModifiedSince(r, entry.ModTime)) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Add("Content-Type", entry.ContentType)
+
+	if r.Method == http.MethodGet && len(r.Header.Get("Range")) > 0 {
+		// ServeContent checks If-Range against the ETag and Last-Modified
+		// set above and answers with the ranges, or a 416
+		http.ServeContent(w, r, "", entry.ModTime, bytes.NewReader(entry.Content))
+		return
+	}
+
+	w.Header().Add("Content-Length", strconv.Itoa(len(entry.Content)))
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	content := entry.Content
+
+	if head := headEnd(entry); head > 0 {
+		// send the <head> on its own so the browser can start on the
+		// stylesheets and scripts it links while the rest follows
+		writeBody(w, r, content[:head])
+		_ = http.NewResponseController(w).Flush()
+
+		content = content[head:]
+	}
+
+	writeBody(w, r, content)
+}
+
+// Stream writes a file as the response, as Write does an entry, reading it
+// from content as it's sent instead of holding it in memory, and answers
+// Range requests from it. entry describes the file and has no content.
+func Stream(w http.ResponseWriter, r *http.Request, entry *cache.Entry, content io.ReadSeeker) {
+	if len(entry.ETag) > 0 {
+		w.Header().Set("ETag", entry.ETag)
+	}
+
+	if len(entry.ContentType) > 0 {
+		w.Header().Set("Content-Type", entry.ContentType)
+	}
+
+	// ServeContent sets Last-Modified and Accept-Ranges, and answers the
+	// conditions as Write does
+	http.ServeContent(w, r, "", entry.ModTime, content)
+}
+
+// headEnd returns the offset just past an HTML entry's </head>, or 0 if it
+// isn't HTML or has no head worth sending early.
+func headEnd(entry *cache.Entry) int {
+	if len(entry.Content) <= chunkSize || !strings.HasPrefix(entry.ContentType, "text/html") {
+		return 0
+	}
+
+	i := bytes.Index(entry.Content, []byte("</head>"))
+	if i < 0 {
+		i = bytes.Index(entry.Content, []byte("</HEAD>"))
+	}
+
+	if i < 0 {
+		return 0
+	}
+
+	return i + len("</head>")
+}
+
+// chunkSize is how much of a body is written between checks for the client
+// having gone away.
+const chunkSize = 64 << 10
+
+// aborted counts responses cut short because the client went away.
+var aborted int64
+
+// Aborted returns how many responses were cut short because the client
+// disconnected or the request's deadline passed.
+func Aborted() int64 {
+	return atomic.LoadInt64(&aborted)
+}
+
+// writeBody writes content in chunks, stopping as soon as r's context is
+// done or a write fails rather than pushing the rest at a closed
+// connection.
+func writeBody(w http.ResponseWriter, r *http.Request, content []byte) {
+	for len(content) > 0 {
+		if r.Context().Err() != nil {
+			atomic.AddInt64(&aborted, 1)
+			return
+		}
+
+		n := len(content)
+		if n > chunkSize {
+			n = chunkSize
+		}
+
+		_, err := w.Write(content[:n])
+		if err != nil {
+			atomic.AddInt64(&aborted, 1)
+			return
+		}
+
+		content = content[n:]
+	}
+}
+
+// WeakETag derives a weak validator from content. Weak is the honest
+// choice for generated representations, which are semantically but not
+// necessarily byte-for-byte stable across deployments.
+func WeakETag(content []byte) string {
+	h := fnv.New64a()
+	_, _ = h.Write(content)
+
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+// NotModified reports whether r's If-None-Match matches etag using the weak
+// comparison required for If-None-Match.
+func NotModified(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if len(header) == 0 || len(etag) == 0 {
+		return false
+	}
+
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	want := strings.TrimPrefix(etag, "W/")
+
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == want {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NotModifiedSince reports whether r's If-Modified-Since is no earlier
+// than modTime, which HTTP dates only have to the second.
+func NotModifiedSince(r *http.Request, modTime time.Time) bool {
+	if modTime.IsZero() {
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	return !modTime.Truncate(time.Second).After(since)
+}
+
+// StrongETag derives a strong validator from content, the hex of the first
+// 128 bits of its SHA-256 as the index uses, so either can answer a
+// client's If-None-Match.
+func StrongETag(content []byte) string {
+	sum := sha256.Sum256(content)
+
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// FileETag derives a strong validator from a file's modification time and
+// size, which is cheaper than hashing it when it isn't kept in memory.
+func FileETag(modTime time.Time, size int64) string {
+	return fmt.Sprintf(`"%x-%x"`, modTime.UnixNano(), size)
+}
+
+// Types remembers the content type detected for each file extension.
+type Types struct {
+	byExt sync.Map // map[string]string{}
+}
+
+// Detect determines the content type of a file from its extension, sniffing
+// raw when the extension isn't registered.
+func (t *Types) Detect(fullpath string, raw []byte) string {
+	ext := filepath.Ext(fullpath)
+	if len(ext) == 0 {
+		return ""
+	}
+
+	if contentType, ok := t.byExt.Load(ext); ok {
+		return contentType.(string)
+	}
+
+	contentType := mime.TypeByExtension(ext)
+
+	if len(contentType) == 0 {
+		length := len(raw)
+		if length > 512 {
+			length = 512
+		}
+
+		contentType = http.DetectContentType(raw[:length])
+	}
+
+	if contentType != "application/octet-stream" {
+		t.byExt.Store(ext, contentType)
+	}
+
+	return contentType
+}
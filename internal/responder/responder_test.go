@@ -0,0 +1,297 @@
+package responder_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/cache"
+	"github.com/coreyog/spa-server/internal/responder"
+)
+
+func TestWrite(t *testing.T) {
+	t.Parallel()
+
+	entry := &cache.Entry{Content: []byte("hello"), ContentType: "text/plain"}
+
+	rec := httptest.NewRecorder()
+	responder.Write(rec, httptest.NewRequest(http.MethodGet, "/", nil), entry)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", rec.Code)
+	}
+
+	if got := rec.Header().Get("Content-Type"); got != "text/plain" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	if got := rec.Header().Get("Content-Length"); got != "5" {
+		t.Errorf("Content-Length = %q", got)
+	}
+
+	if rec.Body.String() != "hello" {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+
+	if len(rec.Header().Get("ETag")) > 0 {
+		t.Error("unexpected ETag on an untransformed entry")
+	}
+}
+
+func TestWriteHead(t *testing.T) {
+	t.Parallel()
+
+	entry := &cache.Entry{Content: []byte("hello"), ContentType: "text/plain"}
+
+	rec := httptest.NewRecorder()
+	responder.Write(rec, httptest.NewRequest(http.MethodHead, "/", nil), entry)
+
+	if got := rec.Header().Get("Content-Length"); got != "5" {
+		t.Errorf("Content-Length = %q", got)
+	}
+
+	if rec.Body.Len() > 0 {
+		t.Errorf("HEAD wrote a body: %q", rec.Body.String())
+	}
+}
+
+func TestWriteNotModified(t *testing.T) {
+	t.Parallel()
+
+	content := []byte("hello")
+	entry := &cache.Entry{Content: content, ContentType: "text/plain", ETag: responder.WeakETag(content)}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", entry.ETag)
+
+	rec := httptest.NewRecorder()
+	responder.Write(rec, req, entry)
+
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("status = %d, want 304", rec.Code)
+	}
+
+	if rec.Body.Len() > 0 {
+		t.Errorf("304 wrote a body: %q", rec.Body.String())
+	}
+
+	if rec.Header().Get("ETag") != entry.ETag {
+		t.Errorf("ETag = %q, want %q", rec.Header().Get("ETag"), entry.ETag)
+	}
+}
+
+func TestWriteNotModifiedSince(t *testing.T) {
+	t.Parallel()
+
+	modTime := time.Date(2024, time.January, 1, 12, 0, 0, 500, time.UTC)
+	entry := &cache.Entry{Content: []byte("hello"), ContentType: "text/plain", ModTime: modTime}
+
+	for _, tc := range []struct {
+		since string
+		match string
+		want  int
+	}{
+		{since: "Mon, 01 Jan 2024 12:00:00 GMT", want: http.StatusNotModified},
+		{since: "Mon, 01 Jan 2024 11:59:59 GMT", want: http.StatusOK},
+		{since: "not a date", want: http.StatusOK},
+		{since: "Mon, 01 Jan 2024 12:00:00 GMT", match: `"other"`, want: http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("If-Modified-Since", tc.since)
+
+		if len(tc.match) > 0 {
+			req.Header.Set("If-None-Match", tc.match)
+		}
+
+		rec := httptest.NewRecorder()
+		responder.Write(rec, req, entry)
+
+		if rec.Code != tc.want {
+			t.Errorf("If-Modified-Since %q, If-None-Match %q: status = %d, want %d", tc.since, tc.match, rec.Code, tc.want)
+		}
+
+		if got := rec.Header().Get("Last-Modified"); got != "Mon, 01 Jan 2024 12:00:00 GMT" {
+			t.Errorf("Last-Modified = %q", got)
+		}
+	}
+}
+
+func TestWriteRange(t *testing.T) {
+	t.Parallel()
+
+	entry := &cache.Entry{Content: []byte("hello world"), ContentType: "text/plain", ETag: `"v1"`}
+
+	for _, tc := range []struct {
+		rangeHeader, ifRange string
+		status               int
+		body                 string
+	}{
+		{"bytes=6-", "", http.StatusPartialContent, "world"},
+		{"bytes=-5", `"v1"`, http.StatusPartialContent, "world"},
+		{"bytes=6-", `"v0"`, http.StatusOK, "hello world"},
+		{"bytes=20-", "", http.StatusRequestedRangeNotSatisfiable, ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Range", tc.rangeHeader)
+
+		if len(tc.ifRange) > 0 {
+			req.Header.Set("If-Range", tc.ifRange)
+		}
+
+		rec := httptest.NewRecorder()
+		responder.Write(rec, req, entry)
+
+		if rec.Code != tc.status {
+			t.Errorf("Range %s, If-Range %s: status = %d, want %d", tc.rangeHeader, tc.ifRange, rec.Code, tc.status)
+		}
+
+		if len(tc.body) > 0 && rec.Body.String() != tc.body {
+			t.Errorf("Range %s, If-Range %s: body = %q, want %q", tc.rangeHeader, tc.ifRange, rec.Body.String(), tc.body)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	t.Parallel()
+
+	modTime := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	entry := &cache.Entry{ContentType: "video/mp4", ETag: `"v1"`, ModTime: modTime}
+
+	req := httptest.NewRequest(http.MethodGet, "/clip.mp4", nil)
+	req.Header.Set("Range", "bytes=2-4")
+
+	rec := httptest.NewRecorder()
+	responder.Stream(rec, req, entry, strings.NewReader("0123456789"))
+
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "234" {
+		t.Errorf("got %d %q, want 206 \"234\"", rec.Code, rec.Body.String())
+	}
+
+	for name, want := range map[string]string{
+		"Content-Type":  "video/mp4",
+		"Content-Range": "bytes 2-4/10",
+		"Accept-Ranges": "bytes",
+		"ETag":          `"v1"`,
+		"Last-Modified": "Mon, 01 Jan 2024 00:00:00 GMT",
+	} {
+		if got := rec.Header().Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/clip.mp4", nil)
+	req.Header.Set("If-None-Match", `"v1"`)
+
+	rec = httptest.NewRecorder()
+	responder.Stream(rec, req, entry, strings.NewReader("0123456789"))
+
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: status = %d, want 304", rec.Code)
+	}
+}
+
+func TestWriteAborted(t *testing.T) {
+	t.Parallel()
+
+	entry := &cache.Entry{Content: []byte("hello"), ContentType: "text/plain"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	before := responder.Aborted()
+
+	rec := httptest.NewRecorder()
+	responder.Write(rec, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx), entry)
+
+	if rec.Body.Len() > 0 {
+		t.Errorf("wrote %q to a client that went away", rec.Body.String())
+	}
+
+	if responder.Aborted() <= before {
+		t.Error("abort not counted")
+	}
+}
+
+func TestWriteFlushesHead(t *testing.T) {
+	t.Parallel()
+
+	content := "<html><head><link rel=stylesheet href=app.css></head><body>" + strings.Repeat("x", 100<<10) + "</body></html>"
+	entry := &cache.Entry{Content: []byte(content), ContentType: "text/html; charset=utf-8"}
+
+	rec := httptest.NewRecorder()
+	responder.Write(rec, httptest.NewRequest(http.MethodGet, "/", nil), entry)
+
+	if !rec.Flushed {
+		t.Error("head not flushed ahead of the body")
+	}
+
+	if rec.Body.String() != content {
+		t.Error("body changed")
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	t.Parallel()
+
+	etag := `W/"abc"`
+
+	tests := map[string]bool{
+		"":               false,
+		"*":              true,
+		`W/"abc"`:        true,
+		`"abc"`:          true,
+		`"xyz", W/"abc"`: true,
+		`"xyz"`:          false,
+	}
+
+	for header, want := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if len(header) > 0 {
+			req.Header.Set("If-None-Match", header)
+		}
+
+		if got := responder.NotModified(req, etag); got != want {
+			t.Errorf("If-None-Match %q: got %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestWeakETagIsStable(t *testing.T) {
+	t.Parallel()
+
+	a := responder.WeakETag([]byte("same"))
+	if a != responder.WeakETag([]byte("same")) {
+		t.Error("ETag differs for identical content")
+	}
+
+	if a == responder.WeakETag([]byte("different")) {
+		t.Error("ETag matches for different content")
+	}
+}
+
+func TestTypesDetect(t *testing.T) {
+	t.Parallel()
+
+	types := &responder.Types{}
+
+	if got := types.Detect("/srv/app.css", nil); got != "text/css; charset=utf-8" {
+		t.Errorf("app.css = %q", got)
+	}
+
+	if got := types.Detect("/srv/README", []byte("plain")); len(got) > 0 {
+		t.Errorf("extensionless file = %q, want empty", got)
+	}
+
+	// unregistered extensions are sniffed and remembered
+	first := types.Detect("/srv/page.spa-unknown", []byte("<html><body>hi</body></html>"))
+	if first != "text/html; charset=utf-8" {
+		t.Errorf("sniffed = %q", first)
+	}
+
+	if got := types.Detect("/srv/other.spa-unknown", []byte("not html")); got != first {
+		t.Errorf("remembered = %q, want %q", got, first)
+	}
+}
@@ -0,0 +1,56 @@
+// Package ring assigns keys to nodes by consistent hashing, so adding or
+// removing a node only moves the keys that node owns.
+package ring
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// Ring maps keys onto a fixed set of nodes.
+type Ring struct {
+	hashes []uint32
+	nodes  map[uint32]string
+}
+
+// New creates a ring placing each node at replicas points, more of which
+// spread keys more evenly.
+func New(replicas int, nodes ...string) *Ring {
+	r := &Ring{nodes: map[uint32]string{}}
+
+	for _, node := range nodes {
+		for i := 0; i < replicas; i++ {
+			hash := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + node))
+
+			r.hashes = append(r.hashes, hash)
+			r.nodes[hash] = node
+		}
+	}
+
+	sort.Slice(r.hashes, func(i, j int) bool {
+		return r.hashes[i] < r.hashes[j]
+	})
+
+	return r
+}
+
+// Get returns the node owning key, or an empty string if the ring has no
+// nodes.
+func (r *Ring) Get(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+
+	hash := crc32.ChecksumIEEE([]byte(key))
+
+	i := sort.Search(len(r.hashes), func(i int) bool {
+		return r.hashes[i] >= hash
+	})
+
+	if i == len(r.hashes) {
+		i = 0 // wrap around
+	}
+
+	return r.nodes[r.hashes[i]]
+}
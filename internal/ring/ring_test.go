@@ -0,0 +1,61 @@
+package ring_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/coreyog/spa-server/internal/ring"
+)
+
+func TestGetIsStable(t *testing.T) {
+	t.Parallel()
+
+	a := ring.New(50, "a", "b", "c")
+	b := ring.New(50, "c", "a", "b")
+
+	for i := 0; i < 1000; i++ {
+		key := "/assets/" + strconv.Itoa(i) + ".js"
+		if a.Get(key) != b.Get(key) {
+			t.Fatalf("%s maps to %s and %s depending on node order", key, a.Get(key), b.Get(key))
+		}
+	}
+}
+
+func TestGetSpreadsKeys(t *testing.T) {
+	t.Parallel()
+
+	r := ring.New(50, "a", "b", "c")
+	counts := map[string]int{}
+
+	for i := 0; i < 3000; i++ {
+		counts[r.Get("/assets/"+strconv.Itoa(i)+".js")]++
+	}
+
+	for _, node := range []string{"a", "b", "c"} {
+		if counts[node] < 500 {
+			t.Errorf("%s owns %d of 3000 keys", node, counts[node])
+		}
+	}
+}
+
+func TestAddingANodeOnlyMovesItsKeys(t *testing.T) {
+	t.Parallel()
+
+	before := ring.New(50, "a", "b")
+	after := ring.New(50, "a", "b", "c")
+
+	for i := 0; i < 1000; i++ {
+		key := "/assets/" + strconv.Itoa(i) + ".js"
+		if owner := after.Get(key); owner != "c" && owner != before.Get(key) {
+			t.Errorf("%s moved from %s to %s", key, before.Get(key), owner)
+		}
+	}
+}
+
+func TestGetEmpty(t *testing.T) {
+	t.Parallel()
+
+	if node := ring.New(50).Get("/index.html"); len(node) != 0 {
+		t.Errorf("empty ring returned %q", node)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// openFilesReserve is how many descriptors are set aside on top of
+// --expected-conns for listeners, log files, the watcher, and files being
+// streamed or loaded.
+const openFilesReserve = 64
+
+// checkOpenFiles makes sure the open file limit leaves room for
+// --expected-conns connections, raising it where the system allows, and
+// warns if it can't. Past the limit, connections are dropped and files fail
+// to open with "too many open files".
+func checkOpenFiles() {
+	need := uint64(args.ExpectedConns) + openFilesReserve
+
+	limit, max, err := openFileLimit()
+	if errors.Is(err, errors.ErrUnsupported) {
+		return
+	}
+
+	if err != nil {
+		logging.Warn("unable to check the open file limit: %s", err)
+		return
+	}
+
+	if limit >= need {
+		logging.Debug("open file limit %d, %d needed", limit, need)
+		return
+	}
+
+	// raising the hard limit takes privileges, the soft one only goes up
+	// to it
+	if setOpenFileLimit(need, maxUint64(max, need)) != nil && max > limit {
+		_ = setOpenFileLimit(max, max)
+	}
+
+	raised, _, _ := openFileLimit()
+	if raised >= need {
+		logging.Info("raised the open file limit from %d to %d", limit, raised)
+		return
+	}
+
+	logging.Warn("the open file limit is %d, short of the %d needed for %d connections (--expected-conns); raise it with ulimit -n or LimitNOFILE=", raised, need, args.ExpectedConns)
+}
+
+// openFilesHint adds the open file limit to err if it's for running out of
+// descriptors, which on its own doesn't say what to do about it.
+func openFilesHint(err error) error {
+	if !errors.Is(err, syscall.EMFILE) {
+		return err
+	}
+
+	limit, _, limitErr := openFileLimit()
+	if limitErr != nil {
+		return err
+	}
+
+	return fmt.Errorf("%w (the open file limit is %d, raise it with ulimit -n)", err, limit)
+}
+
+func maxUint64(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+
+	return b
+}
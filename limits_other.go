@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// openFileLimit isn't checked here, Windows has no per-process limit to
+// speak of.
+func openFileLimit() (uint64, uint64, error) {
+	return 0, 0, errors.ErrUnsupported
+}
+
+func setOpenFileLimit(limit, max uint64) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,22 @@
+//go:build linux || darwin
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// openFileLimit returns the soft and hard RLIMIT_NOFILE.
+func openFileLimit() (uint64, uint64, error) {
+	var rlim unix.Rlimit
+
+	err := unix.Getrlimit(unix.RLIMIT_NOFILE, &rlim)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return rlim.Cur, rlim.Max, nil
+}
+
+// setOpenFileLimit sets the soft and hard RLIMIT_NOFILE.
+func setOpenFileLimit(limit, max uint64) error {
+	return unix.Setrlimit(unix.RLIMIT_NOFILE, &unix.Rlimit{Cur: limit, Max: max})
+}
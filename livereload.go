@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// liveReloadPath is where pages with the --live-reload script listen for
+// changes.
+const liveReloadPath = "/_livereload"
+
+// liveReloadScript reloads the page when the server says files changed. The
+// browser reconnects on its own after a restart.
+const liveReloadScript = `<script>new EventSource("` + liveReloadPath + `").addEventListener("reload",function(){location.reload()})</script>`
+
+// LiveReload serves /_livereload, a stream of server-sent events for the
+// script --live-reload injects into HTML pages: a reload event with the
+// changed paths each time the watcher sees files change.
+type LiveReload struct {
+	drainer *Drainer
+
+	mu      sync.Mutex
+	clients map[chan liveReloadEvent]struct{}
+}
+
+// liveReloadEvent is the data of a reload event. Paths is empty if every
+// file may have changed.
+type liveReloadEvent struct {
+	Paths []string `json:"paths"`
+}
+
+// NewLiveReload creates the endpoint. Streams end once drainer starts
+// draining.
+func NewLiveReload(drainer *Drainer) *LiveReload {
+	return &LiveReload{
+		drainer: drainer,
+		clients: map[chan liveReloadEvent]struct{}{},
+	}
+}
+
+// Reload tells the streams the changed files changed.
+func (lr *LiveReload) Reload(changed []string) {
+	event := liveReloadEvent{Paths: changed}
+	if event.Paths == nil {
+		event.Paths = []string{}
+	}
+
+	lr.mu.Lock()
+
+	for client := range lr.clients {
+		select {
+		case client <- event:
+		default:
+			// the client is behind and about to reload anyway
+		}
+	}
+
+	count := len(lr.clients)
+	lr.mu.Unlock()
+
+	if count > 0 {
+		logging.Info("reloading %d pages", count)
+	}
+}
+
+func (lr *LiveReload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/event-stream")
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	client := make(chan liveReloadEvent, 1)
+
+	lr.mu.Lock()
+	lr.clients[client] = struct{}{}
+	lr.mu.Unlock()
+
+	defer func() {
+		lr.mu.Lock()
+		delete(lr.clients, client)
+		lr.mu.Unlock()
+	}()
+
+	rc := http.NewResponseController(w)
+
+	// the stream outlives the usual write deadline
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	_, _ = fmt.Fprintf(w, "retry: %d\n\n", pingRetry)
+
+	if rc.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(pingHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+
+		select {
+		case event := <-client:
+			err = writeEvent(w, "reload", event)
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": ping\n\n")
+		case <-lr.drainer.Draining():
+			return
+		case <-r.Context().Done():
+			return
+		}
+
+		if err == nil {
+			err = rc.Flush()
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// injectLiveReload adds the --live-reload script to the end of HTML
+// content.
+func injectLiveReload(contentType string, content []byte) []byte {
+	if !strings.HasPrefix(contentType, "text/html") {
+		return content
+	}
+
+	script := []byte(liveReloadScript)
+
+	i := bytes.LastIndex(content, []byte("</body>"))
+	if i < 0 {
+		return append(content[:len(content):len(content)], script...)
+	}
+
+	out := make([]byte, 0, len(content)+len(script))
+	out = append(out, content[:i]...)
+	out = append(out, script...)
+
+	return append(out, content[i:]...)
+}
@@ -1,42 +1,249 @@
 package main
 
 import (
-	"fmt"
-	"io/ioutil"
-	"mime"
-	"net"
+	"crypto/tls"
+	"errors"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
-	"sync"
 	"time"
 
-	"github.com/dustin/go-humanize"
-	"github.com/fatih/color"
+	"github.com/coreyog/spa-server/internal/logging"
 	"github.com/jessevdk/go-flags"
 )
 
-type CacheEntry struct {
-	Content     []byte
-	ContentType string
-}
-
 type Arguments struct {
-	DefaultDoc string `short:"d" long:"default-doc" description:"On 404, return this document" default:"index.html"`
-	Port       int    `short:"p" long:"port" description:"Port to listen on" default:"80"`
-	MemCache   bool   `short:"c" long:"cache" description:"Enable memcache"`
-	LoadCache  bool   `short:"l" long:"load" description:"Load all files into the cache before serving (enables memcache)"`
+	Profile string `long:"profile" description:"Preset flags for an environment, explicit flags override it" choice:"dev" choice:"staging" choice:"prod"`
+	Config  string `long:"config" description:"YAML or TOML file of settings named like the long flags, which flags given here override"`
+
+	DefaultDoc      []string `short:"d" long:"default-doc" description:"On 404, return this document, repeat to try several in order (also used for directories)" default:"index.html"`
+	DirFallback     bool     `long:"dir-fallback" description:"On 404, return the default doc of the nearest directory that has one instead of the top-level one"`
+	DirRequests     string   `long:"dir-requests" description:"For directory paths without a trailing slash, redirect to add it or serve the fallback doc" choice:"redirect" choice:"fallback" default:"redirect"`
+	EncodedSlash    string   `long:"encoded-slash" description:"How to treat %2F in paths: as a slash, as a 400, or as part of the file name" choice:"decode" choice:"reject" choice:"pass" default:"decode"`
+	EncodedDot      string   `long:"encoded-dot" description:"How to treat %2E in paths: as a dot, as a 400, or as part of the file name" choice:"decode" choice:"reject" choice:"pass" default:"decode"`
+	Port            int      `short:"p" long:"port" description:"Port to listen on, 0 to pick a free one" default:"80"`
+	MemCache        bool     `short:"c" long:"cache" description:"Enable memcache"`
+	LoadCache       bool     `short:"l" long:"load" description:"Load all files into the cache before serving (enables memcache)"`
+	Index           bool     `long:"index" description:"Index file metadata before serving and cache bodies on first request, for trees too big to --load (enables memcache)"`
+	IndexSnapshot   string   `long:"index-snapshot" description:"File to save the --index to and start from on restart, refreshing it in the background (enables --index)"`
+	IndexHashes     bool     `long:"index-hashes" description:"Hash every file for the --index to serve ETags without reading files (enables --index)"`
+	WarmFromLog     string   `long:"warm-from-log" description:"Load the files requested in this access log into the cache before serving (enables memcache)"`
+	LazyWarm        bool     `long:"lazy-warm" description:"Start serving straight away while --load or --warm-from-log fills the cache in the background"`
+	ReadyAfter      string   `long:"ready-after" description:"With --lazy-warm, when /readyz starts reporting ready: at start, once the default doc is cached, or once warming is done" choice:"start" choice:"default-doc" choice:"warm" default:"start"`
+	MaxMemory       string   `long:"max-memory" description:"Soft memory limit (sets GOMEMLIMIT), cache entries are evicted as it's approached, e.g. 512MB"`
+	GeoIPDB         string   `long:"geoip-db" description:"MaxMind country database (.mmdb) used to tag requests by country"`
+	GeoAllow        []string `long:"geo-allow" description:"Only serve requests from this country code (repeatable)"`
+	GeoBlock        []string `long:"geo-block" description:"Block requests from this country code (repeatable)"`
+	GeoRedirect     []string `long:"geo-redirect" description:"Redirect requests from a country, as CC=URL (repeatable)"`
+	GeoBlockUnknown bool     `long:"geo-block-unknown" description:"Also block requests whose country isn't known, e.g. from private addresses, which --geo-allow lets through otherwise"`
+
+	ImageVariants bool `long:"image-variants" description:"Serve .avif/.webp/@2x siblings of images based on Accept and client hints"`
+
+	ImageResize    bool   `long:"img-resize" description:"Enable the /_img?src=&w=&h=&fmt=&q= resizing endpoint; fmt is jpeg, png, or gif, and webp sources are sent as png"`
+	ImageMaxDim    int    `long:"img-max-dim" description:"Largest width or height /_img will produce" default:"2048"`
+	ImageCacheSize string `long:"img-cache-size" description:"Memory used to cache resized images" default:"64MB"`
+
+	VariantCacheSize string `long:"variant-cache-size" description:"Memory used to cache per-request variants of cached files" default:"32MB"`
+
+	CacheLimit string   `long:"cache-limit" description:"Memory cached files may use, evicting the least recently used ones past it, e.g. 256MB"`
+	NoCache    []string `long:"no-cache" description:"Never cache files with an extension or matching a name or URL glob, as .mp4, *.mp4, or /videos/** (repeatable)"`
+
+	CacheTTL             time.Duration `long:"cache-ttl" description:"Reload cached files from disk after this long, 0 to cache forever" default:"0s"`
+	StaleWhileRevalidate bool          `long:"stale-while-revalidate" description:"Serve expired cache entries immediately while reloading them in the background"`
+
+	DiskCache     string `long:"disk-cache" description:"Directory for a persistent cache of derived artifacts (resized images, compressed variants)"`
+	DiskCacheSize string `long:"disk-cache-size" description:"Disk space used by --disk-cache" default:"1GB"`
+
+	PrefetchLearn  bool          `long:"prefetch-learn" description:"Learn which assets load right after the default doc and hint them with Link: rel=prefetch"`
+	PrefetchWarm   bool          `long:"prefetch-warm" description:"With --prefetch-learn and memcache, also warm learned assets into the cache"`
+	PrefetchTop    int           `long:"prefetch-top" description:"Most assets hinted by --prefetch-learn" default:"5"`
+	PrefetchWindow time.Duration `long:"prefetch-window" description:"How soon after the default doc an asset load counts for --prefetch-learn" default:"10s"`
+
+	Minify     bool     `long:"minify" description:"Minify HTML/CSS/JS/SVG/JSON as it's loaded into the cache"`
+	MinifySkip []string `long:"minify-skip" description:"Glob of files to leave unminified, e.g. *.min.js (repeatable)"`
+
+	Compress        bool `long:"compress" description:"Compress text responses with Brotli or gzip, as the client's Accept-Encoding allows"`
+	CompressMinSize int  `long:"compress-min-size" description:"Smallest response --compress compresses, in bytes" default:"1024"`
+	Precompressed   bool `long:"precompressed" description:"Send a file's .br or .gz sibling, when there is one, to clients that accept it"`
+
+	SSI   bool     `long:"ssi" description:"Expand <!--# include/env/buildTime --> directives in HTML files, env only reading variables starting with SPA_PUBLIC_"`
+	Slots []string `long:"slot" description:"Fill <!--slot:NAME--> in HTML per request, as NAME=cookie|header:KEY[:DEFAULT] or NAME=prefix[:DEFAULT] (repeatable)"`
+
+	Replace []string `long:"replace" description:"Replace text in HTML/CSS/JS/JSON/SVG files as they're loaded, as FIND=REPLACE (repeatable)"`
+
+	I18nRedirect bool   `long:"i18n-redirect" description:"Redirect / to a localized build (/en/, /de/, ...) based on Accept-Language"`
+	I18nRewrite  bool   `long:"i18n-rewrite" description:"With --i18n-redirect, serve the localized build at / instead of redirecting"`
+	I18nCookie   string `long:"i18n-cookie" description:"Cookie that overrides Accept-Language for --i18n-redirect and --i18n-files" default:"lang"`
+	I18nFiles    bool   `long:"i18n-files" description:"Serve localized siblings of HTML files (about.de.html for about.html) based on Accept-Language"`
+
+	BaseHref string `long:"base-href" description:"Rewrite or add <base href> in HTML to this path, or 'forwarded' to use the trusted X-Forwarded-Prefix"`
+
+	StripQuery       []string `long:"strip-query" description:"Glob of query parameters to drop before resolving, e.g. utm_* (repeatable)"`
+	StripQueryInject bool     `long:"strip-query-inject" description:"Expose the unstripped query to HTML as window.__ORIGINAL_QUERY__"`
+
+	MaxHeaderBytes int `long:"max-header-bytes" description:"Largest request header block accepted, in bytes" default:"1048576"`
+	MaxHeaders     int `long:"max-headers" description:"Most request header fields accepted, 0 for no limit" default:"100"`
+	MaxURLLength   int `long:"max-url-length" description:"Longest request URI accepted, 0 for no limit" default:"8192"`
+	MaxPathLength  int `long:"max-path-length" description:"Longest request path accepted, 0 for no limit" default:"4096"`
+	MaxQueryLength int `long:"max-query-length" description:"Longest query string accepted, 0 for no limit" default:"4096"`
+	MaxQueryParams int `long:"max-query-params" description:"Most query parameters accepted, 0 for no limit" default:"256"`
+
+	FallbackPorts []int  `long:"fallback-port" description:"Port to try, in order, when the previous one is already in use (repeatable)"`
+	IPFamily      string `long:"ip-family" description:"Address families to listen on" choice:"dual" choice:"ipv4" choice:"ipv6" default:"dual"`
+	Workers       int    `long:"workers" description:"Run this many worker processes sharing the port with SO_REUSEPORT, restarting any that crash, 0 for one process" default:"0"`
+
+	NoKeepAlive       bool          `long:"no-keep-alive" description:"Close connections after each response instead of reusing them"`
+	IdleTimeout       time.Duration `long:"idle-timeout" description:"How long an idle keep-alive connection stays open, 0 for no limit" default:"2m"`
+	ReadHeaderTimeout time.Duration `long:"read-header-timeout" description:"How long a client has to send request headers, 0 for no limit" default:"10s"`
+	TCPDelay          bool          `long:"tcp-delay" description:"Enable Nagle's algorithm (disables TCP_NODELAY), batching small writes"`
+	TCPLinger         int           `long:"tcp-linger" description:"SO_LINGER seconds for closed connections, -1 for the OS default" default:"-1"`
+	TCPKeepAlive      time.Duration `long:"tcp-keep-alive" description:"TCP keep-alive probe period, 0 for the OS default" default:"0s"`
+
+	TLSCert         []string      `long:"tls-cert" description:"Certificate file (PEM) to serve HTTPS with, chosen by SNI when repeated"`
+	TLSKey          []string      `long:"tls-key" description:"Private key file (PEM) for the --tls-cert in the same position (repeatable)"`
+	OCSPStaple      bool          `long:"ocsp-staple" description:"Staple OCSP responses to --tls-cert, refreshing them in the background"`
+	TLSTicketKeys   string        `long:"tls-ticket-keys" description:"File of session ticket keys shared across replicas, one hex or base64 32 byte key per line, newest first"`
+	TLSTicketReload time.Duration `long:"tls-ticket-reload" description:"How often to check --tls-ticket-keys for rotated keys, 0 to never" default:"1m"`
+
+	AutoTLS         []string `long:"auto-tls" description:"Domain to get a Let's Encrypt certificate for and serve HTTPS on --port, renewing it automatically (repeatable)"`
+	AutoTLSEmail    string   `long:"auto-tls-email" description:"Contact address Let's Encrypt sends expiry and account notices to"`
+	AutoTLSCache    string   `long:"auto-tls-cache" description:"Directory to keep --auto-tls certificates and the account key in (default: the user cache directory)"`
+	AutoTLSHTTPPort int      `long:"auto-tls-http-port" description:"Port answering HTTP challenges and redirecting to HTTPS for --auto-tls, 0 for none" default:"80"`
+
+	CORSOrigins []string `long:"cors-origin" description:"Origin allowed to fetch files cross-origin, or * for any (repeatable)"`
+
+	CDNMode bool `long:"cdn-mode" description:"Host assets for other origins: CORS and Timing-Allow-Origin for any origin, immutable caching, font types, unaltered bodies for SRI, and no fallback"`
+
+	CSRFCheck       bool     `long:"csrf-check" description:"Reject cross-site POST/PUT/PATCH/DELETE requests based on Origin and Sec-Fetch-Site"`
+	CSRFAllowOrigin []string `long:"csrf-allow-origin" description:"Additional origin trusted by --csrf-check (repeatable)"`
+
+	TrustedProxies []string `long:"trusted-proxy" description:"Proxy IP or CIDR whose X-Forwarded-Prefix is applied to redirects and prefix slots (repeatable)"`
+
+	AffinityCookie string `long:"affinity-cookie" description:"Set this cookie to the instance ID for sticky load balancing"`
+	InstanceID     string `long:"instance-id" description:"Instance ID for --affinity-cookie and logs (default: hostname)"`
+
+	RequestTimeout time.Duration `long:"request-timeout" description:"Longest a request can take, including sending the response, before it's abandoned, 0 for no limit" default:"0s"`
+
+	DrainDelay      time.Duration `long:"drain-delay" description:"On shutdown, fail /readyz for this long before closing the listener" default:"0s"`
+	ShutdownTimeout time.Duration `long:"shutdown-timeout" description:"How long to wait for in-flight requests on shutdown" default:"30s"`
+
+	ExpectedConns int `long:"expected-conns" description:"Connections to make room for in the open file limit, which is raised at startup if allowed, or else warned about" default:"1024"`
+
+	LogFormat string `long:"log-format" description:"How requests and log lines are written: colored lines for a terminal, an access log in Common or Combined Log Format, or JSON" choice:"pretty" choice:"common" choice:"combined" choice:"json" default:"pretty"`
+	LogLevel  string `long:"log-level" description:"Least severe log lines written" choice:"debug" choice:"info" choice:"warn" choice:"error" default:"info"`
+
+	LogFile    string `long:"log-file" description:"Write logs to this file instead of stderr, rotating it by size"`
+	LogMaxSize string `long:"log-max-size" description:"Size --log-file is rotated at" default:"100MB"`
+	LogKeep    int    `long:"log-keep" description:"Number of rotated --log-file files to keep" default:"5"`
+
+	LogRedactParams  []string `long:"log-redact-param" description:"Regexp for query parameter names whose values are masked in logs, on top of common credential names (repeatable)"`
+	LogRedactHeaders []string `long:"log-redact-header" description:"Header whose value is masked in logs, on top of Authorization and Cookie (repeatable)"`
+
+	AdminTokens []string `long:"admin-token" description:"Token for the admin endpoints as NAME:SCOPE[,SCOPE...]=SECRET, scopes being stats, purge, and deploy (repeatable)"`
+	AuditLog    string   `long:"audit-log" description:"Append a JSON line for each administrative action, such as a deploy, to this file"`
+
+	DeploySource string        `long:"deploy-source" description:"Artifact URL (.tar.gz or .zip) fetched on /_hooks/deploy, with {ref} replaced by the requested git ref; DIR must be a symlink"`
+	DeployBranch string        `long:"deploy-branch" description:"Branch whose GitHub pushes /_hooks/deploy deploys, ignoring pushes to others" default:"main"`
+	DeploySecret string        `long:"deploy-secret" env:"SPA_DEPLOY_SECRET" description:"Bearer token or GitHub webhook secret for /_hooks/deploy, or file:PATH, env:NAME, or exec:COMMAND to read it from"`
+	DeployKeep   int           `long:"deploy-keep" description:"Number of releases to keep in DIR.releases" default:"3"`
+	SyncInterval time.Duration `long:"sync-interval" description:"Check --deploy-source for a changed artifact this often and deploy it, 0 to only deploy on /_hooks/deploy" default:"0s"`
+	Previous     bool          `long:"previous" description:"Serve the release before the current one under /_previous to admin tokens with the previous scope; DIR must be a symlink"`
+
+	Alerts           []string `long:"alert" description:"Alert when the share of responses over a window passes a limit, e.g. 5xx>5%/5m, or spikes, e.g. 404>3x/10m (repeatable)"`
+	AlertMinRequests int      `long:"alert-min-requests" description:"Fewest responses in a window for --alert to judge it" default:"20"`
+	AlertWebhook     string   `long:"alert-webhook" description:"URL to POST a JSON notice to when an --alert fires or resolves"`
+	AlertExec        string   `long:"alert-exec" description:"Command to run when an --alert fires or resolves, with ALERT_* environment variables"`
+
+	FallbackReport time.Duration `long:"fallback-report" description:"How often to log the paths that most often fell back to the default doc, 0 to never" default:"1h"`
+	ReferrerStats  bool          `long:"referrer-stats" description:"Count which pages load each asset, from the Referer header, and report them on /_admin/referrers"`
+
+	Analytics     bool `long:"analytics" description:"Count page views and unique visitors per route, without storing anything identifying, shown on /_analytics to admin tokens with the analytics scope"`
+	AnalyticsDays int  `long:"analytics-days" description:"Days of --analytics counts to keep" default:"30"`
+
+	Dashboard bool `long:"dashboard" description:"Serve a live status page on /_dashboard with the request rate, cache usage, recent errors, and deploys to admin tokens with the stats scope"`
+
+	Watch      bool `long:"watch" description:"Watch DIR for changes and drop or refresh the cached copies of files as they change, instead of serving them until a restart or purge"`
+	LiveReload bool `long:"live-reload" description:"Inject a script into HTML pages that reloads them when files in DIR change, for development (implies --watch)"`
+
+	Ping bool `long:"ping" description:"Serve /_ping for apps to check they can reach the server, streaming an event when DIR's symlink switches to a new release"`
+
+	SkewGrace  time.Duration `long:"skew-grace" description:"How long after DIR's symlink switches releases to serve assets missing from the new release out of the previous one"`
+	SkewReload bool          `long:"skew-reload" description:"Answer requests for assets only the previous release has, after any --skew-grace, with a 409 and an X-Spa-Reload header instead of the fallback"`
+
+	ChunkReload       bool   `long:"chunk-reload" description:"Answer requests for .js and .mjs files that don't exist with a script reloading the page instead of the fallback"`
+	ChunkReloadScript string `long:"chunk-reload-script" description:"File with the script --chunk-reload sends instead of the built-in one; implies --chunk-reload"`
+
+	Proxy []string `long:"proxy" description:"Forward requests under a path prefix to a backend instead of serving files, as /api=http://localhost:8080 (repeatable)"`
+
+	Rules  string   `long:"rules" description:"JSON or text file of rules setting headers, caching, basic auth, and fallback behavior for URL globs, applied in order"`
+	MaxAge []string `long:"max-age" description:"Cache-Control for files with an extension or matching a URL glob, as .js=1h, /assets/**=immutable, or /**=no-cache, overridden by --rules (repeatable)"`
+
+	SLO              bool          `long:"slo" description:"Track availability and latency in memory and report them against the objectives on /_slo"`
+	SLOAvailability  float64       `long:"slo-availability" description:"Percentage of responses that should not be server errors" default:"99.9"`
+	SLOLatency       time.Duration `long:"slo-latency" description:"How fast a response should be to count towards --slo-latency-target" default:"500ms"`
+	SLOLatencyTarget float64       `long:"slo-latency-target" description:"Percentage of responses that should be faster than --slo-latency" default:"99"`
+
+	ExplainHeader bool `long:"explain-header" description:"Answer requests sent with an X-Spa-Explain header with X-Spa-Explain headers describing how they were resolved"`
+
+	Builds      string `long:"builds" description:"Directory of retained builds, one subdirectory per version, served to requests pinning a version with --build-param or --build-header"`
+	BuildParam  string `long:"build-param" description:"Query parameter pinning a request to a build in --builds" default:"v"`
+	BuildHeader string `long:"build-header" description:"Header pinning a request to a build in --builds" default:"X-Build-Version"`
+	BuildsKeep  int    `long:"builds-keep" description:"Remove all but this many of the newest builds in --builds, checked hourly, 0 to keep them all" default:"0"`
+
+	Dictionaries []string `long:"dictionary" description:"URL glob of bundles offered as compression dictionaries, e.g. /assets/app.*.js, with precomputed FILE.HASH.dcb or .dcz deltas served to clients holding one (repeatable)"`
+
+	VersionFile string `long:"version-file" description:"App build metadata reported by /_version, relative to the directory (e.g. version.json)"`
+
+	SelfCheck      bool     `long:"self-check" description:"After starting, request / and each --self-check-path and exit non-zero on failure"`
+	SelfCheckPaths []string `long:"self-check-path" description:"Extra path for --self-check, as PATH or PATH=STATUS (repeatable); one for / replaces the built-in check"`
+
+	Peers    []string `long:"peer" description:"Base URL of a replica sharing the cache, including this one (repeatable)"`
+	PeerSelf string   `long:"peer-self" description:"This instance's URL as given to --peer"`
+
+	MirrorDir string        `long:"mirror-dir" description:"Where to keep files fetched when DIR is an upstream URL (default: a temp dir)"`
+	MirrorTTL time.Duration `long:"mirror-ttl" description:"How long a mirrored file, or its absence, is trusted before asking the upstream again" default:"1m"`
+
+	Sites []string `long:"site" description:"Serve another app for requests to a host, as HOST=DIR, or *.DOMAIN=DIR for its subdomains, followed by options such as ,default-doc=FILE ,cache=TTL ,fallback=nearest (repeatable)"`
+
 	Positional struct {
-		Directory string `positional-arg-name:"DIR" description:"Directory to host" required:"true"`
+		Directory string `positional-arg-name:"DIR" description:"Directory to host, or the URL of a site to mirror" required:"true"`
 	} `positional-args:"yes"`
+
+	// Upstream is set from DIR when it's a URL, which leaves DIR pointing
+	// at the local mirror.
+	Upstream string
 }
 
 var args Arguments
 
 func main() {
-	_, err := flags.Parse(&args)
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		os.Exit(verify(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "routes" {
+		os.Exit(routes(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "explain" {
+		os.Exit(explain(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		os.Exit(ctl(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(config(os.Args[2:]))
+	}
+
+	argv, err := withConfig(os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = flags.ParseArgs(&args, withProfile(argv))
 	if err != nil {
 		if !flags.WroteHelp(err) {
 			os.Exit(1)
@@ -45,222 +252,118 @@ func main() {
 		}
 	}
 
-	args.Positional.Directory, err = filepath.Abs(args.Positional.Directory)
+	err = configureLogging()
 	if err != nil {
 		panic(err)
 	}
 
-	cache := &sync.Map{} // map[string]CacheEntry{}
-	types := &sync.Map{} // map[string]string{}
-
-	if args.LoadCache {
-		args.MemCache = true // if pre-caching, we are definitely caching
-		fmt.Print("pre-cacheing...")
-
-		start := time.Now()
-		size, err := precache(cache, types, args.Positional.Directory)
-		dur := time.Since(start)
+	if args.Workers > 0 && !isWorker() {
+		if args.Port == 0 {
+			panic("--workers needs a fixed --port to share")
+		}
 
-		if err != nil {
-			fmt.Println()
-			panic(err)
+		if len(args.DeploySource) > 0 {
+			panic("--workers can't be combined with --deploy-source, each worker would deploy on its own")
 		}
 
-		color.Green("%s (%s)", humanize.Bytes(size), dur)
+		os.Exit(supervise(args.Workers))
 	}
 
-	mux := http.NewServeMux()
+	checkOpenFiles()
 
-	defaultDoc := filepath.Join(args.Positional.Directory, args.DefaultDoc)
-	if !strings.HasPrefix(defaultDoc, args.Positional.Directory) {
-		panic("default doc is not in the directory")
+	err = resolveSecrets()
+	if err != nil {
+		panic(err)
 	}
 
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(200)
-			return
-		}
-
-		// parse URL down to the file being asked for
-		path := r.URL.Path
-		origPath := path
-		if path == "/" {
-			path = args.DefaultDoc
-		}
-
-		fullpath := filepath.Join(args.Positional.Directory, path)
-		if !strings.HasPrefix(fullpath, args.Positional.Directory) {
-			fullpath = defaultDoc
+	if len(args.AuditLog) > 0 {
+		auditLog, err = OpenAuditLog(args.AuditLog)
+		if err != nil {
+			panic(err)
 		}
 
-	again:
-		relPath := strings.TrimPrefix(fullpath, args.Positional.Directory)
-
-		// check if we have a cached version
-		if args.MemCache {
-			if cached, ok := cache.Load(fullpath); ok {
-				entry := cached.(*CacheEntry)
-
-				clr := color.Green // used a cached version
-				if origPath != relPath {
-					clr = color.Yellow // corrected to default doc
-				}
-
-				clr("%s => %s (%s)", origPath, relPath, entry.ContentType)
-				w.Header().Add("Content-Type", entry.ContentType)
-				w.Header().Add("Content-Length", strconv.Itoa(len(entry.Content)))
+		defer auditLog.Close()
+	}
 
-				if r.Method != http.MethodHead {
-					_, _ = w.Write(entry.Content)
-				}
+	if isUpstream(args.Positional.Directory) {
+		args.Upstream = args.Positional.Directory
 
-				return
-			}
+		args.Positional.Directory, err = mirrorDir(args.MirrorDir)
+		if err != nil {
+			panic(err)
 		}
+	}
 
-		file, err := os.Open(fullpath)
-		if err != nil {
-			color.Red("unable to open file: %s", fullpath)
-			if fullpath != defaultDoc {
-				fullpath = defaultDoc
+	args.Positional.Directory, err = filepath.Abs(args.Positional.Directory)
+	if err != nil {
+		panic(err)
+	}
 
-				goto again
-			} else {
-				http.Error(w, err.Error(), http.StatusNotFound)
-				color.Red("%s => ??? (404)", origPath)
+	drainer := &Drainer{}
 
-				return
-			}
-		}
+	handler, cleanup := newHandler(drainer)
+	defer cleanup()
 
-		defer file.Close()
+	srv := &http.Server{
+		Handler:           handler,
+		MaxHeaderBytes:    args.MaxHeaderBytes,
+		IdleTimeout:       args.IdleTimeout,
+		ReadHeaderTimeout: args.ReadHeaderTimeout,
+		// e.g. TLS handshake errors, in the same format as everything else
+		ErrorLog: slog.NewLogLogger(logging.Logger().Handler(), slog.LevelWarn),
+	}
 
-		raw, err := ioutil.ReadAll(file)
-		if err != nil {
-			color.Red("unable to read file: %s", fullpath)
-			http.Error(w, "unable to read file", http.StatusInternalServerError)
-			color.Red("%s => ??? (404)", origPath)
-			return
-		}
+	srv.TLSConfig, err = tlsConfig()
+	if err != nil {
+		panic(err)
+	}
 
-		var contentType string
-		ext := filepath.Ext(fullpath)
-
-		if len(ext) > 0 {
-			t, ok := types.Load(ext)
-			if !ok {
-				contentType = mime.TypeByExtension(ext)
-
-				if len(contentType) == 0 {
-					length := len(raw)
-					if length > 512 {
-						length = 512
-					}
-
-					contentType = http.DetectContentType(raw[:length])
-				}
-
-				if contentType != "application/octet-stream" {
-					types.Store(ext, contentType)
-				}
-			} else {
-				contentType = t.(string)
-			}
-		}
+	srv.SetKeepAlivesEnabled(!args.NoKeepAlive)
 
-		if args.MemCache {
-			cache.Store(fullpath, &CacheEntry{
-				Content:     raw,
-				ContentType: contentType,
-			})
-		}
+	network := ipNetworks[args.IPFamily]
 
-		if args.MemCache {
-			if origPath == relPath {
-				fmt.Printf("%s => %s (%s)\n", origPath, relPath, color.MagentaString("added to cache"))
-			} else {
-				color.Yellow("%s => %s (%s)\n", origPath, relPath, color.MagentaString("added to cache"))
-			}
-		} else {
-			if origPath == relPath {
-				fmt.Printf("%s => %s\n", origPath, relPath)
-			} else {
-				color.Yellow("%s => %s\n", origPath, relPath)
-			}
-		}
+	ln, err := listen(network, append([]int{args.Port}, args.FallbackPorts...), isWorker())
+	if err != nil {
+		logging.Error("unable to listen: %s", err)
+		os.Exit(1)
+	}
 
-		w.Header().Add("Content-Type", contentType)
-		w.Header().Add("Content-Length", strconv.Itoa(len(raw)))
-		if r.Method != http.MethodHead {
-			_, _ = w.Write(raw)
-		}
-	})
+	srv.Addr = ln.Addr().String()
 
-	srv := &http.Server{
-		Addr:    net.JoinHostPort("", strconv.Itoa(args.Port)),
-		Handler: mux,
+	ln = &tunedListener{
+		Listener:  ln,
+		noDelay:   !args.TCPDelay,
+		linger:    args.TCPLinger,
+		keepAlive: args.TCPKeepAlive,
 	}
 
-	fmt.Printf("now listening on %s\n", srv.Addr)
-	_ = srv.ListenAndServe()
-}
+	scheme := "http"
 
-func precache(cache *sync.Map, types *sync.Map, dir string) (size uint64, err error) {
-	files, err := os.ReadDir(dir)
-	if err != nil {
-		panic(err)
+	if srv.TLSConfig != nil {
+		scheme = "https"
+		ln = tls.NewListener(ln, srv.TLSConfig)
+	} else {
+		ln = framingListener{Listener: ln}
 	}
 
-	for _, file := range files {
-		if file.IsDir() {
-			s, err := precache(cache, types, filepath.Join(dir, file.Name()))
-			if err != nil {
-				return 0, err
-			}
+	go func() {
+		err := srv.Serve(ln)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Error("unable to serve: %s", err)
+			os.Exit(1)
+		}
+	}()
 
-			size += s
-		} else {
-			fullpath := filepath.Join(dir, file.Name())
-
-			raw, err := ioutil.ReadFile(fullpath)
-			if err != nil {
-				panic(err)
-			}
-
-			size += uint64(len(raw))
-
-			var contentType string
-			ext := filepath.Ext(fullpath)
-
-			if len(ext) > 0 {
-				t, ok := types.Load(ext)
-				if !ok {
-					contentType = mime.TypeByExtension(ext)
-
-					if len(contentType) == 0 {
-						length := len(raw)
-						if length > 512 {
-							length = 512
-						}
-
-						contentType = http.DetectContentType(raw[:length])
-					}
-
-					if contentType != "application/octet-stream" {
-						types.Store(ext, contentType)
-					}
-				} else {
-					contentType = t.(string)
-				}
-			}
-
-			cache.Store(fullpath, &CacheEntry{
-				Content:     raw,
-				ContentType: contentType,
-			})
+	logStartup(scheme, ln.Addr(), boundFamilies(network, ln))
+
+	if args.SelfCheck {
+		err = selfCheck(scheme+"://"+loopback(network, ln).String(), args.SelfCheckPaths)
+		if err != nil {
+			logging.Error("%s", err)
+			_ = srv.Close()
+			os.Exit(1)
 		}
 	}
 
-	return size, nil
+	drainer.WaitAndShutdown(srv, args.DrainDelay, args.ShutdownTimeout)
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fatih/color"
+)
+
+func TestMain(m *testing.M) {
+	// the request log would drown out the results
+	color.Output = ioutil.Discard
+
+	os.Exit(m.Run())
+}
+
+// benchSite writes a small site to a temp dir and points args at it.
+func benchSite(b *testing.B, memCache bool) http.Handler {
+	b.Helper()
+
+	dir := b.TempDir()
+
+	files := map[string][]byte{
+		"index.html":     []byte("<html><head></head><body><div id=app></div></body></html>"),
+		"small.js":       bytes.Repeat([]byte("console.log(1);\n"), 64),
+		"large.bin":      bytes.Repeat([]byte{0xAB}, 4*1024*1024),
+		"css/styles.css": bytes.Repeat([]byte("body { margin: 0; }\n"), 256),
+	}
+
+	for name, content := range files {
+		fullpath := filepath.Join(dir, name)
+
+		err := os.MkdirAll(filepath.Dir(fullpath), 0o755)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		err = ioutil.WriteFile(fullpath, content, 0o600)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	args = testArgs(dir)
+	args.MemCache = memCache
+
+	handler, cleanup := newHandler(&Drainer{})
+	b.Cleanup(cleanup)
+
+	return handler
+}
+
+func benchRequest(b *testing.B, handler http.Handler, path string) {
+	b.Helper()
+
+	// prime the cache, if there is one
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if rec.Code != http.StatusOK {
+			b.Fatalf("%s: unexpected status %d", path, rec.Code)
+		}
+	}
+}
+
+func BenchmarkSmallUncached(b *testing.B) {
+	benchRequest(b, benchSite(b, false), "/small.js")
+}
+
+func BenchmarkSmallCached(b *testing.B) {
+	benchRequest(b, benchSite(b, true), "/small.js")
+}
+
+func BenchmarkLargeUncached(b *testing.B) {
+	benchRequest(b, benchSite(b, false), "/large.bin")
+}
+
+func BenchmarkLargeCached(b *testing.B) {
+	benchRequest(b, benchSite(b, true), "/large.bin")
+}
+
+func BenchmarkFallbackUncached(b *testing.B) {
+	benchRequest(b, benchSite(b, false), "/app/dashboard")
+}
+
+func BenchmarkFallbackCached(b *testing.B) {
+	benchRequest(b, benchSite(b, true), "/app/dashboard")
+}
+
+func BenchmarkConcurrentFallbacks(b *testing.B) {
+	for _, cached := range []bool{false, true} {
+		name := "uncached"
+		if cached {
+			name = "cached"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			handler := benchSite(b, cached)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					rec := httptest.NewRecorder()
+					handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/app/dashboard", nil))
+
+					if rec.Code != http.StatusOK {
+						b.Errorf("unexpected status %d", rec.Code)
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkConcurrentLargeMisses(b *testing.B) {
+	handler := benchSite(b, false)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/large.bin", nil))
+		}
+	})
+}
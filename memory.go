@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/cache"
+	"github.com/coreyog/spa-server/internal/logging"
+	"github.com/coreyog/spa-server/internal/resolver"
+	"github.com/dustin/go-humanize"
+)
+
+// limitMemory sets the runtime's soft memory limit and sheds cache entries
+// whenever the heap gets close to it, so the process degrades to reading
+// from disk instead of being OOM-killed.
+func limitMemory(store *cache.Cache, limit uint64) {
+	debug.SetMemoryLimit(int64(limit))
+
+	high := limit / 10 * 9
+	low := limit / 4 * 3
+
+	go func() {
+		var stats runtime.MemStats
+
+		for range time.Tick(2 * time.Second) {
+			runtime.ReadMemStats(&stats)
+
+			if stats.HeapAlloc < high {
+				continue
+			}
+
+			freed := store.Release(stats.HeapAlloc - low)
+			logging.Warn("memory pressure: heap %s of %s, released %s from cache", humanize.Bytes(stats.HeapAlloc), humanize.Bytes(limit), humanize.Bytes(freed))
+
+			debug.FreeOSMemory()
+		}
+	}()
+}
+
+// noCacheMatcher returns whether a file matches one of the --no-cache
+// patterns: an extension like .mp4, a file name glob like *.mp4, or a URL
+// glob as in rules.
+func noCacheMatcher(values []string, res *resolver.Resolver) (func(fullpath string) bool, error) {
+	patterns := make([]*regexp.Regexp, 0, len(values))
+
+	for _, value := range values {
+		glob := value
+
+		switch {
+		case strings.HasPrefix(glob, "."):
+			glob = "/**/*" + glob
+		case !strings.HasPrefix(glob, "/"):
+			glob = "/**/" + glob
+		}
+
+		pattern, err := compileRule(glob)
+		if err != nil {
+			return nil, fmt.Errorf("--no-cache %q: %w", value, err)
+		}
+
+		patterns = append(patterns, pattern)
+	}
+
+	return func(fullpath string) bool {
+		rel := res.Rel(fullpath)
+
+		for _, pattern := range patterns {
+			if pattern.MatchString(rel) {
+				return true
+			}
+		}
+
+		return false
+	}, nil
+}
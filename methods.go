@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// methods wraps next so it only sees the listed methods. OPTIONS is
+// answered with them, including a CORS preflight response for origins
+// allowed by --cors-origin, and anything else gets a 405.
+func methods(next http.Handler, allowed ...string) http.Handler {
+	allow := strings.Join(append(allowed, http.MethodOptions), ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := corsOrigin(w, r)
+
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Allow", allow)
+
+			if len(origin) > 0 && len(r.Header.Get("Access-Control-Request-Method")) > 0 {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Methods", allow)
+				w.Header().Set("Access-Control-Max-Age", "600")
+
+				if headers := r.Header.Get("Access-Control-Request-Headers"); len(headers) > 0 {
+					w.Header().Set("Access-Control-Allow-Headers", headers)
+				}
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+
+			return
+		}
+
+		for _, method := range allowed {
+			if r.Method == method {
+				if len(origin) > 0 {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+				}
+
+				next.ServeHTTP(w, r)
+
+				return
+			}
+		}
+
+		w.Header().Set("Allow", allow)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	})
+}
+
+// corsOrigin returns the Access-Control-Allow-Origin value for r, or an
+// empty string if its origin isn't allowed.
+func corsOrigin(w http.ResponseWriter, r *http.Request) string {
+	if len(args.CORSOrigins) == 0 {
+		return ""
+	}
+
+	w.Header().Add("Vary", "Origin")
+
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 {
+		return ""
+	}
+
+	for _, allowed := range args.CORSOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return origin
+		}
+	}
+
+	return ""
+}
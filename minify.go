@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/coreyog/spa-server/internal/logging"
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/minify/v2/css"
+	"github.com/tdewolff/minify/v2/html"
+	"github.com/tdewolff/minify/v2/js"
+	"github.com/tdewolff/minify/v2/json"
+	"github.com/tdewolff/minify/v2/svg"
+)
+
+var minifier = newMinifier()
+
+var (
+	slotMarker      = regexp.MustCompile(`<!--slot:([^<>]*?)-->`)
+	slotPlaceholder = regexp.MustCompile("\ue000slot:([^\ue000]*)\ue000")
+)
+
+func newMinifier() *minify.M {
+	m := minify.New()
+	m.Add("text/html", &html.Minifier{
+		// keep <html>, <head>, and <body> so the document structure survives
+		KeepDocumentTags: true,
+		KeepEndTags:      true,
+	})
+	m.AddFunc("text/css", css.Minify)
+	m.AddFunc("image/svg+xml", svg.Minify)
+	m.AddFunc("application/json", json.Minify)
+	m.AddFunc("text/javascript", js.Minify)
+	m.AddFunc("application/javascript", js.Minify)
+
+	return m
+}
+
+// minifyContent minifies raw if its content type is a supported text type
+// and fullpath doesn't match any of the skip globs. The original content is
+// returned if minification isn't applicable or fails.
+func minifyContent(fullpath string, contentType string, raw []byte, skip []string) []byte {
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+
+	_, _, fn := minifier.Match(mediaType)
+	if fn == nil {
+		return raw
+	}
+
+	relPath := filepath.ToSlash(strings.TrimPrefix(fullpath, args.Positional.Directory))
+	for _, pattern := range skip {
+		if ok, _ := filepath.Match(pattern, filepath.Base(fullpath)); ok {
+			return raw
+		}
+
+		if ok, _ := filepath.Match(pattern, strings.TrimPrefix(relPath, "/")); ok {
+			return raw
+		}
+	}
+
+	html := mediaType == "text/html"
+	in := raw
+
+	if html {
+		// slots are filled per request, after this, so their markers have
+		// to outlive the comments being dropped
+		in = slotMarker.ReplaceAll(raw, []byte("\ue000slot:$1\ue000"))
+	}
+
+	out := &bytes.Buffer{}
+
+	err := minifier.Minify(mediaType, out, bytes.NewReader(in))
+	if err != nil {
+		logging.Error("unable to minify %s: %s", relPath, err)
+		return raw
+	}
+
+	if html {
+		return slotPlaceholder.ReplaceAll(out.Bytes(), []byte("<!--slot:$1-->"))
+	}
+
+	return out.Bytes()
+}
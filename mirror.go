@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/cache"
+	"github.com/coreyog/spa-server/internal/logging"
+	"github.com/coreyog/spa-server/internal/resolver"
+	"golang.org/x/sync/singleflight"
+)
+
+// validators are what a mirrored file's last fetch returned for
+// revalidating it.
+type validators struct {
+	checked      time.Time
+	etag         string
+	lastModified string
+}
+
+// Mirror fetches files on demand from an upstream site into the hosted
+// directory, so a remote site is served, with SPA fallback, as if it were
+// local. Files, including ones the upstream doesn't have, are trusted for
+// ttl before the upstream is asked again.
+type Mirror struct {
+	upstream *url.URL
+	res      *resolver.Resolver
+	ttl      time.Duration
+	client   *http.Client
+	changed  func(changed []string)
+	fetches  singleflight.Group
+	fetched  *cache.LRU // validators by path
+}
+
+// mirrorFetchedLimit bounds the paths the mirror remembers fetching, so
+// made-up URLs can't grow it without limit. Forgotten files are fetched
+// again in full.
+const mirrorFetchedLimit = 4 << 20
+
+// isUpstream reports whether DIR names an upstream site rather than a
+// directory.
+func isUpstream(dir string) bool {
+	return strings.HasPrefix(dir, "http://") || strings.HasPrefix(dir, "https://")
+}
+
+// NewMirror creates a mirror of upstream into res's directory. changed is
+// called with the slash-separated paths of files that were replaced or
+// removed, e.g. to drop cached copies.
+func NewMirror(upstream string, res *resolver.Resolver, ttl time.Duration, changed func(changed []string)) (*Mirror, error) {
+	u, err := url.Parse(upstream)
+	if err != nil || len(u.Host) == 0 {
+		return nil, fmt.Errorf("invalid upstream %q", upstream)
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawQuery = ""
+	u.Fragment = ""
+
+	return &Mirror{
+		upstream: u,
+		res:      res,
+		ttl:      ttl,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			// a redirect isn't the file, and following it would save a
+			// directory's index under the directory's name
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		changed: changed,
+		fetched: cache.NewLRU(mirrorFetchedLimit),
+	}, nil
+}
+
+// Wrap fetches the file each request maps to, or its fallback if the
+// upstream doesn't have it, before next serves it from the directory.
+func (m *Mirror) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fullpath := m.res.Map(r.URL.Path)
+
+		// extensionless paths are the app's routes, and asking the upstream
+		// for every one a crawler makes up would only get the fallback;
+		// directories are still fetched when asked for with their slash
+		route := len(path.Ext(r.URL.Path)) == 0 && !strings.HasSuffix(r.URL.Path, "/")
+		if route {
+			_, err := os.Stat(fullpath)
+			route = err != nil
+		}
+
+		if route || !m.Fetch(r.Context(), fullpath) {
+			fallback := m.res.DefaultPath()
+			if args.DirFallback {
+				fallback = m.res.NearestIndex(fullpath)
+			}
+
+			m.Fetch(r.Context(), fallback)
+		}
+
+		if abandoned(w, r, r.Context().Err()) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Fetch brings the file at fullpath up to date with the upstream unless it
+// was checked within the ttl, and reports whether it exists. If the
+// upstream can't be reached the local copy, if any, is kept. Once ctx is
+// done Fetch stops waiting, leaving the download to finish in the
+// background.
+func (m *Mirror) Fetch(ctx context.Context, fullpath string) bool {
+	last, ok := m.last(fullpath)
+	if !ok || time.Since(last.checked) >= m.ttl {
+		_, _ = doContext(ctx, &m.fetches, fullpath, func() (interface{}, error) {
+			err := m.download(fullpath)
+			if err != nil {
+				logging.Error("unable to mirror %s: %s", m.res.Rel(fullpath), err)
+			}
+
+			return nil, nil
+		})
+	}
+
+	info, err := os.Stat(fullpath)
+
+	return err == nil && !info.IsDir()
+}
+
+func (m *Mirror) download(fullpath string) error {
+	rel := m.res.Rel(fullpath)
+
+	u := *m.upstream
+	u.Path += rel
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	prev, _ := m.last(fullpath)
+
+	if len(prev.etag) > 0 {
+		req.Header.Set("If-None-Match", prev.etag)
+	}
+
+	if len(prev.lastModified) > 0 {
+		req.Header.Set("If-Modified-Since", prev.lastModified)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	now := validators{
+		checked:      time.Now(),
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		prev.checked = now.checked
+		m.remember(fullpath, prev)
+
+		return nil
+	case resp.StatusCode == http.StatusOK:
+		err = m.save(fullpath, resp.Body)
+	case isDirRedirect(resp, rel):
+		// make the directory so the redirect is repeated locally
+		err = os.MkdirAll(fullpath, 0o755)
+		if err == nil {
+			m.remember(fullpath, now)
+		}
+
+		return err
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone ||
+		resp.StatusCode < http.StatusBadRequest:
+		// gone upstream, or never there; other 4xx like 401 or 429 are
+		// the upstream's trouble, not the file's
+		err = os.Remove(fullpath)
+		if errors.Is(err, os.ErrNotExist) {
+			m.remember(fullpath, now)
+			return nil
+		}
+	default:
+		return fmt.Errorf("upstream returned %s", resp.Status)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	m.remember(fullpath, now)
+	m.changed([]string{strings.TrimPrefix(rel, "/")})
+
+	return nil
+}
+
+// last returns what fullpath's last fetch returned, if it's remembered.
+func (m *Mirror) last(fullpath string) (validators, bool) {
+	entry, ok := m.fetched.Get(fullpath)
+	if !ok {
+		return validators{}, false
+	}
+
+	v := validators{checked: entry.Loaded, etag: entry.ETag}
+	if !entry.ModTime.IsZero() {
+		v.lastModified = entry.ModTime.Format(http.TimeFormat)
+	}
+
+	return v, true
+}
+
+// remember records what fullpath's fetch returned. The entry's content is
+// the path, so the LRU's byte limit counts what the paths take up.
+func (m *Mirror) remember(fullpath string, v validators) {
+	modTime, _ := http.ParseTime(v.lastModified)
+
+	m.fetched.Add(fullpath, &cache.Entry{
+		Content: []byte(fullpath),
+		ETag:    v.etag,
+		Loaded:  v.checked,
+		ModTime: modTime,
+	})
+}
+
+// isDirRedirect reports whether resp redirects rel to the directory of the
+// same name, as static hosts do for directories requested without a slash.
+func isDirRedirect(resp *http.Response, rel string) bool {
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		return false
+	}
+
+	loc, err := resp.Location()
+
+	return err == nil && strings.HasSuffix(loc.Path, rel+"/")
+}
+
+// save replaces the file at fullpath with content by renaming a new file
+// over it, so requests never read a partial file.
+func (m *Mirror) save(fullpath string, content io.Reader) error {
+	err := os.MkdirAll(filepath.Dir(fullpath), 0o755)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(fullpath), ".mirror-")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, content)
+	if err == nil {
+		err = f.Chmod(0o644)
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(f.Name(), fullpath)
+	}
+
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+
+	return err
+}
+
+// mirrorDir returns the directory to mirror the upstream into, creating a
+// temporary one if dir is empty.
+func mirrorDir(dir string) (string, error) {
+	if len(dir) == 0 {
+		return ioutil.TempDir("", "spa-mirror-")
+	}
+
+	return dir, os.MkdirAll(dir, 0o755)
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// nginxDirective is a directive in an nginx configuration file, with the
+// directives of its block if it has one.
+type nginxDirective struct {
+	name  string
+	args  []string
+	block []nginxDirective
+	line  int
+}
+
+// parseNginx splits an nginx configuration file into directives.
+func parseNginx(src string) ([]nginxDirective, error) {
+	p := &nginxParser{src: src, line: 1}
+
+	return p.parseBlock(false)
+}
+
+type nginxParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+// parseBlock reads directives up to the end of the file, or the } closing
+// the block when nested.
+func (p *nginxParser) parseBlock(nested bool) ([]nginxDirective, error) {
+	var directives []nginxDirective
+	var words []string
+
+	line := 0
+
+	for {
+		tok, quoted, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case len(tok) == 0 && !quoted:
+			if nested || len(words) > 0 {
+				return nil, errors.New("unexpected end of file")
+			}
+
+			return directives, nil
+		case tok == ";" && !quoted:
+			if len(words) == 0 {
+				return nil, fmt.Errorf("line %d: unexpected ;", p.line)
+			}
+
+			directives = append(directives, nginxDirective{name: words[0], args: words[1:], line: line})
+			words = nil
+		case tok == "{" && !quoted:
+			if len(words) == 0 {
+				return nil, fmt.Errorf("line %d: unexpected {", p.line)
+			}
+
+			block, err := p.parseBlock(true)
+			if err != nil {
+				return nil, err
+			}
+
+			directives = append(directives, nginxDirective{name: words[0], args: words[1:], block: block, line: line})
+			words = nil
+		case tok == "}" && !quoted:
+			if !nested || len(words) > 0 {
+				return nil, fmt.Errorf("line %d: unexpected }", p.line)
+			}
+
+			return directives, nil
+		default:
+			if len(words) == 0 {
+				line = p.line
+			}
+
+			words = append(words, tok)
+		}
+	}
+}
+
+// next returns the next word, quoted string, or one of { } ;, and an empty
+// unquoted token at the end of the file.
+func (p *nginxParser) next() (string, bool, error) {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+
+		switch {
+		case c == '\n':
+			p.line++
+			p.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == '{' || c == '}' || c == ';':
+			p.pos++
+			return string(c), false, nil
+		case c == '"' || c == '\'':
+			var b strings.Builder
+
+			for p.pos++; p.pos < len(p.src); p.pos++ {
+				switch p.src[p.pos] {
+				case '\\':
+					if p.pos+1 < len(p.src) {
+						p.pos++
+						b.WriteByte(p.src[p.pos])
+					}
+				case c:
+					p.pos++
+					return b.String(), true, nil
+				case '\n':
+					p.line++
+					b.WriteByte('\n')
+				default:
+					b.WriteByte(p.src[p.pos])
+				}
+			}
+
+			return "", false, fmt.Errorf("line %d: unterminated string", p.line)
+		default:
+			start := p.pos
+			for p.pos < len(p.src) && !strings.ContainsRune(" \t\r\n{};", rune(p.src[p.pos])) {
+				p.pos++
+			}
+
+			return p.src[start:p.pos], false, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+// importNginx reads the server blocks of an nginx configuration, turning
+// their locations' add_header, expires, try_files, error_page, return, and
+// rewrite directives into rules. Directives set outside of a location
+// apply to the whole site.
+func importNginx(path string) ([]Rule, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	directives, err := parseNginx(string(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	imp := &nginxImport{path: path}
+	imp.walk(directives, []string{"/**"})
+
+	return imp.rules, nil
+}
+
+type nginxImport struct {
+	path    string
+	rules   []Rule
+	servers int
+}
+
+// nginxRedirectsHost reports whether a server block returns a redirect for
+// everything, like those sending HTTP to HTTPS or www to the bare domain.
+func nginxRedirectsHost(block []nginxDirective) bool {
+	for _, d := range block {
+		if d.name == "return" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// warn reports a directive that's left out, and why.
+func (imp *nginxImport) warn(d nginxDirective, reason string) {
+	logging.Warn("%s:%d: skipping %s, %s", imp.path, d.line, strings.TrimSpace(d.name+" "+strings.Join(d.args, " ")), reason)
+}
+
+// nginxIgnored are directives that have an equivalent flag, or nothing to
+// do with routing, and are left out without a warning.
+var nginxIgnored = map[string]bool{
+	"root": true, "index": true, "listen": true, "server_name": true,
+	"access_log": true, "error_log": true, "log_not_found": true,
+	"charset": true, "sendfile": true, "tcp_nopush": true, "tcp_nodelay": true,
+	"gzip": true, "gzip_static": true, "gzip_types": true, "gzip_vary": true,
+	"gzip_comp_level": true, "gzip_min_length": true, "gzip_proxied": true,
+	"brotli": true, "brotli_static": true, "brotli_types": true,
+	"ssl_certificate": true, "ssl_certificate_key": true, "ssl_protocols": true,
+	"ssl_ciphers": true, "include": true, "default_type": true, "etag": true,
+	"autoindex": true, "types": true, "auth_basic_user_file": true,
+}
+
+// walk converts the directives in a block whose locations match globs.
+// http and server blocks are walked as the whole site. A block's own rule
+// comes before those of the locations in it, so theirs take precedence.
+func (imp *nginxImport) walk(directives []nginxDirective, globs []string) {
+	rule := Rule{}
+
+	for _, d := range directives {
+		if d.name != "http" && d.name != "server" && d.name != "location" {
+			imp.apply(&rule, d, globs)
+		}
+	}
+
+	imp.add(rule, globs)
+
+	for _, d := range directives {
+		switch d.name {
+		case "http":
+			imp.walk(d.block, globs)
+		case "server":
+			if nginxRedirectsHost(d.block) {
+				imp.warn(nginxDirective{name: d.name, line: d.line}, "it redirects every request to another host or scheme")
+				continue
+			}
+
+			imp.servers++
+			if imp.servers == 2 {
+				logging.Warn("%s:%d: more than one server block, their rules are combined", imp.path, d.line)
+			}
+
+			imp.walk(d.block, globs)
+		case "location":
+			inner, reason := nginxLocation(d.args)
+			if len(reason) > 0 {
+				imp.warn(nginxDirective{name: d.name, args: d.args, line: d.line}, reason)
+				continue
+			}
+
+			imp.walk(d.block, inner)
+		}
+	}
+}
+
+// add appends a copy of rule for each of globs, unless it sets nothing.
+func (imp *nginxImport) add(rule Rule, globs []string) {
+	if len(rule.Headers) == 0 && len(rule.Cache) == 0 && rule.Auth == nil &&
+		len(rule.Fallback) == 0 && len(rule.Redirect) == 0 && len(rule.Rewrite) == 0 {
+		return
+	}
+
+	for _, glob := range globs {
+		r := rule
+		r.Match = glob
+		imp.rules = append(imp.rules, r)
+	}
+}
+
+// apply converts d into settings of rule, which applies to globs.
+func (imp *nginxImport) apply(rule *Rule, d nginxDirective, globs []string) {
+	switch d.name {
+	case "add_header":
+		if len(d.args) < 2 {
+			imp.warn(d, "it needs a name and a value")
+			return
+		}
+
+		if strings.Contains(d.args[1], "$") {
+			imp.warn(d, "variables aren't supported")
+			return
+		}
+
+		if rule.Headers == nil {
+			rule.Headers = map[string]string{}
+		}
+
+		rule.Headers[d.args[0]] = d.args[1]
+	case "expires":
+		policy, ok := nginxExpires(d.args)
+		if !ok {
+			imp.warn(d, "only a duration, max, epoch, or off are supported")
+			return
+		}
+
+		rule.Cache = policy
+	case "try_files":
+		if len(d.args) < 2 {
+			imp.warn(d, "it needs a file and a fallback")
+			return
+		}
+
+		imp.fallback(rule, d, d.args[len(d.args)-1])
+	case "error_page":
+		if len(d.args) < 2 || d.args[0] != "404" || d.args[len(d.args)-2] != "404" {
+			imp.warn(d, "only a page for 404 is supported")
+			return
+		}
+
+		imp.fallback(rule, d, d.args[len(d.args)-1])
+	case "return":
+		imp.redirect(rule, d, globs)
+	case "rewrite":
+		imp.rewrite(d)
+	case "auth_basic":
+		if len(d.args) == 1 && d.args[0] == "off" {
+			rule.Auth = &RuleAuth{Off: true}
+			return
+		}
+
+		imp.warn(d, "htpasswd files can't be converted, add the users to an auth rule")
+	default:
+		if !nginxIgnored[d.name] {
+			imp.warn(d, "it isn't supported")
+		}
+	}
+}
+
+// fallback sets what a missing file gets from the last argument of
+// try_files or error_page: a 404, the default doc, or another page.
+func (imp *nginxImport) fallback(rule *Rule, d nginxDirective, last string) {
+	switch {
+	case last == "=404":
+		rule.Fallback = "none"
+	case last == "/index.html":
+		rule.Fallback = "default"
+	case strings.HasPrefix(last, "/") && !strings.Contains(last, "$"):
+		rule.Rewrite = last
+	default:
+		imp.warn(d, "only =404 or a page on the site are supported as the fallback")
+	}
+}
+
+// redirect converts return with a 3xx status into a redirect.
+// $request_uri is supported for a location matching the whole site or a
+// single path.
+func (imp *nginxImport) redirect(rule *Rule, d nginxDirective, globs []string) {
+	if len(d.args) != 2 {
+		imp.warn(d, "only redirects are supported")
+		return
+	}
+
+	status, err := strconv.Atoi(d.args[0])
+	if err != nil || status < 300 || status > 399 {
+		imp.warn(d, "only redirects are supported")
+		return
+	}
+
+	to := d.args[1]
+	if len(globs) == 1 && globs[0] == "/**" {
+		to = strings.Replace(to, "$request_uri", "/:splat", 1)
+	} else if len(globs) == 1 && !strings.Contains(globs[0], "*") {
+		// an exact location only matches its own path
+		to = strings.Replace(to, "$request_uri", globs[0], 1)
+	}
+
+	if strings.Contains(to, "$") {
+		imp.warn(d, "variables aren't supported")
+		return
+	}
+
+	rule.Redirect = to
+	if status != 301 {
+		rule.Status = status
+	}
+}
+
+// nginxRewritePrefix matches a rewrite of a path prefix, ^/old/(.*)$.
+var nginxRewritePrefix = regexp.MustCompile(`^\^(/[A-Za-z0-9_./-]*?)/?\(\.\*\)\$?$`)
+
+// nginxRewriteExact matches a rewrite of a single path, ^/old$.
+var nginxRewriteExact = regexp.MustCompile(`^\^(/[A-Za-z0-9_./-]*)\$$`)
+
+// rewrite converts a rewrite that redirects one path, or a prefix with
+// what follows it as $1, into a rule of its own.
+func (imp *nginxImport) rewrite(d nginxDirective) {
+	if len(d.args) != 3 || (d.args[2] != "permanent" && d.args[2] != "redirect") {
+		imp.warn(d, "only permanent and redirect rewrites are supported")
+		return
+	}
+
+	rule := Rule{Redirect: d.args[1]}
+	if d.args[2] == "redirect" {
+		rule.Status = 302
+	}
+
+	if m := nginxRewriteExact.FindStringSubmatch(d.args[0]); m != nil {
+		rule.Match = m[1]
+	} else if m := nginxRewritePrefix.FindStringSubmatch(d.args[0]); m != nil {
+		rule.Match = strings.TrimSuffix(m[1], "/") + "/**"
+		rule.Redirect = strings.ReplaceAll(rule.Redirect, "$1", ":splat")
+	} else {
+		imp.warn(d, "only ^/path$ and ^/path/(.*)$ are supported")
+		return
+	}
+
+	if strings.Contains(rule.Redirect, "$") {
+		imp.warn(d, "variables aren't supported")
+		return
+	}
+
+	imp.rules = append(imp.rules, rule)
+}
+
+// nginxExtensions matches a regular expression location for file
+// extensions, \.(js|css)$, \.(?:js|css)$, or \.js$.
+var nginxExtensions = regexp.MustCompile(`^(?:\^?\.[*+])?\\\.(?:\((?:\?:)?([A-Za-z0-9|]+)\)|([A-Za-z0-9]+))\$$`)
+
+// nginxLocation converts a location's arguments into globs, or the reason
+// it can't.
+func nginxLocation(args []string) ([]string, string) {
+	modifier := ""
+	if len(args) == 2 {
+		modifier = args[0]
+		args = args[1:]
+	}
+
+	if len(args) != 1 {
+		return nil, "it needs a path"
+	}
+
+	path := args[0]
+
+	switch modifier {
+	case "=":
+		if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, "*?[:") {
+			return nil, "only plain paths are supported"
+		}
+
+		return []string{path}, ""
+	case "", "^~":
+		if strings.HasPrefix(path, "@") {
+			return nil, "named locations aren't supported"
+		}
+
+		if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, "*?[:") {
+			return nil, "only plain paths are supported"
+		}
+
+		return []string{strings.TrimSuffix(path, "/") + "/**"}, ""
+	case "~", "~*":
+		m := nginxExtensions.FindStringSubmatch(path)
+		if m == nil {
+			return nil, "only regular expressions for file extensions are supported"
+		}
+
+		exts := m[2]
+		if len(m[1]) > 0 {
+			exts = m[1]
+		}
+
+		var globs []string
+		for _, ext := range strings.Split(exts, "|") {
+			globs = append(globs, "/**/*."+ext)
+		}
+
+		return globs, ""
+	}
+
+	return nil, "unknown modifier " + modifier
+}
+
+// nginxExpires converts expires into a cache policy.
+func nginxExpires(args []string) (string, bool) {
+	if len(args) != 1 {
+		return "", false
+	}
+
+	switch args[0] {
+	case "max":
+		return "immutable", true
+	case "epoch":
+		return "no-cache", true
+	case "off":
+		return "", true
+	}
+
+	d, ok := nginxDuration(args[0])
+	if !ok {
+		return "", false
+	}
+
+	if d <= 0 {
+		return "no-cache", true
+	}
+
+	if d%time.Hour == 0 {
+		return fmt.Sprintf("%dh", d/time.Hour), true
+	}
+
+	return d.String(), true
+}
+
+// nginxUnits are the units of nginx durations.
+var nginxUnits = map[byte]time.Duration{
+	's': time.Second,
+	'm': time.Minute,
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+	'M': 30 * 24 * time.Hour,
+	'y': 365 * 24 * time.Hour,
+}
+
+// nginxDuration parses an nginx duration like 1y, 30d, or 1h30m, where a
+// bare number is seconds.
+func nginxDuration(s string) (time.Duration, bool) {
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	var total time.Duration
+
+	for len(s) > 0 {
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+
+		if i == 0 {
+			return 0, false
+		}
+
+		n, _ := strconv.Atoi(s[:i])
+		unit := time.Second
+
+		if i < len(s) {
+			if strings.HasPrefix(s[i:], "ms") {
+				unit = time.Millisecond
+				i++
+			} else if u, ok := nginxUnits[s[i]]; ok {
+				unit = u
+			} else {
+				return 0, false
+			}
+
+			i++
+		}
+
+		total += time.Duration(n) * unit
+		s = s[i:]
+	}
+
+	if neg {
+		total = -total
+	}
+
+	return total, true
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspRetry is how soon a failed OCSP fetch is retried.
+const ocspRetry = 5 * time.Minute
+
+// stapledCert serves a certificate with its OCSP response stapled, which
+// saves clients from asking the CA themselves during the handshake. The
+// response is refreshed in the background well before it expires.
+type stapledCert struct {
+	current atomic.Pointer[tls.Certificate]
+	leaf    *x509.Certificate
+	issuer  *x509.Certificate
+	client  *http.Client
+}
+
+// newStapledCert fetches the first OCSP response for cert, whose chain must
+// include its issuer. The certificate is served unstapled if that fails.
+func newStapledCert(cert tls.Certificate) (*stapledCert, error) {
+	if len(cert.Certificate) < 2 {
+		return nil, errors.New("OCSP stapling needs the issuer's certificate in the --tls-cert chain")
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(leaf.OCSPServer) == 0 {
+		return nil, fmt.Errorf("certificate for %s has no OCSP server", leaf.Subject.CommonName)
+	}
+
+	sc := &stapledCert{
+		leaf:   leaf,
+		issuer: issuer,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	sc.current.Store(&cert)
+
+	return sc, nil
+}
+
+// Certificate returns the certificate with the latest good OCSP response.
+func (sc *stapledCert) Certificate() *tls.Certificate {
+	return sc.current.Load()
+}
+
+// Run refreshes the staple for as long as the server runs.
+func (sc *stapledCert) Run() {
+	for {
+		time.Sleep(sc.Refresh())
+	}
+}
+
+// Refresh fetches a new OCSP response and returns how long to wait before
+// the next one. A failed fetch keeps the current staple until it expires.
+func (sc *stapledCert) Refresh() time.Duration {
+	resp, err := sc.fetch()
+	if err == nil && resp.Status != ocsp.Good {
+		// an older good response mustn't outlive a revocation
+		sc.staple(nil)
+		err = fmt.Errorf("certificate status is %s", ocspStatus(resp.Status))
+	}
+
+	if err != nil {
+		logging.Error("unable to staple OCSP for %s: %s", sc.leaf.Subject.CommonName, err)
+
+		cert := sc.Certificate()
+		if len(cert.OCSPStaple) > 0 {
+			if stapled, err := ocsp.ParseResponse(cert.OCSPStaple, sc.issuer); err != nil || time.Now().After(stapled.NextUpdate) {
+				sc.staple(nil)
+			}
+		}
+
+		return ocspRetry
+	}
+
+	sc.staple(resp.Raw)
+
+	// refresh halfway through the response's validity
+	next := time.Until(resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2))
+	if resp.NextUpdate.IsZero() || next < time.Minute {
+		next = time.Hour
+	}
+
+	return next
+}
+
+func (sc *stapledCert) fetch() (*ocsp.Response, error) {
+	req, err := ocsp.CreateRequest(sc.leaf, sc.issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	httpResp, err := sc.client.Post(sc.leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP server returned %s", httpResp.Status)
+	}
+
+	raw, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := ocsp.ParseResponseForCert(raw, sc.leaf, sc.issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// staple replaces the served certificate with a copy carrying raw.
+func (sc *stapledCert) staple(raw []byte) {
+	cert := *sc.Certificate()
+	cert.OCSPStaple = raw
+	sc.current.Store(&cert)
+}
+
+func ocspStatus(status int) string {
+	switch status {
+	case ocsp.Revoked:
+		return "revoked"
+	case ocsp.Unknown:
+		return "unknown"
+	default:
+		return "good"
+	}
+}
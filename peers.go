@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/cache"
+	"github.com/coreyog/spa-server/internal/logging"
+	"github.com/coreyog/spa-server/internal/resolver"
+	"github.com/coreyog/spa-server/internal/ring"
+)
+
+// peerReplicas is how many points each peer gets on the ring.
+const peerReplicas = 50
+
+// peerVariant keys the copies peers keep of files fetched from their
+// owner, in the bounded cache of derived entries, so the default doc and
+// other hot files don't cost a round trip to the owner on every request.
+const peerVariant = "peer"
+
+// peerCopyTTL is how long a copy from the owner is used before it's fetched
+// again, unless --cache-ttl says otherwise.
+const peerCopyTTL = time.Minute
+
+// PeerPool splits the cache between replicas serving the same files. Each
+// file is owned by one peer, which caches it, and the others fetch it from
+// the owner rather than keeping their own copy.
+type PeerPool struct {
+	self   string
+	ring   *ring.Ring
+	client *http.Client
+}
+
+var peers *PeerPool
+
+// NewPeerPool creates a pool of the peers at the given base URLs, which
+// must include self, this instance's own URL.
+func NewPeerPool(self string, urls []string) (*PeerPool, error) {
+	found := false
+
+	for i, u := range urls {
+		parsed, err := url.Parse(u)
+		if err != nil || !isUpstream(u) || len(parsed.Host) == 0 {
+			return nil, fmt.Errorf("invalid peer %q, expected an http(s) URL", u)
+		}
+
+		urls[i] = strings.TrimSuffix(u, "/")
+		found = found || urls[i] == strings.TrimSuffix(self, "/")
+	}
+
+	if !found {
+		return nil, fmt.Errorf("--peer-self %q isn't one of the peers", self)
+	}
+
+	return &PeerPool{
+		self:   strings.TrimSuffix(self, "/"),
+		ring:   ring.New(peerReplicas, urls...),
+		client: &http.Client{Timeout: 5 * time.Second},
+	}, nil
+}
+
+// Owns reports whether this instance caches relPath.
+func (p *PeerPool) Owns(relPath string) bool {
+	return p.ring.Get(relPath) == p.self
+}
+
+// Fetch gets relPath's entry from the peer that owns it, loading fullpath
+// itself with load if the owner can't provide it.
+func (p *PeerPool) Fetch(relPath string, fullpath string, load func(fullpath string) (*cache.Entry, error)) (*cache.Entry, error) {
+	owner := p.ring.Get(relPath)
+
+	entry, err := p.get(owner, relPath)
+	if err != nil {
+		logging.Warn("unable to fetch %s from %s: %s", relPath, owner, err)
+		return load(fullpath)
+	}
+
+	return entry, nil
+}
+
+func (p *PeerPool) get(owner string, relPath string) (*cache.Entry, error) {
+	resp, err := p.client.Get(owner + "/_peer?path=" + url.QueryEscape(relPath))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("peer returned %s", resp.Status)
+	}
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+
+	return &cache.Entry{
+		Content:     content,
+		ContentType: resp.Header.Get("Content-Type"),
+		ETag:        resp.Header.Get("ETag"),
+		Loaded:      time.Now(),
+		ModTime:     modTime,
+	}, nil
+}
+
+// servePeer answers a peer's request for the entry of a file this instance
+// owns, loading it into the cache if needed. Fallbacks are left to the
+// asking peer.
+func (h *spaHandler) servePeer(w http.ResponseWriter, r *http.Request) {
+	relPath := r.URL.Query().Get("path")
+
+	fullpath := filepath.Join(h.res.Dir, relPath)
+	if !strings.HasPrefix(relPath, "/") || !h.res.Contains(fullpath) || resolver.Reserved(relPath) {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+
+	entry, ok := h.store.Load(cache.Key{Path: fullpath})
+	if !ok {
+		loaded, err, _ := h.loads.Do(fullpath, func() (interface{}, error) {
+			return h.load(fullpath)
+		})
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		entry = loaded.(*cache.Entry)
+
+		if peers.Owns(relPath) {
+			h.store.Store(cache.Key{Path: fullpath}, entry)
+		}
+	}
+
+	w.Header().Set("Content-Type", entry.ContentType)
+
+	if len(entry.ETag) > 0 {
+		w.Header().Set("ETag", entry.ETag)
+	}
+
+	_, _ = w.Write(entry.Content)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPeerCopiesAreCached(t *testing.T) {
+	var fetches int32
+
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Header().Set("Content-Type", "application/javascript")
+		w.Header().Set("ETag", `"owner"`)
+		_, _ = io.WriteString(w, "from the owner")
+	}))
+	defer owner.Close()
+
+	site, err := filepath.Abs(filepath.Join("testdata", "site"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args = testArgs(site)
+	args.Peers = []string{"http://self.test", owner.URL}
+	args.PeerSelf = "http://self.test"
+
+	handler, cleanup := newHandler(&Drainer{})
+	defer cleanup()
+
+	target := ""
+
+	for _, path := range []string{"/app.js", "/data.json", "/logo.svg", "/legacy.html", "/LICENSE"} {
+		if !peers.Owns(path) {
+			target = path
+			break
+		}
+	}
+
+	if len(target) == 0 {
+		t.Fatal("the owner owns none of the fixtures")
+	}
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+
+		if rec.Body.String() != "from the owner" {
+			t.Fatalf("%s: got %q, want the owner's copy", target, rec.Body.String())
+		}
+	}
+
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("%s was fetched from the owner %d times, want once", target, n)
+	}
+}
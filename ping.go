@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// pingHeartbeat is how often an idle /_ping stream gets a comment, so
+// proxies keep it open and clients notice when it's gone.
+const pingHeartbeat = 15 * time.Second
+
+// pingPoll is how often the release DIR points at is checked.
+const pingPoll = time.Second
+
+// pingRetry is how long, in milliseconds, clients wait to reconnect.
+const pingRetry = 2000
+
+// Pinger serves /_ping, which apps use to tell whether they can still reach
+// the server and whether a new release went live since they loaded, for a
+// "refresh to update" banner. Requests accepting text/event-stream get a
+// stream of server-sent events: a hello with the current release, then a
+// release event each time DIR's symlink is switched to another one, however
+// that happened. Other requests get the current release as JSON.
+type Pinger struct {
+	link    string
+	drainer *Drainer
+
+	mu      sync.Mutex
+	release string
+	clients map[chan pingRelease]struct{}
+}
+
+// pingRelease is the data of the hello and release events, and the body
+// of plain /_ping requests.
+type pingRelease struct {
+	Release  string `json:"release"`
+	Previous string `json:"previous,omitempty"`
+}
+
+// NewPinger creates the endpoint for the releases of link. Streams end
+// once drainer starts draining.
+func NewPinger(link string, drainer *Drainer) *Pinger {
+	return &Pinger{
+		link:    link,
+		drainer: drainer,
+		release: currentRelease(link),
+		clients: map[chan pingRelease]struct{}{},
+	}
+}
+
+// currentRelease returns the name of the release link points at, or "" if
+// it isn't a symlink.
+func currentRelease(link string) string {
+	target, err := os.Readlink(link)
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Base(target)
+}
+
+// Run watches for the release to change and tells the streams about it.
+func (p *Pinger) Run() {
+	for range time.Tick(pingPoll) {
+		release := currentRelease(p.link)
+
+		p.mu.Lock()
+		if release == p.release {
+			p.mu.Unlock()
+			continue
+		}
+
+		event := pingRelease{Release: release, Previous: p.release}
+		p.release = release
+
+		for client := range p.clients {
+			select {
+			case client <- event:
+			default:
+				// the client is behind, it'll see the newest event
+				// once it catches up
+				select {
+				case <-client:
+				default:
+				}
+
+				client <- event
+			}
+		}
+
+		count := len(p.clients)
+		p.mu.Unlock()
+
+		logging.Info("release %s is live, telling %d /_ping clients", release, count)
+	}
+}
+
+func (p *Pinger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") || r.Method == http.MethodHead {
+		p.mu.Lock()
+		body, _ := json.Marshal(pingRelease{Release: p.release})
+		p.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(append(body, '\n'))
+
+		return
+	}
+
+	client := make(chan pingRelease, 1)
+
+	p.mu.Lock()
+	hello := pingRelease{Release: p.release}
+	p.clients[client] = struct{}{}
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.clients, client)
+		p.mu.Unlock()
+	}()
+
+	rc := http.NewResponseController(w)
+
+	// the stream outlives the usual write deadline
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	_, _ = fmt.Fprintf(w, "retry: %d\n\n", pingRetry)
+
+	if writeEvent(w, "hello", hello) != nil || rc.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(pingHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+
+		select {
+		case event := <-client:
+			err = writeEvent(w, "release", event)
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": ping\n\n")
+		case <-p.drainer.Draining():
+			return
+		case <-r.Context().Done():
+			return
+		}
+
+		if err == nil {
+			err = rc.Flush()
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes a server-sent event named name with data as JSON.
+func writeEvent(w http.ResponseWriter, name string, data interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, body)
+
+	return err
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// prefetchMinHits is how many clients must load an asset right after the
+// default doc before it's worth hinting.
+const prefetchMinHits = 3
+
+// maxPrefetchClients bounds the clients remembered between document loads.
+const maxPrefetchClients = 10000
+
+// PrefetchLearner learns which assets clients request immediately after
+// loading the default doc so they can be hinted (Link: rel=prefetch) or
+// warmed into the cache.
+type PrefetchLearner struct {
+	mu      sync.Mutex
+	window  time.Duration
+	top     int
+	warm    func(relPath string)
+	clients map[string]time.Time
+	counts  map[string]int
+}
+
+// NewPrefetchLearner creates a learner that counts assets loaded within
+// window of the default doc and hints at most top of them. If warm isn't
+// nil it's called with each hinted asset.
+func NewPrefetchLearner(window time.Duration, top int, warm func(relPath string)) *PrefetchLearner {
+	return &PrefetchLearner{
+		window:  window,
+		top:     top,
+		warm:    warm,
+		clients: map[string]time.Time{},
+		counts:  map[string]int{},
+	}
+}
+
+// Observe records a response about to be written. Default doc responses get
+// a Link header for the learned assets; anything else counts as an asset.
+func (p *PrefetchLearner) Observe(w http.ResponseWriter, r *http.Request, isDoc bool, relPath string) {
+	if !isDoc {
+		p.SawAsset(r, relPath)
+		return
+	}
+
+	p.SawDocument(r)
+
+	hints := p.Hints()
+	if len(hints) == 0 {
+		return
+	}
+
+	w.Header().Add("Link", linkHeader(hints))
+
+	if p.warm != nil {
+		for _, hint := range hints {
+			p.warm(filepath.FromSlash(hint))
+		}
+	}
+}
+
+func clientKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return host + "|" + r.UserAgent()
+}
+
+// SawDocument records that the client behind r just loaded the default doc.
+func (p *PrefetchLearner) SawDocument(r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.clients) >= maxPrefetchClients {
+		now := time.Now()
+		for key, seen := range p.clients {
+			if now.Sub(seen) > p.window {
+				delete(p.clients, key)
+			}
+		}
+
+		if len(p.clients) >= maxPrefetchClients {
+			p.clients = map[string]time.Time{}
+		}
+	}
+
+	p.clients[clientKey(r)] = time.Now()
+}
+
+// SawAsset counts relPath if the client behind r loaded the default doc
+// within the learning window.
+func (p *PrefetchLearner) SawAsset(r *http.Request, relPath string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	seen, ok := p.clients[clientKey(r)]
+	if !ok || time.Since(seen) > p.window {
+		return
+	}
+
+	p.counts[filepath.ToSlash(relPath)]++
+}
+
+// Hints returns the most commonly loaded assets, most popular first.
+func (p *PrefetchLearner) Hints() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	hints := make([]string, 0, len(p.counts))
+
+	for path, count := range p.counts {
+		if count >= prefetchMinHits {
+			hints = append(hints, path)
+		}
+	}
+
+	sort.Slice(hints, func(i, j int) bool {
+		if p.counts[hints[i]] != p.counts[hints[j]] {
+			return p.counts[hints[i]] > p.counts[hints[j]]
+		}
+
+		return hints[i] < hints[j]
+	})
+
+	if len(hints) > p.top {
+		hints = hints[:p.top]
+	}
+
+	return hints
+}
+
+// linkHeader formats hints as a Link header value.
+func linkHeader(hints []string) string {
+	links := make([]string, 0, len(hints))
+
+	for _, hint := range hints {
+		u := &url.URL{Path: hint}
+		links = append(links, "<"+u.EscapedPath()+">; rel=prefetch")
+	}
+
+	return strings.Join(links, ", ")
+}
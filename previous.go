@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// previousPrefix is where --previous mounts the prior release.
+const previousPrefix = "/_previous"
+
+// previousCookie carries the token for /_previous, see browserAuthorized.
+const previousCookie = "spa_previous"
+
+type mountKey struct{}
+
+// mountPrefix returns the path the handling release is mounted under, e.g.
+// "/_previous", or an empty string for the current release.
+func mountPrefix(r *http.Request) string {
+	prefix, _ := r.Context().Value(mountKey{}).(string)
+	return prefix
+}
+
+// Previous serves the release before the current one under /_previous, so
+// the old and new behavior can be compared side by side right after a
+// deploy. Only admin tokens with the previous scope may see it.
+type Previous struct {
+	link  string
+	serve func(dir string) (http.Handler, error)
+
+	mu      sync.Mutex
+	dir     string
+	handler http.Handler
+}
+
+// NewPrevious creates the /_previous handler for the releases of the
+// symlink link. serve creates the handler for a release's directory.
+func NewPrevious(link string, serve func(dir string) (http.Handler, error)) (*Previous, error) {
+	info, err := os.Lstat(link)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Mode()&os.ModeSymlink == 0 {
+		return nil, errors.New("--previous needs DIR to be a symlink to the current release")
+	}
+
+	return &Previous{link: link, serve: serve}, nil
+}
+
+func (p *Previous) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !browserAuthorized(w, r, "previous", previousCookie, previousPrefix) {
+		return
+	}
+
+	handler, err := p.current()
+	if err != nil {
+		logging.RequestError(r, "%s => ??? (%s)", r.URL.Path, err)
+		http.Error(w, "no previous release", http.StatusNotFound)
+
+		return
+	}
+
+	w.Header().Set("X-Robots-Tag", "noindex")
+
+	u := *r.URL
+	u.Path = strings.TrimPrefix(r.URL.Path, previousPrefix)
+	u.RawPath = ""
+
+	r2 := r.Clone(context.WithValue(r.Context(), mountKey{}, previousPrefix))
+	r2.URL = &u
+
+	explainf(r2, "serving %s from the previous release", u.Path)
+
+	handler.ServeHTTP(&prefixWriter{ResponseWriter: w, prefix: previousPrefix}, r2)
+}
+
+// current returns the handler for the release before the current one,
+// replacing it when a deploy has moved on.
+func (p *Previous) current() (http.Handler, error) {
+	_, dir, err := previousRelease(p.link)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if dir == p.dir {
+		return p.handler, nil
+	}
+
+	handler, err := p.serve(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	p.dir, p.handler = dir, handler
+
+	return handler, nil
+}
+
+// previousRelease returns the name and site directory of the newest
+// release in link's releases that's older than the one link points to.
+func previousRelease(link string) (string, string, error) {
+	releases := link + ".releases"
+
+	target, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return "", "", err
+	}
+
+	releasesDir, err := filepath.EvalSymlinks(releases)
+	if err != nil {
+		return "", "", err
+	}
+
+	rel, err := filepath.Rel(releasesDir, target)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", "", errors.New("the current release isn't in " + releases)
+	}
+
+	current := strings.Split(filepath.ToSlash(rel), "/")[0]
+
+	entries, err := os.ReadDir(releases)
+	if err != nil {
+		return "", "", err
+	}
+
+	var names []string
+
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() < current {
+			names = append(names, entry.Name())
+		}
+	}
+
+	if len(names) == 0 {
+		return "", "", errors.New("no release before " + current)
+	}
+
+	sort.Strings(names)
+
+	name := names[len(names)-1]
+
+	root, err := releaseRoot(filepath.Join(releasesDir, name))
+	if err != nil {
+		return "", "", err
+	}
+
+	return name, root, nil
+}
+
+// releaseRoot returns the directory holding a release's site: dir itself,
+// or its only subdirectory when the artifact wrapped everything in one.
+func releaseRoot(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	if len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(dir, entries[0].Name()), nil
+	}
+
+	return dir, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testReleases creates link and link.releases holding the named releases,
+// each with an index.html, with link pointing to current.
+func testReleases(t *testing.T, current string, names ...string) string {
+	t.Helper()
+
+	link := filepath.Join(t.TempDir(), "site")
+
+	for _, name := range names {
+		dir := filepath.Join(link+".releases", name)
+
+		err := os.MkdirAll(dir, 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.WriteFile(filepath.Join(dir, "index.html"), []byte(name), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := os.Symlink(filepath.Join(link+".releases", current), link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return link
+}
+
+func TestPreviousRelease(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  string
+		releases []string
+		want     string
+		wantErr  bool
+	}{
+		{"newest older release", "003", []string{"001", "002", "003"}, "002", false},
+		{"after a rollback", "002", []string{"001", "002", "003"}, "001", false},
+		{"no earlier release", "001", []string{"001", "002"}, "", true},
+		{"only release", "001", []string{"001"}, "", true},
+	}
+
+	for _, tt := range tests {
+		link := testReleases(t, tt.current, tt.releases...)
+
+		name, dir, err := previousRelease(link)
+		if (err != nil) != tt.wantErr || name != tt.want {
+			t.Errorf("%s: previousRelease() = %q, %v, want %q, error %v", tt.name, name, err, tt.want, tt.wantErr)
+			continue
+		}
+
+		if err == nil && filepath.Base(dir) != tt.want {
+			t.Errorf("%s: previousRelease() dir = %s, want the %s release", tt.name, dir, tt.want)
+		}
+	}
+}
+
+func TestReleaseRoot(t *testing.T) {
+	dir := t.TempDir()
+
+	wrapped := filepath.Join(dir, "wrapped")
+	if err := os.MkdirAll(filepath.Join(wrapped, "dist"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	flat := filepath.Join(dir, "flat")
+	if err := os.MkdirAll(filepath.Join(flat, "assets"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(flat, "index.html"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{wrapped, filepath.Join(wrapped, "dist")},
+		{flat, flat},
+	}
+
+	for _, tt := range tests {
+		got, err := releaseRoot(tt.dir)
+		if err != nil || got != tt.want {
+			t.Errorf("releaseRoot(%s) = %s, %v, want %s", tt.dir, got, err, tt.want)
+		}
+	}
+}
+
+func TestNewPrevious(t *testing.T) {
+	serve := func(dir string) (http.Handler, error) { return http.NotFoundHandler(), nil }
+
+	_, err := NewPrevious(t.TempDir(), serve)
+	if err == nil {
+		t.Error("NewPrevious() accepted a directory that isn't a symlink")
+	}
+
+	link := testReleases(t, "001", "001")
+
+	p, err := NewPrevious(link, serve)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = p.current()
+	if err == nil {
+		t.Error("current() found a release before the only one")
+	}
+}
+
+func TestPreviousFollowsDeploys(t *testing.T) {
+	link := testReleases(t, "002", "001", "002", "003")
+
+	var served []string
+
+	p, err := NewPrevious(link, func(dir string) (http.Handler, error) {
+		served = append(served, filepath.Base(dir))
+		return http.NotFoundHandler(), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the handler is kept while the previous release stays the same
+	for i := 0; i < 2; i++ {
+		if _, err := p.current(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// deploy 003
+	if err := os.Remove(link); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(filepath.Join(link+".releases", "003"), link); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.current(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(served) != 2 || served[0] != "001" || served[1] != "002" {
+		t.Errorf("served %v, want [001 002]", served)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import "strings"
+
+// profiles are the flags each --profile stands for. They're parsed ahead of
+// the command line, so flags given explicitly override them.
+var profiles = map[string][]string{
+	// restart quickly and don't cache, so edits show up on refresh
+	"dev": {
+		"--port=8080",
+		"--shutdown-timeout=1s",
+	},
+	"staging": {
+		"--cache",
+		"--minify",
+		"--csrf-check",
+		"--self-check",
+	},
+	"prod": {
+		"--load",
+		"--minify",
+		"--csrf-check",
+		"--self-check",
+		"--drain-delay=5s",
+	},
+}
+
+// withProfile returns argv with the flags of its --profile, if any,
+// inserted in front.
+func withProfile(argv []string) []string {
+	for i, arg := range argv {
+		if arg == "--" {
+			break
+		}
+
+		var name string
+
+		switch {
+		case strings.HasPrefix(arg, "--profile="):
+			name = strings.TrimPrefix(arg, "--profile=")
+		case arg == "--profile" && i+1 < len(argv):
+			name = argv[i+1]
+		default:
+			continue
+		}
+
+		preset, ok := profiles[name]
+		if !ok {
+			// go-flags reports the invalid choice
+			return argv
+		}
+
+		return append(append([]string{}, preset...), argv...)
+	}
+
+	return argv
+}
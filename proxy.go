@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// Proxy forwards requests under a path prefix to a backend, typically the
+// app's API, instead of serving files or falling back to the default doc.
+type Proxy struct {
+	prefix string
+	target *url.URL
+	rp     *httputil.ReverseProxy
+}
+
+// NewProxy creates a proxy from a --proxy value, PREFIX=URL. Paths are
+// passed on as they are, after the path of URL if it has one.
+func NewProxy(value string) (*Proxy, error) {
+	prefix, rawURL, ok := strings.Cut(value, "=")
+	if !ok || !strings.HasPrefix(prefix, "/") || prefix == "/" {
+		return nil, fmt.Errorf("--proxy %q isn't PREFIX=URL with a PREFIX like /api", value)
+	}
+
+	target, err := url.Parse(rawURL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || len(target.Host) == 0 {
+		return nil, fmt.Errorf("--proxy %q: %q isn't an http or https URL", value, rawURL)
+	}
+
+	p := &Proxy{prefix: prefix, target: target}
+
+	p.rp = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+		},
+		// stream responses as they come, e.g. server-sent events
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if errors.Is(err, r.Context().Err()) {
+				return // the client went away
+			}
+
+			logging.Error("%s%s => %s: %s", logging.Prefix(r), r.URL.Path, target.Redacted(), err)
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		},
+	}
+
+	return p, nil
+}
+
+// Handle registers p with mux for its prefix and everything under it.
+func (p *Proxy) Handle(mux *http.ServeMux) {
+	dir := strings.TrimSuffix(p.prefix, "/")
+
+	mux.Handle(dir+"/", p)
+
+	if dir != p.prefix {
+		return
+	}
+
+	mux.Handle(dir, p)
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	explainf(r, "under --proxy %s: forwarding to %s", p.prefix, p.target.Redacted())
+	logging.RequestInfo(r, "%s %s => %s", r.Method, r.URL.Path, logging.Highlight(p.target.Redacted()))
+
+	p.rp.ServeHTTP(w, r)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path"
+)
+
+type origQueryKey struct{}
+
+// QueryStripper removes tracking parameters (utm_*, fbclid, ...) from
+// request URLs before they are resolved, cached, or logged.
+type QueryStripper struct {
+	patterns []string
+}
+
+// NewQueryStripper creates a stripper for parameter names matching any of
+// the glob patterns.
+func NewQueryStripper(patterns []string) *QueryStripper {
+	return &QueryStripper{patterns: patterns}
+}
+
+func (qs *QueryStripper) matches(name string) bool {
+	for _, pattern := range qs.patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Wrap strips matching parameters before handing off to next. The original
+// query is kept on the request context for originalQuery.
+func (qs *QueryStripper) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.URL.RawQuery) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		orig := r.URL.RawQuery
+
+		values, err := url.ParseQuery(orig)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		stripped := false
+
+		for name := range values {
+			if qs.matches(name) {
+				values.Del(name)
+				stripped = true
+			}
+		}
+
+		if !stripped {
+			// the URL is left as sent, and there's no original to keep
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r2 := r.WithContext(context.WithValue(r.Context(), origQueryKey{}, orig))
+		u := *r.URL
+		u.RawQuery = values.Encode()
+		r2.URL = &u
+		r2.RequestURI = u.RequestURI()
+
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// originalQuery returns the query string as it was before stripping, or an
+// empty string if nothing was stripped.
+func originalQuery(r *http.Request) string {
+	orig, _ := r.Context().Value(origQueryKey{}).(string)
+	return orig
+}
+
+// injectOriginalQuery exposes the unstripped query string to the SPA as
+// window.__ORIGINAL_QUERY__ in HTML content.
+func injectOriginalQuery(orig string, content []byte) []byte {
+	// json.Marshal escapes <, >, and & so this can't close the script tag
+	encoded, err := json.Marshal(orig)
+	if err != nil {
+		return content
+	}
+
+	script := []byte("<script>window.__ORIGINAL_QUERY__=" + string(encoded) + ";</script>")
+
+	i := bytes.Index(content, []byte("</head>"))
+	if i < 0 {
+		return append(script, content...)
+	}
+
+	out := make([]byte, 0, len(content)+len(script))
+	out = append(out, content[:i]...)
+	out = append(out, script...)
+
+	return append(out, content[i:]...)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQueryStripper(t *testing.T) {
+	tests := []struct {
+		target    string
+		wantQuery string
+		wantOrig  string
+	}{
+		{"/?utm_source=news&page=2", "page=2", "utm_source=news&page=2"},
+		{"/?fbclid=abc", "", "fbclid=abc"},
+		{"/?b=2&a=1", "b=2&a=1", ""},
+		{"/", "", ""},
+	}
+
+	for _, tt := range tests {
+		var gotQuery, gotOrig string
+
+		handler := NewQueryStripper([]string{"utm_*", "fbclid"}).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotQuery, gotOrig = r.URL.RawQuery, originalQuery(r)
+		}))
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+		if gotQuery != tt.wantQuery {
+			t.Errorf("%s: query %q, want %q", tt.target, gotQuery, tt.wantQuery)
+		}
+
+		if gotOrig != tt.wantOrig {
+			t.Errorf("%s: original query %q, want %q", tt.target, gotOrig, tt.wantOrig)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/coreyog/spa-server/internal/resolver"
+)
+
+// maxReferrerAssets and maxAssetReferrers bound the breakdown's memory.
+// Loads past them are only counted in the asset's total.
+const (
+	maxReferrerAssets = 5000
+	maxAssetReferrers = 50
+)
+
+// noReferrer is what loads without a Referer are counted under.
+const noReferrer = "(none)"
+
+// ReferrerStats counts, for each asset, which pages loaded it according to
+// the Referer header, so orphaned and unexpectedly hot assets stand out.
+// Pages on this site are counted by path, other sites by origin.
+type ReferrerStats struct {
+	res *resolver.Resolver
+
+	mu     sync.Mutex
+	assets map[string]*assetLoads
+}
+
+// assetLoads is how often an asset was loaded, in total and per page.
+type assetLoads struct {
+	total int
+	pages map[string]int
+}
+
+// referrerCount is how many loads of an asset a page caused.
+type referrerCount struct {
+	Page  string `json:"page"`
+	Count int    `json:"count"`
+}
+
+// assetReferrers is one asset in /_admin/referrers.
+type assetReferrers struct {
+	Asset     string          `json:"asset"`
+	Loads     int             `json:"loads"`
+	Referrers []referrerCount `json:"referrers"`
+}
+
+// referrerReport is the body of /_admin/referrers.
+type referrerReport struct {
+	Assets []assetReferrers `json:"assets"`
+	Unused []string         `json:"unused,omitempty"`
+}
+
+// NewReferrerStats creates an empty breakdown for the files under res.
+func NewReferrerStats(res *resolver.Resolver) *ReferrerStats {
+	return &ReferrerStats{res: res, assets: map[string]*assetLoads{}}
+}
+
+// Observe counts a load of the asset relPath by the page r came from.
+// Documents aren't assets and aren't counted.
+func (rs *ReferrerStats) Observe(r *http.Request, relPath string, contentType string) {
+	if strings.HasPrefix(contentType, "text/html") {
+		return
+	}
+
+	page := referrerPage(r)
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	loads, ok := rs.assets[relPath]
+	if !ok {
+		if len(rs.assets) >= maxReferrerAssets {
+			return
+		}
+
+		loads = &assetLoads{pages: map[string]int{}}
+		rs.assets[relPath] = loads
+	}
+
+	loads.total++
+
+	if _, ok := loads.pages[page]; ok || len(loads.pages) < maxAssetReferrers {
+		loads.pages[page]++
+	}
+}
+
+// referrerPage returns the path of the page on this site that r came from,
+// or the origin of another site, without the query.
+func referrerPage(r *http.Request) string {
+	raw := r.Referer()
+	if len(raw) == 0 {
+		return noReferrer
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || len(u.Host) == 0 {
+		return noReferrer
+	}
+
+	if !strings.EqualFold(u.Host, r.Host) {
+		return u.Scheme + "://" + u.Host
+	}
+
+	if len(u.Path) == 0 {
+		return "/"
+	}
+
+	return u.Path
+}
+
+// ServeHTTP reports each asset's loads by page, hottest first, and with
+// ?unused the assets in the directory that haven't been loaded at all.
+func (rs *ReferrerStats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := referrerReport{Assets: rs.report()}
+
+	if _, ok := r.URL.Query()["unused"]; ok {
+		report.Unused = rs.unused()
+	}
+
+	body, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(append(body, '\n'))
+}
+
+// report returns the breakdown, hottest assets and pages first.
+func (rs *ReferrerStats) report() []assetReferrers {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	assets := make([]assetReferrers, 0, len(rs.assets))
+
+	for asset, loads := range rs.assets {
+		referrers := make([]referrerCount, 0, len(loads.pages))
+		for page, count := range loads.pages {
+			referrers = append(referrers, referrerCount{Page: page, Count: count})
+		}
+
+		sort.Slice(referrers, func(i, j int) bool {
+			if referrers[i].Count != referrers[j].Count {
+				return referrers[i].Count > referrers[j].Count
+			}
+
+			return referrers[i].Page < referrers[j].Page
+		})
+
+		assets = append(assets, assetReferrers{Asset: asset, Loads: loads.total, Referrers: referrers})
+	}
+
+	sort.Slice(assets, func(i, j int) bool {
+		if assets[i].Loads != assets[j].Loads {
+			return assets[i].Loads > assets[j].Loads
+		}
+
+		return assets[i].Asset < assets[j].Asset
+	})
+
+	return assets
+}
+
+// unused lists the files under the directory, other than documents, that
+// haven't been loaded since the server started.
+func (rs *ReferrerStats) unused() []string {
+	rs.mu.Lock()
+	loaded := make(map[string]bool, len(rs.assets))
+	for asset := range rs.assets {
+		loaded[asset] = true
+	}
+	rs.mu.Unlock()
+
+	unused := []string{}
+
+	_ = filepath.WalkDir(rs.res.Dir, func(fullpath string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return nil
+		}
+
+		rel := rs.res.Rel(fullpath)
+		ext := strings.ToLower(filepath.Ext(fullpath))
+
+		if ext == ".html" || ext == ".htm" || resolver.Reserved(rel) || loaded[rel] {
+			return nil
+		}
+
+		unused = append(unused, rel)
+
+		return nil
+	})
+
+	return unused
+}
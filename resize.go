@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/coreyog/spa-server/internal/cache"
+	"github.com/coreyog/spa-server/internal/logging"
+	"github.com/coreyog/spa-server/internal/resolver"
+	"github.com/coreyog/spa-server/internal/responder"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // register the webp decoder
+)
+
+// maxSourcePixels caps the decoded size of source images to protect
+// against decompression bombs.
+const maxSourcePixels = 64 * 1024 * 1024
+
+// ImageResizer serves resized/transcoded copies of images in root, e.g.
+// /_img?src=/img/logo.png&w=200&fmt=jpeg&q=80.
+type ImageResizer struct {
+	root   string
+	maxDim int
+	cache  *cache.LRU
+	disk   *cache.Disk
+}
+
+// NewImageResizer creates a resizer for images under root. Output images
+// are limited to maxDim pixels on either side and results are kept in an
+// LRU cache of cacheSize bytes, backed by disk if it isn't nil.
+func NewImageResizer(root string, maxDim int, cacheSize uint64, disk *cache.Disk) *ImageResizer {
+	return &ImageResizer{
+		root:   root,
+		maxDim: maxDim,
+		cache:  cache.NewLRU(cacheSize),
+		disk:   disk,
+	}
+}
+
+func (ir *ImageResizer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+
+	src := filepath.Join(ir.root, query.Get("src"))
+	if !resolver.Within(ir.root, src) || resolver.Reserved(query.Get("src")) {
+		http.Error(w, "invalid src", http.StatusBadRequest)
+		return
+	}
+
+	width, err := ir.dimension(query.Get("w"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	height, err := ir.dimension(query.Get("h"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	quality := jpeg.DefaultQuality
+	if q := query.Get("q"); len(q) > 0 {
+		quality, err = strconv.Atoi(q)
+		if err != nil || quality < 1 || quality > 100 {
+			http.Error(w, "invalid quality", http.StatusBadRequest)
+			return
+		}
+	}
+
+	format := strings.ToLower(query.Get("fmt"))
+	if format == "jpg" {
+		format = "jpeg"
+	}
+
+	switch format {
+	case "", "jpeg", "png", "gif":
+	default:
+		// webp can be read but not written
+		http.Error(w, fmt.Sprintf("unsupported format %q, expected jpeg, png, or gif", format), http.StatusBadRequest)
+		return
+	}
+
+	info, err := os.Stat(src)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "image not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "unable to open image", http.StatusBadRequest)
+		return
+	}
+
+	// the source's size and mtime keep results from outliving an edit
+	key := fmt.Sprintf("%s|%d|%d|%d|%d|%s|%d", src, info.Size(), info.ModTime().UnixNano(), width, height, format, quality)
+
+	entry, ok := ir.cache.Get(key)
+	if !ok && ir.disk != nil {
+		entry, ok = ir.disk.Get(key)
+		if ok {
+			ir.cache.Add(key, entry)
+		}
+	}
+
+	if ok {
+		logging.RequestSuccess(r, "%s => %s (%s)", logging.RedactURL(r.URL), strings.TrimPrefix(src, ir.root), entry.ContentType)
+	} else {
+		entry, err = ir.resize(src, width, height, format, quality)
+		if err != nil {
+			logging.RequestError(r, "%s => ??? (%s)", logging.RedactURL(r.URL), err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		ir.cache.Add(key, entry)
+
+		if ir.disk != nil {
+			ir.disk.Put(key, entry)
+		}
+
+		logging.RequestInfo(r, "%s => %s (%s)", logging.RedactURL(r.URL), strings.TrimPrefix(src, ir.root), logging.Highlight("resized"))
+	}
+
+	responder.Write(w, r, entry)
+}
+
+func (ir *ImageResizer) dimension(raw string) (int, error) {
+	if len(raw) == 0 {
+		return 0, nil
+	}
+
+	val, err := strconv.Atoi(raw)
+	if err != nil || val < 1 {
+		return 0, fmt.Errorf("invalid dimension %q", raw)
+	}
+
+	if val > ir.maxDim {
+		return 0, fmt.Errorf("dimension %d exceeds limit of %d", val, ir.maxDim)
+	}
+
+	return val, nil
+}
+
+func (ir *ImageResizer) resize(src string, width int, height int, format string, quality int) (*cache.Entry, error) {
+	file, err := os.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open image")
+	}
+
+	defer file.Close()
+
+	cfg, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported image")
+	}
+
+	if cfg.Width*cfg.Height > maxSourcePixels {
+		return nil, fmt.Errorf("source image too large")
+	}
+
+	_, err = file.Seek(0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	img, srcFormat, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported image")
+	}
+
+	bounds := img.Bounds()
+
+	// keep the aspect ratio when only one side is given
+	switch {
+	case width == 0 && height == 0:
+		width, height = bounds.Dx(), bounds.Dy()
+	case width == 0:
+		width = bounds.Dx() * height / bounds.Dy()
+	case height == 0:
+		height = bounds.Dy() * width / bounds.Dx()
+	}
+
+	// a side worked out from the source can be over the limit too, e.g.
+	// w=2000 of a tall image, so shrink both to fit
+	if width > ir.maxDim || height > ir.maxDim {
+		if width >= height {
+			width, height = ir.maxDim, height*ir.maxDim/width
+		} else {
+			width, height = width*ir.maxDim/height, ir.maxDim
+		}
+	}
+
+	if width < 1 {
+		width = 1
+	}
+
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+
+	if len(format) == 0 {
+		format = srcFormat
+	}
+
+	buf := &bytes.Buffer{}
+
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(buf, dst, &jpeg.Options{Quality: quality})
+	case "gif":
+		err = gif.Encode(buf, dst, nil)
+	case "png", "webp":
+		// there's no webp encoder in the standard library, so webp sources
+		// without a fmt are sent as png
+		format = "png"
+		err = png.Encode(buf, dst)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &cache.Entry{
+		Content:     buf.Bytes(),
+		ContentType: "image/" + format,
+	}, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImageResizerMaxDim(t *testing.T) {
+	dir := t.TempDir()
+
+	file, err := os.Create(filepath.Join(dir, "tall.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = png.Encode(file, image.NewGray(image.Rect(0, 0, 10, 4000)))
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ir := NewImageResizer(dir, 100, 1<<20, nil)
+
+	tests := []struct {
+		target        string
+		width, height int
+	}{
+		{"/_img?src=/tall.png", 1, 100},
+		{"/_img?src=/tall.png&w=50", 1, 100},
+		{"/_img?src=/tall.png&h=80", 1, 80},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		ir.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status %d, want 200", tt.target, rec.Code)
+			continue
+		}
+
+		cfg, err := png.DecodeConfig(rec.Body)
+		if err != nil {
+			t.Errorf("%s: %s", tt.target, err)
+			continue
+		}
+
+		if cfg.Width != tt.width || cfg.Height != tt.height {
+			t.Errorf("%s: %dx%d, want %dx%d", tt.target, cfg.Width, cfg.Height, tt.width, tt.height)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	ir.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_img?src=/missing.png&w=50", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("missing src: status %d, want 404", rec.Code)
+	}
+}
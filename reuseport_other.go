@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePort isn't available here, so --workers isn't either.
+func reusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("--workers needs SO_REUSEPORT, which this platform doesn't support")
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// Rollback handles /_admin/rollback, switching the hosted directory back to
+// the release before the current one and re-warming the cache from it
+// before answering, for undoing a bad deploy in well under a second.
+type Rollback struct {
+	link     string
+	deployer *Deployer
+	switched func(changed []string)
+
+	mu sync.Mutex
+}
+
+// NewRollback creates the rollback for the releases of the symlink link.
+// deployer, if not nil, keeps rollbacks from racing its deploys and lists
+// them with its diffs. switched is called after the switch with the paths
+// that changed, or nil if they're unknown.
+func NewRollback(link string, deployer *Deployer, switched func(changed []string)) (*Rollback, error) {
+	info, err := os.Lstat(link)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Mode()&os.ModeSymlink == 0 {
+		return nil, errors.New("rollback needs DIR to be a symlink to the current release")
+	}
+
+	return &Rollback{link: link, deployer: deployer, switched: switched}, nil
+}
+
+func (rb *Rollback) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mu := &rb.mu
+	if rb.deployer != nil {
+		mu = &rb.deployer.mu
+	}
+
+	if !mu.TryLock() {
+		http.Error(w, "a deploy is already in progress", http.StatusConflict)
+		return
+	}
+	defer mu.Unlock()
+
+	token, _ := adminTokenFor(r)
+	event := AuditEvent{Action: "rollback", Actor: "token:" + token.name, Result: "ok", Details: map[string]string{}}
+
+	start := time.Now()
+
+	release, err := rb.rollback()
+	if err != nil {
+		event.Result = "failed"
+		event.Details["error"] = err.Error()
+		auditLog.Record(event)
+		logging.Error("rollback failed: %s", err)
+		http.Error(w, "rollback failed: "+err.Error(), http.StatusConflict)
+
+		return
+	}
+
+	event.Details["release"] = release
+	auditLog.Record(event)
+	logging.Success("rolled back to %s (%s)", release, time.Since(start).Round(time.Microsecond))
+
+	_, _ = fmt.Fprintln(w, release)
+}
+
+// rollback switches to the previous release and returns its name.
+func (rb *Rollback) rollback() (string, error) {
+	name, root, err := previousRelease(rb.link)
+	if err != nil {
+		return "", err
+	}
+
+	current, err := filepath.EvalSymlinks(rb.link)
+	if err != nil {
+		return "", err
+	}
+
+	diff, err := diffTrees(current, root)
+	if err != nil {
+		logging.Warn("unable to compare releases: %s", err)
+		diff = nil
+	}
+
+	err = activateRelease(rb.link, root)
+	if err != nil {
+		return "", err
+	}
+
+	var changed []string
+
+	if diff != nil {
+		diff.Release = name
+		diff.Ref = "rollback"
+		diff.Time = time.Now().UTC().Truncate(time.Second)
+
+		if rb.deployer != nil {
+			rb.deployer.recordDiff(diff)
+		}
+
+		logging.Info("release %s: %s", name, diff)
+
+		changed = diff.paths()
+	}
+
+	rb.switched(changed)
+
+	return name, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRollback(t *testing.T) {
+	link := testReleases(t, "002", "001", "002")
+
+	var switched int
+
+	rb, err := NewRollback(link, nil, func(changed []string) { switched++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	rb.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/_admin/rollback", nil))
+
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "001" {
+		t.Fatalf("rollback answered %d %q, want 200 \"001\"", rec.Code, rec.Body.String())
+	}
+
+	content, err := os.ReadFile(filepath.Join(link, "index.html"))
+	if err != nil || string(content) != "001" {
+		t.Errorf("after the rollback the site serves %q, %v, want the 001 release", content, err)
+	}
+
+	if switched != 1 {
+		t.Errorf("switched was called %d times, want 1", switched)
+	}
+
+	// 001 is the oldest, there's nothing to roll back to
+	rec = httptest.NewRecorder()
+	rb.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/_admin/rollback", nil))
+
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "no release before 001") {
+		t.Errorf("rollback past the oldest release answered %d %q, want 409 naming the release", rec.Code, rec.Body.String())
+	}
+
+	target, err := filepath.EvalSymlinks(link)
+	if err != nil || filepath.Base(target) != "001" {
+		t.Errorf("a failed rollback moved the site to %s, %v", target, err)
+	}
+
+	if switched != 1 {
+		t.Errorf("a failed rollback called switched, %d calls in all", switched)
+	}
+}
+
+func TestRollbackWaitsForDeploys(t *testing.T) {
+	link := testReleases(t, "002", "001", "002")
+
+	d := &Deployer{}
+
+	rb, err := NewRollback(link, d, func(changed []string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rec := httptest.NewRecorder()
+	rb.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/_admin/rollback", nil))
+
+	if rec.Code != http.StatusConflict {
+		t.Errorf("rollback during a deploy answered %d, want 409", rec.Code)
+	}
+}
+
+func TestNewRollback(t *testing.T) {
+	_, err := NewRollback(t.TempDir(), nil, func(changed []string) {})
+	if err == nil {
+		t.Error("NewRollback() accepted a directory that isn't a symlink")
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/coreyog/spa-server/internal/logging"
+	"github.com/coreyog/spa-server/internal/resolver"
+	"github.com/jessevdk/go-flags"
+)
+
+// RoutesArguments are the options for the routes subcommand.
+type RoutesArguments struct {
+	DefaultDoc  []string `short:"d" long:"default-doc" description:"On 404, return this document, repeat to try several in order (also used for directories)" default:"index.html"`
+	DirFallback bool     `long:"dir-fallback" description:"On 404, return the default doc of the nearest directory that has one instead of the top-level one"`
+	DirRequests string   `long:"dir-requests" description:"For directory paths without a trailing slash, redirect to add it or serve the fallback doc" choice:"redirect" choice:"fallback" default:"redirect"`
+	Match       []string `short:"m" long:"match" description:"Only list URLs matching this glob, or under a directory matching it, e.g. /docs/* (repeatable)"`
+	Positional  struct {
+		Directory string `positional-arg-name:"DIR" description:"Directory to list" required:"true"`
+	} `positional-args:"yes"`
+}
+
+// route is a URL the server answers and what it answers with.
+type route struct {
+	url    string
+	target string
+	note   string
+}
+
+// routes prints every URL the server would answer for DIR with the given
+// options and what it would serve for each: files, directories' default
+// docs, redirects, and the fallbacks for everything else.
+//
+//	spa-server routes --match '/docs/*' ./dist
+func routes(argv []string) int {
+	var rargs RoutesArguments
+
+	parser := flags.NewParser(&rargs, flags.Default)
+	parser.Usage = "routes [OPTIONS]"
+
+	_, err := parser.ParseArgs(argv)
+	if err != nil {
+		if flags.WroteHelp(err) {
+			return 0
+		}
+
+		return 1
+	}
+
+	dir, err := filepath.Abs(rargs.Positional.Directory)
+	if err != nil {
+		logging.Error("%s", err)
+		return 1
+	}
+
+	res, err := resolver.New(dir, rargs.DefaultDoc...)
+	if err != nil {
+		logging.Error("%s", err)
+		return 1
+	}
+
+	list, err := listRoutes(res, &rargs)
+	if err != nil {
+		logging.Error("%s", err)
+		return 1
+	}
+
+	for _, rt := range list {
+		if !matchesRoute(rt.url, rargs.Match) {
+			continue
+		}
+
+		if len(rt.note) > 0 {
+			fmt.Printf("%s => %s (%s)\n", rt.url, rt.target, rt.note)
+		} else {
+			fmt.Printf("%s => %s\n", rt.url, rt.target)
+		}
+	}
+
+	return 0
+}
+
+// listRoutes walks res's directory and lists its routes sorted by URL, with
+// the catch-all fallbacks last.
+func listRoutes(res *resolver.Resolver, rargs *RoutesArguments) ([]route, error) {
+	var list, fallbacks []route
+
+	fallbackFor := func(fullpath string) string {
+		if rargs.DirFallback {
+			return res.Rel(res.NearestIndex(fullpath))
+		}
+
+		return res.Rel(res.DefaultPath())
+	}
+
+	err := filepath.Walk(res.Dir, func(fullpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel := res.Rel(fullpath)
+
+		if !info.IsDir() {
+			if resolver.Reserved(rel) {
+				return nil
+			}
+
+			list = append(list, route{url: rel, target: rel})
+
+			return nil
+		}
+
+		dirURL := strings.TrimSuffix(rel, "/") + "/"
+
+		index, hasIndex := "", false
+		for _, doc := range res.DefaultDocs {
+			if candidate := filepath.Join(fullpath, doc); res.Exists(candidate) {
+				index, hasIndex = res.Rel(candidate), true
+				break
+			}
+		}
+
+		if hasIndex {
+			list = append(list, route{url: dirURL, target: index})
+		} else {
+			list = append(list, route{url: dirURL, target: fallbackFor(filepath.Join(fullpath, res.DefaultDocs[0])), note: "fallback"})
+		}
+
+		if dirURL != "/" {
+			if rargs.DirRequests == "redirect" {
+				list = append(list, route{url: strings.TrimSuffix(dirURL, "/"), target: dirURL, note: "301"})
+			} else {
+				list = append(list, route{url: strings.TrimSuffix(dirURL, "/"), target: fallbackFor(fullpath), note: "fallback"})
+			}
+		}
+
+		if rargs.DirFallback && hasIndex {
+			fallbacks = append(fallbacks, route{url: dirURL + "*", target: index, note: "fallback"})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].url < list[j].url })
+
+	// the most specific directory's fallback applies
+	sort.Slice(fallbacks, func(i, j int) bool { return len(fallbacks[i].url) > len(fallbacks[j].url) })
+
+	switch {
+	case !res.Exists(res.DefaultPath()):
+		fallbacks = append(fallbacks, route{url: "/*", target: "???", note: "404"})
+	case !rargs.DirFallback:
+		fallbacks = append(fallbacks, route{url: "/*", target: res.Rel(res.DefaultPath()), note: "fallback"})
+	}
+
+	return append(list, fallbacks...), nil
+}
+
+// matchesRoute reports whether url, or a directory it's in, matches one of
+// the patterns. No patterns match everything.
+func matchesRoute(url string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		for candidate := url; ; candidate = path.Dir(strings.TrimSuffix(candidate, "/")) {
+			if ok, _ := path.Match(pattern, candidate); ok {
+				return true
+			}
+
+			if ok, _ := path.Match(pattern, strings.TrimSuffix(candidate, "/")); ok {
+				return true
+			}
+
+			if candidate == "/" {
+				break
+			}
+		}
+	}
+
+	return false
+}
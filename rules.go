@@ -0,0 +1,572 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// RulesFile is the file given to --rules. Its fields' descriptions, enums,
+// and patterns feed the JSON Schema printed by spa-server config schema.
+type RulesFile struct {
+	Schema string `json:"$schema,omitempty" description:"JSON Schema the file follows, for editors"`
+	Rules  []Rule `json:"rules" description:"Rules applied in order, later ones overriding what earlier ones set"`
+}
+
+// Rule sets how requests for paths matching a glob are served. Every rule
+// matching a request applies, in order, with later rules overriding the
+// settings of earlier ones, so broad rules come first and exceptions after.
+type Rule struct {
+	Match    string            `json:"match" pattern:"^/" description:"URL path glob: * and ? match within a segment, :name matches a whole segment, a trailing /** matches everything under a directory, and /**/ any number of directories"`
+	Headers  map[string]string `json:"headers,omitempty" description:"Response headers to set, or to remove when empty"`
+	Cache    string            `json:"cache,omitempty" pattern:"^(immutable|no-cache|no-store|([0-9.]+(ns|us|µs|ms|s|m|h))+)$" description:"Caching policy: immutable, no-cache, no-store, or a duration for max-age, e.g. 1h"`
+	Auth     *RuleAuth         `json:"auth,omitempty" description:"HTTP basic auth required for matching paths"`
+	Fallback string            `json:"fallback,omitempty" enum:"default,nearest,none" description:"What a missing file gets: the default doc, the default doc of the nearest directory that has one, or a 404"`
+	Redirect string            `json:"redirect,omitempty" description:"URL or path to redirect to, with :name and :splat replaced by what the match captured"`
+	Status   int               `json:"status,omitempty" enum:"301,302,303,307,308" description:"Status of the redirect, 301 by default"`
+	Rewrite  string            `json:"rewrite,omitempty" pattern:"^/" description:"Path to serve instead when the file doesn't exist, with :name and :splat replaced by what the match captured"`
+
+	pattern *regexp.Regexp
+}
+
+// RuleAuth is a rule's basic auth.
+type RuleAuth struct {
+	Realm string            `json:"realm,omitempty" description:"Realm shown by the browser's login prompt"`
+	Users map[string]string `json:"users,omitempty" description:"Passwords by user name, or file:PATH, env:NAME, or exec:COMMAND to read them from"`
+	Off   bool              `json:"off,omitempty" description:"Lift the basic auth of an earlier rule"`
+}
+
+// rulePolicy is the settings of every rule matching a request, combined.
+type rulePolicy struct {
+	headers  map[string]string
+	cache    string
+	auth     *RuleAuth
+	fallback string
+	redirect string
+	status   int
+	rewrite  string
+}
+
+type ruleKey struct{}
+
+// Rules applies --rules to requests for files.
+type Rules struct {
+	rules []Rule
+}
+
+// LoadRules reads and checks the rules in file, which is JSON or the text
+// form parseRulesText reads, resolving their secrets.
+func LoadRules(file string) (*Rules, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseRules(file, raw)
+}
+
+// parseRules checks the rules in raw, which came from file.
+func parseRules(file string, raw []byte) (*Rules, error) {
+	var err error
+
+	rf := &RulesFile{}
+
+	// JSON is an object, anything else the text form
+	if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+
+		err = dec.Decode(rf)
+	} else {
+		rf, err = parseRulesText(string(raw))
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	for i := range rf.Rules {
+		err = checkRule(&rf.Rules[i])
+		if err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %w", file, i+1, err)
+		}
+	}
+
+	return &Rules{rules: rf.Rules}, nil
+}
+
+// maxAgeRules turns --max-age values into rules, which come before those of
+// --rules so the file can make exceptions. A pattern starting with . is an
+// extension anywhere on the site, anything else a glob.
+func maxAgeRules(values []string) ([]Rule, error) {
+	rules := make([]Rule, 0, len(values))
+
+	for _, value := range values {
+		pattern, policy, ok := strings.Cut(value, "=")
+		if !ok || len(pattern) == 0 {
+			return nil, fmt.Errorf("--max-age %q isn't PATTERN=POLICY", value)
+		}
+
+		if strings.HasPrefix(pattern, ".") {
+			pattern = "/**/*" + pattern
+		}
+
+		rule := Rule{Match: pattern, Cache: policy}
+
+		err := checkRule(&rule)
+		if err != nil {
+			return nil, fmt.Errorf("--max-age %q: %w", value, err)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// checkRule validates rule and resolves its passwords.
+func checkRule(rule *Rule) error {
+	if !strings.HasPrefix(rule.Match, "/") {
+		return fmt.Errorf("match %q must start with /", rule.Match)
+	}
+
+	var err error
+
+	rule.pattern, err = compileRule(rule.Match)
+	if err != nil {
+		return fmt.Errorf("match %q: %w", rule.Match, err)
+	}
+
+	_, err = cacheControl(rule.Cache)
+	if err != nil {
+		return err
+	}
+
+	switch rule.Fallback {
+	case "", "default", "nearest", "none":
+	default:
+		return fmt.Errorf("fallback %q isn't default, nearest, or none", rule.Fallback)
+	}
+
+	switch rule.Status {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return fmt.Errorf("status %d isn't a redirect", rule.Status)
+	}
+
+	if rule.Status != 0 && len(rule.Redirect) == 0 {
+		return errors.New("status is only for redirects")
+	}
+
+	if len(rule.Rewrite) > 0 && !strings.HasPrefix(rule.Rewrite, "/") {
+		return fmt.Errorf("rewrite %q must be a path starting with /", rule.Rewrite)
+	}
+
+	if rule.Auth != nil && !rule.Auth.Off {
+		if len(rule.Auth.Users) == 0 {
+			return errors.New("auth needs users, or off")
+		}
+
+		for user, ref := range rule.Auth.Users {
+			password, _, err := resolveSecret(ref)
+			if err != nil {
+				return fmt.Errorf("password for %s: %w", user, err)
+			}
+
+			rule.Auth.Users[user] = strings.TrimSpace(password)
+		}
+	}
+
+	return nil
+}
+
+// cacheControl returns the Cache-Control header for a rule's cache policy.
+func cacheControl(policy string) (string, error) {
+	switch policy {
+	case "":
+		return "", nil
+	case "immutable":
+		return cdnCacheControl, nil
+	case "no-cache", "no-store":
+		return policy, nil
+	}
+
+	maxAge, err := time.ParseDuration(policy)
+	if err != nil || maxAge < 0 {
+		return "", fmt.Errorf("cache %q isn't immutable, no-cache, no-store, or a duration", policy)
+	}
+
+	return fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())), nil
+}
+
+// compileRule turns a rule's glob into a regexp. * and ? match within a
+// segment as for path.Match, as do [classes]. A segment that's :name
+// captures it under that name, and a trailing /** matches the directory and
+// everything under it, while /**/ elsewhere matches any number of
+// directories. The last * or /** is captured as splat.
+func compileRule(glob string) (*regexp.Regexp, error) {
+	var re strings.Builder
+
+	re.WriteString("^")
+
+	rest, tree := strings.CutSuffix(glob, "/**")
+	splat := strings.LastIndex(rest, "*")
+
+	if tree {
+		splat = -1
+	}
+
+	for i := 0; i < len(rest); i++ {
+		c := rest[i]
+
+		switch {
+		case c == '*' && strings.HasPrefix(rest[i:], "**/") && i > 0 && rest[i-1] == '/':
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case c == '*' && i == splat:
+			re.WriteString("(?P<splat>[^/]*)")
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(rest[i:], ']')
+			if end < 0 {
+				return nil, path.ErrBadPattern
+			}
+
+			class := rest[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			re.WriteString("[" + class + "]")
+			i += end
+		case c == '\\' && i+1 < len(rest):
+			i++
+			re.WriteString(regexp.QuoteMeta(rest[i : i+1]))
+		case c == ':' && i > 0 && rest[i-1] == '/':
+			end := strings.IndexByte(rest[i:], '/')
+			if end < 0 {
+				end = len(rest) - i
+			}
+
+			name := rest[i+1 : i+end]
+			if !placeholderName.MatchString(name) {
+				return nil, fmt.Errorf("bad placeholder :%s", name)
+			}
+
+			re.WriteString("(?P<" + name + ">[^/]+)")
+			i += end - 1
+		default:
+			re.WriteString(regexp.QuoteMeta(rest[i : i+1]))
+		}
+	}
+
+	if tree {
+		re.WriteString("(?:/(?P<splat>.*))?")
+	}
+
+	re.WriteString("$")
+
+	return regexp.Compile(re.String())
+}
+
+// placeholderName matches the names of :name segments, and placeholders
+// the references to them in redirects and rewrites.
+var (
+	placeholderName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	placeholders    = regexp.MustCompile(`:[A-Za-z_][A-Za-z0-9_]*`)
+)
+
+// expandRule replaces the :name references in target with what rule's match
+// captured from urlPath. Unknown names are left alone.
+func expandRule(rule *Rule, urlPath string, target string) string {
+	match := rule.pattern.FindStringSubmatch(urlPath)
+	captured := map[string]string{}
+
+	for i, name := range rule.pattern.SubexpNames() {
+		if len(name) > 0 && i < len(match) {
+			captured[name] = match[i]
+		}
+	}
+
+	return placeholders.ReplaceAllStringFunc(target, func(ref string) string {
+		if value, ok := captured[ref[1:]]; ok {
+			return value
+		}
+
+		return ref
+	})
+}
+
+// policy combines the rules matching urlPath.
+func (rs *Rules) policy(urlPath string) *rulePolicy {
+	var p *rulePolicy
+
+	for i := range rs.rules {
+		rule := &rs.rules[i]
+		if !rule.pattern.MatchString(urlPath) {
+			continue
+		}
+
+		if p == nil {
+			p = &rulePolicy{headers: map[string]string{}}
+		}
+
+		for name, value := range rule.Headers {
+			p.headers[name] = value
+		}
+
+		if len(rule.Cache) > 0 {
+			p.cache, _ = cacheControl(rule.Cache)
+		}
+
+		if rule.Auth != nil {
+			p.auth = rule.Auth
+		}
+
+		if len(rule.Fallback) > 0 {
+			p.fallback = rule.Fallback
+		}
+
+		// the first redirect or rewrite matching wins, as on hosting
+		// platforms, rather than the last
+		if len(rule.Redirect) > 0 && len(p.redirect) == 0 && len(p.rewrite) == 0 {
+			p.redirect = expandRule(rule, urlPath, rule.Redirect)
+			p.status = rule.Status
+		}
+
+		if len(rule.Rewrite) > 0 && len(p.redirect) == 0 && len(p.rewrite) == 0 {
+			p.rewrite = expandRule(rule, urlPath, rule.Rewrite)
+		}
+	}
+
+	return p
+}
+
+// authorize checks r has the credentials p's auth asks for, if any,
+// answering 401 and returning false if it doesn't. urlPath is the path
+// being protected, which is r's own unless r names a file to read.
+func (p *rulePolicy) authorize(w http.ResponseWriter, r *http.Request, urlPath string) bool {
+	if p == nil || p.auth == nil || p.auth.Off || basicAuthorized(r, p.auth) {
+		return true
+	}
+
+	logging.RequestWarn(r, "%s => %s", urlPath, logging.Highlight("unauthorized (401)"))
+
+	realm := p.auth.Realm
+	if len(realm) == 0 {
+		realm = "Restricted"
+	}
+
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", realm))
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+
+	return false
+}
+
+// filePath returns the URL path of the file r names in its param query
+// parameter, as the rules match it.
+func filePath(r *http.Request, param string) string {
+	return path.Clean("/" + r.URL.Query().Get(param))
+}
+
+// protects reports whether urlPath is behind basic auth. rs may be nil.
+func (rs *Rules) protects(urlPath string) bool {
+	if rs == nil {
+		return false
+	}
+
+	p := rs.policy(urlPath)
+
+	return p != nil && p.auth != nil && !p.auth.Off
+}
+
+// GuardFile applies the rules' basic auth to the file endpoints like /_img
+// read, named by their param query parameter, so they don't hand out files
+// the rules protect. rs may be nil.
+func (rs *Rules) GuardFile(param string, next http.Handler) http.Handler {
+	if rs == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		urlPath := filePath(r, param)
+
+		if rs.policy(urlPath).authorize(w, r, urlPath) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// RefuseProtected answers 403 for files behind the rules' basic auth,
+// named by the param query parameter, for endpoints like /_peer that
+// aren't asked by the client. The asking peer checked the client's
+// credentials already and reads such files itself. rs may be nil.
+func (rs *Rules) RefuseProtected(param string, next http.Handler) http.Handler {
+	if rs == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		urlPath := filePath(r, param)
+
+		if rs.protects(urlPath) {
+			logging.RequestError(r, "%s => ??? (403 protected by --rules)", urlPath)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Wrap applies the rules matching each request before handing it to next.
+func (rs *Rules) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := rs.policy(r.URL.Path)
+		if p == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !p.authorize(w, r, r.URL.Path) {
+			return
+		}
+
+		for name, value := range p.headers {
+			if len(value) == 0 {
+				w.Header().Del(name)
+			} else {
+				w.Header().Set(name, value)
+			}
+		}
+
+		explainf(r, "matched --rules")
+
+		if len(p.redirect) > 0 {
+			target := p.redirect
+			if len(r.URL.RawQuery) > 0 && !strings.Contains(target, "?") {
+				target += "?" + r.URL.RawQuery
+			}
+
+			status := p.status
+			if status == 0 {
+				status = http.StatusMovedPermanently
+			}
+
+			explainf(r, "redirecting to %s", p.redirect)
+			logging.RequestWarn(r, "%s => %s (%d)", r.URL.Path, p.redirect, status)
+			http.Redirect(w, r, target, status)
+
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), ruleKey{}, p))
+
+		if len(p.cache) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rw := &ruleWriter{ResponseWriter: w, policy: p}
+		next.ServeHTTP(rw, r)
+
+		// a HEAD response that's only headers never writes its status
+		if !rw.wrote {
+			rw.WriteHeader(http.StatusOK)
+		}
+	})
+}
+
+// ruleWriter sets the caching policy once the status is known, so errors
+// aren't cached as if they were the file.
+type ruleWriter struct {
+	http.ResponseWriter
+	policy *rulePolicy
+	wrote  bool
+}
+
+func (rw *ruleWriter) WriteHeader(status int) {
+	if !rw.wrote {
+		rw.wrote = true
+
+		ok := status == http.StatusOK || status == http.StatusPartialContent || status == http.StatusNotModified
+		if ok && len(rw.policy.cache) > 0 {
+			rw.Header().Set("Cache-Control", rw.policy.cache)
+		}
+	}
+
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *ruleWriter) Write(b []byte) (int, error) {
+	if !rw.wrote {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	return rw.ResponseWriter.Write(b)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (rw *ruleWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// basicAuthorized reports whether r has the credentials of one of auth's
+// users.
+func basicAuthorized(r *http.Request, auth *RuleAuth) bool {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	want, ok := auth.Users[user]
+	if !ok {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(password), []byte(want)) == 1
+}
+
+// ruleFallback returns the fallback the rules matching r set, if any.
+func ruleFallback(r *http.Request) string {
+	p, _ := r.Context().Value(ruleKey{}).(*rulePolicy)
+	if p == nil {
+		return ""
+	}
+
+	return p.fallback
+}
+
+// ruleRewrite returns the path the rules matching r serve instead of a
+// missing file, if any.
+func ruleRewrite(r *http.Request) string {
+	p, _ := r.Context().Value(ruleKey{}).(*rulePolicy)
+	if p == nil {
+		return ""
+	}
+
+	return p.rewrite
+}
+
+// dropRuleCache drops the caching policy the rules matching r set, which
+// was meant for the file and not the fallback served instead.
+func dropRuleCache(r *http.Request) {
+	if p, _ := r.Context().Value(ruleKey{}).(*rulePolicy); p != nil {
+		p.cache = ""
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// rulesNode is a line of a text rules file, with the lines of the block it
+// opens, if it ends with {.
+type rulesNode struct {
+	words    []string
+	children []rulesNode
+	line     int
+}
+
+// parseRulesText reads the text form of a rules file, a terser alternative
+// to JSON in the spirit of a Caddyfile. Each block starts with one or more
+// globs and holds a directive per line, and a glob with a single directive
+// can be written on one line:
+//
+//	/** {
+//		header X-Frame-Options DENY
+//		cache no-cache
+//	}
+//	/assets/** cache immutable
+//	/old-blog/** redirect /blog/:splat 308
+//	/admin/** {
+//		auth Staff {
+//			alice env:ADMIN_PASSWORD
+//		}
+//	}
+func parseRulesText(src string) (*RulesFile, error) {
+	lines, err := lexRulesText(src)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, rest, err := treeRulesText(lines, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("line %d: unexpected }", rest[0].line)
+	}
+
+	rf := &RulesFile{Rules: []Rule{}}
+
+	for _, node := range nodes {
+		n := 0
+		for n < len(node.words) && strings.HasPrefix(node.words[n], "/") {
+			n++
+		}
+
+		if n == 0 {
+			return nil, fmt.Errorf("line %d: expected a path glob, got %q", node.line, node.words[0])
+		}
+
+		rule := Rule{}
+
+		switch {
+		case len(node.words) > n && node.children == nil:
+			err = applyRulesDirective(&rule, rulesNode{words: node.words[n:], line: node.line})
+		case len(node.words) > n:
+			err = fmt.Errorf("line %d: a block follows the globs directly", node.line)
+		case node.children == nil:
+			err = fmt.Errorf("line %d: expected a directive or a block after %s", node.line, strings.Join(node.words, " "))
+		}
+
+		for _, child := range node.children {
+			if err != nil {
+				break
+			}
+
+			err = applyRulesDirective(&rule, child)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, glob := range node.words[:n] {
+			r := copyRule(rule)
+			r.Match = glob
+			rf.Rules = append(rf.Rules, r)
+		}
+	}
+
+	return rf, nil
+}
+
+// copyRule copies rule deeply enough that checkRule resolving one copy's
+// passwords leaves the others alone.
+func copyRule(rule Rule) Rule {
+	if rule.Headers != nil {
+		headers := make(map[string]string, len(rule.Headers))
+		for k, v := range rule.Headers {
+			headers[k] = v
+		}
+
+		rule.Headers = headers
+	}
+
+	if rule.Auth != nil {
+		auth := *rule.Auth
+		if auth.Users != nil {
+			auth.Users = make(map[string]string, len(rule.Auth.Users))
+			for k, v := range rule.Auth.Users {
+				auth.Users[k] = v
+			}
+		}
+
+		rule.Auth = &auth
+	}
+
+	return rule
+}
+
+// applyRulesDirective sets what the directive in node says on rule.
+func applyRulesDirective(rule *Rule, node rulesNode) error {
+	name, params := node.words[0], node.words[1:]
+
+	wrong := func(usage string) error {
+		return fmt.Errorf("line %d: expected %s %s", node.line, name, usage)
+	}
+
+	if node.children != nil && name != "header" && name != "auth" {
+		return fmt.Errorf("line %d: %s doesn't take a block", node.line, name)
+	}
+
+	switch name {
+	case "header":
+		if rule.Headers == nil {
+			rule.Headers = map[string]string{}
+		}
+
+		if node.children != nil {
+			if len(params) > 0 {
+				return wrong("{ NAME VALUE ... }")
+			}
+
+			for _, child := range node.children {
+				if len(child.words) > 2 || child.children != nil {
+					return fmt.Errorf("line %d: expected NAME [VALUE], quote values with spaces", child.line)
+				}
+
+				rule.Headers[child.words[0]] = strings.Join(child.words[1:], "")
+			}
+
+			return nil
+		}
+
+		if len(params) < 1 || len(params) > 2 {
+			return wrong("NAME [VALUE], quote values with spaces")
+		}
+
+		rule.Headers[params[0]] = strings.Join(params[1:], "")
+	case "cache", "fallback", "rewrite":
+		if len(params) != 1 {
+			return wrong(strings.ToUpper(name))
+		}
+
+		switch name {
+		case "cache":
+			rule.Cache = params[0]
+		case "fallback":
+			rule.Fallback = params[0]
+		case "rewrite":
+			rule.Rewrite = params[0]
+		}
+	case "redirect":
+		if len(params) < 1 || len(params) > 2 {
+			return wrong("TO [STATUS]")
+		}
+
+		rule.Redirect = params[0]
+
+		if len(params) == 2 {
+			switch params[1] {
+			case "permanent":
+				rule.Status = http.StatusMovedPermanently
+			case "temporary":
+				rule.Status = http.StatusFound
+			default:
+				status, err := strconv.Atoi(params[1])
+				if err != nil {
+					return wrong("TO [STATUS], with a status code, permanent, or temporary")
+				}
+
+				rule.Status = status
+			}
+		}
+	case "auth":
+		if len(params) == 1 && params[0] == "off" && node.children == nil {
+			rule.Auth = &RuleAuth{Off: true}
+			return nil
+		}
+
+		if len(params) > 1 || node.children == nil {
+			return wrong("[REALM] { USER PASSWORD ... }, or off")
+		}
+
+		rule.Auth = &RuleAuth{Realm: strings.Join(params, ""), Users: map[string]string{}}
+
+		for _, child := range node.children {
+			if len(child.words) != 2 || child.children != nil {
+				return fmt.Errorf("line %d: expected USER PASSWORD", child.line)
+			}
+
+			rule.Auth.Users[child.words[0]] = child.words[1]
+		}
+	default:
+		return fmt.Errorf("line %d: unknown directive %s", node.line, name)
+	}
+
+	return nil
+}
+
+// treeRulesText nests lines ending in { under them, up to a line that's
+// just }, returning the nodes and the lines after the block.
+func treeRulesText(lines []rulesNode, nested bool) ([]rulesNode, []rulesNode, error) {
+	nodes := []rulesNode{}
+
+	for len(lines) > 0 {
+		line := lines[0]
+		lines = lines[1:]
+
+		last := line.words[len(line.words)-1]
+
+		switch {
+		case len(line.words) == 1 && last == "}":
+			if !nested {
+				return nodes, append([]rulesNode{line}, lines...), nil
+			}
+
+			return nodes, lines, nil
+		case last == "{" && len(line.words) > 1:
+			children, rest, err := treeRulesText(lines, true)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			line.words = line.words[:len(line.words)-1]
+			line.children = children
+			lines = rest
+		case last == "{" || last == "}":
+			return nil, nil, fmt.Errorf("line %d: unexpected %s", line.line, last)
+		}
+
+		nodes = append(nodes, line)
+	}
+
+	if nested {
+		return nil, nil, errors.New("unexpected end of file, a block isn't closed")
+	}
+
+	return nodes, nil, nil
+}
+
+// lexRulesText splits src into lines of words, dropping comments and empty
+// lines. Words are separated by spaces unless they're quoted, and { and }
+// are words of their own at the end of a line.
+func lexRulesText(src string) ([]rulesNode, error) {
+	var lines []rulesNode
+
+	for n, text := range strings.Split(src, "\n") {
+		line := rulesNode{line: n + 1}
+
+		for i := 0; i < len(text); {
+			c := text[i]
+
+			switch {
+			case c == ' ' || c == '\t' || c == '\r':
+				i++
+			case c == '#':
+				i = len(text)
+			case c == '"':
+				var b strings.Builder
+
+				closed := false
+
+				for i++; i < len(text) && !closed; i++ {
+					switch {
+					case text[i] == '\\' && i+1 < len(text):
+						i++
+						b.WriteByte(text[i])
+					case text[i] == '"':
+						closed = true
+					default:
+						b.WriteByte(text[i])
+					}
+				}
+
+				if !closed {
+					return nil, fmt.Errorf("line %d: unterminated string", line.line)
+				}
+
+				line.words = append(line.words, b.String())
+			default:
+				start := i
+				for i < len(text) && !strings.ContainsRune(" \t\r", rune(text[i])) {
+					i++
+				}
+
+				line.words = append(line.words, text[start:i])
+			}
+		}
+
+		if len(line.words) > 0 {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// secret is a flag holding a sensitive value, and the environment variable
+// it can also be given in. For flags like --admin-token, the secret is the
+// part of the value after the first sep.
+type secret struct {
+	flag  string
+	env   string
+	value *string
+	sep   string
+}
+
+// secrets lists the flags resolveSecrets applies to. Every flag that takes
+// a secret belongs here, so it gets the same references and warning.
+func secrets() []secret {
+	list := []secret{
+		{flag: "--deploy-secret", env: "SPA_DEPLOY_SECRET", value: &args.DeploySecret},
+	}
+
+	for i := range args.AdminTokens {
+		list = append(list, secret{flag: "--admin-token", value: &args.AdminTokens[i], sep: "="})
+	}
+
+	return list
+}
+
+// resolveSecrets replaces references in the secret flags with the values
+// they refer to, warning about secrets given on the command line where
+// process listings show them.
+func resolveSecrets() error {
+	for _, s := range secrets() {
+		prefix, ref := "", *s.value
+
+		if len(s.sep) > 0 {
+			before, after, ok := strings.Cut(ref, s.sep)
+			if !ok {
+				continue // left for the flag's own parsing to reject
+			}
+
+			prefix, ref = before+s.sep, after
+		}
+
+		if len(ref) == 0 {
+			continue
+		}
+
+		resolved, literal, err := resolveSecret(ref)
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.flag, err)
+		}
+
+		if literal && ref != os.Getenv(s.env) {
+			logging.Warn("%s is visible in process listings, consider file:, env:, or exec:", s.flag)
+		}
+
+		*s.value = prefix + resolved
+	}
+
+	return nil
+}
+
+// resolveSecret returns the secret ref refers to: a file's contents for
+// "file:PATH", an environment variable for "env:NAME", a command's output
+// for "exec:COMMAND [ARGS...]", e.g. a secrets manager's CLI, or ref itself
+// otherwise, which it reports as literal.
+func resolveSecret(ref string) (value string, literal bool, err error) {
+	kind, rest, _ := strings.Cut(ref, ":")
+
+	switch kind {
+	case "file":
+		content, err := ioutil.ReadFile(rest)
+		if err != nil {
+			return "", false, err
+		}
+
+		value = string(content)
+	case "env":
+		var ok bool
+
+		value, ok = os.LookupEnv(rest)
+		if !ok {
+			return "", false, fmt.Errorf("environment variable %s isn't set", rest)
+		}
+	case "exec":
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return "", false, fmt.Errorf("no command in %q", ref)
+		}
+
+		cmd := exec.Command(fields[0], fields[1:]...)
+		cmd.Stderr = os.Stderr
+
+		out, err := cmd.Output()
+		if err != nil {
+			return "", false, fmt.Errorf("%s: %w", fields[0], err)
+		}
+
+		value = string(out)
+	default:
+		return ref, true, nil
+	}
+
+	// files and commands usually end with a newline that isn't part of it
+	value = strings.TrimRight(value, "\r\n")
+	if len(value) == 0 {
+		return "", false, fmt.Errorf("%q is empty", ref)
+	}
+
+	return value, false, nil
+}
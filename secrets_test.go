@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+)
+
+func TestResolveSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+
+	err := os.WriteFile(path, []byte("from-file\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("SPA_TEST_SECRET", "from-env")
+
+	tests := []struct {
+		ref         string
+		want        string
+		wantLiteral bool
+		wantErr     bool
+	}{
+		{"plain", "plain", true, false},
+		{"file:" + path, "from-file", false, false},
+		{"env:SPA_TEST_SECRET", "from-env", false, false},
+		{"env:SPA_TEST_UNSET", "", false, true},
+		{"file:" + path + ".missing", "", false, true},
+	}
+
+	for _, tt := range tests {
+		got, literal, err := resolveSecret(tt.ref)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("resolveSecret(%q) error = %v, want error %v", tt.ref, err, tt.wantErr)
+			continue
+		}
+
+		if got != tt.want || literal != tt.wantLiteral {
+			t.Errorf("resolveSecret(%q) = %q, %v, want %q, %v", tt.ref, got, literal, tt.want, tt.wantLiteral)
+		}
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	t.Setenv("SPA_TEST_TOKEN", "from-env")
+
+	tests := []struct {
+		deploySecret string
+		adminTokens  []string
+		wantSecret   string
+		wantTokens   []string
+		wantWarnings []string
+	}{
+		{
+			deploySecret: "env:SPA_TEST_TOKEN",
+			adminTokens:  []string{"ops:stats=env:SPA_TEST_TOKEN"},
+			wantSecret:   "from-env",
+			wantTokens:   []string{"ops:stats=from-env"},
+		},
+		{
+			deploySecret: "hunter2",
+			adminTokens:  []string{"ops:stats=hunter2"},
+			wantSecret:   "hunter2",
+			wantTokens:   []string{"ops:stats=hunter2"},
+			wantWarnings: []string{"--deploy-secret is visible", "--admin-token is visible"},
+		},
+	}
+
+	out := color.Output
+	defer func() { color.Output = out }()
+
+	for _, tt := range tests {
+		logs := &bytes.Buffer{}
+		color.Output = logs
+
+		args = Arguments{DeploySecret: tt.deploySecret, AdminTokens: append([]string(nil), tt.adminTokens...)}
+
+		err := resolveSecrets()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if args.DeploySecret != tt.wantSecret || strings.Join(args.AdminTokens, " ") != strings.Join(tt.wantTokens, " ") {
+			t.Errorf("resolved %q and %q, want %q and %q", args.DeploySecret, args.AdminTokens, tt.wantSecret, tt.wantTokens)
+		}
+
+		for _, warning := range tt.wantWarnings {
+			if !strings.Contains(logs.String(), warning) {
+				t.Errorf("%q: no warning %q in %q", tt.deploySecret, warning, logs.String())
+			}
+		}
+
+		if len(tt.wantWarnings) == 0 && strings.Contains(logs.String(), "visible") {
+			t.Errorf("%q: unexpected warning in %q", tt.deploySecret, logs.String())
+		}
+	}
+}
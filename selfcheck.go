@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// selfCheck requests / and each of specs (PATH or PATH=STATUS, default
+// status 200) from the server at base and returns an error if any response
+// has an unexpected status. A spec for / replaces the built-in check, e.g.
+// /=401 when the whole site needs a login.
+func selfCheck(base string, specs []string) error {
+	checks := parseSelfChecks(specs)
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			// the certificate names the public host rather than loopback,
+			// and it's the server being checked, not the certificate
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	failed := 0
+
+	for _, check := range checks {
+		path, expected := check.path, check.status
+
+		resp, err := client.Get(base + path)
+		if err != nil {
+			logging.Error("self-check %s => %s", path, err)
+			failed++
+
+			continue
+		}
+
+		resp.Body.Close()
+
+		if resp.StatusCode != expected {
+			logging.Error("self-check %s => %d (expected %d)", path, resp.StatusCode, expected)
+			failed++
+
+			continue
+		}
+
+		logging.Success("self-check %s => %d", path, resp.StatusCode)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d self-check(s) failed", failed)
+	}
+
+	return nil
+}
+
+// selfCheckStatus splits PATH=STATUS. A path whose query ends in three
+// digits, like /health?n=100, needs its status given too.
+var selfCheckStatus = regexp.MustCompile(`^(.*)=(\d{3})$`)
+
+// selfCheckSpec is a path for --self-check and the status it should get.
+type selfCheckSpec struct {
+	path   string
+	status int
+}
+
+// parseSelfChecks parses the --self-check-path values, starting with a
+// check for / unless one of them is for /.
+func parseSelfChecks(specs []string) []selfCheckSpec {
+	checks := make([]selfCheckSpec, 0, len(specs)+1)
+	root := false
+
+	for _, spec := range specs {
+		check := selfCheckSpec{path: spec, status: http.StatusOK}
+
+		// a query's = is part of the path, e.g. /health?deep=1
+		if m := selfCheckStatus.FindStringSubmatch(spec); m != nil && len(m[1]) > 0 {
+			status, _ := strconv.Atoi(m[2])
+			check.path, check.status = m[1], status
+		}
+
+		root = root || check.path == "/"
+		checks = append(checks, check)
+	}
+
+	if !root {
+		checks = append([]selfCheckSpec{{path: "/", status: http.StatusOK}}, checks...)
+	}
+
+	return checks
+}
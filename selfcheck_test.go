@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseSelfChecks(t *testing.T) {
+	tests := []struct {
+		specs []string
+		want  []selfCheckSpec
+	}{
+		{nil, []selfCheckSpec{{"/", 200}}},
+		{[]string{"/=401"}, []selfCheckSpec{{"/", 401}}},
+		{[]string{"/health?deep=1"}, []selfCheckSpec{{"/", 200}, {"/health?deep=1", 200}}},
+		{[]string{"/health?deep=1=503"}, []selfCheckSpec{{"/", 200}, {"/health?deep=1", 503}}},
+		{[]string{"/health?n=100=200"}, []selfCheckSpec{{"/", 200}, {"/health?n=100", 200}}},
+		{[]string{"/missing=404", "/"}, []selfCheckSpec{{"/missing", 404}, {"/", 200}}},
+	}
+
+	for _, tt := range tests {
+		got := parseSelfChecks(tt.specs)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSelfChecks(%q) = %v, want %v", tt.specs, got, tt.want)
+		}
+	}
+}
+
+func TestSelfCheckRoot(t *testing.T) {
+	// a site behind a login answers / with 401
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	err := selfCheck(srv.URL, []string{"/=401", "/healthz"})
+	if err != nil {
+		t.Errorf("with /=401: %s", err)
+	}
+
+	err = selfCheck(srv.URL, []string{"/healthz"})
+	if err == nil {
+		t.Error("without /=401: expected the built-in check for / to fail")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+	"github.com/coreyog/spa-server/spa"
+)
+
+// siteFallbacks are the values of a --site's fallback option.
+var siteFallbacks = map[string]spa.Fallback{
+	"default": spa.FallbackDefault,
+	"nearest": spa.FallbackNearest,
+	"none":    spa.FallbackNone,
+}
+
+// Sites serves other apps from the same process by the request's Host,
+// each from its own directory with its own default doc and cache, leaving
+// requests for other hosts to DIR. The server's own endpoints, like
+// /readyz, are the same for every host.
+type Sites struct {
+	mux      *http.ServeMux
+	exact    map[string]http.Handler
+	wildcard map[string]http.Handler // by the domain after *.
+}
+
+// NewSites creates the sites given as --site values. mux has the server's
+// endpoints.
+func NewSites(values []string, mux *http.ServeMux) (*Sites, error) {
+	s := &Sites{
+		mux:      mux,
+		exact:    map[string]http.Handler{},
+		wildcard: map[string]http.Handler{},
+	}
+
+	for _, value := range values {
+		host, handler, err := parseSite(value)
+		if err != nil {
+			return nil, err
+		}
+
+		sites := s.exact
+		if strings.HasPrefix(host, "*.") {
+			sites = s.wildcard
+			host = strings.TrimPrefix(host, "*.")
+		}
+
+		if _, ok := sites[host]; ok {
+			return nil, fmt.Errorf("--site %s: the host is already a site", value)
+		}
+
+		sites[host] = handler
+	}
+
+	return s, nil
+}
+
+// parseSite parses a --site value, HOST=DIR followed by comma-separated
+// options: default-doc=FILE (repeatable), cache or cache=TTL, and
+// fallback=default|nearest|none.
+func parseSite(value string) (string, http.Handler, error) {
+	host, rest, ok := strings.Cut(value, "=")
+	if !ok || len(host) == 0 {
+		return "", nil, fmt.Errorf("--site %s: expected HOST=DIR", value)
+	}
+
+	fields := strings.Split(rest, ",")
+	dir := fields[0]
+
+	if len(dir) == 0 {
+		return "", nil, fmt.Errorf("--site %s: expected HOST=DIR", value)
+	}
+
+	var (
+		opts []spa.Option
+		docs []string
+	)
+
+	for _, field := range fields[1:] {
+		name, arg, _ := strings.Cut(field, "=")
+
+		switch name {
+		case "default-doc":
+			docs = append(docs, arg)
+		case "cache":
+			ttl := time.Duration(0)
+
+			if len(arg) > 0 {
+				var err error
+
+				ttl, err = time.ParseDuration(arg)
+				if err != nil {
+					return "", nil, fmt.Errorf("--site %s: %w", value, err)
+				}
+			}
+
+			opts = append(opts, spa.WithCache(ttl))
+		case "fallback":
+			fallback, ok := siteFallbacks[arg]
+			if !ok {
+				return "", nil, fmt.Errorf("--site %s: fallback is default, nearest, or none, not %q", value, arg)
+			}
+
+			opts = append(opts, spa.WithFallback(fallback))
+		default:
+			return "", nil, fmt.Errorf("--site %s: unknown option %q", value, name)
+		}
+	}
+
+	if len(docs) > 0 {
+		opts = append(opts, spa.WithDefaultDocs(docs...))
+	}
+
+	host = strings.ToLower(host)
+
+	if args.LogFormat == "pretty" {
+		// the access log has the requests otherwise
+		opts = append(opts, spa.WithLogger(logging.Logger().With("site", host)))
+	}
+
+	handler, err := spa.New(dir, opts...)
+	if err != nil {
+		return "", nil, fmt.Errorf("--site %s: %w", value, err)
+	}
+
+	return host, handler, nil
+}
+
+// site returns the handler for host, or nil if it isn't a site.
+func (s *Sites) site(host string) http.Handler {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	if handler, ok := s.exact[host]; ok {
+		return handler
+	}
+
+	// the most specific wildcard wins
+	for domain := host; ; {
+		_, parent, ok := strings.Cut(domain, ".")
+		if !ok {
+			return nil
+		}
+
+		if handler, ok := s.wildcard[parent]; ok {
+			return handler
+		}
+
+		domain = parent
+	}
+}
+
+// Wrap sends requests for the sites' hosts to them and the rest to next.
+func (s *Sites) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// apps have paths like /_next/ of their own, so only those the
+		// server handles are its
+		if _, pattern := s.mux.Handler(r); pattern == "/readyz" || strings.HasPrefix(pattern, "/_") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if handler := s.site(r.Host); handler != nil {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
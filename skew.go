@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// SkewGuard protects apps with an old document open from a deploy removing
+// the lazily loaded chunks it refers to. For a while after DIR's symlink
+// switches releases, assets missing from the new release are served from
+// the one before it. After that, or without a grace period, requests for
+// them can get a 409 telling the app to reload instead of the fallback.
+type SkewGuard struct {
+	link   string
+	grace  time.Duration
+	reload bool
+	old    *Previous
+}
+
+// NewSkewGuard creates the guard for the releases of the symlink link.
+// serve creates the handler for a release's directory.
+func NewSkewGuard(link string, grace time.Duration, reload bool, serve func(dir string) (http.Handler, error)) (*SkewGuard, error) {
+	info, err := os.Lstat(link)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Mode()&os.ModeSymlink == 0 {
+		return nil, errors.New("--skew-grace and --skew-reload need DIR to be a symlink to the current release")
+	}
+
+	return &SkewGuard{
+		link:   link,
+		grace:  grace,
+		reload: reload,
+		old:    &Previous{link: link, serve: serve},
+	}, nil
+}
+
+// Serve answers a request for relPath, which the current release doesn't
+// have, if the previous release does. It reports whether it did, leaving
+// the request to the usual fallback otherwise.
+func (sg *SkewGuard) Serve(w http.ResponseWriter, r *http.Request, relPath string) bool {
+	ext := strings.ToLower(filepath.Ext(relPath))
+	if len(ext) == 0 || ext == ".html" || ext == ".htm" {
+		// documents fall back as usual, only assets are pinned to a release
+		return false
+	}
+
+	name, root, err := previousRelease(sg.link)
+	if err != nil {
+		return false
+	}
+
+	info, err := os.Stat(filepath.Join(root, filepath.FromSlash(relPath)))
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+
+	if sg.grace > 0 {
+		link, err := os.Lstat(sg.link)
+		if err == nil && time.Since(link.ModTime()) < sg.grace {
+			handler, err := sg.old.current()
+			if err == nil {
+				explainf(r, "missing from the current release: serving it from %s", name)
+				handler.ServeHTTP(w, r)
+
+				return true
+			}
+		}
+	}
+
+	if !sg.reload {
+		return false
+	}
+
+	explainf(r, "missing from the current release but in %s: asking the app to reload", name)
+	logging.RequestWarn(r, "%s => %s (409)", r.URL.Path, logging.Highlight("reload"))
+
+	w.Header().Set("X-Spa-Reload", "true")
+	w.Header().Set("Cache-Control", "no-store")
+	http.Error(w, "this asset is from an older release, reload the page", http.StatusConflict)
+
+	return true
+}
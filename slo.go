@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sloResolution is the width of each bucket of SLO counts.
+	sloResolution = 5 * time.Minute
+
+	// sloHistory is the longest window reported.
+	sloHistory = 7 * 24 * time.Hour
+)
+
+// sloWindows are the windows /_slo reports on.
+var sloWindows = []struct {
+	name   string
+	length time.Duration
+}{
+	{"24h", 24 * time.Hour},
+	{"7d", sloHistory},
+}
+
+// sloCounts are the responses counted in one bucket.
+type sloCounts struct {
+	slot   int64
+	total  int
+	errors int
+	slow   int
+}
+
+// SLOTracker keeps a week of availability and latency counts in memory and
+// reports them against the objectives on /_slo, for teams without a
+// monitoring stack. Counts start over when the server restarts.
+type SLOTracker struct {
+	availability  float64
+	latency       time.Duration
+	latencyTarget float64
+
+	mu      sync.Mutex
+	buckets []sloCounts
+}
+
+// NewSLOTracker creates a tracker for the availability and latencyTarget
+// objectives, as percentages of responses that aren't server errors and
+// that take less than latency.
+func NewSLOTracker(availability float64, latency time.Duration, latencyTarget float64) *SLOTracker {
+	return &SLOTracker{
+		availability:  availability,
+		latency:       latency,
+		latencyTarget: latencyTarget,
+		buckets:       make([]sloCounts, sloHistory/sloResolution),
+	}
+}
+
+// Wrap times every response to next and records whether it met the
+// objectives, leaving out health checks and the server's own endpoints.
+func (t *SLOTracker) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/readyz" || strings.HasPrefix(r.URL.Path, "/_") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		sw := trackResponse(w)
+		next.ServeHTTP(sw, r)
+
+		t.record(start, sw.Status() >= 500, time.Since(start) > t.latency)
+	})
+}
+
+func (t *SLOTracker) record(now time.Time, failed bool, slow bool) {
+	slot := now.UnixNano() / int64(sloResolution)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := &t.buckets[slot%int64(len(t.buckets))]
+	if b.slot != slot {
+		*b = sloCounts{slot: slot}
+	}
+
+	b.total++
+
+	if failed {
+		b.errors++
+	}
+
+	if slow {
+		b.slow++
+	}
+}
+
+// sums adds up the buckets over the length before now.
+func (t *SLOTracker) sums(now time.Time, length time.Duration) sloCounts {
+	first := now.Add(-length).UnixNano() / int64(sloResolution)
+	last := now.UnixNano() / int64(sloResolution)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var sum sloCounts
+
+	for _, b := range t.buckets {
+		if b.slot > first && b.slot <= last {
+			sum.total += b.total
+			sum.errors += b.errors
+			sum.slow += b.slow
+		}
+	}
+
+	return sum
+}
+
+// sloObjective is how one objective did over a window. Budget is the share
+// of the allowed failures still unspent, negative once it's overspent.
+type sloObjective struct {
+	Target   float64 `json:"target"`
+	Achieved float64 `json:"achieved"`
+	Failed   int     `json:"failed"`
+	Budget   float64 `json:"budget_remaining"`
+	Met      bool    `json:"met"`
+}
+
+func newSLOObjective(target float64, total int, failed int) sloObjective {
+	obj := sloObjective{Target: target, Achieved: 100, Failed: failed, Budget: 100, Met: true}
+
+	if total == 0 {
+		return obj
+	}
+
+	obj.Achieved = round(100*float64(total-failed)/float64(total), 3)
+	obj.Met = obj.Achieved >= target
+
+	allowed := float64(total) * (100 - target) / 100
+	obj.Budget = round(100*(1-float64(failed)/allowed), 1)
+
+	return obj
+}
+
+func round(f float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(f*scale) / scale
+}
+
+// sloWindow is one window of the /_slo report.
+type sloWindow struct {
+	Requests     int          `json:"requests"`
+	Availability sloObjective `json:"availability"`
+	Latency      sloObjective `json:"latency"`
+}
+
+// sloReport is the body of /_slo.
+type sloReport struct {
+	Since     time.Time            `json:"since"`
+	Uptime    string               `json:"uptime"`
+	Threshold string               `json:"latency_threshold"`
+	Windows   map[string]sloWindow `json:"windows"`
+}
+
+// ServeHTTP reports the last 24 hours and 7 days against the objectives.
+func (t *SLOTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+
+	report := sloReport{
+		Since:     started.UTC().Truncate(time.Second),
+		Uptime:    now.Sub(started).Round(time.Second).String(),
+		Threshold: t.latency.String(),
+		Windows:   map[string]sloWindow{},
+	}
+
+	for _, window := range sloWindows {
+		sum := t.sums(now, window.length)
+
+		report.Windows[window.name] = sloWindow{
+			Requests:     sum.total,
+			Availability: newSLOObjective(t.availability, sum.total, sum.errors),
+			Latency:      newSLOObjective(t.latencyTarget, sum.total, sum.slow),
+		}
+	}
+
+	body, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(append(body, '\n'))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSLOObjective(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   float64
+		total    int
+		failed   int
+		achieved float64
+		budget   float64
+		met      bool
+	}{
+		{"no traffic", 99.9, 0, 0, 100, 100, true},
+		{"no failures", 99.9, 10000, 0, 100, 100, true},
+		{"half the budget burned", 99.9, 10000, 5, 99.95, 50, true},
+		{"budget exactly spent", 99.9, 10000, 10, 99.9, 0, true},
+		{"budget overspent", 99.9, 10000, 20, 99.8, -100, false},
+		{"latency objective", 99, 200, 1, 99.5, 50, true},
+		{"everything failed", 99, 100, 100, 0, -9900, false},
+	}
+
+	for _, tt := range tests {
+		obj := newSLOObjective(tt.target, tt.total, tt.failed)
+
+		if obj.Achieved != tt.achieved || obj.Budget != tt.budget || obj.Met != tt.met || obj.Failed != tt.failed || obj.Target != tt.target {
+			t.Errorf("%s: achieved %v%%, budget %v%%, met %v, want %v%%, %v%%, %v", tt.name, obj.Achieved, obj.Budget, obj.Met, tt.achieved, tt.budget, tt.met)
+		}
+	}
+}
+
+func TestSLOWindows(t *testing.T) {
+	st := NewSLOTracker(99.9, 500*time.Millisecond, 99)
+	now := time.Unix(1700000000, 0)
+
+	record := func(when time.Time, count int, failed bool, slow bool) {
+		for i := 0; i < count; i++ {
+			st.record(when, failed, slow)
+		}
+	}
+
+	record(now.Add(-time.Hour), 90, false, false)
+	record(now.Add(-time.Hour), 10, true, false)
+	record(now.Add(-3*24*time.Hour), 95, false, false)
+	record(now.Add(-3*24*time.Hour), 5, false, true)
+	record(now.Add(-8*24*time.Hour), 1000, true, true) // older than a week
+
+	tests := []struct {
+		length time.Duration
+		want   sloCounts
+	}{
+		{24 * time.Hour, sloCounts{total: 100, errors: 10}},
+		{sloHistory, sloCounts{total: 200, errors: 10, slow: 5}},
+	}
+
+	for _, tt := range tests {
+		if got := st.sums(now, tt.length); got != tt.want {
+			t.Errorf("sums over %s = %+v, want %+v", tt.length, got, tt.want)
+		}
+	}
+}
+
+func TestSLOReport(t *testing.T) {
+	st := NewSLOTracker(99.9, 500*time.Millisecond, 99)
+
+	for i := 0; i < 1000; i++ {
+		st.record(time.Now(), i < 2, i < 5)
+	}
+
+	rec := httptest.NewRecorder()
+	st.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_slo", nil))
+
+	var report sloReport
+
+	err := json.Unmarshal(rec.Body.Bytes(), &report)
+	if err != nil {
+		t.Fatalf("bad /_slo body %q: %s", rec.Body.String(), err)
+	}
+
+	if report.Threshold != "500ms" {
+		t.Errorf("latency threshold %q, want 500ms", report.Threshold)
+	}
+
+	for _, name := range []string{"24h", "7d"} {
+		w := report.Windows[name]
+
+		// 2 errors against 1 allowed, 5 slow against 10 allowed
+		if w.Requests != 1000 || w.Availability.Budget != -100 || w.Availability.Met || w.Latency.Budget != 50 || !w.Latency.Met {
+			t.Errorf("%s window = %+v, want 1000 requests, availability budget -100%% and latency budget 50%%", name, w)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+)
+
+// maxSlotValue caps how much of a cookie or header ends up in a page.
+const maxSlotValue = 128
+
+// SlotRule fills <!--slot:NAME--> markers in HTML responses from a request
+// cookie, header, or forwarded prefix, falling back to Default when it's
+// missing.
+type SlotRule struct {
+	Name    string
+	Source  string // "cookie", "header", or "prefix"
+	Key     string
+	Default string
+}
+
+var slotRules []SlotRule
+
+// parseSlotRules parses NAME=cookie:KEY[:DEFAULT], NAME=header:KEY[:DEFAULT],
+// and NAME=prefix[:DEFAULT] rules.
+func parseSlotRules(raw []string) ([]SlotRule, error) {
+	rules := make([]SlotRule, 0, len(raw))
+
+	for _, r := range raw {
+		nameSpec := strings.SplitN(r, "=", 2)
+		if len(nameSpec) != 2 || len(nameSpec[0]) == 0 {
+			return nil, fmt.Errorf("invalid slot %q, expected NAME=cookie|header:KEY[:DEFAULT] or NAME=prefix[:DEFAULT]", r)
+		}
+
+		if nameSpec[1] == "prefix" || strings.HasPrefix(nameSpec[1], "prefix:") {
+			rules = append(rules, SlotRule{
+				Name:    nameSpec[0],
+				Source:  "prefix",
+				Default: strings.TrimPrefix(strings.TrimPrefix(nameSpec[1], "prefix"), ":"),
+			})
+
+			continue
+		}
+
+		spec := strings.SplitN(nameSpec[1], ":", 3)
+		if len(spec) < 2 || (spec[0] != "cookie" && spec[0] != "header") || len(spec[1]) == 0 {
+			return nil, fmt.Errorf("invalid slot %q, expected NAME=cookie|header:KEY[:DEFAULT] or NAME=prefix[:DEFAULT]", r)
+		}
+
+		rule := SlotRule{
+			Name:   nameSpec[0],
+			Source: spec[0],
+			Key:    spec[1],
+		}
+
+		if len(spec) == 3 {
+			rule.Default = spec[2]
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+type slotValue struct {
+	Name  string
+	Value string // already HTML escaped
+}
+
+// slotValues resolves the slots used by content for this request, setting
+// Vary for the cookies and headers they come from.
+func slotValues(w http.ResponseWriter, r *http.Request, contentType string, content []byte) []slotValue {
+	if len(slotRules) == 0 || !strings.HasPrefix(contentType, "text/html") || !bytes.Contains(content, []byte("<!--slot:")) {
+		return nil
+	}
+
+	var values []slotValue
+
+	vary := map[string]bool{}
+
+	for _, rule := range slotRules {
+		if !bytes.Contains(content, []byte("<!--slot:"+rule.Name+"-->")) {
+			continue
+		}
+
+		value := rule.Default
+
+		switch rule.Source {
+		case "cookie":
+			vary["Cookie"] = true
+
+			if c, err := r.Cookie(rule.Key); err == nil && len(c.Value) > 0 {
+				value = c.Value
+			}
+		case "header":
+			vary[http.CanonicalHeaderKey(rule.Key)] = true
+
+			if h := r.Header.Get(rule.Key); len(h) > 0 {
+				value = h
+			}
+		case "prefix":
+			vary["X-Forwarded-Prefix"] = true
+
+			if prefix := forwardedPrefix(r); len(prefix) > 0 {
+				value = prefix
+			}
+		}
+
+		if len(value) > maxSlotValue {
+			value = value[:maxSlotValue]
+		}
+
+		values = append(values, slotValue{Name: rule.Name, Value: html.EscapeString(value)})
+	}
+
+	for header := range vary {
+		w.Header().Add("Vary", header)
+	}
+
+	return values
+}
+
+// applySlots returns a copy of content with the slot markers substituted.
+func applySlots(content []byte, values []slotValue) []byte {
+	for _, v := range values {
+		content = bytes.ReplaceAll(content, []byte("<!--slot:"+v.Name+"-->"), []byte(v.Value))
+	}
+
+	return content
+}
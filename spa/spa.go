@@ -0,0 +1,237 @@
+// Package spa serves a single-page app from a directory as an http.Handler,
+// for programs that embed it rather than running spa-server. Features are
+// opted into with options:
+//
+//	handler, err := spa.New("./dist",
+//		spa.WithCache(time.Hour),
+//		spa.WithFallback(spa.FallbackNearest),
+//		spa.WithHeaders(http.Header{"X-Frame-Options": {"DENY"}}),
+//		spa.WithLogger(slog.Default()),
+//	)
+package spa
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/cache"
+	"github.com/coreyog/spa-server/internal/resolver"
+	"github.com/coreyog/spa-server/internal/responder"
+)
+
+// Fallback is what a request for a file that doesn't exist gets.
+type Fallback int
+
+const (
+	// FallbackDefault serves the top-level default doc, so the app's
+	// client-side router handles the path.
+	FallbackDefault Fallback = iota
+
+	// FallbackNearest serves the default doc of the nearest directory that
+	// has one, for directories holding apps of their own.
+	FallbackNearest
+
+	// FallbackNone answers with a 404.
+	FallbackNone
+)
+
+// Option configures the handler created by New.
+type Option func(*Handler)
+
+// WithCache keeps files in memory once they've been read, reading them
+// again after ttl, or never if ttl is 0.
+func WithCache(ttl time.Duration) Option {
+	return func(h *Handler) {
+		h.store = cache.New(0, nil)
+		h.ttl = ttl
+	}
+}
+
+// WithFallback sets what requests for files that don't exist get,
+// FallbackDefault unless it's given.
+func WithFallback(fallback Fallback) Option {
+	return func(h *Handler) {
+		h.fallback = fallback
+	}
+}
+
+// WithHeaders sets headers on every response, e.g. security headers. Later
+// calls add to the headers of earlier ones.
+func WithHeaders(headers http.Header) Option {
+	return func(h *Handler) {
+		for name, values := range headers {
+			h.headers[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+		}
+	}
+}
+
+// WithLogger sends the handler's events through logger: each response at
+// Info, fallbacks at Debug, 404s at Warn, and read errors at Error, with the
+// request's context so the logger's handler can add request-scoped values.
+// Nothing is logged unless it's given.
+func WithLogger(logger *slog.Logger) Option {
+	return func(h *Handler) {
+		h.log = logger
+	}
+}
+
+// WithDefaultDocs sets the files served for directories, tried in order,
+// index.html unless it's given.
+func WithDefaultDocs(docs ...string) Option {
+	return func(h *Handler) {
+		h.defaultDocs = docs
+	}
+}
+
+// Handler serves a single-page app. It's created by New.
+type Handler struct {
+	res   *resolver.Resolver
+	types responder.Types
+
+	defaultDocs []string
+	store       *cache.Cache
+	ttl         time.Duration
+	fallback    Fallback
+	headers     http.Header
+	log         *slog.Logger
+}
+
+// New creates a handler serving the app in dir with opts applied.
+func New(dir string, opts ...Option) (*Handler, error) {
+	h := &Handler{
+		defaultDocs: []string{"index.html"},
+		headers:     http.Header{},
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "open", Path: dir, Err: errors.New("not a directory")}
+	}
+
+	h.res, err = resolver.New(dir, h.defaultDocs...)
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// ServeHTTP answers GET and HEAD requests with the file the path asks for,
+// or the fallback if there isn't one.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	for name, values := range h.headers {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+
+	fullpath := h.res.Map(r.URL.Path)
+
+	entry, hit, err := h.entry(fullpath)
+	if errors.Is(err, fs.ErrNotExist) && h.fallback != FallbackNone {
+		fullpath = h.res.DefaultPath()
+		if h.fallback == FallbackNearest {
+			fullpath = h.res.NearestIndex(h.res.Map(r.URL.Path))
+		}
+
+		h.logf(r, slog.LevelDebug, "falling back", slog.String("file", h.res.Rel(fullpath)))
+
+		entry, hit, err = h.entry(fullpath)
+	}
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		h.logf(r, slog.LevelWarn, "not found")
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case err != nil:
+		h.logf(r, slog.LevelError, "unable to read file", slog.String("file", h.res.Rel(fullpath)), slog.Any("error", err))
+		http.Error(w, "unable to read file", http.StatusInternalServerError)
+	default:
+		h.logf(r, slog.LevelInfo, "served", slog.String("file", h.res.Rel(fullpath)), slog.Bool("cached", hit), slog.Int("bytes", len(entry.Content)))
+		responder.Write(w, r, entry)
+	}
+}
+
+// logf logs an event for r, if there's a logger.
+func (h *Handler) logf(r *http.Request, level slog.Level, msg string, attrs ...slog.Attr) {
+	if h.log == nil {
+		return
+	}
+
+	attrs = append([]slog.Attr{slog.String("method", r.Method), slog.String("path", r.URL.Path)}, attrs...)
+
+	h.log.LogAttrs(r.Context(), level, msg, attrs...)
+}
+
+// entry returns the cached entry for fullpath, reading it if it isn't
+// cached or has expired, and whether it was cached. Directories don't exist
+// as far as it's concerned.
+func (h *Handler) entry(fullpath string) (*cache.Entry, bool, error) {
+	key := cache.Key{Path: fullpath}
+
+	if h.store != nil {
+		entry, ok := h.store.Load(key)
+		if ok && (h.ttl == 0 || time.Since(entry.Loaded) < h.ttl) {
+			return entry, true, nil
+		}
+	}
+
+	file, err := os.Open(fullpath)
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+
+	if info.IsDir() {
+		return nil, false, &fs.PathError{Op: "open", Path: fullpath, Err: fs.ErrNotExist}
+	}
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, false, err
+	}
+
+	entry := &cache.Entry{
+		Content:     content,
+		ContentType: h.types.Detect(fullpath, content),
+		ETag:        responder.FileETag(info.ModTime(), info.Size()),
+		Loaded:      time.Now(),
+		ModTime:     info.ModTime(),
+	}
+
+	if h.store != nil {
+		entry.ETag = responder.StrongETag(content)
+		h.store.Store(key, entry)
+	}
+
+	return entry, false, nil
+}
@@ -0,0 +1,214 @@
+package spa_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coreyog/spa-server/spa"
+)
+
+// site creates an app with a nested one under admin/.
+func site(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	for name, content := range map[string]string{
+		"index.html":       "<p>app</p>",
+		"app.js":           "console.log(1)",
+		"admin/index.html": "<p>admin</p>",
+	} {
+		path := filepath.Join(dir, name)
+
+		err := os.MkdirAll(filepath.Dir(path), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.WriteFile(path, []byte(content), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func get(t *testing.T, h http.Handler, target string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+
+	return rec
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	h, err := spa.New(site(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for target, want := range map[string]string{
+		"/":              "<p>app</p>",
+		"/app.js":        "console.log(1)",
+		"/settings/2":    "<p>app</p>",
+		"/admin/":        "<p>admin</p>",
+		"/admin/users":   "<p>app</p>",
+		"/../index.html": "<p>app</p>",
+	} {
+		rec := get(t, h, target)
+		if rec.Code != http.StatusOK || rec.Body.String() != want {
+			t.Errorf("%s: %d %q, want %q", target, rec.Code, rec.Body.String(), want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: %d, want 405", rec.Code)
+	}
+}
+
+func TestNewMissingDir(t *testing.T) {
+	t.Parallel()
+
+	_, err := spa.New(filepath.Join(t.TempDir(), "missing"))
+	if err == nil {
+		t.Error("no error for a missing directory")
+	}
+}
+
+func TestWithFallback(t *testing.T) {
+	t.Parallel()
+
+	dir := site(t)
+
+	nearest, err := spa.New(dir, spa.WithFallback(spa.FallbackNearest))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := get(t, nearest, "/admin/users"); rec.Body.String() != "<p>admin</p>" {
+		t.Errorf("nearest: %q, want the admin app", rec.Body.String())
+	}
+
+	none, err := spa.New(dir, spa.WithFallback(spa.FallbackNone))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := get(t, none, "/settings"); rec.Code != http.StatusNotFound {
+		t.Errorf("none: %d, want 404", rec.Code)
+	}
+}
+
+func TestWithCache(t *testing.T) {
+	t.Parallel()
+
+	dir := site(t)
+
+	h, err := spa.New(dir, spa.WithCache(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := get(t, h, "/app.js")
+
+	err = os.WriteFile(filepath.Join(dir, "app.js"), []byte("console.log(2)"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := get(t, h, "/app.js"); rec.Body.String() != "console.log(1)" {
+		t.Errorf("cached: %q, want the first version", rec.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/app.js", nil)
+	req.Header.Set("If-None-Match", first.Header().Get("ETag"))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("revalidated: %d, want 304", rec.Code)
+	}
+
+	expiring, err := spa.New(dir, spa.WithCache(time.Nanosecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get(t, expiring, "/app.js")
+	time.Sleep(time.Millisecond)
+
+	if rec := get(t, expiring, "/app.js"); rec.Body.String() != "console.log(2)" {
+		t.Errorf("expired: %q, want the file as it is now", rec.Body.String())
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+
+			return a
+		},
+	}))
+
+	h, err := spa.New(site(t), spa.WithCache(0), spa.WithLogger(logger), spa.WithFallback(spa.FallbackNearest))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get(t, h, "/app.js")
+	get(t, h, "/app.js")
+	get(t, h, "/admin/users")
+
+	want := `level=INFO msg=served method=GET path=/app.js file=/app.js cached=false bytes=14
+level=INFO msg=served method=GET path=/app.js file=/app.js cached=true bytes=14
+level=DEBUG msg="falling back" method=GET path=/admin/users file=/admin/index.html
+level=INFO msg=served method=GET path=/admin/users file=/admin/index.html cached=false bytes=12
+`
+
+	if buf.String() != want {
+		t.Errorf("logged\n%s\nwant\n%s", buf, want)
+	}
+}
+
+func TestWithHeaders(t *testing.T) {
+	t.Parallel()
+
+	h, err := spa.New(site(t),
+		spa.WithHeaders(http.Header{"x-frame-options": {"DENY"}}),
+		spa.WithHeaders(http.Header{"Referrer-Policy": {"same-origin"}}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := get(t, h, "/")
+
+	if got := rec.Header().Get("X-Frame-Options"); got != "DENY" {
+		t.Errorf("X-Frame-Options = %q", got)
+	}
+
+	if got := rec.Header().Get("Referrer-Policy"); got != "same-origin" {
+		t.Errorf("Referrer-Policy = %q", got)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+	"github.com/coreyog/spa-server/internal/resolver"
+)
+
+// maxIncludeDepth stops runaway recursive includes.
+const maxIncludeDepth = 10
+
+// ssiEnvPrefix is what the names of the environment variables the env
+// directive reads start with. Others, like SPA_DEPLOY_SECRET or a password
+// read with env:, render empty, since they'd end up in a public page.
+const ssiEnvPrefix = "SPA_PUBLIC_"
+
+// startTime is reported to templates as the build timestamp.
+var startTime = time.Now()
+
+// expandTemplate evaluates SSI-style directives in HTML files, e.g.
+//
+//	<!--# include "partials/header.html" -->
+//	<!--# env "SPA_PUBLIC_API_URL" -->
+//	<!--# buildTime -->
+//
+// Directives are Go template actions with <!--# and --> as delimiters. Non
+// HTML content, or content that fails to expand, is returned unchanged.
+func expandTemplate(fullpath string, contentType string, raw []byte) []byte {
+	if !strings.HasPrefix(contentType, "text/html") || !bytes.Contains(raw, []byte("<!--#")) {
+		return raw
+	}
+
+	out, err := expand(fullpath, raw, 0)
+	if err != nil {
+		logging.Error("unable to expand %s: %s", strings.TrimPrefix(fullpath, args.Positional.Directory), err)
+		return raw
+	}
+
+	return out
+}
+
+func expand(fullpath string, raw []byte, depth int) ([]byte, error) {
+	if depth > maxIncludeDepth {
+		return nil, fmt.Errorf("includes nested deeper than %d", maxIncludeDepth)
+	}
+
+	funcs := template.FuncMap{
+		"include": func(name string) (string, error) {
+			incPath := filepath.Join(args.Positional.Directory, name)
+			if !resolver.Within(args.Positional.Directory, incPath) || resolver.Reserved(name) {
+				return "", fmt.Errorf("include %q is not in the directory", name)
+			}
+
+			inc, err := ioutil.ReadFile(incPath)
+			if err != nil {
+				return "", err
+			}
+
+			out, err := expand(incPath, inc, depth+1)
+
+			return string(out), err
+		},
+		"env": ssiEnv,
+		"buildTime": func() string {
+			return startTime.UTC().Format(time.RFC3339)
+		},
+		"modTime": func() string {
+			info, err := os.Stat(fullpath)
+			if err != nil {
+				return ""
+			}
+
+			return info.ModTime().UTC().Format(time.RFC3339)
+		},
+	}
+
+	tmpl, err := template.New(filepath.Base(fullpath)).Delims("<!--#", "-->").Funcs(funcs).Parse(string(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	out := &bytes.Buffer{}
+
+	err = tmpl.Execute(out, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// ssiEnv returns the environment variable name, if it's public.
+func ssiEnv(name string) string {
+	if !strings.HasPrefix(name, ssiEnvPrefix) {
+		return ""
+	}
+
+	return os.Getenv(name)
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSSIEnv(t *testing.T) {
+	t.Setenv("SPA_PUBLIC_API_URL", "https://api.example.com")
+	t.Setenv("SPA_DEPLOY_SECRET", "hunter2")
+
+	raw := []byte(`<p><!--# env "SPA_PUBLIC_API_URL" --></p><p><!--# env "SPA_DEPLOY_SECRET" --></p>`)
+
+	got, err := expand(filepath.Join(t.TempDir(), "index.html"), raw, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `<p>https://api.example.com</p><p></p>`
+	if string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
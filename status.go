@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+// statusWriter records the status and size of the response written through
+// it, for the middleware that reports on responses. It implements
+// http.Flusher and http.Hijacker on top of whatever writer it wraps, so
+// streaming and protocol upgrades keep working behind it.
+type statusWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+// trackResponse returns w as a statusWriter, wrapping it unless it already
+// is one so that nested middleware share a single record.
+func trackResponse(w http.ResponseWriter) *statusWriter {
+	if sw, ok := w.(*statusWriter); ok {
+		return sw
+	}
+
+	return &statusWriter{ResponseWriter: w}
+}
+
+// trackResponses gives next a statusWriter that the middleware inside it
+// share.
+func trackResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(trackResponse(w), r)
+	})
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	// informational responses are followed by the real one
+	if sw.status == 0 && (status >= 200 || status == http.StatusSwitchingProtocols) {
+		sw.status = status
+	}
+
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+
+	n, err := sw.ResponseWriter.Write(b)
+	sw.written += int64(n)
+
+	return n, err
+}
+
+// ReadFrom keeps the underlying writer's sendfile path for io.Copy.
+func (sw *statusWriter) ReadFrom(src io.Reader) (int64, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+
+	rf, ok := sw.ResponseWriter.(io.ReaderFrom)
+	if !ok {
+		rf = writerOnly{sw.ResponseWriter}
+	}
+
+	n, err := rf.ReadFrom(src)
+	sw.written += n
+
+	return n, err
+}
+
+// writerOnly hides a writer's other methods so io.Copy doesn't recurse.
+type writerOnly struct {
+	io.Writer
+}
+
+func (wo writerOnly) ReadFrom(src io.Reader) (int64, error) {
+	return io.Copy(wo.Writer, src)
+}
+
+// Flush sends any buffered response to the client, if the underlying
+// writer supports it.
+func (sw *statusWriter) Flush() {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+
+	_ = http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+// Hijack hands the connection over to the caller, e.g. for a WebSocket,
+// recording it as switching protocols.
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(sw.ResponseWriter).Hijack()
+	if err == nil && sw.status == 0 {
+		sw.status = http.StatusSwitchingProtocols
+	}
+
+	return conn, rw, err
+}
+
+// Status returns the response's status, 200 if nothing was written.
+func (sw *statusWriter) Status() int {
+	if sw.status == 0 {
+		return http.StatusOK
+	}
+
+	return sw.status
+}
+
+// Written returns the number of body bytes written.
+func (sw *statusWriter) Written() int64 {
+	return sw.written
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Substitution is a find/replace rule applied to text files as they're
+// loaded, e.g. to point a build at a different API or CDN.
+type Substitution struct {
+	Find    []byte
+	Replace []byte
+}
+
+var substitutions []Substitution
+
+// parseSubstitutions parses FIND=REPLACE rules. The first = separates the
+// two, so FIND can't contain one but REPLACE can.
+func parseSubstitutions(raw []string) ([]Substitution, error) {
+	subs := make([]Substitution, 0, len(raw))
+
+	for _, r := range raw {
+		parts := strings.SplitN(r, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return nil, fmt.Errorf("invalid replacement %q, expected FIND=REPLACE", r)
+		}
+
+		subs = append(subs, Substitution{Find: []byte(parts[0]), Replace: []byte(parts[1])})
+	}
+
+	return subs, nil
+}
+
+// isText reports whether contentType is a text format substitutions and
+// other textual rewrites can safely apply to.
+func isText(contentType string) bool {
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+xml"),
+		strings.HasSuffix(mediaType, "+json"):
+		return true
+	}
+
+	switch mediaType {
+	case "application/javascript", "application/json", "application/xml", "application/manifest+json":
+		return true
+	}
+
+	return false
+}
+
+// substitute applies the substitutions to raw, in order, if it's text.
+func substitute(contentType string, raw []byte) []byte {
+	if len(substitutions) == 0 || !isText(contentType) {
+		return raw
+	}
+
+	for _, s := range substitutions {
+		if bytes.Contains(raw, s.Find) {
+			raw = bytes.ReplaceAll(raw, s.Find, s.Replace)
+		}
+	}
+
+	return raw
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// workerEnv marks a process as one of a supervisor's workers, holding its
+// number.
+const workerEnv = "SPA_WORKER"
+
+// isWorker reports whether this process was started by a supervisor.
+func isWorker() bool {
+	return len(os.Getenv(workerEnv)) > 0
+}
+
+// Restarts back off from minRestartDelay, doubling for each crash in a row
+// up to maxRestartDelay. A worker that stayed up for healthyUptime resets
+// the delay.
+const (
+	minRestartDelay = time.Second
+	maxRestartDelay = 30 * time.Second
+	healthyUptime   = time.Minute
+)
+
+// supervise runs n copies of this process with the same arguments, each
+// listening on the same port with SO_REUSEPORT so the kernel spreads
+// connections across them, and restarts any that exit until it's told to
+// stop. It returns the exit code for the supervisor.
+func supervise(n int) int {
+	exe, err := os.Executable()
+	if err != nil {
+		logging.Error("unable to find the executable: %s", err)
+		return 1
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	var (
+		mu       sync.Mutex
+		stopping bool
+		procs    = make([]*os.Process, n)
+		wg       sync.WaitGroup
+	)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+
+		go func(worker int) {
+			defer wg.Done()
+
+			var delay time.Duration
+
+			for {
+				cmd := exec.Command(exe, os.Args[1:]...)
+				cmd.Stdout = os.Stdout
+				cmd.Stderr = os.Stderr
+				if len(args.LogFile) > 0 && isCLF() {
+					// one process rotates --log-file, which only has the
+					// access lines
+					cmd.Stdout = accessOutput
+				} else if len(args.LogFile) > 0 {
+					// one process rotates --log-file
+					cmd.Stderr = logging.Output
+				}
+				cmd.Env = append(os.Environ(), workerEnv+"="+strconv.Itoa(worker))
+
+				mu.Lock()
+				if stopping {
+					mu.Unlock()
+					return
+				}
+
+				err := cmd.Start()
+				if err == nil {
+					procs[worker-1] = cmd.Process
+				}
+				mu.Unlock()
+
+				if err != nil {
+					logging.Error("unable to start worker %d: %s", worker, err)
+					return
+				}
+
+				logging.Info("started worker %d (pid %d)", worker, cmd.Process.Pid)
+
+				start := time.Now()
+				err = cmd.Wait()
+
+				mu.Lock()
+				procs[worker-1] = nil
+				done := stopping
+				mu.Unlock()
+
+				if done {
+					return
+				}
+
+				delay = restartDelay(delay, time.Since(start))
+
+				logging.Error("worker %d exited (%s), restarting in %s", worker, exitReason(err), delay)
+				time.Sleep(delay)
+			}
+		}(i + 1)
+	}
+
+	s := <-sig
+	signal.Stop(sig)
+
+	logging.Warn("stopping %d workers", n)
+
+	mu.Lock()
+	stopping = true
+
+	for _, proc := range procs {
+		if proc != nil {
+			_ = proc.Signal(s)
+		}
+	}
+	mu.Unlock()
+
+	wg.Wait()
+
+	return 0
+}
+
+// restartDelay returns how long to wait before restarting a worker that
+// exited after running for uptime, given the delay before its previous
+// restart, or 0 if it hasn't been restarted yet.
+func restartDelay(last time.Duration, uptime time.Duration) time.Duration {
+	if last == 0 || uptime >= healthyUptime {
+		return minRestartDelay
+	}
+
+	delay := last * 2
+	if delay > maxRestartDelay {
+		delay = maxRestartDelay
+	}
+
+	return delay
+}
+
+// exitReason describes how a worker exited.
+func exitReason(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+
+	return err.Error()
+}
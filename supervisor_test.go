@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestartDelay(t *testing.T) {
+	tests := []struct {
+		name   string
+		last   time.Duration
+		uptime time.Duration
+		want   time.Duration
+	}{
+		{"first crash", 0, time.Second, minRestartDelay},
+		{"crash in a row doubles", time.Second, time.Second, 2 * time.Second},
+		{"doubles again", 4 * time.Second, 0, 8 * time.Second},
+		{"capped", 16 * time.Second, time.Second, maxRestartDelay},
+		{"stays capped", maxRestartDelay, time.Second, maxRestartDelay},
+		{"healthy run resets", maxRestartDelay, healthyUptime, minRestartDelay},
+		{"just short of healthy", 2 * time.Second, healthyUptime - time.Millisecond, 4 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := restartDelay(tt.last, tt.uptime); got != tt.want {
+			t.Errorf("%s: restartDelay(%s, %s) = %s, want %s", tt.name, tt.last, tt.uptime, got, tt.want)
+		}
+	}
+
+	// a worker that keeps crashing backs off to the cap and stays there
+	var delay time.Duration
+
+	for i := 0; i < 10; i++ {
+		delay = restartDelay(delay, 0)
+	}
+
+	if delay != maxRestartDelay {
+		t.Errorf("after 10 crashes in a row the delay is %s, want %s", delay, maxRestartDelay)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// tunedListener applies socket options to every accepted TCP connection.
+type tunedListener struct {
+	net.Listener
+	noDelay   bool
+	linger    int
+	keepAlive time.Duration
+}
+
+// Accept waits for the next connection and tunes it.
+func (l *tunedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		_ = tcp.SetNoDelay(l.noDelay)
+
+		if l.linger >= 0 {
+			_ = tcp.SetLinger(l.linger)
+		}
+
+		if l.keepAlive > 0 {
+			_ = tcp.SetKeepAlive(true)
+			_ = tcp.SetKeepAlivePeriod(l.keepAlive)
+		}
+	}
+
+	return conn, nil
+}
+
+// ipNetworks maps --ip-family to the network passed to net.Listen. Go
+// binds "tcp" dual-stack where the OS supports it and "tcp6" IPv6-only.
+var ipNetworks = map[string]string{
+	"dual": "tcp",
+	"ipv4": "tcp4",
+	"ipv6": "tcp6",
+}
+
+// listen listens on the first of ports that isn't already in use. With
+// shared, the port can be shared with other processes using SO_REUSEPORT.
+func listen(network string, ports []int, shared bool) (net.Listener, error) {
+	var err error
+
+	lc := net.ListenConfig{}
+	if shared {
+		lc.Control = reusePort
+	}
+
+	for _, port := range ports {
+		var ln net.Listener
+
+		ln, err = lc.Listen(context.Background(), network, net.JoinHostPort("", strconv.Itoa(port)))
+		if err == nil {
+			return ln, nil
+		}
+
+		if !errors.Is(err, errAddrInUse) {
+			return nil, err
+		}
+
+		logging.Warn("port %d is in use", port)
+	}
+
+	return nil, err
+}
+
+// boundFamilies describes the address families ln accepts connections on.
+func boundFamilies(network string, ln net.Listener) string {
+	addr, ok := ln.Addr().(*net.TCPAddr)
+	if !ok {
+		return network
+	}
+
+	switch {
+	case addr.IP.To4() != nil:
+		return "IPv4 only"
+	case network == "tcp6":
+		return "IPv6 only"
+	default:
+		return "IPv4 and IPv6"
+	}
+}
+
+// loopback returns the address of ln on the loopback interface of a family
+// it's bound to.
+func loopback(network string, ln net.Listener) net.Addr {
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	if network == "tcp6" {
+		addr.IP = net.IPv6loopback
+	}
+
+	if tcp, ok := ln.Addr().(*net.TCPAddr); ok {
+		addr.Port = tcp.Port
+	}
+
+	return addr
+}
@@ -0,0 +1,7 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+var errAddrInUse error = syscall.EADDRINUSE
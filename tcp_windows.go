@@ -0,0 +1,6 @@
+package main
+
+import "syscall"
+
+// errAddrInUse is WSAEADDRINUSE, which syscall doesn't name.
+var errAddrInUse error = syscall.Errno(10048)
@@ -0,0 +1 @@
+window.widgetVersion = "1.0.0";
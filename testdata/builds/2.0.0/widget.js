@@ -0,0 +1 @@
+window.widgetVersion = "2.0.0";
@@ -0,0 +1,2 @@
+// client-side router stand-in
+document.getElementById("app").textContent = location.pathname;
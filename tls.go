@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+)
+
+// tlsConfig builds the server's TLS config from args, or returns nil to
+// serve plain HTTP.
+func tlsConfig() (*tls.Config, error) {
+	if len(args.AutoTLS) > 0 {
+		return autoTLSConfig()
+	}
+
+	if len(args.TLSCert) == 0 && len(args.TLSKey) == 0 {
+		if len(args.TLSTicketKeys) > 0 || args.OCSPStaple {
+			return nil, errors.New("--tls-ticket-keys and --ocsp-staple need --tls-cert and --tls-key")
+		}
+
+		return nil, nil
+	}
+
+	if len(args.TLSCert) != len(args.TLSKey) {
+		return nil, errors.New("each --tls-cert needs a matching --tls-key")
+	}
+
+	certs := make([]tls.Certificate, len(args.TLSCert))
+
+	for i := range args.TLSCert {
+		cert, err := tls.LoadX509KeyPair(args.TLSCert[i], args.TLSKey[i])
+		if err != nil {
+			return nil, err
+		}
+
+		certs[i] = cert
+	}
+
+	cfg := &tls.Config{
+		Certificates: certs,
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+
+	if args.OCSPStaple {
+		stapled := make([]*stapledCert, len(certs))
+
+		for i, cert := range certs {
+			sc, err := newStapledCert(cert)
+			if err != nil {
+				return nil, err
+			}
+
+			go sc.Run()
+
+			stapled[i] = sc
+		}
+
+		cfg.Certificates = nil
+		cfg.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			for _, sc := range stapled {
+				if cert := sc.Certificate(); hello.SupportsCertificate(cert) == nil {
+					return cert, nil
+				}
+			}
+
+			return stapled[0].Certificate(), nil
+		}
+	}
+
+	if len(args.TLSTicketKeys) > 0 {
+		tk := &ticketKeys{file: args.TLSTicketKeys, cfg: cfg}
+
+		err := tk.load()
+		if err != nil {
+			return nil, err
+		}
+
+		if args.TLSTicketReload > 0 {
+			go tk.watch(args.TLSTicketReload)
+		}
+	}
+
+	return cfg, nil
+}
+
+// ticketKeys keeps cfg's session ticket keys in sync with a file shared by
+// every replica, so a session resumed on any of them works on all of them.
+// The file holds one 32 byte key per line, hex or base64 encoded. The first
+// key encrypts new tickets and all of them decrypt, so a key can be added
+// everywhere before it's moved to the top.
+type ticketKeys struct {
+	file    string
+	cfg     *tls.Config
+	modTime time.Time
+}
+
+func (tk *ticketKeys) load() error {
+	info, err := os.Stat(tk.file)
+	if err != nil {
+		return err
+	}
+
+	keys, err := readTicketKeys(tk.file)
+	if err != nil {
+		return err
+	}
+
+	tk.cfg.SetSessionTicketKeys(keys)
+	tk.modTime = info.ModTime()
+
+	return nil
+}
+
+// watch reloads the keys whenever the file changes, checking every
+// interval.
+func (tk *ticketKeys) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		tk.reload()
+	}
+}
+
+// reload loads the keys again if the file has changed since they were last
+// loaded. A bad file is logged and the current keys are kept.
+func (tk *ticketKeys) reload() {
+	info, err := os.Stat(tk.file)
+	if err != nil || info.ModTime().Equal(tk.modTime) {
+		return
+	}
+
+	err = tk.load()
+	if err != nil {
+		logging.Error("unable to reload session ticket keys: %s", err)
+		tk.modTime = info.ModTime() // don't log it again until it changes
+
+		return
+	}
+
+	logging.Success("reloaded session ticket keys from %s", tk.file)
+}
+
+func readTicketKeys(file string) ([][32]byte, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys [][32]byte
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		raw, err := hex.DecodeString(line)
+		if err != nil {
+			raw, err = base64.StdEncoding.DecodeString(line)
+		}
+
+		if err != nil || len(raw) != 32 {
+			return nil, fmt.Errorf("%s:%d: expected a 32 byte key in hex or base64", file, n)
+		}
+
+		var key [32]byte
+		copy(key[:], raw)
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s has no session ticket keys", file)
+	}
+
+	return keys, nil
+}
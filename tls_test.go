@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTicketKeyRotation(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tickets")
+	keyA, keyB, keyC := strings.Repeat("aa", 32), strings.Repeat("bb", 32), strings.Repeat("cc", 32)
+
+	modTime := time.Now()
+	writeKeys := func(content string) {
+		t.Helper()
+
+		err := os.WriteFile(file, []byte(content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the check goes by the modification time, which may not tick
+		modTime = modTime.Add(time.Second)
+
+		err = os.Chtimes(file, modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeKeys(keyA + "\n")
+
+	server := &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}}
+	client := &tls.Config{InsecureSkipVerify: true, ClientSessionCache: tls.NewLRUClientSessionCache(1)}
+
+	tk := &ticketKeys{file: file, cfg: server}
+
+	err := tk.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name    string
+		keys    string // the file's new contents, if it changes
+		resumes bool
+	}{
+		{name: "first connection", resumes: false},
+		{name: "same key", resumes: true},
+		{name: "new key on top, old key kept", keys: keyB + "\n" + keyA + "\n", resumes: true},
+		{name: "old keys dropped", keys: "# rotated\n" + keyC + "\n", resumes: false},
+		{name: "bad file keeps the keys", keys: "not a key\n", resumes: true},
+	}
+
+	for _, step := range steps {
+		if len(step.keys) > 0 {
+			writeKeys(step.keys)
+			tk.reload()
+		}
+
+		if got := resumes(t, server, client); got != step.resumes {
+			t.Errorf("%s: resumed %v, want %v", step.name, got, step.resumes)
+		}
+	}
+}
+
+func TestReadTicketKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		content string
+		want    int
+		wantErr bool
+	}{
+		{strings.Repeat("ab", 32) + "\n" + strings.Repeat("A", 43) + "=\n", 2, false},
+		{"# comment\n\n" + strings.Repeat("ab", 32) + "\n", 1, false},
+		{strings.Repeat("ab", 16) + "\n", 0, true},
+		{"# nothing\n", 0, true},
+	}
+
+	for i, tt := range tests {
+		file := filepath.Join(dir, hex.EncodeToString([]byte{byte(i)}))
+
+		err := os.WriteFile(file, []byte(tt.content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys, err := readTicketKeys(file)
+		if (err != nil) != tt.wantErr || len(keys) != tt.want {
+			t.Errorf("%q: %d keys, error %v, want %d keys, error %v", tt.content, len(keys), err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// resumes connects a client to a server over a pipe and reports whether
+// the client resumed its last session.
+func resumes(t *testing.T, server *tls.Config, client *tls.Config) bool {
+	t.Helper()
+
+	// closing the pipe rather than the TLS conns skips the close_notify
+	// writes, which would block on an unbuffered pipe
+	sc, cc := net.Pipe()
+	defer sc.Close()
+	defer cc.Close()
+
+	done := make(chan error, 1)
+
+	go func() {
+		conn := tls.Server(sc, server)
+
+		// the ticket goes out with the first write after the handshake
+		_, err := conn.Write([]byte{1})
+		done <- err
+	}()
+
+	conn := tls.Client(cc, client)
+
+	// reading takes in the ticket
+	_, err := conn.Read(make([]byte, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	return conn.ConnectionState().DidResume
+}
+
+// testCertificate returns a self-signed certificate for the tests.
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/coreyog/spa-server/internal/cache"
+)
+
+// variantSources lists the image extensions that may be swapped for a
+// smaller sibling in a more modern format.
+var variantSources = map[string]bool{
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".gif":  true,
+}
+
+// variantFormats is the order modern formats are preferred in, paired with
+// the media type the client must accept for each.
+var variantFormats = []struct {
+	Ext  string
+	Type string
+}{
+	{".avif", "image/avif"},
+	{".webp", "image/webp"},
+}
+
+// imageVariant picks the best sibling of fullpath for the client based on
+// the Accept header and the DPR client hint, e.g. logo.png => logo@2x.avif.
+// Vary and Accept-CH are set on w whenever fullpath is a candidate image.
+func imageVariant(w http.ResponseWriter, r *http.Request, fullpath string, store *cache.Cache) string {
+	ext := strings.ToLower(filepath.Ext(fullpath))
+	if !variantSources[ext] {
+		return fullpath
+	}
+
+	w.Header().Add("Vary", "Accept, Sec-CH-DPR, DPR")
+	w.Header().Set("Accept-CH", "Sec-CH-DPR")
+
+	base := strings.TrimSuffix(fullpath, filepath.Ext(fullpath))
+	accept := r.Header.Get("Accept")
+
+	var bases []string
+	if dpr(r) >= 2 {
+		bases = append(bases, base+"@2x")
+	}
+
+	bases = append(bases, base)
+
+	for _, b := range bases {
+		for _, f := range variantFormats {
+			if accepts(accept, f.Type) && fileExists(b+f.Ext, store) {
+				return b + f.Ext
+			}
+		}
+
+		if b != base && fileExists(b+filepath.Ext(fullpath), store) {
+			return b + filepath.Ext(fullpath)
+		}
+	}
+
+	return fullpath
+}
+
+// dpr reads the device pixel ratio client hint, defaulting to 1.
+func dpr(r *http.Request) float64 {
+	raw := r.Header.Get("Sec-CH-DPR")
+	if len(raw) == 0 {
+		raw = r.Header.Get("DPR")
+	}
+
+	val, err := strconv.ParseFloat(raw, 64)
+	if err != nil || val <= 0 {
+		return 1
+	}
+
+	return val
+}
+
+// fileExists reports whether fullpath is cached or is a regular file on disk.
+func fileExists(fullpath string, store *cache.Cache) bool {
+	if store.Has(fullpath) {
+		return true
+	}
+
+	info, err := os.Stat(fullpath)
+
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreyog/spa-server/internal/cache"
+	"github.com/coreyog/spa-server/internal/logging"
+	"github.com/coreyog/spa-server/internal/resolver"
+	"github.com/jessevdk/go-flags"
+)
+
+// VerifyArguments are the options for the verify subcommand.
+type VerifyArguments struct {
+	DefaultDoc []string `short:"d" long:"default-doc" description:"On 404, return this document, repeat to try several in order" default:"index.html"`
+	Routes     string   `short:"r" long:"routes" description:"File listing one route per line, optionally followed by 'file', 'fallback', or '404'" required:"true"`
+	Positional struct {
+		Directory string `positional-arg-name:"DIR" description:"Directory to verify" required:"true"`
+	} `positional-args:"yes"`
+}
+
+// verify runs the path resolution offline against a routes file and reports
+// which routes would be served from a file, fall back to the default doc, or
+// 404. Each route expects a file unless marked 'fallback' or '404'. The returned exit
+// code is non-zero when any expectation isn't met.
+//
+//	spa-server verify --routes routes.txt ./dist
+func verify(argv []string) int {
+	var vargs VerifyArguments
+
+	parser := flags.NewParser(&vargs, flags.Default)
+	parser.Usage = "verify [OPTIONS]"
+
+	_, err := parser.ParseArgs(argv)
+	if err != nil {
+		if flags.WroteHelp(err) {
+			return 0
+		}
+
+		return 1
+	}
+
+	dir, err := filepath.Abs(vargs.Positional.Directory)
+	if err != nil {
+		logging.Error("%s", err)
+		return 1
+	}
+
+	res, err := resolver.New(dir, vargs.DefaultDoc...)
+	if err != nil {
+		logging.Error("%s", err)
+		return 1
+	}
+
+	// nothing is cached offline, fileExists always goes to disk
+	noCache := cache.New(0, nil)
+
+	hasDefault := fileExists(res.DefaultPath(), noCache)
+
+	file, err := os.Open(vargs.Routes)
+	if err != nil {
+		logging.Error("%s", err)
+		return 1
+	}
+
+	defer file.Close()
+
+	checks, err := parseRoutes(file)
+	if err != nil {
+		logging.Error("%s: %s", vargs.Routes, err)
+		return 1
+	}
+
+	failed := 0
+	total := len(checks)
+
+	for _, check := range checks {
+		got := verifyRoute(res, hasDefault, noCache, check.route)
+
+		if got == check.expect {
+			fmt.Printf("%s => %s\n", check.route, got)
+			continue
+		}
+
+		failed++
+
+		logging.Error("%s => %s (expected %s)", check.route, got, check.expect)
+	}
+
+	if failed > 0 {
+		logging.Error("%d of %d routes failed", failed, total)
+		return 1
+	}
+
+	logging.Success("all %d routes ok", total)
+
+	return 0
+}
+
+// routeCheck is a line of a routes file: a route and how it should be
+// served.
+type routeCheck struct {
+	route  string
+	expect string
+}
+
+// routeOutcomes are the ways a route can be served, as written in a routes
+// file.
+var routeOutcomes = map[string]bool{"file": true, "fallback": true, "404": true}
+
+// parseRoutes reads a routes file, one route per line optionally followed
+// by file, fallback, or 404, skipping blank lines and # comments. Anything
+// else is an error, so a typo can't pass unchecked.
+func parseRoutes(r io.Reader) ([]routeCheck, error) {
+	var checks []routeCheck
+
+	scanner := bufio.NewScanner(r)
+
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		check := routeCheck{route: fields[0], expect: "file"}
+
+		if !strings.HasPrefix(check.route, "/") {
+			return nil, fmt.Errorf("line %d: route %q must start with /", line, check.route)
+		}
+
+		if len(fields) > 1 {
+			check.expect = fields[1]
+		}
+
+		if !routeOutcomes[check.expect] {
+			return nil, fmt.Errorf("line %d: expected file, fallback, or 404, not %q", line, check.expect)
+		}
+
+		if len(fields) > 2 && !strings.HasPrefix(fields[2], "#") {
+			return nil, fmt.Errorf("line %d: unexpected %q after %s", line, fields[2], check.expect)
+		}
+
+		checks = append(checks, check)
+	}
+
+	return checks, scanner.Err()
+}
+
+// verifyRoute returns how route would be served: "file", "fallback", or
+// "404".
+func verifyRoute(res *resolver.Resolver, hasDefault bool, store *cache.Cache, route string) string {
+	fullpath := res.Map(route)
+	escaped := !res.Contains(filepath.Join(res.Dir, route))
+
+	switch {
+	case !escaped && fileExists(fullpath, store):
+		return "file"
+	case hasDefault:
+		return "fallback"
+	default:
+		return "404"
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreyog/spa-server/internal/cache"
+	"github.com/coreyog/spa-server/internal/resolver"
+)
+
+func TestParseRoutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		routes  string
+		want    []routeCheck
+		wantErr string
+	}{
+		{
+			name:   "outcomes",
+			routes: "/app.js\n/settings fallback\n/gone 404\n",
+			want:   []routeCheck{{"/app.js", "file"}, {"/settings", "fallback"}, {"/gone", "404"}},
+		},
+		{
+			name:   "comments and blank lines",
+			routes: "# routes\n\n/app.js file # the bundle\n",
+			want:   []routeCheck{{"/app.js", "file"}},
+		},
+		{name: "unknown outcome", routes: "/app.js fil\n", wantErr: `line 1: expected file, fallback, or 404, not "fil"`},
+		{name: "status instead of outcome", routes: "/\n/app.js 200\n", wantErr: `line 2: expected file, fallback, or 404, not "200"`},
+		{name: "relative route", routes: "app.js\n", wantErr: `line 1: route "app.js" must start with /`},
+		{name: "extra field", routes: "/app.js file fallback\n", wantErr: `line 1: unexpected "fallback" after file`},
+	}
+
+	for _, tt := range tests {
+		got, err := parseRoutes(strings.NewReader(tt.routes))
+
+		if len(tt.wantErr) > 0 {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("%s: error %v, want %s", tt.name, err, tt.wantErr)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyRoute(t *testing.T) {
+	site, err := filepath.Abs(filepath.Join("testdata", "site"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dir   string
+		route string
+		want  string
+	}{
+		{site, "/app.js", "file"},
+		{site, "/docs/", "file"},
+		{site, "/app/dashboard", "fallback"},
+		{site, "/missing.js", "fallback"},
+		{site, "/../etc/passwd", "fallback"},
+		{t.TempDir(), "/app/dashboard", "404"},
+	}
+
+	store := cache.New(0, nil)
+
+	for _, tt := range tests {
+		res, err := resolver.New(tt.dir, "index.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hasDefault := fileExists(res.DefaultPath(), store)
+
+		if got := verifyRoute(res, hasDefault, store, tt.route); got != tt.want {
+			t.Errorf("%s in %s => %s, want %s", tt.route, filepath.Base(tt.dir), got, tt.want)
+		}
+	}
+}
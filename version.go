@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"runtime/debug"
+)
+
+// version is the server's version, set at build time with
+// -ldflags "-X main.version=...".
+var version = "dev"
+
+// serverVersion returns version, or the module version when installed with
+// go install and not set at build time.
+func serverVersion() string {
+	if version != "dev" {
+		return version
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok && len(info.Main.Version) > 0 && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+
+	return version
+}
+
+// versionInfo is the body of /_version.
+type versionInfo struct {
+	Server string          `json:"server"`
+	App    json.RawMessage `json:"app,omitempty"`
+}
+
+// serveVersion reports the server's version along with the hosted app's
+// build metadata from --version-file, which is read on each request so it
+// follows deploys.
+func serveVersion(w http.ResponseWriter, r *http.Request) {
+	info := versionInfo{Server: serverVersion()}
+
+	if len(args.VersionFile) > 0 {
+		file := args.VersionFile
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(args.Positional.Directory, file)
+		}
+
+		content, err := ioutil.ReadFile(file)
+		if err == nil && !json.Valid(content) {
+			// not JSON, e.g. a bare commit hash, so report it as a string
+			content, err = json.Marshal(string(bytes.TrimSpace(content)))
+		}
+
+		if err == nil {
+			info.App = content
+		}
+	}
+
+	body, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(append(body, '\n'))
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/cache"
+	"github.com/coreyog/spa-server/internal/logging"
+	"github.com/coreyog/spa-server/internal/resolver"
+	"github.com/coreyog/spa-server/internal/responder"
+	"github.com/dustin/go-humanize"
+)
+
+var (
+	// ansiEscape matches the color codes in logs captured from a terminal.
+	ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+	// requestLine matches the quoted request of common/combined log lines.
+	requestLine = regexp.MustCompile(`"[A-Z]+ (\S+) HTTP/[0-9.]+"`)
+)
+
+// logPath extracts the requested path from an access log line. It
+// understands this server's own "/path => /file" lines, common/combined log
+// lines, and files with a bare path per line.
+func logPath(line string) string {
+	line = strings.TrimSpace(ansiEscape.ReplaceAllString(line, ""))
+
+	if m := requestLine.FindStringSubmatch(line); m != nil {
+		u, err := url.ParseRequestURI(m[1])
+		if err != nil {
+			return ""
+		}
+
+		return u.Path
+	}
+
+	if i := strings.Index(line, " => "); i >= 0 {
+		// the resolved file is the first field after the arrow
+		fields := strings.Fields(line[i+4:])
+		if len(fields) > 0 && strings.HasPrefix(fields[0], "/") {
+			return fields[0]
+		}
+
+		return ""
+	}
+
+	if strings.HasPrefix(line, "/") && !strings.ContainsAny(line, " \t") {
+		return line
+	}
+
+	return ""
+}
+
+// warmProgress is how often filling the cache logs how far along it is.
+const warmProgress = 2 * time.Second
+
+// warmUp runs fill, which loads files into the cache and sums up what it
+// did, before returning or, with --lazy-warm, in the background while the
+// server starts, returning a func that stops it. SIGINT and SIGTERM stop it
+// too, which before serving exits rather than starting half warmed. ready
+// is called once fill is done, or failed and requests go to the disk.
+func warmUp(name string, ready func(), fill func(ctx context.Context) (string, error)) func() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	start := time.Now()
+
+	if args.LazyWarm {
+		logging.Info("%s in the background...", name)
+
+		go func() {
+			defer stop()
+
+			summary, err := fill(ctx)
+
+			switch {
+			case errors.Is(err, context.Canceled):
+				logging.Warn("%s stopped: %s", name, summary)
+			case err != nil:
+				logging.Error("%s failed: %s", name, openFilesHint(err))
+				ready()
+			default:
+				logging.Success("%s (%s)", summary, time.Since(start))
+				ready()
+			}
+		}()
+
+		return stop
+	}
+
+	defer stop()
+
+	logging.Info("%s...", name)
+
+	summary, err := fill(ctx)
+	if errors.Is(err, context.Canceled) {
+		logging.Warn("%s cancelled: %s", name, summary)
+		os.Exit(1)
+	}
+
+	if err != nil {
+		panic(openFilesHint(err))
+	}
+
+	logging.Success("%s (%s)", summary, time.Since(start))
+
+	return func() {}
+}
+
+// warmFromLog loads every file requested in the access log at logFile into
+// the cache, returning how many files and bytes were loaded. It logs how
+// far along it is every few seconds, and stops with ctx's error once ctx is
+// done.
+func warmFromLog(ctx context.Context, store *cache.Cache, types *responder.Types, logFile string, res *resolver.Resolver) (count int, size uint64, err error) {
+	file, err := os.Open(logFile)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer file.Close()
+
+	seen := map[string]bool{}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	progress := time.NewTicker(warmProgress)
+	defer progress.Stop()
+
+	for scanner.Scan() {
+		select {
+		case <-ctx.Done():
+			return count, size, ctx.Err()
+		case <-progress.C:
+			logging.Info("warming from log: %d files, %s so far", count, humanize.Bytes(size))
+		default:
+		}
+
+		path := logPath(scanner.Text())
+		if len(path) == 0 {
+			continue
+		}
+
+		fullpath := res.Map(path)
+		if seen[fullpath] {
+			continue
+		}
+
+		seen[fullpath] = true
+
+		if store.Excluded(fullpath) {
+			continue
+		}
+
+		entry, err := loadEntry(fullpath, types)
+		if err != nil {
+			// the log may be from an older build
+			continue
+		}
+
+		store.Store(cache.Key{Path: fullpath}, entry)
+
+		count++
+		size += uint64(len(entry.Content))
+	}
+
+	return count, size, scanner.Err()
+}
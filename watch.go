@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/coreyog/spa-server/internal/logging"
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchSettle is how long the watcher waits for a burst of changes, like a
+// build writing its output, to settle before acting on them.
+const watchSettle = 100 * time.Millisecond
+
+// Watcher follows changes to the files in a directory as they happen and
+// hands them over in batches, so --cache doesn't go on serving files that
+// were replaced on disk. Subdirectories are watched as they're created.
+type Watcher struct {
+	root     string
+	fsw      *fsnotify.Watcher
+	dirs     map[string]struct{}
+	onChange func(changed []string)
+}
+
+// NewWatcher starts watching dir. onChange is called from Run with the
+// slash-separated paths relative to dir that changed, or nil if it can't
+// tell which, e.g. after a directory was removed.
+func NewWatcher(dir string, onChange func(changed []string)) (*Watcher, error) {
+	// DIR is often a symlink, the walk and the events are for its target
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	wa := &Watcher{
+		root:     root,
+		fsw:      fsw,
+		dirs:     map[string]struct{}{},
+		onChange: onChange,
+	}
+
+	_, err = wa.add(root)
+	if err != nil {
+		_ = fsw.Close()
+		return nil, err
+	}
+
+	return wa, nil
+}
+
+// add watches dir and the directories under it, returning the files in
+// them.
+func (wa *Watcher) add(dir string) ([]string, error) {
+	var files []string
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() {
+			files = append(files, path)
+			return nil
+		}
+
+		err = wa.fsw.Add(path)
+		if err != nil {
+			return err
+		}
+
+		wa.dirs[path] = struct{}{}
+
+		return nil
+	})
+
+	return files, err
+}
+
+// Run collects changes until they settle and hands them to onChange, until
+// the watcher is closed.
+func (wa *Watcher) Run() {
+	pending := map[string]struct{}{}
+	all := false
+
+	settle := time.NewTimer(watchSettle)
+	settle.Stop()
+
+	for {
+		select {
+		case event, ok := <-wa.fsw.Events:
+			if !ok {
+				return
+			}
+
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+
+			changed := []string{event.Name}
+
+			if _, ok := wa.dirs[event.Name]; ok && event.Has(fsnotify.Remove|fsnotify.Rename) {
+				// whatever was under it is gone too, and inotify doesn't
+				// say what that was
+				delete(wa.dirs, event.Name)
+				all = true
+			}
+
+			if info, err := os.Stat(event.Name); err == nil && info.IsDir() && event.Has(fsnotify.Create) {
+				// files moved in with the directory don't get events
+				files, err := wa.add(event.Name)
+				if err != nil {
+					logging.Warn("unable to watch %s: %s", event.Name, err)
+				}
+
+				changed = files
+			}
+
+			for _, path := range changed {
+				rel, err := filepath.Rel(wa.root, path)
+				if err == nil {
+					pending[filepath.ToSlash(rel)] = struct{}{}
+				}
+			}
+
+			settle.Reset(watchSettle)
+		case err, ok := <-wa.fsw.Errors:
+			if !ok {
+				return
+			}
+
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				all = true
+				settle.Reset(watchSettle)
+
+				continue
+			}
+
+			logging.Warn("watching %s: %s", wa.root, err)
+		case <-settle.C:
+			var changed []string
+
+			if !all {
+				changed = make([]string, 0, len(pending))
+				for rel := range pending {
+					changed = append(changed, rel)
+				}
+
+				sort.Strings(changed)
+			}
+
+			pending = map[string]struct{}{}
+			all = false
+
+			wa.onChange(changed)
+		}
+	}
+}
+
+// Close stops watching, ending Run.
+func (wa *Watcher) Close() error {
+	return wa.fsw.Close()
+}